@@ -0,0 +1,406 @@
+// Package ldaptest 是一个只用于测试的内存 LDAP 服务器，它只支持简单绑定和查询，
+// 查询条件支持 and, or, not, 等于, 存在和子串匹配，足够测试 LDAP 登录和目录同步
+package ldaptest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// Entry 是目录中的一个条目
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+
+	dn *ldap.DN
+}
+
+func (e *Entry) get(name string) []string {
+	for key, values := range e.Attributes {
+		if strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+type Server struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	entries []*Entry
+	conns   map[net.Conn]struct{}
+	wg      sync.WaitGroup
+}
+
+// NewServer 在 127.0.0.1 的随机端口上启动一个 LDAP 服务器
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listener: listener,
+		conns:    map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr 返回服务器的监听地址，可以直接用于 users.ldap_address 配置
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Add 添加或替换一个条目，password 不为空时可以用这个条目的 DN 绑定
+func (s *Server) Add(dn, password string, attributes map[string][]string) error {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return err
+	}
+	entry := &Entry{
+		DN:         dn,
+		Password:   password,
+		Attributes: attributes,
+		dn:         parsed,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for idx := range s.entries {
+		if s.entries[idx].dn.EqualFold(parsed) {
+			s.entries[idx] = entry
+			return nil
+		}
+	}
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+// Delete 删除一个条目
+func (s *Server) Delete(dn string) error {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for idx := range s.entries {
+		if s.entries[idx].dn.EqualFold(parsed) {
+			s.entries = append(s.entries[:idx], s.entries[idx+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		messageID, ok := packet.Children[0].Value.(int64)
+		if !ok {
+			return
+		}
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code, msg := s.bind(op)
+			err = writeResult(conn, messageID, ldap.ApplicationBindResponse, code, msg)
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationAbandonRequest:
+			// 查询是同步执行的，没有可以放弃的操作
+		case ldap.ApplicationSearchRequest:
+			err = s.search(conn, messageID, op)
+		default:
+			err = writeResult(conn, messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultUnwillingToPerform, "operation isnot supported")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) bind(op *ber.Packet) (uint16, string) {
+	if len(op.Children) < 3 {
+		return ldap.LDAPResultProtocolError, "bind request is invalid"
+	}
+	name, _ := op.Children[1].Value.(string)
+	if op.Children[2].ClassType != ber.ClassContext || op.Children[2].Tag != 0 {
+		return ldap.LDAPResultAuthMethodNotSupported, "only simple bind is supported"
+	}
+	password := op.Children[2].Data.String()
+	if name == "" && password == "" {
+		return ldap.LDAPResultSuccess, ""
+	}
+
+	dn, err := ldap.ParseDN(name)
+	if err != nil {
+		return ldap.LDAPResultInvalidCredentials, err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if entry.dn.EqualFold(dn) {
+			if entry.Password != "" && entry.Password == password {
+				return ldap.LDAPResultSuccess, ""
+			}
+			break
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials, "invalid credentials"
+}
+
+func (s *Server) search(w io.Writer, messageID int64, op *ber.Packet) error {
+	if len(op.Children) < 8 {
+		return writeResult(w, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError, "search request is invalid")
+	}
+	baseDN, _ := op.Children[0].Value.(string)
+	scope, _ := op.Children[1].Value.(int64)
+	filter := op.Children[6]
+	var attributes []string
+	for _, child := range op.Children[7].Children {
+		if name, ok := child.Value.(string); ok {
+			attributes = append(attributes, name)
+		}
+	}
+
+	base, err := ldap.ParseDN(baseDN)
+	if err != nil {
+		return writeResult(w, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultInvalidDNSyntax, err.Error())
+	}
+
+	s.mu.Lock()
+	var results []*Entry
+	for _, entry := range s.entries {
+		switch scope {
+		case ldap.ScopeBaseObject:
+			if !entry.dn.EqualFold(base) {
+				continue
+			}
+		case ldap.ScopeSingleLevel:
+			if !base.AncestorOfFold(entry.dn) || len(entry.dn.RDNs) != len(base.RDNs)+1 {
+				continue
+			}
+		default:
+			if !entry.dn.EqualFold(base) && !base.AncestorOfFold(entry.dn) {
+				continue
+			}
+		}
+		matched, err := match(entry, filter)
+		if err != nil {
+			s.mu.Unlock()
+			return writeResult(w, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultFilterError, err.Error())
+		}
+		if matched {
+			results = append(results, entry)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(results) == 0 && scope == ldap.ScopeBaseObject {
+		return writeResult(w, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject, "no such object")
+	}
+	for _, entry := range results {
+		if err := writeEntry(w, messageID, entry, attributes); err != nil {
+			return err
+		}
+	}
+	return writeResult(w, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, "")
+}
+
+var errFilterUnsupported = errors.New("filter isnot supported")
+
+func match(entry *Entry, filter *ber.Packet) (bool, error) {
+	if filter.ClassType != ber.ClassContext {
+		return false, errFilterUnsupported
+	}
+
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			ok, err := match(entry, child)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			ok, err := match(entry, child)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case ldap.FilterNot:
+		if len(filter.Children) != 1 {
+			return false, errFilterUnsupported
+		}
+		ok, err := match(entry, filter.Children[0])
+		return !ok, err
+	case ldap.FilterPresent:
+		name := filter.Data.String()
+		if strings.EqualFold(name, "objectClass") {
+			return true, nil
+		}
+		return len(entry.get(name)) > 0, nil
+	case ldap.FilterEqualityMatch:
+		if len(filter.Children) != 2 {
+			return false, errFilterUnsupported
+		}
+		name, _ := filter.Children[0].Value.(string)
+		value, _ := filter.Children[1].Value.(string)
+		for _, v := range entry.get(name) {
+			if strings.EqualFold(v, value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case ldap.FilterSubstrings:
+		if len(filter.Children) != 2 {
+			return false, errFilterUnsupported
+		}
+		name, _ := filter.Children[0].Value.(string)
+		for _, v := range entry.get(name) {
+			if matchSubstrings(strings.ToLower(v), filter.Children[1].Children) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, errFilterUnsupported
+}
+
+func matchSubstrings(value string, parts []*ber.Packet) bool {
+	for _, part := range parts {
+		s := strings.ToLower(part.Data.String())
+		switch part.Tag {
+		case ldap.FilterSubstringsInitial:
+			if !strings.HasPrefix(value, s) {
+				return false
+			}
+			value = value[len(s):]
+		case ldap.FilterSubstringsAny:
+			idx := strings.Index(value, s)
+			if idx < 0 {
+				return false
+			}
+			value = value[idx+len(s):]
+		case ldap.FilterSubstringsFinal:
+			if !strings.HasSuffix(value, s) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func writeEntry(w io.Writer, messageID int64, entry *Entry, attributes []string) error {
+	all := len(attributes) == 0
+	for _, name := range attributes {
+		if name == "*" {
+			all = true
+		}
+	}
+
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "DN"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for key, values := range entry.Attributes {
+		if !all && !containsFold(attributes, key) {
+			continue
+		}
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, key, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return writeMessage(w, messageID, op)
+}
+
+func writeResult(w io.Writer, messageID int64, tag ber.Tag, code uint16, msg string) error {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, msg, "Diagnostic Message"))
+	return writeMessage(w, messageID, op)
+}
+
+func writeMessage(w io.Writer, messageID int64, op *ber.Packet) error {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	packet.AppendChild(op)
+	_, err := w.Write(packet.Bytes())
+	return err
+}
+
+func containsFold(names []string, name string) bool {
+	for _, s := range names {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
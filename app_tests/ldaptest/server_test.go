@@ -0,0 +1,95 @@
+package ldaptest
+
+import (
+	"testing"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestServer(t *testing.T) {
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	srv.Add("ou=rd,dc=example,dc=com", "", map[string][]string{
+		"objectClass": {"organizationalUnit"},
+		"ou":          {"rd"},
+	})
+	srv.Add("cn=tom,ou=rd,dc=example,dc=com", "tom123", map[string][]string{
+		"objectClass": {"person"},
+		"cn":          {"tom"},
+		"mail":        {"tom@example.com"},
+		"memberOf":    {"cn=admins,ou=groups,dc=example,dc=com"},
+	})
+	srv.Add("cn=jerry,dc=example,dc=com", "jerry123", map[string][]string{
+		"objectClass": {"person"},
+		"cn":          {"jerry"},
+	})
+
+	conn, err := ldap.Dial("tcp", srv.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.Bind("cn=tom,ou=rd,dc=example,dc=com", "bad"); !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		t.Error("want invalid credentials got", err)
+	}
+	if err := conn.Bind("CN=Tom,ou=rd,dc=example,dc=com", "tom123"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		baseDN string
+		scope  int
+		filter string
+		want   []string
+	}{
+		{"dc=example,dc=com", ldap.ScopeWholeSubtree, "(objectClass=person)", []string{"cn=tom,ou=rd,dc=example,dc=com", "cn=jerry,dc=example,dc=com"}},
+		{"dc=example,dc=com", ldap.ScopeSingleLevel, "(objectClass=*)", []string{"ou=rd,dc=example,dc=com", "cn=jerry,dc=example,dc=com"}},
+		{"cn=tom,ou=rd,dc=example,dc=com", ldap.ScopeBaseObject, "(objectClass=*)", []string{"cn=tom,ou=rd,dc=example,dc=com"}},
+		{"dc=example,dc=com", ldap.ScopeWholeSubtree, "(&(objectClass=person)(mail=*))", []string{"cn=tom,ou=rd,dc=example,dc=com"}},
+		{"dc=example,dc=com", ldap.ScopeWholeSubtree, "(|(cn=JERRY)(cn=nobody))", []string{"cn=jerry,dc=example,dc=com"}},
+		{"dc=example,dc=com", ldap.ScopeWholeSubtree, "(&(objectClass=person)(!(cn=tom)))", []string{"cn=jerry,dc=example,dc=com"}},
+		{"dc=example,dc=com", ldap.ScopeWholeSubtree, "(mail=*@example.*)", []string{"cn=tom,ou=rd,dc=example,dc=com"}},
+	} {
+		result, err := conn.SearchWithPaging(ldap.NewSearchRequest(test.baseDN,
+			test.scope, ldap.NeverDerefAliases, 0, 0, false,
+			test.filter, []string{"cn", "memberOf"}, nil), 100)
+		if err != nil {
+			t.Error(test.filter, err)
+			continue
+		}
+		if len(result.Entries) != len(test.want) {
+			t.Error(test.filter, "want", test.want, "got", len(result.Entries))
+			continue
+		}
+		for idx, entry := range result.Entries {
+			if entry.DN != test.want[idx] {
+				t.Error(test.filter, "want", test.want[idx], "got", entry.DN)
+			}
+			if entry.GetAttributeValue("mail") != "" {
+				t.Error(test.filter, "attribute 'mail' isnot requested")
+			}
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest("cn=tom,ou=rd,dc=example,dc=com",
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{"memberOf"}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Entries[0].GetAttributeValues("memberOf"); len(got) != 1 || got[0] != "cn=admins,ou=groups,dc=example,dc=com" {
+		t.Error("memberOf is invalid:", got)
+	}
+
+	srv.Delete("cn=jerry,dc=example,dc=com")
+	if _, err := conn.Search(ldap.NewSearchRequest("cn=jerry,dc=example,dc=com",
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", nil, nil)); !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		t.Error("want no such object got", err)
+	}
+}
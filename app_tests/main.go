@@ -21,6 +21,7 @@ import (
 	"github.com/boo-admin/boo/services/authn/base_auth"
 	"github.com/boo-admin/boo/services/authn/jwt_auth"
 	"github.com/boo-admin/boo/services/authn/session_auth"
+	"github.com/boo-admin/boo/services/authn/token_auth"
 	"github.com/golang-jwt/jwt/v4"
 	_ "github.com/lib/pq"
 	gobatis "github.com/runner-mei/GoBatis"
@@ -71,6 +72,7 @@ func NewTestApp(t testing.TB, params map[string]string) *TestApp {
 	setDefault(params, "db.url", "host=127.0.0.1 port=5432 user=golang password=123456 dbname=golang sslmode=disable")
 
 	setDefault(params, "users.cookie.hash_secret", "xxxx")
+	setDefault(params, "users.totp.secret_key", "yyyy")
 
 	env, err := booclient.NewEnvironmentWith("boo", "test.properties", params)
 	if err != nil {
@@ -90,18 +92,16 @@ func (app *TestApp) BaseURL() string {
 
 func (app *TestApp) Start(t testing.TB) {
 	jwtUser := func(ctx context.Context, req *http.Request, token *jwt.Token) (context.Context, error) {
-		claims, ok := token.Claims.(*jwt.StandardClaims)
+		claims, ok := token.Claims.(*jwt_auth.UserClaims)
 		if !ok {
-			return nil, errors.New("claims not jwt.StandardClaims")
+			return nil, errors.New("claims not jwt_auth.UserClaims")
 		}
 
-		ss := strings.SplitN(claims.Audience, " ", 2)
-		if len(ss) < 2 {
-			return nil, errors.New("Audience '" + claims.Audience + "' is invalid")
+		ctx, err := echosrv.CheckToken(ctx, app.Server, claims)
+		if err != nil {
+			return nil, err
 		}
-		// userid := ss[0]
-		username := ss[1]
-
+		username := claims.Username
 		return authn.ContextWithReadCurrentUser(ctx, authn.ReadCurrentUserFunc(func(ctx context.Context) (authn.AuthUser, error) {
 			return authn.NewMockUser(username), nil
 		})), nil
@@ -114,6 +114,11 @@ func (app *TestApp) Start(t testing.TB) {
 
 	sessionUser := func(ctx context.Context, req *http.Request, values url.Values) (context.Context, error) {
 		username := values.Get(session_auth.SESSION_USER_KEY)
+
+		ctx, err := echosrv.CheckOnline(ctx, app.Server, values.Get(session_auth.SESSION_ID_KEY))
+		if err != nil {
+			return nil, err
+		}
 		return authn.ContextWithReadCurrentUser(ctx, authn.ReadCurrentUserFunc(func(ctx context.Context) (authn.AuthUser, error) {
 			return authn.NewMockUser(username), nil
 		})), nil
@@ -130,6 +135,13 @@ func (app *TestApp) Start(t testing.TB) {
 				return authn.NewMockUser("admin"), nil
 			})), nil
 		}
+		if app.Server != nil && app.Server.AuthUsers != nil {
+			u, err := app.Server.AuthUsers.Verify(ctx, username, password, booclient.RealIP(req))
+			if err != nil {
+				return ctx, err
+			}
+			return authn.ContextWithUser(ctx, u), nil
+		}
 		return ctx, authn.ErrInvalidCredentials
 	}
 	baseAuth /* , err */ := base_auth.Verify(validator)
@@ -138,12 +150,26 @@ func (app *TestApp) Start(t testing.TB) {
 	//	t.FailNow()
 	// }
 
+	tokenAuth := token_auth.Verify(func(ctx context.Context, req *http.Request, token string) (context.Context, error) {
+		if app.Server != nil && app.Server.AccessTokens != nil {
+			u, err := app.Server.AccessTokens.Verify(ctx, token, booclient.RealIP(req))
+			if err != nil {
+				return ctx, err
+			}
+			return authn.ContextWithUser(ctx, u), nil
+		}
+		return ctx, authn.ErrInvalidCredentials
+	})
+
 	var validateFns = []authn.AuthValidateFunc{
 		jwtAuth,
 		sessionAuth,
+		tokenAuth,
 		baseAuth,
 	}
 	echosrv.Use(echofunctions.HTTPAuth(nil, validateFns...))
+	echosrv.Use(echofunctions.PasswordChangeCheck(nil, "/boo/api/v1", echosrv.PasswordChangeAllowedPaths...))
+	echosrv.Use(echofunctions.TwoFactorEnrollCheck(nil, "/boo/api/v1", echosrv.TwoFactorEnrollAllowedPaths...))
 
 	srv, err := boo.NewServer(app.Env)
 	if err != nil {
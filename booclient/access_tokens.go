@@ -0,0 +1,66 @@
+//go:generate gogenv2 server -ext=.server-gen.go access_tokens.go
+//go:generate gogenv2 client -ext=.client-gen.go access_tokens.go
+
+package booclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/runner-mei/resty"
+)
+
+// AccessToken 是用户的访问令牌，用于程序调用 api，常用于 source 为 "api" 的用户。
+// Token 是令牌的明文，它只会在创建时返回这一次，数据库中只保存它的 hash 值
+type AccessToken struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+	// Prefix 是令牌明文的前几个字符，用于在列表中识别令牌
+	Prefix string `json:"prefix,omitempty"`
+	// Scopes 是令牌允许使用的权限，它只能缩小用户本身的权限，为空时表示用户的全部权限
+	Scopes          []string   `json:"scopes,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty"`
+	LastUsedAddress string     `json:"last_used_address,omitempty"`
+	CreatedAt       time.Time  `json:"created_at,omitempty"`
+
+	Token string `json:"token,omitempty"`
+}
+
+type AccessTokens interface {
+	// @Summary 为指定用户新建一个访问令牌
+	// @Tags     Auth,Users
+	// @Param    userID     path int           true     "用户ID"
+	// @Param    token      body AccessToken   true     "令牌的名称，有效期和权限范围"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /access_tokens/users/{userID} [post]
+	// @Success  200 {object} AccessToken  "返回新建的令牌，令牌的明文只会返回这一次"
+	Create(ctx context.Context, userID int64, token *AccessToken) (*AccessToken, error)
+
+	// @Summary 查询指定用户的访问令牌
+	// @Tags     Auth,Users
+	// @Param    userID     path int      true     "用户ID"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /access_tokens/users/{userID} [get]
+	// @Success  200 {array} AccessToken  "返回令牌列表，不包含令牌的明文"
+	List(ctx context.Context, userID int64) ([]AccessToken, error)
+
+	// @Summary 吊销指定用户的访问令牌
+	// @Tags     Auth,Users
+	// @Param    userID     path int      true     "用户ID"
+	// @Param    id         path int      true     "令牌ID"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /access_tokens/users/{userID}/{id} [delete]
+	// @Success  200 {string} string  "返回一个无意义的 'OK' 字符串"
+	Revoke(ctx context.Context, userID, id int64) error
+}
+
+func NewRemoteAccessTokens(pxy *resty.Proxy) AccessTokens {
+	return AccessTokensClient{
+		Proxy: pxy,
+	}
+}
@@ -0,0 +1,79 @@
+//go:generate gogenv2 server -ext=.server-gen.go custom_fields.go
+//go:generate gogenv2 client -ext=.client-gen.go custom_fields.go
+
+package booclient
+
+import (
+	"context"
+
+	"github.com/runner-mei/resty"
+)
+
+// 自定义字段所属的对象类型
+const (
+	CustomFieldUser       = "user"
+	CustomFieldEmployee   = "employee"
+	CustomFieldDepartment = "department"
+)
+
+// IsValidCustomFieldObjectType 判断对象类型是否合法
+func IsValidCustomFieldObjectType(objectType string) bool {
+	switch objectType {
+	case CustomFieldUser, CustomFieldEmployee, CustomFieldDepartment:
+		return true
+	}
+	return false
+}
+
+type CustomFields interface {
+	// @Summary 新建一个自定义字段
+	// @Param    objectType  path string         true     "对象类型" enums(user,employee,department)
+	// @Param    field       body CustomField    true     "字段定义"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /custom_fields/{objectType} [post]
+	// @Success  200 {string} string  "返回一个无意义的 'OK' 字符串"
+	Create(ctx context.Context, objectType string, field *CustomField) error
+
+	// @Summary 修改自定义字段
+	// @Param    objectType  path string         true     "对象类型" enums(user,employee,department)
+	// @Param    id          path string         true     "字段ID"
+	// @Param    field       body CustomField    true     "字段定义"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /custom_fields/{objectType}/{id} [put]
+	// @Success  200 {string} string  "返回一个无意义的 'OK' 字符串"
+	UpdateByID(ctx context.Context, objectType, id string, field *CustomField) error
+
+	// @Summary 删除自定义字段
+	// @Param    objectType  path string         true     "对象类型" enums(user,employee,department)
+	// @Param    id          path string         true     "字段ID"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /custom_fields/{objectType}/{id} [delete]
+	// @Success  200 {string} string  "返回一个无意义的 'OK' 字符串"
+	DeleteByID(ctx context.Context, objectType, id string) error
+
+	// @Summary 查询指定的自定义字段
+	// @Param    objectType  path string         true     "对象类型" enums(user,employee,department)
+	// @Param    id          path string         true     "字段ID"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /custom_fields/{objectType}/{id} [get]
+	// @Success  200 {object} CustomField  "返回指定的自定义字段"
+	FindByID(ctx context.Context, objectType, id string) (*CustomField, error)
+
+	// @Summary 查询对象的所有自定义字段
+	// @Param    objectType  path string         true     "对象类型" enums(user,employee,department)
+	// @Accept   json
+	// @Produce  json
+	// @Router   /custom_fields/{objectType} [get]
+	// @Success  200 {array} CustomField  "返回所有自定义字段"
+	List(ctx context.Context, objectType string) ([]CustomField, error)
+}
+
+func NewRemoteCustomFields(pxy *resty.Proxy) CustomFields {
+	return CustomFieldsClient{
+		Proxy: pxy,
+	}
+}
@@ -36,6 +36,13 @@ func (u *Department) GetString(key string) string {
 	return s
 }
 
+// DepartmentOrder 是批量调整部门顺序时的一项
+type DepartmentOrder struct {
+	ID       int64 `json:"id"`
+	ParentID int64 `json:"parent_id"`
+	OrderNum int   `json:"order_num"`
+}
+
 type Departments interface {
 	// @Summary 新建一个部门
 	// @Param department     body Department    true     "部门定义"
@@ -103,6 +110,40 @@ type Departments interface {
 	// @Router  /departments/tree [get]
 	// @Success 200 {array} Department  "返回所有部门"
 	GetTree(ctx context.Context) ([]*Department, error)
+
+	// @Summary 将部门（包括它的下级部门）移到新的上级部门下
+	// @Param id            path  int                       true     "部门ID"
+	// @Param newParentID   query int                       false    "新的上级部门ID，为 0 时移到顶层"
+	// @Param position      query int                       false    "在新的上级部门中的位置，从 0 开始"
+	// @Accept  json
+	// @Produce json
+	// @Router  /departments/{id}/move [put]
+	// @Success 200 {string} string  "返回一个无意义的 'OK' 字符串"
+	Move(ctx context.Context, id, newParentID int64, position int) error
+
+	// @Summary 批量调整部门的上级部门和顺序
+	// @Param orders        body []DepartmentOrder          true     "部门的顺序"
+	// @Accept  json
+	// @Produce json
+	// @Router  /departments/reorder [put]
+	// @Success 200 {string} string  "返回一个无意义的 'OK' 字符串"
+	Reorder(ctx context.Context, orders []DepartmentOrder) error
+
+	// @Summary 查询指定部门及它的所有下级部门, 并将它转成 tree 形式返回
+	// @Param id            path int                       true     "部门ID"
+	// @Accept  json
+	// @Produce json
+	// @Router  /departments/{id}/subtree [get]
+	// @Success 200 {object} Department  "返回指定的部门"
+	GetSubtree(ctx context.Context, id int64) (*Department, error)
+
+	// @Summary 查询指定部门的所有上级部门，按从顶层到直接上级的顺序返回
+	// @Param id            path int                       true     "部门ID"
+	// @Accept  json
+	// @Produce json
+	// @Router  /departments/{id}/ancestors [get]
+	// @Success 200 {array} Department  "返回所有上级部门"
+	GetAncestors(ctx context.Context, id int64) ([]Department, error)
 }
 
 func NewRemoteDepartments(pxy *resty.Proxy) Departments {
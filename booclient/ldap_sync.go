@@ -0,0 +1,76 @@
+//go:generate gogenv2 server -ext=.server-gen.go ldap_sync.go
+//go:generate gogenv2 client -ext=.client-gen.go ldap_sync.go
+
+package booclient
+
+import (
+	"context"
+
+	"github.com/runner-mei/resty"
+)
+
+// LdapSyncUser 是 LDAP 目录中需要新建的用户
+type LdapSyncUser struct {
+	Name     string `json:"name"`
+	Nickname string `json:"nickname"`
+	DN       string `json:"dn"`
+	// Department 是用户所在的 OU 对应的部门，上下级之间用 '/' 分隔
+	Department string   `json:"department,omitempty"`
+	Email      string   `json:"email,omitempty"`
+	Mobile     string   `json:"mobile,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+}
+
+// LdapSyncChange 是 LDAP 目录中已有用户的变化
+type LdapSyncChange struct {
+	UserID  int64          `json:"user_id"`
+	Name    string         `json:"name"`
+	Records []ChangeRecord `json:"records"`
+}
+
+// LdapSyncDiff 是 LDAP 目录和系统中用户之间的差异
+type LdapSyncDiff struct {
+	NewDepartments []string         `json:"new_departments,omitempty"`
+	NewUsers       []LdapSyncUser   `json:"new_users,omitempty"`
+	UpdatedUsers   []LdapSyncChange `json:"updated_users,omitempty"`
+	// DisabledUsers 是已经从 LDAP 目录中删除，需要禁用的用户
+	DisabledUsers []string `json:"disabled_users,omitempty"`
+	// Skipped 是和系统中来源不是 LDAP 的用户同名，不会同步的用户
+	Skipped []string `json:"skipped,omitempty"`
+	// Errors 是同步时失败的用户和原因，只有 Sync 才会返回它
+	Errors []string `json:"errors,omitempty"`
+	// Warnings 是不会同步的部门和用户的原因，如部门冲突或要禁用的用户过多
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// IsEmpty 判断是否有需要同步的内容
+func (diff *LdapSyncDiff) IsEmpty() bool {
+	return len(diff.NewDepartments) == 0 &&
+		len(diff.NewUsers) == 0 &&
+		len(diff.UpdatedUsers) == 0 &&
+		len(diff.DisabledUsers) == 0
+}
+
+type LdapSync interface {
+	// @Summary 比较 LDAP 目录和系统中的用户，返回同步时会做的修改，但不会修改任何数据
+	// @Tags     Users
+	// @Accept   json
+	// @Produce  json
+	// @Router   /ldap_sync/diff [get]
+	// @Success  200 {object} LdapSyncDiff  "返回 LDAP 目录和系统中用户之间的差异"
+	GetDiff(ctx context.Context) (*LdapSyncDiff, error)
+
+	// @Summary 从 LDAP 目录同步用户和部门，并禁用已从目录中删除的用户
+	// @Tags     Users
+	// @Accept   json
+	// @Produce  json
+	// @Router   /ldap_sync [post]
+	// @Success  200 {object} LdapSyncDiff  "返回本次同步所做的修改"
+	Sync(ctx context.Context) (*LdapSyncDiff, error)
+}
+
+func NewRemoteLdapSync(pxy *resty.Proxy) LdapSync {
+	return LdapSyncClient{
+		Proxy: pxy,
+	}
+}
@@ -6,14 +6,30 @@ package booclient
 import (
 	"context"
 	"time"
+
+	"github.com/runner-mei/resty"
 )
 
+// SessionMetadata 是登录时记录的客户端信息，用于用户识别自已的各个会话
+type SessionMetadata struct {
+	UserAgent string `json:"user_agent,omitempty"`
+	// LoginType 是登录的方式，如 session, jwt, oidc 和 cas
+	LoginType string `json:"login_type,omitempty"`
+	// ClientHints 是浏览器发送的 Sec-CH-UA 系列的请求头，键为小写的头名称
+	ClientHints map[string]string `json:"client_hints,omitempty"`
+}
+
 type OnlineInfo struct {
 	UUID      string    `json:"uuid"`
 	Username  string    `json:"username"`
 	Address   string    `json:"address"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	SessionMetadata
+
+	// Current 表示是否是发起当前请求的会话
+	Current bool `json:"current,omitempty"`
 }
 
 type OnlineQueryer interface {
@@ -61,9 +77,54 @@ type OnlineQueryer interface {
 	LogoutBySessionID(ctx context.Context, uuid string) error
 }
 
+// Sessions 是面向用户的在线会话接口，用户可以查看和注销自已的会话，
+// 管理员可以按用户查看和注销所有的会话
+type Sessions interface {
+	// @Summary 返回当前用户的在线会话
+	// @Tags     Auth
+	// @Accept   json
+	// @Produce  json
+	// @Router   /sessions/mine [get]
+	// @Success  200 {array}  OnlineInfo
+	ListMine(ctx context.Context) ([]OnlineInfo, error)
+
+	// @Summary 注销当前用户的一个在线会话
+	// @Tags     Auth
+	// @Param    uuid path string   true        "会话 ID"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /sessions/mine/{uuid} [delete]
+	// @Success  200 {string}  string  "返回一个无意义的 'ok'"
+	RevokeMine(ctx context.Context, uuid string) error
+
+	// @Summary 返回在线会话
+	// @Tags     Auth,Users
+	// @Param    username query string  false       "用户名，为空时返回所有用户的会话"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /sessions [get]
+	// @Success  200 {array}  OnlineInfo
+	List(ctx context.Context, username string) ([]OnlineInfo, error)
+
+	// @Summary 注销一个在线会话
+	// @Tags     Auth,Users
+	// @Param    uuid path string   true        "会话 ID"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /sessions/{uuid} [delete]
+	// @Success  200 {string}  string  "返回一个无意义的 'ok'"
+	Revoke(ctx context.Context, uuid string) error
+}
+
+func NewRemoteSessions(pxy *resty.Proxy) Sessions {
+	return SessionsClient{
+		Proxy: pxy,
+	}
+}
+
 type LockedUser struct {
-	Username  string
-	Address   string
+	Username  string    `json:"username"`
+	Address   string    `json:"address"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -95,3 +156,9 @@ type LockedUsers interface {
 	// @Success 200 {string}  string  "返回一个无意义的 'ok'"
 	Unlock(ctx context.Context, username string) error
 }
+
+func NewRemoteLockedUsers(pxy *resty.Proxy) LockedUsers {
+	return LockedUsersClient{
+		Proxy: pxy,
+	}
+}
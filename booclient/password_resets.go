@@ -0,0 +1,47 @@
+//go:generate gogenv2 client -ext=.client-gen.go password_resets.go
+
+package booclient
+
+import (
+	"context"
+
+	"github.com/runner-mei/resty"
+)
+
+const (
+	// PasswordResetEmail 通过邮件发送重置密码的链接
+	PasswordResetEmail = "email"
+	// PasswordResetSMS 通过短信发送重置密码的链接
+	PasswordResetSMS = "sms"
+)
+
+// PasswordResets 是忘记密码时重置密码的接口，它们都不需要登录，
+// 服务端的路由是手工注册的，见 echosrv.InitPasswordResets
+type PasswordResets interface {
+	// @Summary 申请重置密码，一次性的重置链接会发送到用户的邮箱或手机上。
+	//          为了不泄露用户是否存在，用户不存在或没有邮箱和手机时也返回成功
+	// @Tags     Auth
+	// @Param    name        body string      true     "用户名、邮箱或手机号"
+	// @Param    channel     body string      false    "发送方式，可以为 email 或 sms，为空时优先用邮件"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /password_resets [post]
+	// @Success  200 {string} string  "返回一个无意义的 'OK' 字符串"
+	Request(ctx context.Context, name, channel string) error
+
+	// @Summary 用重置链接中的令牌设置新的密码，令牌只能使用一次
+	// @Tags     Auth
+	// @Param    token       body string      true     "重置链接中的令牌"
+	// @Param    password    body string      true     "新密码"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /password_resets/confirm [post]
+	// @Success  200 {string} string  "返回一个无意义的 'OK' 字符串"
+	Confirm(ctx context.Context, token, password string) error
+}
+
+func NewRemotePasswordResets(pxy *resty.Proxy) PasswordResets {
+	return PasswordResetsClient{
+		Proxy: pxy,
+	}
+}
@@ -6,20 +6,50 @@ package booclient
 import (
 	"context"
 	"time"
+
+	"github.com/boo-admin/boo/services/authn"
+	"github.com/runner-mei/resty"
 )
 
 type Role struct {
-	TableName   struct{}  `json:"-" xorm:"boo_user_roles"`
-	ID          int64     `json:"id" xorm:"id pk autoincr"`
-	UUID        string    `json:"uuid" xorm:"uuid unique notnull"`
-	Title       string    `json:"title" xorm:"title unique notnull"`
-	Description string    `json:"description,omitempty" xorm:"description clob null"`
-	CreatedAt   time.Time `json:"created_at,omitempty" xorm:"created_at created"`
-	UpdatedAt   time.Time `json:"updated_at,omitempty" xorm:"updated_at updated"`
+	TableName   struct{} `json:"-" xorm:"boo_user_roles"`
+	ID          int64    `json:"id" xorm:"id pk autoincr"`
+	UUID        string   `json:"uuid" xorm:"uuid unique notnull"`
+	Title       string   `json:"title" xorm:"title unique notnull"`
+	Description string   `json:"description,omitempty" xorm:"description clob null"`
+	DataScope   string   `json:"data_scope,omitempty" xorm:"data_scope null"`
+	// TwoFactorRequired 拥有该角色的用户必须开通两步验证
+	TwoFactorRequired bool      `json:"two_factor_required,omitempty" xorm:"two_factor_required null"`
+	CreatedAt         time.Time `json:"created_at,omitempty" xorm:"created_at created"`
+	UpdatedAt         time.Time `json:"updated_at,omitempty" xorm:"updated_at updated"`
 
 	IsDefault bool `json:"is_default,omitempty" xorm:"-"`
 }
 
+// 角色的数据范围，它决定了拥有该角色的用户可以看到哪些部门的用户和员工
+const (
+	// DataScopeAll 所有数据，空值等同于它
+	DataScopeAll = "all"
+	// DataScopeDepartment 本部门的数据
+	DataScopeDepartment = "department"
+	// DataScopeDepartmentAndChildren 本部门及下级部门的数据
+	DataScopeDepartmentAndChildren = "department_and_children"
+	// DataScopeCustom 自定义的部门的数据， 部门列表通过 SetDataDepartments 设置
+	DataScopeCustom = "custom"
+)
+
+// IsValidDataScope 判断数据范围是否合法
+func IsValidDataScope(scope string) bool {
+	switch scope {
+	case "", DataScopeAll, DataScopeDepartment, DataScopeDepartmentAndChildren, DataScopeCustom:
+		return true
+	}
+	return false
+}
+
+// Permission 权限定义
+type Permission = authn.Permission
+
 type Roles interface {
 	// @Summary 新建一个角色
 	// @Param    role     body Role    true     "角色定义"
@@ -80,4 +110,60 @@ type Roles interface {
 	// @Router  /roles [get]
 	// @Success 200 {array} Role  "返回所有角色"
 	List(ctx context.Context, keyword string, sort string, offset, limit int64) ([]Role, error)
+
+	// @Summary 查询所有可以授予角色的权限
+	// @Accept  json
+	// @Produce json
+	// @Router  /roles/permissions [get]
+	// @Success 200 {array} Permission  "返回所有权限"
+	ListPermissions(ctx context.Context) ([]Permission, error)
+
+	// @Summary 查询角色拥有的权限
+	// @Param   id            path int                       true     "角色ID"
+	// @Accept  json
+	// @Produce json
+	// @Router  /roles/{id}/permissions [get]
+	// @Success 200 {array} string  "返回角色拥有的权限ID"
+	GetPermissions(ctx context.Context, id int64) ([]string, error)
+
+	// @Summary 授予角色权限
+	// @Param   id            path int                       true     "角色ID"
+	// @Param   permissions   body []string                  true     "权限ID"
+	// @Accept  json
+	// @Produce json
+	// @Router  /roles/{id}/permissions [post]
+	// @Success 200 {string} string  "返回一个无意义的 'OK' 字符串"
+	GrantPermissions(ctx context.Context, id int64, permissions []string) error
+
+	// @Summary 收回角色的权限
+	// @Param   id            path int                       true     "角色ID"
+	// @Param   permissions   query []string                 true     "权限ID"
+	// @Accept  json
+	// @Produce json
+	// @Router  /roles/{id}/permissions [delete]
+	// @Success 200 {string} string  "返回一个无意义的 'OK' 字符串"
+	RevokePermissions(ctx context.Context, id int64, permissions []string) error
+
+	// @Summary 查询角色的自定义数据范围中的部门
+	// @Param   id            path int                       true     "角色ID"
+	// @Accept  json
+	// @Produce json
+	// @Router  /roles/{id}/data_departments [get]
+	// @Success 200 {array} int64  "返回部门ID"
+	GetDataDepartments(ctx context.Context, id int64) ([]int64, error)
+
+	// @Summary 设置角色的自定义数据范围中的部门，仅当数据范围为 custom 时有效
+	// @Param   id            path int                       true     "角色ID"
+	// @Param   departments   body []int64                   true     "部门ID"
+	// @Accept  json
+	// @Produce json
+	// @Router  /roles/{id}/data_departments [put]
+	// @Success 200 {string} string  "返回一个无意义的 'OK' 字符串"
+	SetDataDepartments(ctx context.Context, id int64, departments []int64) error
+}
+
+func NewRemoteRoles(pxy *resty.Proxy) Roles {
+	return RolesClient{
+		Proxy: pxy,
+	}
 }
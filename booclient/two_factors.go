@@ -0,0 +1,96 @@
+//go:generate gogenv2 server -ext=.server-gen.go two_factors.go
+//go:generate gogenv2 client -ext=.client-gen.go two_factors.go
+
+package booclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/runner-mei/resty"
+)
+
+// TwoFactorStatus 是用户两步验证的状态
+type TwoFactorStatus struct {
+	Enabled bool `json:"enabled"`
+	// Required 表示用户的角色要求必须启用两步验证
+	Required               bool      `json:"required"`
+	RecoveryCodesRemaining int       `json:"recovery_codes_remaining"`
+	EnabledAt              time.Time `json:"enabled_at,omitempty"`
+}
+
+// TwoFactorEnrollment 是开通两步验证时返回的密钥和恢复码，它们只会返回这一次
+type TwoFactorEnrollment struct {
+	Secret          string   `json:"secret"`
+	ProvisioningURI string   `json:"provisioning_uri"`
+	RecoveryCodes   []string `json:"recovery_codes"`
+}
+
+type TwoFactors interface {
+	// @Summary 查询当前用户的两步验证状态
+	// @Tags     Auth
+	// @Accept   json
+	// @Produce  json
+	// @Router   /two_factor [get]
+	// @Success  200 {object} TwoFactorStatus  "返回两步验证的状态"
+	Status(ctx context.Context) (*TwoFactorStatus, error)
+
+	// @Summary 为当前用户生成新的 TOTP 密钥和恢复码，需要调用 Activate 确认后才会生效
+	// @Tags     Auth
+	// @Accept   json
+	// @Produce  json
+	// @Router   /two_factor/enroll [post]
+	// @Success  200 {object} TwoFactorEnrollment  "返回密钥，二维码地址和恢复码"
+	Enroll(ctx context.Context) (*TwoFactorEnrollment, error)
+
+	// @Summary 用验证器上的验证码确认并启用两步验证
+	// @Tags     Auth
+	// @Param    code     body string      true     "验证码"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /two_factor/activate [post]
+	// @Success  200 {string} string  "返回一个无意义的 'OK' 字符串"
+	Activate(ctx context.Context, code string) error
+
+	// @Summary 关闭当前用户的两步验证
+	// @Tags     Auth
+	// @Param    code     body string      true     "验证码或恢复码"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /two_factor/disable [post]
+	// @Success  200 {string} string  "返回一个无意义的 'OK' 字符串"
+	Disable(ctx context.Context, code string) error
+
+	// @Summary 重新生成当前用户的恢复码，原来的恢复码将失效
+	// @Tags     Auth
+	// @Param    code     body string      true     "验证码"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /two_factor/recovery_codes [post]
+	// @Success  200 {array} string  "返回新的恢复码"
+	RegenerateRecoveryCodes(ctx context.Context, code string) ([]string, error)
+
+	// @Summary 查询指定用户的两步验证状态
+	// @Tags     Auth,Users
+	// @Param    userID     path int      true     "用户ID"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /two_factor/users/{userID} [get]
+	// @Success  200 {object} TwoFactorStatus  "返回两步验证的状态"
+	StatusByUserID(ctx context.Context, userID int64) (*TwoFactorStatus, error)
+
+	// @Summary 重置指定用户的两步验证，用户丢失验证器时使用
+	// @Tags     Auth,Users
+	// @Param    userID     path int      true     "用户ID"
+	// @Accept   json
+	// @Produce  json
+	// @Router   /two_factor/users/{userID} [delete]
+	// @Success  200 {string} string  "返回一个无意义的 'OK' 字符串"
+	ResetByUserID(ctx context.Context, userID int64) error
+}
+
+func NewRemoteTwoFactors(pxy *resty.Proxy) TwoFactors {
+	return TwoFactorsClient{
+		Proxy: pxy,
+	}
+}
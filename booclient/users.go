@@ -124,6 +124,7 @@ type User struct {
 	Nickname               string                 `json:"nickname" xorm:"nickname unique notnull"`
 	Password               string                 `json:"password,omitempty" xorm:"password null"`
 	LastPasswordModifiedAt time.Time              `json:"last_password_modified_at,omitempty" xorm:"last_password_modified_at null"`
+	MustChangePassword     bool                   `json:"must_change_password,omitempty" xorm:"must_change_password null"`
 	Description            string                 `json:"description,omitempty" xorm:"description clob null"`
 	Source                 string                 `json:"source,omitempty" xorm:"source null"`
 	Disabled               bool                   `json:"disabled,omitempty" xorm:"disabled null"`
@@ -147,6 +148,10 @@ func (u *User) GetPhone() string {
 	return u.getString(Telephone.ID)
 }
 
+func (u *User) GetMobile() string {
+	return u.getString(Mobile.ID)
+}
+
 func (u *User) GetEmail() string {
 	return u.getString(Email.ID)
 }
@@ -231,6 +236,24 @@ func (u *User) GetBoolWithDefault(key string, defaultValue bool) bool {
 	return as.BoolWithDefault(o, defaultValue)
 }
 
+// PasswordPolicy 是密码策略的说明，用于界面提示用户密码的要求
+type PasswordPolicy struct {
+	MinLength int `json:"min_length"`
+	MaxLength int `json:"max_length,omitempty"`
+	// MinClasses 至少要包含小写字母、大写字母、数字和特殊字符中的几种
+	MinClasses int `json:"min_classes,omitempty"`
+	// BanUsername 密码中不能包含用户名和呢称
+	BanUsername bool `json:"ban_username,omitempty"`
+	// MinScore 是密码强度的最低分数，从 1 到 5
+	MinScore int `json:"min_score,omitempty"`
+	// History 新密码不能和最近几次使用过的密码相同
+	History int `json:"history,omitempty"`
+	// MaxAgeDays 密码的最长有效天数，过期后必须修改密码才能继续使用
+	MaxAgeDays int `json:"max_age_days,omitempty"`
+	// Rules 是各个规则的文字说明，界面可以直接显示
+	Rules []string `json:"rules,omitempty"`
+}
+
 type Users interface {
 	// @Summary 新建一个用户
 	// @Param    user     body User    true     "用户定义"
@@ -250,7 +273,7 @@ type Users interface {
 	// @Success 200 {string} string  "返回一个无意义的 'OK' 字符串"
 	UpdateByID(ctx context.Context, id int64, user *User, mode UpdateMode) error
 
-	// @Summary 修改用户的密码
+	// @Summary 管理员重置用户的密码，不能用于修改自已的密码，修改自已的密码请用 ChangeMyPassword
 	// @Param    id           path int         true     "用户ID"
 	// @Param    password     body string      true     "用户新密码"
 	// @Accept   json
@@ -259,6 +282,23 @@ type Users interface {
 	// @Success 200 {string} string  "返回一个无意义的 'OK' 字符串"
 	ChangePassword(ctx context.Context, id int64, password string) error
 
+	// @Summary 当前用户修改自已的密码，需要校验原密码
+	// @Param    old_password      body string      true     "原密码"
+	// @Param    new_password      body string      true     "新密码"
+	// @Param    logout_others     body bool        false    "是否注销自已的其它在线会话"
+	// @Accept   json
+	// @Produce  json
+	// @Router /users/me/password [put]
+	// @Success 200 {string} string  "返回一个无意义的 'OK' 字符串"
+	ChangeMyPassword(ctx context.Context, oldPassword, newPassword string, logoutOthers bool) error
+
+	// @Summary 返回密码策略
+	// @Accept   json
+	// @Produce  json
+	// @Router /users/password_policy [get]
+	// @Success 200 {object} PasswordPolicy  "返回密码策略"
+	PasswordPolicy(ctx context.Context) (*PasswordPolicy, error)
+
 	// @Summary 删除指定的用户
 	// @Param   id            path  int                       true     "用户ID"
 	// @Param   force         query bool                      true     "是软删除还是真删除"
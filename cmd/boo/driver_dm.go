@@ -0,0 +1,8 @@
+//go:build dm
+// +build dm
+
+package main
+
+// 达梦的驱动比较大，只有加上 dm 标签编译时才注册它，例如
+//   go build -tags dm ./cmd/boo
+import _ "gitee.com/chunanyong/dm"
@@ -7,7 +7,9 @@ import (
 	"github.com/boo-admin/boo"
 	"github.com/boo-admin/boo/booclient"
 	"github.com/boo-admin/boo/engine/echosrv"
+	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/exp/slog"
 )
 
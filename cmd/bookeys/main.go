@@ -0,0 +1,152 @@
+// bookeys 用于轮换和停用 cookie 和 jwt 的签名密钥，密钥文件的位置从配置中的
+// users.cookie.keys_file 和 auth.jwt.keys_file 读取，服务会自动加载修改后的密钥文件。
+//
+//	bookeys cookie list
+//	bookeys cookie rotate -grace 24h
+//	bookeys jwt retire -id 0a1b2c3d4e5f -grace 1h
+//	bookeys jwt prune
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn/jwt_auth"
+	"github.com/boo-admin/boo/services/authn/keyring"
+	"github.com/boo-admin/boo/services/authn/session_auth"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "用法: bookeys <cookie|jwt> <list|rotate|retire|prune> [选项]")
+	fmt.Fprintln(os.Stderr, "  list    列出所有密钥")
+	fmt.Fprintln(os.Stderr, "  rotate  生成新的签名密钥，原来的密钥在宽限期后过期")
+	fmt.Fprintln(os.Stderr, "  retire  让指定的密钥在宽限期后过期")
+	fmt.Fprintln(os.Stderr, "  prune   删除已过期的密钥")
+}
+
+func main() {
+	if len(os.Args) < 3 {
+		usage()
+		os.Exit(2)
+		return
+	}
+	target, action := os.Args[1], os.Args[2]
+
+	flags := flag.NewFlagSet("bookeys "+target+" "+action, flag.ExitOnError)
+	config := flags.String("config", "app.properties", "配置文件")
+	grace := flags.Duration("grace", 24*time.Hour, "旧密钥的宽限期，在此期间用旧密钥签名的 cookie 或 token 仍然有效")
+	id := flags.String("id", "", "要停用的密钥 ID")
+	flags.Parse(os.Args[3:])
+
+	if err := run(target, action, *config, *grace, *id); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(target, action, config string, grace time.Duration, id string) error {
+	env, err := booclient.NewEnvironmentWith("boo", config, nil)
+	if err != nil {
+		return err
+	}
+
+	var cfgKey string
+	var generate func() (string, error)
+	switch target {
+	case "cookie":
+		cfgKey = session_auth.CfgUserSessionKeysFile
+		generate = keyring.GenerateSecret
+	case "jwt":
+		cfgKey = jwt_auth.CfgJwtKeysFile
+		alg := env.Config.StringWithDefault(jwt_auth.CfgJwtAlg, "")
+		if alg == "" {
+			return fmt.Errorf("没有配置 jwt 的签名算法 '%s'", jwt_auth.CfgJwtAlg)
+		}
+		generate = func() (string, error) {
+			return jwt_auth.GenerateKey(alg)
+		}
+	default:
+		usage()
+		return fmt.Errorf("未知的密钥类型 '%s'", target)
+	}
+
+	filename := env.Config.StringWithDefault(cfgKey, "")
+	if filename == "" {
+		return fmt.Errorf("没有配置密钥文件 '%s'", cfgKey)
+	}
+	filename = booclient.GetRealDir(context.Background(), env, filename)
+
+	ring, err := keyring.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) || action != "rotate" {
+			return err
+		}
+		ring = &keyring.Keyring{}
+	}
+
+	now := time.Now()
+	switch action {
+	case "list":
+		return list(ring, now)
+	case "rotate":
+		secret, err := generate()
+		if err != nil {
+			return err
+		}
+		key := ring.Rotate(secret, grace, now)
+		if err := keyring.WriteFile(filename, ring); err != nil {
+			return err
+		}
+		fmt.Println("新的签名密钥为", key.ID)
+		if len(ring.Keys) > 1 {
+			fmt.Println("旧的密钥将在", now.Add(grace).Format(time.RFC3339), "过期")
+		}
+	case "retire":
+		if id == "" {
+			return fmt.Errorf("请用 -id 指定要停用的密钥")
+		}
+		if err := ring.Retire(id, grace, now); err != nil {
+			return err
+		}
+		if err := keyring.WriteFile(filename, ring); err != nil {
+			return err
+		}
+		fmt.Println("密钥", id, "将在", now.Add(grace).Format(time.RFC3339), "过期")
+	case "prune":
+		count := ring.Prune(now)
+		if err := keyring.WriteFile(filename, ring); err != nil {
+			return err
+		}
+		fmt.Println("删除了", count, "个已过期的密钥")
+	default:
+		usage()
+		return fmt.Errorf("未知的命令 '%s'", action)
+	}
+	return nil
+}
+
+func list(ring *keyring.Keyring, now time.Time) error {
+	current, _ := ring.Current(now)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\t创建时间\t过期时间\t状态")
+	for _, key := range ring.Keys {
+		expiresAt := "-"
+		if key.ExpiresAt != nil {
+			expiresAt = key.ExpiresAt.Format(time.RFC3339)
+		}
+		status := "有效"
+		if key.ID == current.ID {
+			status = "签名"
+		} else if !key.IsActive(now) {
+			status = "已过期"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key.ID, key.CreatedAt.Format(time.RFC3339), expiresAt, status)
+	}
+	return w.Flush()
+}
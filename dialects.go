@@ -0,0 +1,43 @@
+package boo
+
+import (
+	"fmt"
+
+	"github.com/runner-mei/GoBatis/dialects"
+)
+
+// Sqlite 是 sqlite 的方言， gobatis 没有内置它，不注册时 sqlite 会使用 dialects.None,
+// 这时 dao 中的 @sqlite 语句不会生效，分页的语法也不对
+var Sqlite dialects.Dialect = &sqliteDialect{Dialect: dialects.None}
+
+type sqliteDialect struct {
+	dialects.Dialect
+}
+
+func (d *sqliteDialect) Name() string {
+	return "sqlite"
+}
+
+// Limit sqlite 只支持 LIMIT m OFFSET n 的写法
+func (d *sqliteDialect) Limit(offset, limit int64) string {
+	if offset > 0 {
+		if limit > 0 {
+			return fmt.Sprintf(" LIMIT %d OFFSET %d ", limit, offset)
+		}
+		return fmt.Sprintf(" LIMIT -1 OFFSET %d ", offset)
+	}
+	if limit > 0 {
+		return fmt.Sprintf(" LIMIT %d ", limit)
+	}
+	return ""
+}
+
+func init() {
+	dialects.RegisterDialectFactory(func(driverName string) dialects.Dialect {
+		switch driverName {
+		case "sqlite", "sqlite3":
+			return Sqlite
+		}
+		return dialects.None
+	})
+}
@@ -3,6 +3,7 @@ package echofunctions
 import (
 	"context"
 	"net/http"
+	"sync"
 
 	"github.com/boo-admin/boo/services/authn"
 	"github.com/labstack/echo/v4"
@@ -24,6 +25,19 @@ func SetContext(ctx echo.Context, stdctx context.Context) {
 	ctx.Set("stdcontext", stdctx)
 }
 
+var anonymousRoutes sync.Map
+
+// AllowAnonymous 标记指定的路由不需要认证，如登录页面
+func AllowAnonymous(route *echo.Route) *echo.Route {
+	anonymousRoutes.Store(route.Method+" "+route.Path, struct{}{})
+	return route
+}
+
+func IsAnonymous(ctx echo.Context) bool {
+	_, ok := anonymousRoutes.Load(ctx.Request().Method + " " + ctx.Path())
+	return ok
+}
+
 func HTTPAuth(returnError func(echo.Context, string, int) error, validateFns ...authn.AuthValidateFunc) echo.MiddlewareFunc {
 	if returnError == nil {
 		returnError = func(ctx echo.Context, err string, statusCode int) error {
@@ -36,10 +50,17 @@ func HTTPAuth(returnError func(echo.Context, string, int) error, validateFns ...
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(ctx echo.Context) error {
+			if IsAnonymous(ctx) {
+				return next(ctx)
+			}
+
 			nctx := GetContext(ctx)
 			for _, fn := range validateFns {
 				nctx, err := fn(nctx, ctx.Request())
 				if err == nil {
+					if cookie := authn.SetCookieFromContext(nctx); cookie != nil {
+						ctx.SetCookie(cookie)
+					}
 					SetContext(ctx, nctx)
 					return next(ctx)
 				}
@@ -52,3 +73,59 @@ func HTTPAuth(returnError func(echo.Context, string, int) error, validateFns ...
 		}
 	}
 }
+
+// PasswordChangeCheck 当前用户必须修改密码时(如密码已过期或者被管理员重置)，只允许访问
+// allowedPaths 中的路由，allowedPaths 为去掉 prefix 后的路由模板，如 "/users/me/password",
+// 它加上 prefix 后要和 ctx.Path() 完全相同
+func PasswordChangeCheck(returnError func(echo.Context, string, int) error, prefix string, allowedPaths ...string) echo.MiddlewareFunc {
+	return restrictedCheck(returnError, authn.ErrPasswordChangeRequired, func(currentUser authn.AuthUser) bool {
+		checker, ok := currentUser.(authn.PasswordChangeChecker)
+		return ok && checker.IsPasswordChangeRequired()
+	}, prefix, allowedPaths)
+}
+
+// TwoFactorEnrollCheck 当前用户的角色要求两步验证但还没有开通时，只允许访问
+// allowedPaths 中的路由，allowedPaths 的格式同 PasswordChangeCheck
+func TwoFactorEnrollCheck(returnError func(echo.Context, string, int) error, prefix string, allowedPaths ...string) echo.MiddlewareFunc {
+	return restrictedCheck(returnError, authn.ErrTwoFactorEnrollRequired, func(currentUser authn.AuthUser) bool {
+		checker, ok := currentUser.(authn.TwoFactorEnrollChecker)
+		return ok && checker.IsTwoFactorEnrollRequired()
+	}, prefix, allowedPaths)
+}
+
+// restrictedCheck 当 isRestricted 返回 true 时只允许当前用户访问 allowedPaths 中的路由
+func restrictedCheck(returnError func(echo.Context, string, int) error, restrictedErr error, isRestricted func(authn.AuthUser) bool, prefix string, allowedPaths []string) echo.MiddlewareFunc {
+	if returnError == nil {
+		returnError = func(ctx echo.Context, err string, statusCode int) error {
+			return ctx.JSON(statusCode, map[string]interface{}{
+				"code":  statusCode,
+				"error": err,
+			})
+		}
+	}
+
+	allowed := make(map[string]struct{}, len(allowedPaths))
+	for _, path := range allowedPaths {
+		allowed[prefix+path] = struct{}{}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if IsAnonymous(ctx) {
+				return next(ctx)
+			}
+			currentUser, err := authn.ReadUserFromContext(GetContext(ctx))
+			if err != nil {
+				return next(ctx)
+			}
+			if !isRestricted(currentUser) {
+				return next(ctx)
+			}
+
+			if _, ok := allowed[ctx.Path()]; ok {
+				return next(ctx)
+			}
+			return returnError(ctx, restrictedErr.Error(), http.StatusForbidden)
+		}
+	}
+}
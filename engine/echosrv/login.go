@@ -0,0 +1,295 @@
+package echosrv
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/boo-admin/boo"
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/engine/echofunctions"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/goutils/as"
+	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/services/authn/jwt_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_store"
+	"github.com/boo-admin/boo/services/users"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/exp/slog"
+)
+
+func NewLoginHandler(srv *boo.Server) (*session_auth.LoginHandler, error) {
+	cookieOpt, err := session_auth.NewOption(srv.Env)
+	if err != nil {
+		return nil, errors.Wrap(err, "init session cookie")
+	}
+	limits, err := session_auth.NewSessionLimits(srv.Env)
+	if err != nil {
+		return nil, errors.Wrap(err, "init session limits")
+	}
+
+	return &session_auth.LoginHandler{
+		Logger:          srv.Env.Logger.WithGroup("login"),
+		Auth:            srv.AuthService,
+		Users:           srv.LoginUsers,
+		Onlines:         srv.Onlines,
+		OnlineApiKey:    srv.Env.Config.StringWithDefault(session_store.CfgSessionRemoteApiKey, ""),
+		Cookie:          cookieOpt,
+		Limits:          limits,
+		OperationLogger: srv.OperationLogger,
+		IssueToken: func(ctx context.Context, userID interface{}, username string, roles []string, sessionID string) (*jwt_auth.TokenPair, error) {
+			return srv.JWTAuth.IssueTokenPair(as.Int64WithDefault(userID, 0), username, roles, sessionID)
+		},
+		RevokeToken: func(ctx context.Context, req *http.Request) error {
+			return RevokeTokens(ctx, srv, req)
+		},
+	}, nil
+}
+
+// CheckToken 检查 jwt 是否已被吊销，以及它对应的会话是否还在线
+func CheckToken(ctx context.Context, srv *boo.Server, claims *jwt_auth.UserClaims) (context.Context, error) {
+	if srv.TokenRevocations != nil && claims.ID != "" {
+		revoked, err := srv.TokenRevocations.IsRevoked(ctx, claims.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "查询 token 是否已吊销失败")
+		}
+		if revoked {
+			return nil, authn.ErrTokenExpired
+		}
+	}
+	ctx, err := CheckOnline(ctx, srv, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	return jwt_auth.ContextWithClaims(ctx, claims), nil
+}
+
+// RevokeTokens 吊销当前请求的访问令牌，以及请求参数中的刷新令牌
+func RevokeTokens(ctx context.Context, srv *boo.Server, req *http.Request) error {
+	if claims := jwt_auth.ClaimsFromContext(ctx); claims != nil {
+		if err := jwt_auth.RevokeClaims(ctx, srv.TokenRevocations, claims); err != nil {
+			return err
+		}
+	}
+	if s := req.FormValue("refresh_token"); s != "" {
+		// 已过期或无效的刷新令牌本身就不能用了，不需要吊销
+		if claims, err := srv.JWTAuth.ParseRefreshToken(s); err == nil {
+			return jwt_auth.RevokeClaims(ctx, srv.TokenRevocations, claims)
+		}
+	}
+	return nil
+}
+
+// RefreshToken 用刷新令牌换取新的访问令牌和刷新令牌，旧的刷新令牌会被吊销。
+// 已吊销的刷新令牌被再次使用时说明它可能已泄露，这时会注销它对应的会话
+func RefreshToken(ctx context.Context, srv *boo.Server, refreshToken string) (*jwt_auth.TokenPair, error) {
+	if refreshToken == "" {
+		return nil, errors.WithCode(errors.New("refresh_token 不能为空"), http.StatusBadRequest)
+	}
+	claims, err := srv.JWTAuth.ParseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	reused := func() (*jwt_auth.TokenPair, error) {
+		if claims.SessionID != "" {
+			if err := srv.Onlines.LogoutBySessionID(ctx, claims.SessionID); err != nil {
+				return nil, errors.Wrap(err, "删除在线会话失败")
+			}
+		}
+		srv.Env.Logger.WarnContext(ctx, "已吊销的刷新令牌被再次使用，注销它对应的会话",
+			slog.String("username", claims.Username),
+			slog.String("session_id", claims.SessionID))
+		return nil, authn.ErrTokenExpired
+	}
+
+	if srv.TokenRevocations != nil {
+		revoked, err := srv.TokenRevocations.IsRevoked(ctx, claims.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "查询 token 是否已吊销失败")
+		}
+		if revoked {
+			return reused()
+		}
+	}
+	if _, err := CheckOnline(ctx, srv, claims.SessionID); err != nil {
+		return nil, err
+	}
+	if srv.TokenRevocations != nil {
+		// 并发使用同一个刷新令牌时只有一个请求能吊销成功，其它的都当作重复使用
+		ok, err := srv.TokenRevocations.Revoke(ctx, claims.ID, claims.ExpiresTime())
+		if err != nil {
+			return nil, errors.Wrap(err, "吊销旧的刷新令牌失败")
+		}
+		if !ok {
+			return reused()
+		}
+	}
+
+	// 重新读取用户，用户被禁用或删除后不能再刷新，角色变化后新的令牌中也会更新
+	var user authn.AuthUser
+	if claims.UserID > 0 {
+		user, err = srv.AuthUsers.UserByID(ctx, claims.UserID)
+	} else {
+		user, err = srv.AuthUsers.UserByName(ctx, claims.Username)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return srv.JWTAuth.IssueTokenPair(user.ID(), user.Name(), user.RoleNames(), claims.SessionID)
+}
+
+func returnError(c echo.Context, err error, code ...int) error {
+	encodedError := errors.ToEncodeError(err, code...)
+	return c.JSON(encodedError.HTTPCode(), encodedError)
+}
+
+// InitLogin 注册登录，注销和查询当前用户的路由，其中登录是不需要认证的
+func InitLogin(mux *echo.Group, h *session_auth.LoginHandler) {
+	echofunctions.AllowAnonymous(mux.POST("/login", func(c echo.Context) error {
+		var request session_core.LoginRequest
+		if err := c.Bind(&request); err != nil {
+			return returnError(c, err, http.StatusBadRequest)
+		}
+		loginType, err := session_core.ParseLoginType(c.FormValue("login_type"))
+		if err != nil {
+			return returnError(c, err, http.StatusBadRequest)
+		}
+		request.LoginType = loginType
+
+		result, err := h.Login(echofunctions.GetContext(c), c.Response(), c.Request(), &request)
+		if err != nil {
+			return returnError(c, err, http.StatusUnauthorized)
+		}
+		return c.JSON(http.StatusOK, result)
+	}))
+
+	mux.POST("/logout", func(c echo.Context) error {
+		err := h.Logout(echofunctions.GetContext(c), c.Response(), c.Request())
+		if err != nil {
+			return returnError(c, err)
+		}
+		return c.JSON(http.StatusOK, "ok")
+	})
+
+	mux.GET("/me", func(c echo.Context) error {
+		ctx := echofunctions.GetContext(c)
+		currentUser, err := authn.ReadUserFromContext(ctx)
+		if err != nil {
+			return returnError(c, err, http.StatusUnauthorized)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"id":         currentUser.ID(),
+			"name":       currentUser.Name(),
+			"nickname":   currentUser.Nickname(),
+			"roles":      currentUser.RoleNames(),
+			"session_id": session_auth.SessionIDFromContext(ctx),
+		})
+	})
+}
+
+type tokenRequest struct {
+	session_core.LoginRequest
+
+	GrantType    string `json:"grant_type" xml:"grant_type" form:"grant_type" query:"grant_type"`
+	RefreshToken string `json:"refresh_token" xml:"refresh_token" form:"refresh_token" query:"refresh_token"`
+}
+
+// InitToken 注册签发 jwt 的路由和公布 jwt 公钥的 JWKS 路由，它们是不需要认证的
+//
+// POST /token 的 grant_type 为 password 时用用户名和密码登录，为 refresh_token 时用刷新令牌换取新的令牌
+func InitToken(mux *echo.Group, srv *boo.Server, h *session_auth.LoginHandler) {
+	echofunctions.AllowAnonymous(mux.POST("/token", func(c echo.Context) error {
+		ctx := echofunctions.GetContext(c)
+
+		var request tokenRequest
+		if err := c.Bind(&request); err != nil {
+			return returnError(c, err, http.StatusBadRequest)
+		}
+
+		switch request.GrantType {
+		case "", "password":
+			request.LoginRequest.Address = ""
+			request.LoginRequest.LoginType = session_core.TokenJWT
+			result, err := h.Login(ctx, c.Response(), c.Request(), &request.LoginRequest)
+			if err != nil {
+				return returnError(c, err, http.StatusUnauthorized)
+			}
+			return c.JSON(http.StatusOK, result.Data)
+		case "refresh_token":
+			pair, err := RefreshToken(ctx, srv, request.RefreshToken)
+			if err != nil {
+				return returnError(c, err, http.StatusUnauthorized)
+			}
+			return c.JSON(http.StatusOK, pair)
+		default:
+			return returnError(c, errors.New("grant_type '"+request.GrantType+"' 不支持"), http.StatusBadRequest)
+		}
+	}))
+
+	echofunctions.AllowAnonymous(mux.GET("/.well-known/jwks.json", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, srv.JWTAuth.JWKS())
+	}))
+}
+
+// InitPasswordResets 注册忘记密码时重置密码的路由，它们是不需要认证的
+func InitPasswordResets(mux *echo.Group, svc users.PasswordResets) {
+	echofunctions.AllowAnonymous(mux.POST("/password_resets", func(c echo.Context) error {
+		var request struct {
+			Name    string `json:"name" form:"name"`
+			Channel string `json:"channel" form:"channel"`
+		}
+		if err := c.Bind(&request); err != nil {
+			return returnError(c, err, http.StatusBadRequest)
+		}
+		err := svc.Request(echofunctions.GetContext(c), request.Name, request.Channel, booclient.RealIP(c.Request()))
+		if err != nil {
+			return returnError(c, err)
+		}
+		return c.JSON(http.StatusOK, "ok")
+	}))
+
+	echofunctions.AllowAnonymous(mux.POST("/password_resets/confirm", func(c echo.Context) error {
+		var request struct {
+			Token    string `json:"token" form:"token"`
+			Password string `json:"password" form:"password"`
+		}
+		if err := c.Bind(&request); err != nil {
+			return returnError(c, err, http.StatusBadRequest)
+		}
+		err := svc.Confirm(echofunctions.GetContext(c), request.Token, request.Password)
+		if err != nil {
+			return returnError(c, err)
+		}
+		return c.JSON(http.StatusOK, "ok")
+	}))
+}
+
+// InitExternalLogin 注册外部认证服务（如 OIDC 和 CAS）的登录路由 /login/<name> 和 /login/<name>/callback,
+// 登录成功后跳转到登录前的页面，没有时跳转到 defaultURL
+func InitExternalLogin(mux *echo.Group, h *session_auth.LoginHandler, name string, external session_auth.ExternalLogin, defaultURL string) {
+	echofunctions.AllowAnonymous(mux.GET("/login/"+name, func(c echo.Context) error {
+		err := external.Redirect(echofunctions.GetContext(c), c.Response(), c.Request())
+		if err != nil {
+			return returnError(c, err)
+		}
+		return nil
+	}))
+
+	echofunctions.AllowAnonymous(mux.GET("/login/"+name+"/callback", func(c echo.Context) error {
+		ctx := echofunctions.GetContext(c)
+		identity, returnURL, err := external.Callback(ctx, c.Response(), c.Request())
+		if err != nil {
+			return returnError(c, err, http.StatusUnauthorized)
+		}
+		_, err = h.LoginWithIdentity(ctx, c.Response(), c.Request(), identity, session_core.TokenNone)
+		if err != nil {
+			return returnError(c, err, http.StatusUnauthorized)
+		}
+		if returnURL == "" {
+			returnURL = defaultURL
+		}
+		return c.Redirect(http.StatusFound, returnURL)
+	}))
+}
@@ -13,8 +13,12 @@ import (
 	"github.com/boo-admin/boo/goutils/httpext"
 	"github.com/boo-admin/boo/services/authn"
 	"github.com/boo-admin/boo/services/authn/base_auth"
+	"github.com/boo-admin/boo/services/authn/cas_auth"
 	"github.com/boo-admin/boo/services/authn/jwt_auth"
+	"github.com/boo-admin/boo/services/authn/oidc_auth"
 	"github.com/boo-admin/boo/services/authn/session_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_store"
+	"github.com/boo-admin/boo/services/authn/token_auth"
 	"github.com/boo-admin/boo/services/docs"
 	"github.com/boo-admin/boo/services/users"
 	"github.com/golang-jwt/jwt/v4"
@@ -26,6 +30,27 @@ import (
 
 var middlewares []echo.MiddlewareFunc
 
+// PasswordChangeAllowedPaths 用户必须修改密码时仍然可以访问的路由
+var PasswordChangeAllowedPaths = []string{
+	"/users/me/password",
+	"/users/password_policy",
+	"/sessions/mine",
+	"/sessions/mine/:uuid",
+	"/logout",
+	"/me",
+}
+
+// TwoFactorEnrollAllowedPaths 用户的角色要求两步验证但还没有开通时仍然可以访问的路由
+var TwoFactorEnrollAllowedPaths = []string{
+	"/two_factor",
+	"/two_factor/enroll",
+	"/two_factor/activate",
+	"/sessions/mine",
+	"/sessions/mine/:uuid",
+	"/logout",
+	"/me",
+}
+
 func Use(middleware ...echo.MiddlewareFunc) {
 	middlewares = append(middlewares, middleware...)
 }
@@ -77,6 +102,7 @@ func New(srv *boo.Server, prefix string) (*echo.Echo, error) {
 	EnalbeSwaggerAt(mux, "/swagger", docs.SwaggerInfobooswagger.InstanceName())
 	booclient.InitOperationQueryer(mux, srv.OperationQueryer)
 	booclient.InitDepartments(mux, srv.Departments)
+	users.InitDepartmentsForHTTP(mux, srv.Departments)
 	booclient.InitUsers(mux, srv.Users)
 	booclient.InitUserTags(mux, srv.UserTags)
 	users.InitUsersForHTTP(mux, srv.Users)
@@ -84,32 +110,69 @@ func New(srv *boo.Server, prefix string) (*echo.Echo, error) {
 	booclient.InitEmployees(mux, srv.Employees)
 	users.InitEmployeesForHTTP(mux, srv.Employees)
 	booclient.InitEmployeeTags(mux, srv.EmployeeTags)
+	booclient.InitCustomFields(mux, srv.CustomFields)
+	booclient.InitLockedUsers(mux, srv.LockedUsers)
+	booclient.InitSessions(mux, srv.Sessions)
+	booclient.InitTwoFactors(mux, srv.TwoFactors)
+	booclient.InitAccessTokens(mux, srv.AccessTokens)
+	booclient.InitLdapSync(mux, srv.LdapSync)
+
+	loginHandler, err := NewLoginHandler(srv)
+	if err != nil {
+		return nil, err
+	}
+	InitLogin(mux, loginHandler)
+	InitToken(mux, srv, loginHandler)
+	InitPasswordResets(mux, srv.PasswordResets)
+
+	if srv.Env.Config.BoolWithDefault(oidc_auth.CfgOidcEnabled, false) {
+		oidcHandler, err := oidc_auth.NewHandlerFromEnv(srv.Env)
+		if err != nil {
+			return nil, errors.Wrap(err, "init oidc login")
+		}
+		InitExternalLogin(mux, loginHandler, "oidc", oidcHandler, srv.Env.AppPathWithSlash)
+	}
+	if srv.Env.Config.BoolWithDefault(cas_auth.CfgCasEnabled, false) {
+		casHandler, err := cas_auth.NewHandlerFromEnv(srv.Env)
+		if err != nil {
+			return nil, errors.Wrap(err, "init cas login")
+		}
+		InitExternalLogin(mux, loginHandler, "cas", casHandler, srv.Env.AppPathWithSlash)
+	}
 
 	return e, nil
 }
 
-func Run(srv *boo.Server, prefix, listenAt string) error {
-	engine, err := New(srv, prefix)
-	if err != nil {
-		return err
+// CheckOnline 检查会话是否还在线，并更新它的存活时间
+func CheckOnline(ctx context.Context, srv *boo.Server, sessionID string) (context.Context, error) {
+	if sessionID == "" {
+		return ctx, nil
+	}
+	apiKey := srv.Env.Config.StringWithDefault(session_store.CfgSessionRemoteApiKey, "")
+	if err := srv.Onlines.UpdateNow(ctx, sessionID, apiKey); err != nil {
+		if err == session_auth.ErrSessionNotExists || err == session_auth.ErrSessionExpired {
+			return nil, authn.ErrTokenExpired
+		}
+		return nil, err
 	}
+	return session_auth.ContextWithSessionID(ctx, sessionID), nil
+}
 
+func Run(srv *boo.Server, prefix, listenAt string) error {
 	jwtUser := func(ctx context.Context, req *http.Request, token *jwt.Token) (context.Context, error) {
-		claims, ok := token.Claims.(*jwt.StandardClaims)
+		claims, ok := token.Claims.(*jwt_auth.UserClaims)
 		if !ok {
-			return nil, errors.New("claims not jwt.StandardClaims")
+			return nil, errors.New("claims not jwt_auth.UserClaims")
 		}
 
-		ss := strings.SplitN(claims.Audience, " ", 2)
-		if len(ss) < 2 {
-			return nil, errors.New("Audience '" + claims.Audience + "' is invalid")
+		ctx, err := CheckToken(ctx, srv, claims)
+		if err != nil {
+			return nil, err
 		}
-		// userid := ss[0]
-		username := ss[1]
-
-		return authn.ContextWithReadCurrentUser(ctx, authn.ReadCurrentUserFunc(func(ctx context.Context) (authn.AuthUser, error) {
-			return authn.NewMockUser(username), nil
-		})), nil
+		if claims.UserID > 0 {
+			return srv.AuthUsers.ContextWithUserByID(ctx, claims.UserID), nil
+		}
+		return srv.AuthUsers.ContextWithUserByName(ctx, claims.Username), nil
 	}
 	jwtAuth, err := jwt_auth.New(srv.Env, jwtUser)
 	if err != nil {
@@ -118,9 +181,12 @@ func Run(srv *boo.Server, prefix, listenAt string) error {
 
 	sessionUser := func(ctx context.Context, req *http.Request, values url.Values) (context.Context, error) {
 		username := values.Get(session_auth.SESSION_USER_KEY)
-		return authn.ContextWithReadCurrentUser(ctx, authn.ReadCurrentUserFunc(func(ctx context.Context) (authn.AuthUser, error) {
-			return authn.NewMockUser(username), nil
-		})), nil
+
+		ctx, err := CheckOnline(ctx, srv, values.Get(session_auth.SESSION_ID_KEY))
+		if err != nil {
+			return nil, err
+		}
+		return srv.AuthUsers.ContextWithUserByName(ctx, username), nil
 	}
 	sessionAuth, err := session_auth.New(srv.Env, sessionUser)
 	if err != nil {
@@ -128,27 +194,44 @@ func Run(srv *boo.Server, prefix, listenAt string) error {
 	}
 
 	validator := func(ctx context.Context, req *http.Request, username string, password string) (context.Context, error) {
-		if username == "admin" && password == "admin" {
-			user := authn.NewMockUser("admin")
-			return authn.ContextWithReadCurrentUser(ctx,
-				authn.ReadCurrentUserFunc(func(stdctx context.Context) (authn.AuthUser, error) {
-					return user, nil
-				})), nil
+		user, err := srv.AuthUsers.Verify(ctx, username, password, booclient.RealIP(req))
+		if err != nil {
+			return ctx, err
 		}
-		return ctx, authn.ErrInvalidCredentials
+		return authn.ContextWithUser(ctx, user), nil
 	}
 	baseAuth /* , err */ := base_auth.Verify(validator)
 	// if err != nil {
 	// 	return errors.Wrap(err, "init base auth")
 	// }
 
+	tokenAuth := token_auth.Verify(func(ctx context.Context, req *http.Request, token string) (context.Context, error) {
+		user, err := srv.AccessTokens.Verify(ctx, token, booclient.RealIP(req))
+		if err != nil {
+			return ctx, err
+		}
+		return authn.ContextWithUser(ctx, user), nil
+	})
+
 	var validateFns = []authn.AuthValidateFunc{
 		jwtAuth,
 		sessionAuth,
+		tokenAuth,
 		baseAuth,
 	}
 	Use(echofunctions.HTTPAuth(nil, validateFns...))
+	Use(echofunctions.PasswordChangeCheck(nil, prefix, PasswordChangeAllowedPaths...))
+	Use(echofunctions.TwoFactorEnrollCheck(nil, prefix, TwoFactorEnrollAllowedPaths...))
+
+	engine, err := New(srv, prefix)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.LdapSync.Run(ctx)
 
 	runner := httpext.NewRunner(srv.Env.Logger, listenAt)
-	return runner.Run(context.Background(), engine)
+	return runner.Run(ctx, engine)
 }
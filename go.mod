@@ -4,18 +4,21 @@ go 1.20
 
 require (
 	gitee.com/Trisia/gotlcp v1.3.21
+	gitee.com/chunanyong/dm v1.8.16
 	github.com/GeertJohan/go.rice v1.0.3
 	github.com/emmansun/gmsm v0.27.2
 	github.com/extrame/xls v0.0.1
 	github.com/go-playground/locales v0.14.1
 	github.com/go-playground/universal-translator v0.18.1
 	github.com/go-playground/validator/v10 v10.14.0
+	github.com/go-sql-driver/mysql v1.7.1
 	github.com/golang-jwt/jwt/v4 v4.5.0
 	github.com/google/go-cmp v0.6.0
 	github.com/google/uuid v1.6.0
 	github.com/hjson/hjson-go/v4 v4.4.0
 	github.com/labstack/echo/v4 v4.11.1
 	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/mei-rune/go-good-password v0.0.0-20231011004208-1e3fa0e30592
 	github.com/mei-rune/ipfilter v1.0.2
 	github.com/mei-rune/properties v0.0.0-20240409111623-08fe9404e84d
@@ -54,6 +57,7 @@ require (
 	github.com/go-openapi/swag v0.23.0 // indirect
 	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
+	github.com/golang/snappy v0.0.1 // indirect
 	github.com/grsmv/inflect v0.0.0-20140723132642-a28d3de3b3ad // indirect
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/labstack/gommon v0.4.0 // indirect
@@ -0,0 +1,73 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateCode(t *testing.T) {
+	// RFC 6238 附录 B 中 SHA1 的测试数据，取最后 6 位
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for _, test := range []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+		{unix: 20000000000, code: "353130"},
+	} {
+		code, err := GenerateCode(secret, time.Unix(test.unix, 0))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if code != test.code {
+			t.Error(test.unix, ": want", test.code, "got", code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	code, err := GenerateCode(secret, now.Add(-Period*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := Validate(secret, code, now, 1)
+	if !ok {
+		t.Error("want ok got fail")
+	}
+	if step != Step(now)-1 {
+		t.Error("want", Step(now)-1, "got", step)
+	}
+	if _, ok := Validate(secret, code, now, 0); ok {
+		t.Error("want fail got ok")
+	}
+	if _, ok := Validate(secret, "12345", now, 1); ok {
+		t.Error("want fail got ok")
+	}
+	if _, ok := Validate("!!!", code, now, 1); ok {
+		t.Error("want fail got ok")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("boo", "admin@example.com", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/boo:admin@example.com?") {
+		t.Error(uri)
+	}
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=boo") {
+		t.Error(uri)
+	}
+}
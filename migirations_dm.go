@@ -0,0 +1,101 @@
+//go:build go1.16
+// +build go1.16
+
+package boo
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	"github.com/boo-admin/boo/errors"
+	"github.com/pressly/goose/v3/database"
+)
+
+var _ database.Store = &dmStore{}
+
+// dmStore 是达梦数据库的 goose 版本表， goose 没有内置达梦的支持
+type dmStore struct {
+	tablename string
+}
+
+func (s *dmStore) Tablename() string {
+	return s.tablename
+}
+
+func (s *dmStore) CreateVersionTable(ctx context.Context, db database.DBTxConn) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE `+s.tablename+` (
+		id          BIGINT IDENTITY(1, 1) NOT NULL,
+		version_id  BIGINT NOT NULL,
+		is_applied  BIT NOT NULL,
+		tstamp      TIMESTAMP DEFAULT SYSDATE,
+		PRIMARY KEY(id)
+	)`)
+	if err != nil {
+		return errors.Wrap(err, "创建版本表 '"+s.tablename+"' 失败")
+	}
+	return nil
+}
+
+func (s *dmStore) Insert(ctx context.Context, db database.DBTxConn, req database.InsertRequest) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO `+s.tablename+` (version_id, is_applied) VALUES (?, 1)`, req.Version)
+	if err != nil {
+		return errors.Wrap(err, "插入版本 "+strconv.FormatInt(req.Version, 10)+" 失败")
+	}
+	return nil
+}
+
+func (s *dmStore) Delete(ctx context.Context, db database.DBTxConn, version int64) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM `+s.tablename+` WHERE version_id = ?`, version)
+	if err != nil {
+		return errors.Wrap(err, "删除版本 "+strconv.FormatInt(version, 10)+" 失败")
+	}
+	return nil
+}
+
+func (s *dmStore) GetMigration(ctx context.Context, db database.DBTxConn, version int64) (*database.GetMigrationResult, error) {
+	var result database.GetMigrationResult
+	err := db.QueryRowContext(ctx, `SELECT tstamp, is_applied FROM `+s.tablename+
+		` WHERE version_id = ? ORDER BY tstamp DESC LIMIT 1`, version).
+		Scan(&result.Timestamp, &result.IsApplied)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrVersionNotFound
+		}
+		return nil, errors.Wrap(err, "查询版本 "+strconv.FormatInt(version, 10)+" 失败")
+	}
+	return &result, nil
+}
+
+func (s *dmStore) GetLatestVersion(ctx context.Context, db database.DBTxConn) (int64, error) {
+	var version sql.NullInt64
+	err := db.QueryRowContext(ctx, `SELECT MAX(version_id) FROM `+s.tablename).Scan(&version)
+	if err != nil {
+		return -1, errors.Wrap(err, "查询最新的版本失败")
+	}
+	if !version.Valid {
+		return -1, nil
+	}
+	return version.Int64, nil
+}
+
+func (s *dmStore) ListMigrations(ctx context.Context, db database.DBTxConn) ([]*database.ListMigrationsResult, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version_id, is_applied FROM `+s.tablename+` ORDER BY id DESC`)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询版本列表失败")
+	}
+	defer rows.Close()
+
+	var results []*database.ListMigrationsResult
+	for rows.Next() {
+		var result database.ListMigrationsResult
+		if err := rows.Scan(&result.Version, &result.IsApplied); err != nil {
+			return nil, errors.Wrap(err, "读取版本列表失败")
+		}
+		results = append(results, &result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "读取版本列表失败")
+	}
+	return results, nil
+}
@@ -7,12 +7,13 @@ import (
 	"context"
 	"database/sql"
 	"embed"
+	"fmt"
 	"io/fs"
 	"strings"
-	"fmt"
 
 	"github.com/boo-admin/boo/errors"
 	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/database"
 )
 
 //go:embed migrations/*/*.sql
@@ -22,29 +23,58 @@ func GetMigrationDir() (fs.FS, error) {
 	return fs.Sub(embedMigrations, "migrations")
 }
 
+// lookupMigrations 按 db.drv 返回 migrations 下的子目录和 goose 的方言，
+// goose 不支持的数据库会返回一个自定义的版本表
+func lookupMigrations(driverName string) (string, goose.Dialect, database.Store, error) {
+	switch strings.ToLower(driverName) {
+	case "postgres":
+		return "postgres", goose.DialectPostgres, nil, nil
+	case "mysql":
+		return "mysql", goose.DialectMySQL, nil, nil
+	case "sqlite", "sqlite3":
+		return "sqlite", goose.DialectSQLite3, nil, nil
+	case "dm":
+		return "dm", "", &dmStore{tablename: goose.DefaultTablename}, nil
+	default:
+		return "", "", nil, errors.New("数据库 '" + driverName + "' 不支持自动迁移")
+	}
+}
+
 func RunMigrations(ctx context.Context, driverName string, db *sql.DB, reset bool) error {
 	dir, err := GetMigrationDir()
 	if err != nil {
 		return errors.Wrap(err, "加载 migrations 目录失败")
 	}
-	goose.SetBaseFS(dir)
 
-	if err := goose.SetDialect(driverName); err != nil {
+	name, dialect, store, err := lookupMigrations(driverName)
+	if err != nil {
 		return err
 	}
+	dir, err = fs.Sub(dir, name)
+	if err != nil {
+		return errors.Wrap(err, "加载 migrations/"+name+" 目录失败")
+	}
+
+	var opts []goose.ProviderOption
+	if store != nil {
+		opts = append(opts, goose.WithStore(store))
+	}
+	// 注意不要调用 provider.Close()， 它会关闭 db
+	provider, err := goose.NewProvider(dialect, db, dir, opts...)
+	if err != nil {
+		return errors.Wrap(err, "初始化数据库迁移失败")
+	}
 
 	if reset {
-		if err := goose.ResetContext(ctx, db, ""+driverName); err != nil {
-			if !strings.Contains(err.Error(), "\"goose_db_version\"") {
-				return err
-			}
+		if _, err := provider.DownTo(ctx, 0); err != nil {
+			return errors.Wrap(err, "重置数据库失败")
 		}
 	} else {
 		fmt.Println("reset skip")
 	}
 
-	if err := goose.UpContext(ctx, db, driverName); err != nil {
-		return err
+	if _, err := provider.Up(ctx); err != nil {
+		return errors.Wrap(err, "升级数据库失败")
 	}
 	return nil
 }
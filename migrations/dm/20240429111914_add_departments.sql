@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE boo_departments (
+  id                          BIGINT IDENTITY(1, 1) PRIMARY KEY,
+  parent_id                   BIGINT NULL REFERENCES boo_departments(id) on delete set null,
+  uuid                        VARCHAR(50),
+  name                        VARCHAR(50),
+  order_num                   int,
+  fields                      CLOB,
+  created_at                  TIMESTAMP WITH TIME ZONE DEFAULT SYSDATE,
+  updated_at                  TIMESTAMP WITH TIME ZONE DEFAULT SYSDATE,
+  unique(name)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_departments;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE boo_operation_logs (
+  id           BIGINT IDENTITY(1, 1) PRIMARY KEY,
+  userid       bigint REFERENCES boo_users(id) ON DELETE SET NULL,
+  username     varchar(100),
+  type         varchar(100),
+  successful   BIT,
+  content      CLOB,
+  fields       CLOB,
+  created_at   TIMESTAMP WITH TIME ZONE
+);
+-- +goose StatementEnd
+
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_operation_logs;
@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE boo_employees (
+  id                          BIGINT IDENTITY(1, 1) PRIMARY KEY,
+  department_id               bigint NULL REFERENCES boo_departments(id) on delete set null,
+  user_id                     bigint NULL REFERENCES boo_users(id) on delete set null,
+  name                        varchar(100) NOT NULL UNIQUE,
+  nickname                    varchar(100) NOT NULL UNIQUE,
+  description                 CLOB,
+  source                      varchar(50),
+  fields                      CLOB,
+  deleted_at                  TIMESTAMP WITH TIME ZONE,
+  created_at                  TIMESTAMP WITH TIME ZONE,
+  updated_at                  TIMESTAMP WITH TIME ZONE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE boo_employee_tags (
+    id          BIGINT IDENTITY(1, 1) PRIMARY KEY,
+    uuid        varchar(100) NOT NULL,
+    title       varchar(100) NOT NULL,
+    created_at  TIMESTAMP WITH TIME ZONE,
+    updated_at  TIMESTAMP WITH TIME ZONE,
+
+    UNIQUE(uuid),
+    UNIQUE(title)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE boo_employee_to_tags (
+    employee_id     bigint REFERENCES boo_employees(id) ON DELETE CASCADE,
+    tag_id          bigint REFERENCES boo_employee_tags(id) ON DELETE CASCADE,
+
+    UNIQUE(employee_id, tag_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_employee_to_tags;
+DROP TABLE IF EXISTS boo_employees;
+DROP TABLE IF EXISTS boo_employee_tags;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE boo_user_roles (
+  id                          BIGINT IDENTITY(1, 1) PRIMARY KEY,
+  uuid                        VARCHAR(50),
+  title                       VARCHAR(250),
+  description                 VARCHAR(250),
+  created_at                  TIMESTAMP WITH TIME ZONE DEFAULT SYSDATE,
+  updated_at                  TIMESTAMP WITH TIME ZONE DEFAULT SYSDATE,
+  unique(uuid),
+  unique(title)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE boo_user_to_roles (
+    user_id          bigint REFERENCES boo_users(id) ON DELETE CASCADE,
+    role_id          bigint REFERENCES boo_user_roles(id) ON DELETE CASCADE,
+
+    UNIQUE(user_id, role_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_user_to_roles;
+DROP TABLE IF EXISTS boo_user_roles;
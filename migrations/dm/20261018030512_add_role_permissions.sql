@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE boo_role_permissions (
+    role_id          bigint REFERENCES boo_user_roles(id) ON DELETE CASCADE,
+    permission       VARCHAR(100) NOT NULL,
+
+    UNIQUE(role_id, permission)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_role_permissions;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE boo_custom_fields (
+  id                          BIGINT IDENTITY(1, 1) PRIMARY KEY,
+  object_type                 VARCHAR(50) NOT NULL,
+  field_id                    VARCHAR(100) NOT NULL,
+  name                        VARCHAR(100) NOT NULL,
+  aliases                     CLOB,
+  default_value               VARCHAR(250),
+  type                        VARCHAR(50),
+  enum_values                 CLOB,
+  order_num                   int,
+  created_at                  TIMESTAMP WITH TIME ZONE DEFAULT SYSDATE,
+  updated_at                  TIMESTAMP WITH TIME ZONE DEFAULT SYSDATE,
+
+  UNIQUE(object_type, field_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_custom_fields;
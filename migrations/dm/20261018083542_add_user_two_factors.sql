@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE boo_user_two_factors (
+  user_id                     bigint PRIMARY KEY REFERENCES boo_users(id) ON DELETE CASCADE,
+  secret                      VARCHAR(250) NOT NULL,
+  recovery_codes              VARCHAR(1000),
+  last_used_step              bigint,
+  enabled                     BIT,
+  enabled_at                  TIMESTAMP WITH TIME ZONE NULL,
+  created_at                  TIMESTAMP WITH TIME ZONE DEFAULT SYSDATE,
+  updated_at                  TIMESTAMP WITH TIME ZONE DEFAULT SYSDATE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_user_two_factors;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE boo_access_tokens (
+  id                          BIGINT IDENTITY(1, 1) PRIMARY KEY,
+  user_id                     bigint NOT NULL REFERENCES boo_users(id) ON DELETE CASCADE,
+  name                        VARCHAR(100) NOT NULL,
+  token_hash                  VARCHAR(100) NOT NULL UNIQUE,
+  token_prefix                VARCHAR(50),
+  scopes                      CLOB,
+  expires_at                  TIMESTAMP WITH TIME ZONE NULL,
+  last_used_at                TIMESTAMP WITH TIME ZONE NULL,
+  last_used_address           VARCHAR(100),
+  created_at                  TIMESTAMP WITH TIME ZONE DEFAULT SYSDATE,
+
+  UNIQUE(user_id, name)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_access_tokens;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE boo_revoked_tokens (
+  id                          VARCHAR(100) PRIMARY KEY,
+  expires_at                  TIMESTAMP WITH TIME ZONE NULL,
+  created_at                  TIMESTAMP WITH TIME ZONE DEFAULT SYSDATE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_revoked_tokens;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE boo_online_sessions ADD COLUMN user_agent VARCHAR(500);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE boo_online_sessions ADD COLUMN login_type VARCHAR(50);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE boo_online_sessions ADD COLUMN client_hints CLOB;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE boo_online_sessions DROP COLUMN client_hints;
+ALTER TABLE boo_online_sessions DROP COLUMN login_type;
+ALTER TABLE boo_online_sessions DROP COLUMN user_agent;
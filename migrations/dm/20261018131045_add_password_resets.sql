@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE boo_password_resets (
+  id                          BIGINT IDENTITY(1, 1) PRIMARY KEY,
+  user_id                     bigint NOT NULL REFERENCES boo_users(id) ON DELETE CASCADE,
+  token_hash                  VARCHAR(100) NOT NULL UNIQUE,
+  channel                     VARCHAR(50) NOT NULL,
+  address                     VARCHAR(100),
+  expires_at                  TIMESTAMP WITH TIME ZONE NOT NULL,
+  used_at                     TIMESTAMP WITH TIME ZONE NULL,
+  created_at                  TIMESTAMP WITH TIME ZONE DEFAULT SYSDATE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX boo_password_resets_user_id_idx ON boo_password_resets(user_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE boo_password_reset_attempts (
+  id                          BIGINT IDENTITY(1, 1) PRIMARY KEY,
+  address                     VARCHAR(100) NOT NULL,
+  created_at                  TIMESTAMP WITH TIME ZONE DEFAULT SYSDATE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX boo_password_reset_attempts_address_idx ON boo_password_reset_attempts(address);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_password_reset_attempts;
+DROP TABLE IF EXISTS boo_password_resets;
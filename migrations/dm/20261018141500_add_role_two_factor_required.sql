@@ -0,0 +1,7 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE boo_user_roles ADD COLUMN two_factor_required BIT;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE boo_user_roles DROP COLUMN two_factor_required;
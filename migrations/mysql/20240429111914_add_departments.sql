@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_departments (
+  id                          BIGINT AUTO_INCREMENT PRIMARY KEY,
+  parent_id                   BIGINT NULL,
+  uuid                        VARCHAR(50),
+  name                        VARCHAR(50),
+  order_num                   int,
+  fields                      JSON,
+  created_at                  DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at                  DATETIME DEFAULT CURRENT_TIMESTAMP,
+  unique(name),
+
+  FOREIGN KEY (parent_id) REFERENCES boo_departments(id) on delete set null
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_departments;
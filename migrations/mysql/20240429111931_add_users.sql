@@ -0,0 +1,65 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_users (
+  id                          BIGINT AUTO_INCREMENT PRIMARY KEY,
+  department_id               BIGINT NULL,
+  name                        varchar(100) NOT NULL UNIQUE,
+  nickname                    varchar(100) NOT NULL UNIQUE,
+  password                    varchar(500) ,
+  last_password_modified_at   DATETIME,
+  description                 text,
+  disabled                    boolean,
+  source                      varchar(50),
+  fields                      JSON,
+  deleted_at                  DATETIME,
+  created_at                  DATETIME,
+  updated_at                  DATETIME,
+
+  FOREIGN KEY (department_id) REFERENCES boo_departments(id) on delete set null
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_user_profiles (
+    user_id     BIGINT,
+    name        varchar(100) NOT NULL,
+    value       text,
+    created_at  DATETIME,
+    updated_at  DATETIME,
+
+    UNIQUE(user_id,name),
+    FOREIGN KEY (user_id) REFERENCES boo_users(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_user_tags (
+    id          BIGINT AUTO_INCREMENT PRIMARY KEY,
+    uuid        varchar(100) NOT NULL,
+    title       varchar(100) NOT NULL,
+    created_at  DATETIME,
+    updated_at  DATETIME,
+
+    UNIQUE(uuid),
+    UNIQUE(title)
+);
+-- +goose StatementEnd
+
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_user_to_tags (
+    user_id     BIGINT,
+    tag_id      BIGINT,
+
+    UNIQUE(user_id, tag_id),
+    FOREIGN KEY (user_id) REFERENCES boo_users(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES boo_user_tags(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_user_to_tags;
+DROP TABLE IF EXISTS boo_user_profiles;
+DROP TABLE IF EXISTS boo_users;
+DROP TABLE IF EXISTS boo_user_tags;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_operation_logs (
+  id           BIGINT AUTO_INCREMENT PRIMARY KEY,
+  userid       BIGINT,
+  username     varchar(100),
+  type         varchar(100),
+  successful   boolean,
+  content      text,
+  fields       JSON,
+  created_at   DATETIME,
+
+  FOREIGN KEY (userid) REFERENCES boo_users(id) ON DELETE SET NULL
+);
+-- +goose StatementEnd
+
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_operation_logs;
@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_employees (
+  id                          BIGINT AUTO_INCREMENT PRIMARY KEY,
+  department_id               BIGINT NULL,
+  user_id                     BIGINT NULL,
+  name                        varchar(100) NOT NULL UNIQUE,
+  nickname                    varchar(100) NOT NULL UNIQUE,
+  description                 text,
+  source                      varchar(50),
+  fields                      JSON,
+  deleted_at                  DATETIME,
+  created_at                  DATETIME,
+  updated_at                  DATETIME,
+
+  FOREIGN KEY (department_id) REFERENCES boo_departments(id) on delete set null,
+  FOREIGN KEY (user_id) REFERENCES boo_users(id) on delete set null
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_employee_tags (
+    id          BIGINT AUTO_INCREMENT PRIMARY KEY,
+    uuid        varchar(100) NOT NULL,
+    title       varchar(100) NOT NULL,
+    created_at  DATETIME,
+    updated_at  DATETIME,
+
+    UNIQUE(uuid),
+    UNIQUE(title)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_employee_to_tags (
+    employee_id     BIGINT,
+    tag_id          BIGINT,
+
+    UNIQUE(employee_id, tag_id),
+    FOREIGN KEY (employee_id) REFERENCES boo_employees(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES boo_employee_tags(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_employee_to_tags;
+DROP TABLE IF EXISTS boo_employees;
+DROP TABLE IF EXISTS boo_employee_tags;
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_user_roles (
+  id                          BIGINT AUTO_INCREMENT PRIMARY KEY,
+  uuid                        VARCHAR(50),
+  title                       VARCHAR(250),
+  description                 VARCHAR(250),
+  created_at                  DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at                  DATETIME DEFAULT CURRENT_TIMESTAMP,
+  unique(uuid),
+  unique(title)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_user_to_roles (
+    user_id          BIGINT,
+    role_id          BIGINT,
+
+    UNIQUE(user_id, role_id),
+    FOREIGN KEY (user_id) REFERENCES boo_users(id) ON DELETE CASCADE,
+    FOREIGN KEY (role_id) REFERENCES boo_user_roles(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_user_to_roles;
+DROP TABLE IF EXISTS boo_user_roles;
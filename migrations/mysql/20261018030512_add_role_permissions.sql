@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_role_permissions (
+    role_id          BIGINT,
+    permission       VARCHAR(100) NOT NULL,
+
+    UNIQUE(role_id, permission),
+    FOREIGN KEY (role_id) REFERENCES boo_user_roles(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_role_permissions;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE boo_user_roles ADD COLUMN data_scope VARCHAR(50);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_role_departments (
+    role_id          BIGINT,
+    department_id    BIGINT,
+
+    UNIQUE(role_id, department_id),
+    FOREIGN KEY (role_id) REFERENCES boo_user_roles(id) ON DELETE CASCADE,
+    FOREIGN KEY (department_id) REFERENCES boo_departments(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_role_departments;
+ALTER TABLE boo_user_roles DROP COLUMN data_scope;
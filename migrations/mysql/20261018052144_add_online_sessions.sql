@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_online_sessions (
+  uuid                        VARCHAR(100) PRIMARY KEY,
+  username                    VARCHAR(100) NOT NULL,
+  address                     VARCHAR(100),
+  created_at                  DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at                  DATETIME DEFAULT CURRENT_TIMESTAMP,
+
+  INDEX boo_online_sessions_username_idx (username)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_online_sessions;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_user_two_factors (
+  user_id                     BIGINT PRIMARY KEY,
+  secret                      VARCHAR(250) NOT NULL,
+  recovery_codes              VARCHAR(1000),
+  last_used_step              BIGINT,
+  enabled                     boolean,
+  enabled_at                  DATETIME NULL,
+  created_at                  DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at                  DATETIME DEFAULT CURRENT_TIMESTAMP,
+
+  FOREIGN KEY (user_id) REFERENCES boo_users(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_user_two_factors;
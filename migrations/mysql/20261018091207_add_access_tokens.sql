@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_access_tokens (
+  id                          BIGINT AUTO_INCREMENT PRIMARY KEY,
+  user_id                     BIGINT NOT NULL,
+  name                        VARCHAR(100) NOT NULL,
+  token_hash                  VARCHAR(100) NOT NULL UNIQUE,
+  token_prefix                VARCHAR(50),
+  scopes                      JSON,
+  expires_at                  DATETIME NULL,
+  last_used_at                DATETIME NULL,
+  last_used_address           VARCHAR(100),
+  created_at                  DATETIME DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE(user_id, name),
+  FOREIGN KEY (user_id) REFERENCES boo_users(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_access_tokens;
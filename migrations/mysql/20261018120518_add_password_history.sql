@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE boo_users ADD COLUMN must_change_password boolean;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_password_history (
+  id                          BIGINT AUTO_INCREMENT PRIMARY KEY,
+  user_id                     BIGINT NOT NULL,
+  password                    VARCHAR(500) NOT NULL,
+  created_at                  DATETIME DEFAULT CURRENT_TIMESTAMP,
+
+  INDEX boo_password_history_user_id_idx (user_id),
+  FOREIGN KEY (user_id) REFERENCES boo_users(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_password_history;
+ALTER TABLE boo_users DROP COLUMN must_change_password;
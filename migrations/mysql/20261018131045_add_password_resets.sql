@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_password_resets (
+  id                          BIGINT AUTO_INCREMENT PRIMARY KEY,
+  user_id                     BIGINT NOT NULL,
+  token_hash                  VARCHAR(100) NOT NULL UNIQUE,
+  channel                     VARCHAR(50) NOT NULL,
+  address                     VARCHAR(100),
+  expires_at                  DATETIME NOT NULL,
+  used_at                     DATETIME NULL,
+  created_at                  DATETIME DEFAULT CURRENT_TIMESTAMP,
+
+  INDEX boo_password_resets_user_id_idx (user_id),
+  FOREIGN KEY (user_id) REFERENCES boo_users(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_password_reset_attempts (
+  id                          BIGINT AUTO_INCREMENT PRIMARY KEY,
+  address                     VARCHAR(100) NOT NULL,
+  created_at                  DATETIME DEFAULT CURRENT_TIMESTAMP,
+
+  INDEX boo_password_reset_attempts_address_idx (address)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_password_reset_attempts;
+DROP TABLE IF EXISTS boo_password_resets;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_online_sessions (
+  uuid                        VARCHAR(100) PRIMARY KEY,
+  username                    VARCHAR(100) NOT NULL,
+  address                     VARCHAR(100),
+  created_at                  TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  updated_at                  TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS boo_online_sessions_username_idx ON boo_online_sessions(username);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_online_sessions;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_login_lockouts (
+  username                    VARCHAR(100) PRIMARY KEY,
+  address                     VARCHAR(100),
+  fail_count                  int NOT NULL DEFAULT 0,
+  locked_at                   TIMESTAMP WITH TIME ZONE NULL,
+  created_at                  TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  updated_at                  TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_login_lockouts;
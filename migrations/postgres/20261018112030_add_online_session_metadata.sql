@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE boo_online_sessions ADD COLUMN IF NOT EXISTS user_agent VARCHAR(500);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE boo_online_sessions ADD COLUMN IF NOT EXISTS login_type VARCHAR(50);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE boo_online_sessions ADD COLUMN IF NOT EXISTS client_hints jsonb;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE boo_online_sessions DROP COLUMN IF EXISTS client_hints;
+ALTER TABLE boo_online_sessions DROP COLUMN IF EXISTS login_type;
+ALTER TABLE boo_online_sessions DROP COLUMN IF EXISTS user_agent;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE boo_user_roles ADD COLUMN IF NOT EXISTS two_factor_required boolean;
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE boo_user_roles DROP COLUMN IF EXISTS two_factor_required;
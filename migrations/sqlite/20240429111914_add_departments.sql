@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_departments (
+  id                          INTEGER PRIMARY KEY AUTOINCREMENT,
+  parent_id                   int NULL REFERENCES boo_departments(id) on delete set null,
+  uuid                        VARCHAR(50),
+  name                        VARCHAR(50),
+  order_num                   int,
+  fields                      TEXT,
+  created_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  unique(name)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_departments;
@@ -0,0 +1,60 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_users (
+  id                          INTEGER PRIMARY KEY AUTOINCREMENT,
+  department_id               bigint NULL REFERENCES boo_departments(id) on delete set null,
+  name                        varchar(100) NOT NULL UNIQUE,
+  nickname                    varchar(100) NOT NULL UNIQUE,
+  password                    varchar(500) ,
+  last_password_modified_at   TIMESTAMP,
+  description                 text,
+  disabled                    boolean,
+  source                      varchar(50),
+  fields                      TEXT,
+  deleted_at                  TIMESTAMP,
+  created_at                  TIMESTAMP,
+  updated_at                  TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_user_profiles (
+    user_id     bigint REFERENCES boo_users ON DELETE CASCADE,
+    name        varchar(100) NOT NULL,
+    value       text,
+    created_at  TIMESTAMP,
+    updated_at  TIMESTAMP,
+
+    UNIQUE(user_id,name)
+);
+-- +goose StatementEnd
+
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_user_tags (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    uuid        varchar(100) NOT NULL,
+    title       varchar(100) NOT NULL,
+    created_at  TIMESTAMP,
+    updated_at  TIMESTAMP,
+
+    UNIQUE(uuid),
+    UNIQUE(title)
+);
+-- +goose StatementEnd
+
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_user_to_tags (
+    user_id     bigint REFERENCES boo_users ON DELETE CASCADE,
+    tag_id      bigint REFERENCES boo_user_tags ON DELETE CASCADE,
+
+    UNIQUE(user_id, tag_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_user_to_tags;
+DROP TABLE IF EXISTS boo_user_profiles;
+DROP TABLE IF EXISTS boo_users;
+DROP TABLE IF EXISTS boo_user_tags;
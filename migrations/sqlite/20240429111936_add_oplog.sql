@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_operation_logs (
+  id           INTEGER PRIMARY KEY AUTOINCREMENT,
+  userid       bigint REFERENCES boo_users(id) ON DELETE SET NULL,
+  username     varchar(100),
+  type         varchar(100),
+  successful   boolean,
+  content      text,
+  fields       TEXT,
+  created_at   TIMESTAMP
+);
+-- +goose StatementEnd
+
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_operation_logs;
@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_employees (
+  id                          INTEGER PRIMARY KEY AUTOINCREMENT,
+  department_id               bigint NULL REFERENCES boo_departments(id) on delete set null,
+  user_id                     bigint NULL REFERENCES boo_users(id) on delete set null,
+  name                        varchar(100) NOT NULL UNIQUE,
+  nickname                    varchar(100) NOT NULL UNIQUE,
+  description                 text,
+  source                      varchar(50),
+  fields                      TEXT,
+  deleted_at                  TIMESTAMP,
+  created_at                  TIMESTAMP,
+  updated_at                  TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_employee_tags (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    uuid        varchar(100) NOT NULL,
+    title       varchar(100) NOT NULL,
+    created_at  TIMESTAMP,
+    updated_at  TIMESTAMP,
+
+    UNIQUE(uuid),
+    UNIQUE(title)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_employee_to_tags (
+    employee_id     bigint REFERENCES boo_employees ON DELETE CASCADE,
+    tag_id          bigint REFERENCES boo_employee_tags ON DELETE CASCADE,
+
+    UNIQUE(employee_id, tag_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_employee_to_tags;
+DROP TABLE IF EXISTS boo_employees;
+DROP TABLE IF EXISTS boo_employee_tags;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_user_roles (
+  id                          INTEGER PRIMARY KEY AUTOINCREMENT,
+  uuid                        VARCHAR(50),
+  title                       VARCHAR(250),
+  description                 VARCHAR(250),
+  created_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  unique(uuid),
+  unique(title)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_user_to_roles (
+    user_id          bigint REFERENCES boo_users ON DELETE CASCADE,
+    role_id          bigint REFERENCES boo_user_roles ON DELETE CASCADE,
+
+    UNIQUE(user_id, role_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_user_to_roles;
+DROP TABLE IF EXISTS boo_user_roles;
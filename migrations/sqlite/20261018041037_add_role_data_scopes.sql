@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE boo_user_roles ADD COLUMN data_scope VARCHAR(50);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_role_departments (
+    role_id          bigint REFERENCES boo_user_roles ON DELETE CASCADE,
+    department_id    bigint REFERENCES boo_departments ON DELETE CASCADE,
+
+    UNIQUE(role_id, department_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_role_departments;
+ALTER TABLE boo_user_roles DROP COLUMN data_scope;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_login_lockouts (
+  username                    VARCHAR(100) PRIMARY KEY,
+  address                     VARCHAR(100),
+  fail_count                  int NOT NULL DEFAULT 0,
+  locked_at                   TIMESTAMP NULL,
+  created_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_login_lockouts;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_custom_fields (
+  id                          INTEGER PRIMARY KEY AUTOINCREMENT,
+  object_type                 VARCHAR(50) NOT NULL,
+  field_id                    VARCHAR(100) NOT NULL,
+  name                        VARCHAR(100) NOT NULL,
+  aliases                     TEXT,
+  default_value               VARCHAR(250),
+  type                        VARCHAR(50),
+  enum_values                 TEXT,
+  order_num                   int,
+  created_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE(object_type, field_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_custom_fields;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_user_two_factors (
+  user_id                     bigint PRIMARY KEY REFERENCES boo_users ON DELETE CASCADE,
+  secret                      VARCHAR(250) NOT NULL,
+  recovery_codes              VARCHAR(1000),
+  last_used_step              bigint,
+  enabled                     boolean,
+  enabled_at                  TIMESTAMP NULL,
+  created_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_user_two_factors;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_access_tokens (
+  id                          INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id                     bigint NOT NULL REFERENCES boo_users ON DELETE CASCADE,
+  name                        VARCHAR(100) NOT NULL,
+  token_hash                  VARCHAR(100) NOT NULL UNIQUE,
+  token_prefix                VARCHAR(50),
+  scopes                      TEXT,
+  expires_at                  TIMESTAMP NULL,
+  last_used_at                TIMESTAMP NULL,
+  last_used_address           VARCHAR(100),
+  created_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+  UNIQUE(user_id, name)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_access_tokens;
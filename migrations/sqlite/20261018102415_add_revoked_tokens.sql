@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_revoked_tokens (
+  id                          VARCHAR(100) PRIMARY KEY,
+  expires_at                  TIMESTAMP NULL,
+  created_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_revoked_tokens;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE boo_users ADD COLUMN must_change_password boolean;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_password_history (
+  id                          INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id                     bigint NOT NULL REFERENCES boo_users ON DELETE CASCADE,
+  password                    VARCHAR(500) NOT NULL,
+  created_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS boo_password_history_user_id_idx ON boo_password_history(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_password_history;
+ALTER TABLE boo_users DROP COLUMN must_change_password;
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_password_resets (
+  id                          INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id                     bigint NOT NULL REFERENCES boo_users ON DELETE CASCADE,
+  token_hash                  VARCHAR(100) NOT NULL UNIQUE,
+  channel                     VARCHAR(50) NOT NULL,
+  address                     VARCHAR(100),
+  expires_at                  TIMESTAMP NOT NULL,
+  used_at                     TIMESTAMP NULL,
+  created_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS boo_password_resets_user_id_idx ON boo_password_resets(user_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS boo_password_reset_attempts (
+  id                          INTEGER PRIMARY KEY AUTOINCREMENT,
+  address                     VARCHAR(100) NOT NULL,
+  created_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS boo_password_reset_attempts_address_idx ON boo_password_reset_attempts(address);
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS boo_password_reset_attempts;
+DROP TABLE IF EXISTS boo_password_resets;
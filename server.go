@@ -5,6 +5,11 @@ import (
 	"database/sql"
 
 	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn/jwt_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_store"
 	"github.com/boo-admin/boo/services/users"
 	gobatis "github.com/runner-mei/GoBatis"
 )
@@ -17,12 +22,27 @@ type Server struct {
 
 	OperationLogger  users.OperationLogger
 	OperationQueryer booclient.OperationQueryer
-	Departments      booclient.Departments
+	Departments      users.Departments
 	Users            users.Users
 	UserTags         booclient.UserTags
 	Roles            booclient.Roles
 	Employees        users.Employees
 	EmployeeTags     booclient.EmployeeTags
+	CustomFields     booclient.CustomFields
+	TwoFactors       booclient.TwoFactors
+	AccessTokens     users.AccessTokens
+	PasswordResets   users.PasswordResets
+	LdapSync         users.LdapSync
+
+	AuthUsers   *users.AuthUsers
+	Onlines     session_auth.Onlines
+	Sessions    booclient.Sessions
+	LockedUsers booclient.LockedUsers
+	LoginUsers  session_core.UserManager
+	AuthService *session_core.AuthService
+
+	JWTAuth          *jwt_auth.JWTAuth
+	TokenRevocations jwt_auth.Revocations
 }
 
 func SetAutoMigrations(env *booclient.Environment, value bool) *booclient.Environment {
@@ -64,17 +84,17 @@ func NewServer(env *booclient.Environment) (*Server, error) {
 	}
 	srv.OperationQueryer = operationQueryer
 
-	departments, err := users.NewDepartments(env, dbFactory, srv.OperationLogger)
+	usvc, err := users.NewUsers(env, dbFactory, srv.OperationLogger)
 	if err != nil {
 		return nil, err
 	}
-	srv.Departments = departments
+	srv.Users = usvc
 
-	usvc, err := users.NewUsers(env, dbFactory, srv.OperationLogger)
+	departments, err := users.NewDepartments(env, dbFactory, usvc, srv.OperationLogger)
 	if err != nil {
 		return nil, err
 	}
-	srv.Users = usvc
+	srv.Departments = departments
 
 	userTagSvc, err := users.NewUserTags(env, dbFactory, srv.OperationLogger)
 	if err != nil {
@@ -100,6 +120,57 @@ func NewServer(env *booclient.Environment) (*Server, error) {
 	}
 	srv.EmployeeTags = employeeTagSvc
 
+	customFieldSvc, err := users.NewCustomFields(env, dbFactory, usvc, srv.OperationLogger)
+	if err != nil {
+		return nil, err
+	}
+	srv.CustomFields = customFieldSvc
+	srv.TwoFactors = users.NewTwoFactors(usvc)
+
+	accessTokens, err := users.NewAccessTokens(env, dbFactory, usvc, srv.OperationLogger)
+	if err != nil {
+		return nil, err
+	}
+	srv.AccessTokens = accessTokens
+
+	passwordResets, err := users.NewPasswordResets(env, dbFactory, usvc, srv.OperationLogger)
+	if err != nil {
+		return nil, err
+	}
+	srv.PasswordResets = passwordResets
+
+	ldapSync, err := users.NewLdapSync(env, dbFactory, usvc, srv.OperationLogger)
+	if err != nil {
+		return nil, err
+	}
+	srv.LdapSync = ldapSync
+
+	srv.AuthUsers = users.NewAuthUsers(usvc)
+	if err := srv.AuthUsers.InitAdministrator(context.Background()); err != nil {
+		return nil, err
+	}
+
+	srv.Onlines = session_store.Create(env, dbFactory)
+	usvc.SetOnlines(srv.Onlines)
+	srv.Sessions = session_auth.NewSessions(env.Logger.WithGroup("sessions"), srv.Onlines, srv.OperationLogger)
+	lockouts := session_store.CreateDbLockouts(env, dbFactory, srv.OperationLogger)
+	lockouts.SetUserExists(usvc.UsernameExists)
+	srv.LockedUsers = lockouts
+	usvc.SetLockouts(lockouts)
+	srv.LoginUsers = users.NewLoginUsers(usvc)
+	authService, err := session_auth.NewAuthService(env, srv.LoginUsers, srv.Onlines, lockouts)
+	if err != nil {
+		return nil, errors.Wrap(err, "初始化登录服务失败")
+	}
+	srv.AuthService = authService
+
+	jwtAuth, err := jwt_auth.NewJWTAuthFromEnv(env)
+	if err != nil {
+		return nil, errors.Wrap(err, "初始化 jwt 失败")
+	}
+	srv.JWTAuth = jwtAuth
+	srv.TokenRevocations = session_store.CreateRevocations(env, dbFactory)
+
 	return srv, nil
 }
 
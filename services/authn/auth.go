@@ -20,6 +20,12 @@ var (
 	ErrUserNotFound       = NewHTTPError(http.StatusForbidden, "auth: user isnot exists")
 	ErrInvalidCredentials = NewHTTPError(http.StatusForbidden, "auth: invalid credentials")
 
+	// ErrPasswordChangeRequired 用户必须先通过 /users/me/password 修改密码才能访问其它的功能
+	ErrPasswordChangeRequired = NewHTTPError(http.StatusForbidden, "auth: password must be changed, please change it at /users/me/password")
+
+	// ErrTwoFactorEnrollRequired 用户必须先开通两步验证才能访问其它的功能
+	ErrTwoFactorEnrollRequired = NewHTTPError(http.StatusForbidden, "auth: two-factor authentication must be enabled")
+
 	// 仅用于 token 找到，但不适用检验函数的时候
 	ErrSkipped = errors.ErrSkipped
 )
@@ -45,6 +51,19 @@ func RenderJSON(ctx context.Context, w http.ResponseWriter, r *http.Request, cod
 type AuthValidateFunc func(ctx context.Context, req *http.Request) (context.Context, error)
 type ContextHandlerFunc func(context.Context, http.ResponseWriter, *http.Request)
 
+type setCookieKey struct{}
+
+// ContextWithSetCookie 认证时需要更新 cookie (例如会话续期)，因为 AuthValidateFunc 不能访问响应，
+// 所以将它保存在 ctx 中，由 http 中间件写到响应中
+func ContextWithSetCookie(ctx context.Context, cookie *http.Cookie) context.Context {
+	return context.WithValue(ctx, setCookieKey{}, cookie)
+}
+
+func SetCookieFromContext(ctx context.Context) *http.Cookie {
+	cookie, _ := ctx.Value(setCookieKey{}).(*http.Cookie)
+	return cookie
+}
+
 func RawHTTPAuth(returnError func(context.Context, http.ResponseWriter, *http.Request, string, int), validateFns ...AuthValidateFunc) func(ContextHandlerFunc) ContextHandlerFunc {
 	if returnError == nil {
 		returnError = func(ctx context.Context, w http.ResponseWriter, r *http.Request, err string, statusCode int) {
@@ -60,6 +79,9 @@ func RawHTTPAuth(returnError func(context.Context, http.ResponseWriter, *http.Re
 			for _, fn := range validateFns {
 				nctx, err := fn(ctx, r)
 				if err == nil {
+					if cookie := SetCookieFromContext(nctx); cookie != nil {
+						http.SetCookie(w, cookie)
+					}
 					next(nctx, w, r)
 					return
 				}
@@ -61,6 +61,18 @@ type AuthUser interface {
 	ForEach(func(string, interface{}))
 }
 
+// PasswordChangeChecker 由 AuthUser 可选实现，返回 true 时用户必须先修改密码，
+// 在这之前只能访问修改密码等少数几个功能
+type PasswordChangeChecker interface {
+	IsPasswordChangeRequired() bool
+}
+
+// TwoFactorEnrollChecker 由 AuthUser 可选实现，返回 true 时用户的角色要求两步验证但用户还没有开通，
+// 在这之前只能访问开通两步验证等少数几个功能
+type TwoFactorEnrollChecker interface {
+	IsTwoFactorEnrollRequired() bool
+}
+
 type userKey string
 
 func (s userKey) constUserKey() {} // nolint:unused
@@ -141,6 +153,15 @@ const (
 	OpResetPassword = "resetpassword"
 	OpDeleteUser    = "deleteuser"
 	OpViewUser      = "viewuser"
+	OpUnlockUser    = "unlockuser"
+
+	OpResetTwoFactor    = "resettwofactor"
+	OpManageAccessToken = "manageaccesstoken"
+
+	OpViewOnlineUser   = "viewonlineuser"
+	OpLogoutOnlineUser = "logoutonlineuser"
+
+	OpSyncLdapUsers = "syncldapusers"
 
 	OpUpdateDepartment = "updatedepartment"
 	OpCreateDepartment = "createdepartment"
@@ -156,6 +177,8 @@ const (
 	OpCreateRole = "createRole"
 	OpDeleteRole = "deleteRole"
 	OpViewRole   = "viewRole"
+
+	OpUpdateCustomField = "updatecustomfield"
 )
 
 func GetHash(alg string) (func() hash.Hash, error) {
@@ -0,0 +1,235 @@
+package cas_auth
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn/session_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+)
+
+const (
+	CfgCasEnabled           = "users.cas.enabled"
+	CfgCasServerURL         = "users.cas.server_url"
+	CfgCasServiceURL        = "users.cas.service_url"
+	CfgCasVersion           = "users.cas.version"
+	CfgCasNicknameAttribute = "users.cas.nickname_attribute"
+	CfgCasRolesAttribute    = "users.cas.roles_attribute"
+	CfgCasAttributeFields   = "users.cas.attribute_fields"
+	CfgCasDefaultRoles      = "users.cas.default_roles"
+)
+
+// Source 是通过 CAS 登录的用户的来源， UserService.ValidateUser 不会检查这类用户的密码
+const Source = "cas"
+
+// Config 是 CAS 的配置
+type Config struct {
+	// ServerURL 是 CAS 服务的地址，如 https://cas.example.com/cas
+	ServerURL string
+	// ServiceURL 是 CAS 登录成功后跳转回来的地址，即本系统的回调地址
+	ServiceURL string
+	// Version 是 CAS 协议的版本，支持 2.0 和 3.0, 2.0 的服务一般不返回属性
+	Version string
+
+	NicknameAttribute string
+	RolesAttribute    string
+	// AttributeFields 是 CAS 属性到用户自定义字段的映射
+	AttributeFields map[string]string
+	DefaultRoles    []string
+
+	HTTPClient *http.Client
+}
+
+// NewConfigFromEnv 从配置中读取 CAS 的配置，格式如下
+//
+//	users.cas.server_url=https://cas.example.com/cas
+//	users.cas.service_url=https://boo.example.com/boo/login/cas/callback
+//	users.cas.attribute_fields=mail:email,telephoneNumber:phone
+func NewConfigFromEnv(env *booclient.Environment) (*Config, error) {
+	config := &Config{
+		ServerURL:         strings.TrimSuffix(env.Config.StringWithDefault(CfgCasServerURL, ""), "/"),
+		ServiceURL:        env.Config.StringWithDefault(CfgCasServiceURL, ""),
+		Version:           env.Config.StringWithDefault(CfgCasVersion, "3.0"),
+		NicknameAttribute: env.Config.StringWithDefault(CfgCasNicknameAttribute, "displayName"),
+		RolesAttribute:    env.Config.StringWithDefault(CfgCasRolesAttribute, ""),
+		DefaultRoles:      session_auth.SplitList(env.Config.StringWithDefault(CfgCasDefaultRoles, "")),
+	}
+	if config.ServerURL == "" {
+		return nil, errors.New("读 " + CfgCasServerURL + " 失败，没有在配置中找到它")
+	}
+	if config.ServiceURL == "" {
+		return nil, errors.New("读 " + CfgCasServiceURL + " 失败，没有在配置中找到它")
+	}
+	if config.Version != "2.0" && config.Version != "3.0" {
+		return nil, errors.New("参数 '" + CfgCasVersion + "' 的值 '" + config.Version + "' 不正确，它应该是 2.0 或 3.0")
+	}
+
+	for _, s := range session_auth.SplitList(env.Config.StringWithDefault(CfgCasAttributeFields, "")) {
+		ss := strings.SplitN(s, ":", 2)
+		if len(ss) != 2 || strings.TrimSpace(ss[0]) == "" || strings.TrimSpace(ss[1]) == "" {
+			return nil, errors.New("参数 '" + CfgCasAttributeFields + "' 的值 '" + s + "' 格式不正确，它应该是 'attribute:field'")
+		}
+		if config.AttributeFields == nil {
+			config.AttributeFields = map[string]string{}
+		}
+		config.AttributeFields[strings.TrimSpace(ss[0])] = strings.TrimSpace(ss[1])
+	}
+	return config, nil
+}
+
+// Client 是 CAS 服务的客户端
+type Client struct {
+	config Config
+	client *http.Client
+}
+
+func NewClient(config Config) *Client {
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Client{
+		config: config,
+		client: client,
+	}
+}
+
+// ServiceURL 返回带上登录后跳转地址的 service 参数，校验 ticket 时必须使用相同的 service
+func (c *Client) ServiceURL(returnURL string) string {
+	if returnURL == "" {
+		return c.config.ServiceURL
+	}
+	params := url.Values{}
+	params.Set("return_url", returnURL)
+	if strings.Contains(c.config.ServiceURL, "?") {
+		return c.config.ServiceURL + "&" + params.Encode()
+	}
+	return c.config.ServiceURL + "?" + params.Encode()
+}
+
+// LoginURL 返回 CAS 服务的登录地址
+func (c *Client) LoginURL(service string) string {
+	return c.config.ServerURL + "/login?" + url.Values{"service": []string{service}}.Encode()
+}
+
+// LogoutURL 返回 CAS 服务的注销地址
+func (c *Client) LogoutURL(service string) string {
+	if service == "" {
+		return c.config.ServerURL + "/logout"
+	}
+	return c.config.ServerURL + "/logout?" + url.Values{"service": []string{service}}.Encode()
+}
+
+func (c *Client) validateURL(service, ticket string) string {
+	path := "/p3/serviceValidate"
+	if c.config.Version == "2.0" {
+		path = "/serviceValidate"
+	}
+	params := url.Values{}
+	params.Set("service", service)
+	params.Set("ticket", ticket)
+	return c.config.ServerURL + path + "?" + params.Encode()
+}
+
+type serviceResponse struct {
+	XMLName xml.Name `xml:"serviceResponse"`
+	Success *struct {
+		User       string `xml:"user"`
+		Attributes struct {
+			Values []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"attributes"`
+	} `xml:"authenticationSuccess"`
+	Failure *struct {
+		Code    string `xml:"code,attr"`
+		Message string `xml:",chardata"`
+	} `xml:"authenticationFailure"`
+}
+
+// Response 是 ticket 校验成功后 CAS 服务返回的用户名和属性
+type Response struct {
+	User       string
+	Attributes map[string][]string
+}
+
+// ValidateTicket 到 CAS 服务上校验 ticket
+func (c *Client) ValidateTicket(ctx context.Context, service, ticket string) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.validateURL(service, ticket), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "校验 ticket 失败")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "校验 ticket 失败")
+	}
+	defer resp.Body.Close()
+
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "校验 ticket 失败")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("校验 ticket 失败，" + resp.Status + ": " + string(bs))
+	}
+
+	var result serviceResponse
+	if err := xml.Unmarshal(bs, &result); err != nil {
+		return nil, errors.Wrap(err, "校验 ticket 失败，返回的结果不正确")
+	}
+	if result.Failure != nil {
+		return nil, errors.WithHTTPCode(errors.New("校验 ticket 失败，"+result.Failure.Code+": "+strings.TrimSpace(result.Failure.Message)), http.StatusUnauthorized)
+	}
+	if result.Success == nil || strings.TrimSpace(result.Success.User) == "" {
+		return nil, errors.New("校验 ticket 失败，返回的结果中没有用户名")
+	}
+
+	response := &Response{
+		User:       strings.TrimSpace(result.Success.User),
+		Attributes: map[string][]string{},
+	}
+	for _, attr := range result.Success.Attributes.Values {
+		response.Attributes[attr.XMLName.Local] = append(response.Attributes[attr.XMLName.Local], strings.TrimSpace(attr.Value))
+	}
+	return response, nil
+}
+
+// Identity 按配置将 CAS 返回的属性转换为用户身份
+func (c *Client) Identity(response *Response) *session_core.ExternalIdentity {
+	identity := &session_core.ExternalIdentity{
+		Source:   Source,
+		Username: response.User,
+	}
+	if values := response.Attributes[c.config.NicknameAttribute]; len(values) > 0 {
+		identity.Nickname = values[0]
+	}
+	for attr, field := range c.config.AttributeFields {
+		values := response.Attributes[attr]
+		if len(values) == 0 {
+			continue
+		}
+		if identity.Fields == nil {
+			identity.Fields = map[string]interface{}{}
+		}
+		if len(values) == 1 {
+			identity.Fields[field] = values[0]
+		} else {
+			identity.Fields[field] = values
+		}
+	}
+	if c.config.RolesAttribute != "" {
+		for _, value := range response.Attributes[c.config.RolesAttribute] {
+			identity.Roles = append(identity.Roles, session_auth.SplitList(value)...)
+		}
+	}
+	identity.Roles = append(identity.Roles, c.config.DefaultRoles...)
+	return identity
+}
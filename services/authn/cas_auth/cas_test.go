@@ -0,0 +1,141 @@
+package cas_auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const successResponse = `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+  <cas:authenticationSuccess>
+    <cas:user>casuser</cas:user>
+    <cas:attributes>
+      <cas:displayName>统一认证用户</cas:displayName>
+      <cas:mail>casuser@example.com</cas:mail>
+      <cas:memberOf>teacher</cas:memberOf>
+      <cas:memberOf>admin</cas:memberOf>
+    </cas:attributes>
+  </cas:authenticationSuccess>
+</cas:serviceResponse>`
+
+const failureResponse = `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+  <cas:authenticationFailure code="INVALID_TICKET">
+    Ticket ST-1856339-aA5Yuvrxzpv8Tau1cYQ7 not recognized
+  </cas:authenticationFailure>
+</cas:serviceResponse>`
+
+func newTestServer(t *testing.T, validatePath string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(validatePath, func(w http.ResponseWriter, r *http.Request) {
+		service := r.URL.Query().Get("service")
+		if service != "http://boo.example.com/boo/login/cas/callback?return_url=%2Fboo%2Fusers" {
+			t.Error("service is invalid -", service)
+		}
+		if r.URL.Query().Get("ticket") != "ST-1" {
+			w.Write([]byte(failureResponse))
+			return
+		}
+		w.Write([]byte(successResponse))
+	})
+	return httptest.NewServer(mux)
+}
+
+func newTestHandler(srv *httptest.Server, version string) *Handler {
+	return &Handler{
+		Client: NewClient(Config{
+			ServerURL:         srv.URL + "/cas",
+			ServiceURL:        "http://boo.example.com/boo/login/cas/callback",
+			Version:           version,
+			NicknameAttribute: "displayName",
+			RolesAttribute:    "memberOf",
+			AttributeFields:   map[string]string{"mail": "email"},
+			DefaultRoles:      []string{"guest"},
+		}),
+	}
+}
+
+func login(t *testing.T, h *Handler, ticket string) *http.Request {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/boo/login/cas?return_url=/boo/users", nil)
+	if err := h.Redirect(context.Background(), w, req); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusFound {
+		t.Fatal("want 302 got", w.Code)
+	}
+	loginURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(loginURL.Path, "/cas/login") {
+		t.Fatal("want redirect to cas got", loginURL)
+	}
+
+	// 模拟用户在 CAS 上登录成功后跳转回来
+	service := loginURL.Query().Get("service")
+	return httptest.NewRequest("GET", service+"&ticket="+url.QueryEscape(ticket), nil)
+}
+
+func TestLogin(t *testing.T) {
+	for _, test := range []struct {
+		version string
+		path    string
+	}{
+		{version: "3.0", path: "/cas/p3/serviceValidate"},
+		{version: "2.0", path: "/cas/serviceValidate"},
+	} {
+		t.Run(test.version, func(t *testing.T) {
+			srv := newTestServer(t, test.path)
+			defer srv.Close()
+
+			h := newTestHandler(srv, test.version)
+			req := login(t, h, "ST-1")
+			identity, returnURL, err := h.Callback(context.Background(), httptest.NewRecorder(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if returnURL != "/boo/users" {
+				t.Error("want /boo/users got", returnURL)
+			}
+			if identity.Source != Source {
+				t.Error("want", Source, "got", identity.Source)
+			}
+			if identity.Username != "casuser" {
+				t.Error("want casuser got", identity.Username)
+			}
+			if identity.Nickname != "统一认证用户" {
+				t.Error("want 统一认证用户 got", identity.Nickname)
+			}
+			if identity.Fields["email"] != "casuser@example.com" {
+				t.Error("want casuser@example.com got", identity.Fields["email"])
+			}
+			if strings.Join(identity.Roles, ",") != "teacher,admin,guest" {
+				t.Error("want teacher,admin,guest got", identity.Roles)
+			}
+		})
+	}
+}
+
+func TestLoginFail(t *testing.T) {
+	srv := newTestServer(t, "/cas/p3/serviceValidate")
+	defer srv.Close()
+
+	h := newTestHandler(srv, "3.0")
+	req := login(t, h, "ST-2")
+	_, _, err := h.Callback(context.Background(), httptest.NewRecorder(), req)
+	if err == nil {
+		t.Fatal("want error got ok")
+	}
+	if !strings.Contains(err.Error(), "INVALID_TICKET") {
+		t.Error("want INVALID_TICKET got", err)
+	}
+
+	req = httptest.NewRequest("GET", "/boo/login/cas/callback", nil)
+	_, _, err = h.Callback(context.Background(), httptest.NewRecorder(), req)
+	if err == nil {
+		t.Fatal("want error got ok")
+	}
+}
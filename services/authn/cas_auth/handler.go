@@ -0,0 +1,55 @@
+package cas_auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn/session_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+)
+
+// Handler 处理 CAS 的登录跳转和回调
+type Handler struct {
+	Client *Client
+}
+
+func NewHandlerFromEnv(env *booclient.Environment) (*Handler, error) {
+	config, err := NewConfigFromEnv(env)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Client: NewClient(*config),
+	}, nil
+}
+
+func returnURLFromRequest(req *http.Request) string {
+	if returnURL := req.URL.Query().Get("return_url"); session_auth.IsSafeReturnURL(returnURL) {
+		return returnURL
+	}
+	return ""
+}
+
+// Redirect 跳转到 CAS 服务的登录页面
+func (h *Handler) Redirect(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	service := h.Client.ServiceURL(returnURLFromRequest(req))
+	http.Redirect(w, req, h.Client.LoginURL(service), http.StatusFound)
+	return nil
+}
+
+// Callback 校验回调中的 ticket, 返回用户身份和登录后要跳转的地址
+func (h *Handler) Callback(ctx context.Context, w http.ResponseWriter, req *http.Request) (*session_core.ExternalIdentity, string, error) {
+	ticket := req.URL.Query().Get("ticket")
+	if ticket == "" {
+		return nil, "", errors.WithHTTPCode(errors.New("CAS 服务没有返回 ticket"), http.StatusUnauthorized)
+	}
+
+	returnURL := returnURLFromRequest(req)
+	response, err := h.Client.ValidateTicket(ctx, h.Client.ServiceURL(returnURL), ticket)
+	if err != nil {
+		return nil, "", errors.WithHTTPCode(err, http.StatusUnauthorized)
+	}
+	return h.Client.Identity(response), returnURL, nil
+}
@@ -0,0 +1,151 @@
+package jwt_auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// UserClaims 是签发给登录用户的 token 的内容，访问令牌和刷新令牌用 TokenType 区分，
+// ID (jti) 在每个 token 中都是唯一的，用于吊销 token
+type UserClaims struct {
+	jwt.RegisteredClaims
+
+	UserID    int64    `json:"uid,omitempty"`
+	Username  string   `json:"name,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+}
+
+// ExpiresTime 返回过期时间，永不过期时返回零值
+func (c *UserClaims) ExpiresTime() time.Time {
+	if c.RegisteredClaims.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return c.RegisteredClaims.ExpiresAt.Time
+}
+
+// TokenPair 是一对访问令牌和刷新令牌，字段名和 OAuth2 的 token 响应一致
+type TokenPair struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in,omitempty"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in,omitempty"`
+}
+
+// generateTokenID 生成 token 的 jti，它用于吊销 token，所以必须是不可猜测的随机数
+func generateTokenID() (string, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
+
+func (ja *JWTAuth) issue(now time.Time, tokenType string, expires time.Duration, userID int64, username string, roles []string, sessionID string) (string, error) {
+	id, err := generateTokenID()
+	if err != nil {
+		return "", errors.Wrap(err, "生成 token 的 ID 失败")
+	}
+	claims := &UserClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       id,
+			Issuer:   ja.issuer,
+			Subject:  username,
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+		UserID:    userID,
+		Username:  username,
+		Roles:     roles,
+		SessionID: sessionID,
+		TokenType: tokenType,
+	}
+	if expires > 0 {
+		claims.RegisteredClaims.ExpiresAt = jwt.NewNumericDate(now.Add(expires))
+	}
+	_, tokenString, err := ja.Encode(claims)
+	return tokenString, err
+}
+
+// IssueTokenPair 为登录用户签发访问令牌和刷新令牌， sessionID 为在线会话的 ID
+func (ja *JWTAuth) IssueTokenPair(userID int64, username string, roles []string, sessionID string) (*TokenPair, error) {
+	if _, signKey := ja.currentKey(); ja.signer == nil || signKey == nil {
+		return nil, errors.New("jwt 的签名算法或签名密钥没有配置")
+	}
+
+	now := time.Now()
+	accessToken, err := ja.issue(now, TokenTypeAccess, ja.expires, userID, username, roles, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := ja.issue(now, TokenTypeRefresh, ja.refreshExpires, userID, username, nil, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(ja.expires / time.Second),
+		RefreshExpiresIn: int64(ja.refreshExpires / time.Second),
+	}, nil
+}
+
+// ParseRefreshToken 校验刷新令牌，它不检查令牌是否已被吊销
+func (ja *JWTAuth) ParseRefreshToken(tokenString string) (*UserClaims, error) {
+	claims, err := ja.ParseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenType != TokenTypeRefresh {
+		return nil, errors.WithHTTPCode(errors.New("token 不是刷新令牌"), http.StatusUnauthorized)
+	}
+	return claims, nil
+}
+
+// ParseClaims 校验 token 的签名和有效期，并返回它的内容
+func (ja *JWTAuth) ParseClaims(tokenString string) (*UserClaims, error) {
+	token, err := ja.Decode(tokenString)
+	if err != nil {
+		return nil, errors.WithHTTPCode(err, http.StatusUnauthorized)
+	}
+	claims, ok := token.Claims.(*UserClaims)
+	if !ok || !token.Valid {
+		return nil, authn.ErrUnauthorized
+	}
+	if err := claims.Valid(); err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
+			return nil, authn.ErrTokenExpired
+		}
+		return nil, errors.WithHTTPCode(err, http.StatusUnauthorized)
+	}
+	if ja.issuer != "" && !claims.VerifyIssuer(ja.issuer, true) {
+		return nil, authn.ErrUnauthorized
+	}
+	return claims, nil
+}
+
+type claimsKey struct{}
+
+// ContextWithClaims 在 ctx 中保存当前请求的 token 内容，注销时用它来吊销 token
+func ContextWithClaims(ctx context.Context, claims *UserClaims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+func ClaimsFromContext(ctx context.Context) *UserClaims {
+	claims, _ := ctx.Value(claimsKey{}).(*UserClaims)
+	return claims
+}
@@ -2,12 +2,15 @@ package jwt_auth
 
 import (
 	"context"
+	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/boo-admin/boo/booclient"
 	"github.com/boo-admin/boo/errors"
 	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/services/authn/keyring"
 	jwt "github.com/golang-jwt/jwt/v4"
 )
 
@@ -16,10 +19,11 @@ type TokenCheckFunc func(ctx context.Context, req *http.Request, tokenStr string
 
 // TokenVerify http middleware handler will verify a Token string from a http request.
 //
-// TokenVerify will search for a token in a http request, in the order:
-//  1. 'token' URI query parameter
-//  2. 'Authorization: BEARER T' request header
-//  3. Cookie 'token' value
+// TokenVerify will search for a token in a http request by calling findTokenFns
+// in order, New uses:
+//  1. 'Authorization: BEARER T' request header
+//  2. Cookie 'token' value
+//  3. 'token' URI query parameter
 //
 // example:
 //
@@ -119,7 +123,20 @@ func JWTCheck(ja *JWTAuth, handle func(ctx context.Context, req *http.Request, t
 		}
 
 		if err = token.Claims.Valid(); err != nil {
-			return nil, err
+			if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
+				return nil, authn.ErrTokenExpired
+			}
+			return nil, errors.WithHTTPCode(err, http.StatusUnauthorized)
+		}
+
+		if claims, ok := token.Claims.(*UserClaims); ok {
+			// 刷新令牌只能用于换取新的令牌，不能用于访问 api
+			if claims.TokenType == TokenTypeRefresh {
+				return nil, authn.ErrUnauthorized
+			}
+			if ja.issuer != "" && !claims.VerifyIssuer(ja.issuer, true) {
+				return nil, authn.ErrUnauthorized
+			}
 		}
 
 		return handle(ctx, req, token)
@@ -131,12 +148,18 @@ type JWTAuth struct {
 	verifyKey interface{}
 	signer    jwt.SigningMethod
 	parser    *jwt.Parser
+
+	keyID          string
+	keys           *keyring.File
+	issuer         string
+	expires        time.Duration
+	refreshExpires time.Duration
 }
 
 // NewJWTAuth creates a JWTAuth authenticator instance that provides middleware handlers
 // and encoding/decoding functions for JWT signing.
 func NewJWTAuth(alg string, signKey interface{}, verifyKey interface{}) *JWTAuth {
-	return NewJWTAuthWithParser(alg, &jwt.Parser{}, signKey, verifyKey)
+	return NewJWTAuthWithParser(alg, jwt.NewParser(jwt.WithValidMethods([]string{alg})), signKey, verifyKey)
 }
 
 // NewJWTAuthWithParser is the same as New, except it supports custom parser settings
@@ -148,23 +171,28 @@ func NewJWTAuth(alg string, signKey interface{}, verifyKey interface{}) *JWTAuth
 func NewJWTAuthWithParser(alg string, parser *jwt.Parser, signKey interface{}, verifyKey interface{}) *JWTAuth {
 	parser.SkipClaimsValidation = true
 	return &JWTAuth{
-		signKey:   signKey,
-		verifyKey: verifyKey,
-		signer:    jwt.GetSigningMethod(alg),
-		parser:    parser,
+		signKey:        signKey,
+		verifyKey:      verifyKey,
+		signer:         jwt.GetSigningMethod(alg),
+		parser:         parser,
+		expires:        24 * time.Hour,
+		refreshExpires: 7 * 24 * time.Hour,
 	}
 }
 
-func (ja *JWTAuth) Encode(claims *jwt.StandardClaims) (t *jwt.Token, tokenString string, err error) {
-	t = jwt.New(ja.signer)
-	t.Claims = claims
-	tokenString, err = t.SignedString(ja.signKey)
+func (ja *JWTAuth) Encode(claims jwt.Claims) (t *jwt.Token, tokenString string, err error) {
+	keyID, signKey := ja.currentKey()
+	t = jwt.NewWithClaims(ja.signer, claims)
+	if keyID != "" {
+		t.Header["kid"] = keyID
+	}
+	tokenString, err = t.SignedString(signKey)
 	t.Raw = tokenString
 	return
 }
 
 func (ja *JWTAuth) Decode(tokenString string) (*jwt.Token, error) {
-	return ja.parser.ParseWithClaims(tokenString, &jwt.StandardClaims{}, ja.keyFunc)
+	return ja.parser.ParseWithClaims(tokenString, &UserClaims{}, ja.keyFunc)
 }
 
 func (ja *JWTAuth) Signer() jwt.SigningMethod {
@@ -172,10 +200,56 @@ func (ja *JWTAuth) Signer() jwt.SigningMethod {
 }
 
 func (ja *JWTAuth) keyFunc(t *jwt.Token) (interface{}, error) {
+	if kid, _ := t.Header["kid"].(string); kid != "" && ja.keys != nil {
+		set := ja.keys.Value().(*keySet)
+		if key, ok := set.keys[kid]; ok {
+			if _, ok := set.ring.Lookup(kid, time.Now()); !ok {
+				return nil, errors.New("jwt 的密钥 '" + kid + "' 已过期")
+			}
+			return key.verifyKey, nil
+		}
+	}
 	if ja.verifyKey != nil {
 		return ja.verifyKey, nil
 	}
-	return ja.signKey, nil
+	if ja.signKey != nil {
+		return ja.signKey, nil
+	}
+	return nil, errors.New("jwt 的密钥没有配置")
+}
+
+type jwtKey struct {
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// keySet 是从密钥文件中解析出来的密钥，过期时间仍然以 ring 为准
+type keySet struct {
+	ring *keyring.Keyring
+	keys map[string]jwtKey
+}
+
+func (ja *JWTAuth) parseKeys(ring *keyring.Keyring) (interface{}, error) {
+	set := &keySet{ring: ring, keys: map[string]jwtKey{}}
+	for _, key := range ring.Keys {
+		signKey, err := ParseSignKey(ja.signer.Alg(), []byte(key.Secret))
+		if err != nil {
+			return nil, errors.Wrap(err, "解析 jwt 的密钥 '"+key.ID+"' 失败")
+		}
+		set.keys[key.ID] = jwtKey{signKey: signKey, verifyKey: PublicKeyOf(signKey)}
+	}
+	return set, nil
+}
+
+// currentKey 返回签名用的密钥，密钥文件中有有效的密钥时用最新的那个，否则用配置中的密钥
+func (ja *JWTAuth) currentKey() (string, interface{}) {
+	if ja.keys != nil {
+		set := ja.keys.Value().(*keySet)
+		if key, ok := set.ring.Current(time.Now()); ok {
+			return key.ID, set.keys[key.ID].signKey
+		}
+	}
+	return ja.keyID, ja.signKey
 }
 
 // TokenFromCookie tries to retreive the token string from a cookie named
@@ -206,15 +280,106 @@ func TokenFromQuery(r *http.Request) string {
 	return r.URL.Query().Get("token")
 }
 
-func New(env *booclient.Environment, jwtUser func(ctx context.Context, req *http.Request, token *jwt.Token) (context.Context, error)) (authn.AuthValidateFunc, error) {
-	jwtAlg := env.Config.StringWithDefault("auth.jwt.alg", "")
-	jwtSignKey := env.Config.StringWithDefault("auth.jwt.sign_key", "")
-	jwtVerifyKey := env.Config.StringWithDefault("auth.jwt.verify_key", "")
+const (
+	CfgJwtAlg            = "auth.jwt.alg"
+	CfgJwtSignKey        = "auth.jwt.sign_key"
+	CfgJwtSignKeyFile    = "auth.jwt.sign_key_file"
+	CfgJwtVerifyKey      = "auth.jwt.verify_key"
+	CfgJwtVerifyKeyFile  = "auth.jwt.verify_key_file"
+	CfgJwtKeyID          = "auth.jwt.key_id"
+	CfgJwtKeysFile       = "auth.jwt.keys_file"
+	CfgJwtIssuer         = "auth.jwt.issuer"
+	CfgJwtExpires        = "auth.jwt.expires"
+	CfgJwtRefreshExpires = "auth.jwt.refresh_expires"
+)
+
+// NewJWTAuthFromEnv 从配置中创建 JWTAuth， HS 系列算法的密钥直接配置在
+// auth.jwt.sign_key 中， RS, PS, ES, EdDSA 和 SM2 算法的密钥为 PEM 格式，
+// 一般放在 auth.jwt.sign_key_file 和 auth.jwt.verify_key_file 指定的文件中，
+// 没有配置验证密钥时从签名密钥中取出公钥。
+//
+// 需要轮换密钥时用 auth.jwt.keys_file 指定一个由 bookeys 命令维护的密钥文件，
+// 签名时用其中最新的密钥，校验时按 kid 查找，上面配置的密钥仍然可以校验没有 kid 或
+// kid 不在密钥文件中的 token
+func NewJWTAuthFromEnv(env *booclient.Environment) (*JWTAuth, error) {
+	alg := env.Config.StringWithDefault(CfgJwtAlg, "")
+	if alg == "" {
+		return NewJWTAuth(alg, nil, nil), nil
+	}
+	if jwt.GetSigningMethod(alg) == nil {
+		return nil, errors.New("jwt 的签名算法 '" + alg + "' 不支持")
+	}
+
+	readKey := func(key, fileKey string) ([]byte, error) {
+		if filename := env.Config.StringWithDefault(fileKey, ""); filename != "" {
+			bs, err := ioutil.ReadFile(booclient.GetRealDir(context.Background(), env, filename))
+			if err != nil {
+				return nil, errors.Wrap(err, "读 jwt 的密钥文件 '"+filename+"' 失败")
+			}
+			return bs, nil
+		}
+		if s := env.Config.StringWithDefault(key, ""); s != "" {
+			return []byte(s), nil
+		}
+		return nil, nil
+	}
+
+	signKeyBytes, err := readKey(CfgJwtSignKey, CfgJwtSignKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	verifyKeyBytes, err := readKey(CfgJwtVerifyKey, CfgJwtVerifyKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var signKey, verifyKey interface{}
+	if len(signKeyBytes) > 0 {
+		signKey, err = ParseSignKey(alg, signKeyBytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "解析 jwt 的签名密钥失败")
+		}
+	}
+	if len(verifyKeyBytes) > 0 {
+		verifyKey, err = ParseVerifyKey(alg, verifyKeyBytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "解析 jwt 的验证密钥失败")
+		}
+	} else if signKey != nil {
+		verifyKey = PublicKeyOf(signKey)
+	}
+	keysFile := env.Config.StringWithDefault(CfgJwtKeysFile, "")
+	if signKey == nil && verifyKey == nil && keysFile == "" {
+		return nil, errors.New("jwt 的密钥没有配置")
+	}
 
-	jwtConfig := NewJWTAuth(jwtAlg, []byte(jwtSignKey), []byte(jwtVerifyKey))
+	ja := NewJWTAuth(alg, signKey, verifyKey)
+	if keysFile != "" {
+		ja.keys, err = keyring.NewFile(env.Logger, booclient.GetRealDir(context.Background(), env, keysFile), ja.parseKeys)
+		if err != nil {
+			return nil, errors.Wrap(err, "读参数 '"+CfgJwtKeysFile+"' 指定的密钥文件失败")
+		}
+	}
+	ja.issuer = env.Config.StringWithDefault(CfgJwtIssuer, "")
+	ja.expires = env.Config.DurationWithDefault(CfgJwtExpires, ja.expires)
+	ja.refreshExpires = env.Config.DurationWithDefault(CfgJwtRefreshExpires, ja.refreshExpires)
+	ja.keyID = env.Config.StringWithDefault(CfgJwtKeyID, "")
+	if ja.keyID == "" {
+		ja.keyID = KeyIDOf(verifyKey)
+	}
+	return ja, nil
+}
+
+func New(env *booclient.Environment, jwtUser func(ctx context.Context, req *http.Request, token *jwt.Token) (context.Context, error)) (authn.AuthValidateFunc, error) {
+	jwtConfig, err := NewJWTAuthFromEnv(env)
+	if err != nil {
+		return nil, err
+	}
 
 	return TokenVerify(
 		[]TokenFindFunc{
+			TokenFromHeader,
+			TokenFromCookie,
 			TokenFromQuery,
 		},
 		[]TokenCheckFunc{
@@ -0,0 +1,349 @@
+package jwt_auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/services/authn/keyring"
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/smx509"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+func newTestEnv(t *testing.T, params map[string]string) *booclient.Environment {
+	t.Helper()
+
+	params["log.filename"] = "console"
+	env, err := booclient.NewEnvironmentWith("boo", "test.properties", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+
+	filename := filepath.Join(dir, name)
+	bs := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(filename, bs, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDer, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm2Key, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm2Der, err := smx509.MarshalPKCS8PrivateKey(sm2Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm2PubDer, err := smx509.MarshalPKIXPublicKey(&sm2Key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		alg     string
+		kty     string
+		crv     string
+		signKey string
+		pubKey  string
+	}{
+		{alg: "RS256", kty: "RSA",
+			signKey: writePEM(t, dir, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))},
+		{alg: "ES256", kty: "EC", crv: "P-256",
+			signKey: writePEM(t, dir, "ec.pem", "EC PRIVATE KEY", ecDer)},
+		{alg: "SM2", kty: "EC", crv: "SM2",
+			signKey: writePEM(t, dir, "sm2.pem", "PRIVATE KEY", sm2Der),
+			pubKey:  writePEM(t, dir, "sm2.pub", "PUBLIC KEY", sm2PubDer)},
+	} {
+		t.Run(test.alg, func(t *testing.T) {
+			params := map[string]string{
+				CfgJwtAlg:         test.alg,
+				CfgJwtSignKeyFile: test.signKey,
+			}
+			if test.pubKey != "" {
+				params[CfgJwtVerifyKeyFile] = test.pubKey
+			}
+			ja, err := NewJWTAuthFromEnv(newTestEnv(t, params))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pair, err := ja.IssueTokenPair(1, "admin", []string{"administrator"}, "abc")
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := ja.Decode(pair.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Method.Alg() != test.alg {
+				t.Error("want", test.alg, "got", token.Method.Alg())
+			}
+			claims := token.Claims.(*UserClaims)
+			if claims.UserID != 1 || claims.Username != "admin" || claims.SessionID != "abc" ||
+				len(claims.Roles) != 1 || claims.TokenType != TokenTypeAccess {
+				t.Errorf("claims is invalid: %#v", claims)
+			}
+
+			jwks := ja.JWKS()
+			if len(jwks.Keys) != 1 {
+				t.Fatal("want 1 key got", len(jwks.Keys))
+			}
+			if jwks.Keys[0].Kty != test.kty || jwks.Keys[0].Crv != test.crv {
+				t.Errorf("jwk is invalid: %#v", jwks.Keys[0])
+			}
+			if jwks.Keys[0].Kid == "" || jwks.Keys[0].Kid != token.Header["kid"] {
+				t.Error("want kid", jwks.Keys[0].Kid, "got", token.Header["kid"])
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	env := newTestEnv(t, map[string]string{
+		CfgJwtAlg:     "HS256",
+		CfgJwtSignKey: "abc",
+		CfgJwtIssuer:  "boo",
+	})
+	ja, err := NewJWTAuthFromEnv(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ja.JWKS().Keys) != 0 {
+		t.Error("hmac key must not be published")
+	}
+
+	var got *UserClaims
+	verify, err := New(env, func(ctx context.Context, req *http.Request, token *jwt.Token) (context.Context, error) {
+		got = token.Claims.(*UserClaims)
+		return ctx, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pair, err := ja.IssueTokenPair(2, "test", nil, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, set := range []func(req *http.Request, token string){
+		func(req *http.Request, token string) { req.Header.Set("Authorization", "Bearer "+token) },
+		func(req *http.Request, token string) { req.AddCookie(&http.Cookie{Name: "token", Value: token}) },
+		func(req *http.Request, token string) { req.URL.RawQuery = "token=" + token },
+	} {
+		got = nil
+		req, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+		set(req, pair.AccessToken)
+		if _, err := verify(context.Background(), req); err != nil {
+			t.Error(err)
+			continue
+		}
+		if got == nil || got.UserID != 2 || got.Username != "test" {
+			t.Errorf("claims is invalid: %#v", got)
+		}
+	}
+
+	// 刷新令牌不能用于访问 api
+	req, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+	req.Header.Set("Authorization", "Bearer "+pair.RefreshToken)
+	if _, err := verify(context.Background(), req); err == nil {
+		t.Error("want error got ok")
+	}
+
+	claims, err := ja.ParseRefreshToken(pair.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.SessionID != "s1" {
+		t.Error("want s1 got", claims.SessionID)
+	}
+	if _, err := ja.ParseRefreshToken(pair.AccessToken); err == nil {
+		t.Error("want error got ok")
+	}
+
+	// 签发者不一致
+	other, err := NewJWTAuthFromEnv(newTestEnv(t, map[string]string{
+		CfgJwtAlg:     "HS256",
+		CfgJwtSignKey: "abc",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPair, err := other.IssueTokenPair(2, "test", nil, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ja.ParseRefreshToken(otherPair.RefreshToken); err == nil {
+		t.Error("want error got ok")
+	}
+
+	// 过期
+	_, expired, err := ja.Encode(&UserClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "boo",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+		UserID:    2,
+		Username:  "test",
+		TokenType: TokenTypeAccess,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest("GET", "http://127.0.0.1/", nil)
+	req.Header.Set("Authorization", "Bearer "+expired)
+	if _, err := verify(context.Background(), req); err != authn.ErrTokenExpired {
+		t.Error("want ErrTokenExpired got", err)
+	}
+}
+
+func TestInmemRevocations(t *testing.T) {
+	ctx := context.Background()
+	r := NewInmemRevocations()
+
+	if ok, err := r.Revoke(ctx, "a", time.Now().Add(-time.Minute)); err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	if ok, err := r.Revoke(ctx, "b", time.Now().Add(time.Minute)); err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	// 重复吊销时返回 false
+	if ok, err := r.Revoke(ctx, "b", time.Now().Add(time.Minute)); err != nil || ok {
+		t.Error("want false got", ok, err)
+	}
+	if revoked, _ := r.IsRevoked(ctx, "b"); !revoked {
+		t.Error("want revoked")
+	}
+	if revoked, _ := r.IsRevoked(ctx, "c"); revoked {
+		t.Error("want not revoked")
+	}
+	// 已过期的会在下一次吊销时被清除
+	if _, ok := r.list["a"]; ok {
+		t.Error("want a is removed")
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "keys.json")
+	secret, err := GenerateKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring := &keyring.Keyring{}
+	first := ring.Rotate(secret, time.Hour, time.Now())
+	if err := keyring.WriteFile(filename, ring); err != nil {
+		t.Fatal(err)
+	}
+
+	ja, err := NewJWTAuthFromEnv(newTestEnv(t, map[string]string{
+		CfgJwtAlg:      "ES256",
+		CfgJwtKeysFile: filename,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ja.keys.SetCheckInterval(0)
+
+	_, oldToken, err := ja.Encode(&UserClaims{UserID: 1, Username: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err = GenerateKey("ES256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := ring.Rotate(secret, time.Hour, time.Now())
+	if err := keyring.WriteFile(filename, ring); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filename, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+
+	newToken, _, err := ja.Encode(&UserClaims{UserID: 1, Username: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newToken.Header["kid"] != second.ID {
+		t.Error("want", second.ID, "got", newToken.Header["kid"])
+	}
+	if _, err := ja.Decode(oldToken); err != nil {
+		t.Error(err)
+	}
+	if len(ja.JWKS().Keys) != 2 {
+		t.Error("want 2 keys got", len(ja.JWKS().Keys))
+	}
+
+	if err := ring.Retire(first.ID, 0, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.WriteFile(filename, ring); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filename, time.Now().Add(2*time.Minute), time.Now().Add(2*time.Minute))
+
+	if _, err := ja.Decode(oldToken); err == nil {
+		t.Error("want error got ok")
+	}
+	if _, err := ja.Decode(newToken.Raw); err != nil {
+		t.Error(err)
+	}
+	if jwks := ja.JWKS(); len(jwks.Keys) != 1 || jwks.Keys[0].Kid != second.ID {
+		t.Errorf("jwks is invalid: %#v", jwks)
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	for _, alg := range []string{"HS256", "RS256", "PS256", "ES384", "EdDSA", "SM2"} {
+		secret, err := GenerateKey(alg)
+		if err != nil {
+			t.Error(alg, err)
+			continue
+		}
+		signKey, err := ParseSignKey(alg, []byte(secret))
+		if err != nil {
+			t.Error(alg, err)
+			continue
+		}
+		token, err := jwt.NewWithClaims(jwt.GetSigningMethod(alg), jwt.RegisteredClaims{}).SignedString(signKey)
+		if err != nil {
+			t.Error(alg, err)
+			continue
+		}
+		if _, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return PublicKeyOf(signKey), nil }); err != nil {
+			t.Error(alg, err)
+		}
+	}
+}
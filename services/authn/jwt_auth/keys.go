@@ -0,0 +1,275 @@
+package jwt_auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn/keyring"
+	"github.com/emmansun/gmsm/sm2"
+	"github.com/emmansun/gmsm/smx509"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+func isHMAC(alg string) bool {
+	return strings.HasPrefix(alg, "HS")
+}
+
+// ParseSignKey 按签名算法解析签名密钥， HS 系列算法直接使用密钥的字节，
+// 其它算法使用 PEM 格式的私钥
+func ParseSignKey(alg string, data []byte) (interface{}, error) {
+	switch {
+	case isHMAC(alg):
+		return data, nil
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		return jwt.ParseRSAPrivateKeyFromPEM(data)
+	case strings.HasPrefix(alg, "ES"):
+		return jwt.ParseECPrivateKeyFromPEM(data)
+	case alg == "EdDSA":
+		return jwt.ParseEdPrivateKeyFromPEM(data)
+	case alg == signingMethodSM2.Alg():
+		return parseSM2PrivateKeyFromPEM(data)
+	}
+	return nil, errors.New("jwt 的签名算法 '" + alg + "' 不支持")
+}
+
+// ParseVerifyKey 按签名算法解析验证密钥， HS 系列算法直接使用密钥的字节，
+// 其它算法使用 PEM 格式的公钥或证书
+func ParseVerifyKey(alg string, data []byte) (interface{}, error) {
+	switch {
+	case isHMAC(alg):
+		return data, nil
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		return jwt.ParseRSAPublicKeyFromPEM(data)
+	case strings.HasPrefix(alg, "ES"):
+		return jwt.ParseECPublicKeyFromPEM(data)
+	case alg == "EdDSA":
+		return jwt.ParseEdPublicKeyFromPEM(data)
+	case alg == signingMethodSM2.Alg():
+		return parseSM2PublicKeyFromPEM(data)
+	}
+	return nil, errors.New("jwt 的签名算法 '" + alg + "' 不支持")
+}
+
+func parseSM2PrivateKeyFromPEM(data []byte) (*sm2.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+	if key, err := smx509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if priv, ok := key.(*sm2.PrivateKey); ok {
+			return priv, nil
+		}
+		return nil, errors.New("密钥不是 SM2 私钥")
+	}
+	return smx509.ParseSM2PrivateKey(block.Bytes)
+}
+
+func parseSM2PublicKeyFromPEM(data []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+
+	var key interface{}
+	if block.Type == "CERTIFICATE" {
+		cert, err := smx509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	} else {
+		var err error
+		key, err = smx509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok || !sm2.IsSM2PublicKey(pub) {
+		return nil, errors.New("密钥不是 SM2 公钥")
+	}
+	return pub, nil
+}
+
+// PublicKeyOf 返回私钥对应的公钥， HS 系列算法的密钥原样返回
+func PublicKeyOf(signKey interface{}) interface{} {
+	switch k := signKey.(type) {
+	case *sm2.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case crypto.Signer:
+		return k.Public()
+	}
+	return signKey
+}
+
+// KeyIDOf 用公钥的 sha256 值生成一个 kid， HS 系列算法的密钥不能公开，返回空字符串
+func KeyIDOf(verifyKey interface{}) string {
+	switch verifyKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return ""
+	}
+	der, err := smx509.MarshalPKIXPublicKey(verifyKey)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// JSONWebKey 是 RFC 7517 中的公钥格式
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func encodeCoordinate(n *big.Int, size int) string {
+	bs := n.Bytes()
+	if len(bs) < size {
+		padded := make([]byte, size)
+		copy(padded[size-len(bs):], bs)
+		bs = padded
+	}
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+// ToJSONWebKey 将公钥转换为 JWK， HS 系列算法的密钥不能公开，返回 false
+func ToJSONWebKey(alg, kid string, verifyKey interface{}) (JSONWebKey, bool) {
+	jwk := JSONWebKey{
+		Kid: kid,
+		Use: "sig",
+		Alg: alg,
+	}
+	switch k := verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.Kty = "EC"
+		if sm2.IsSM2PublicKey(k) {
+			jwk.Crv = "SM2"
+		} else {
+			jwk.Crv = k.Curve.Params().Name
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.X = encodeCoordinate(k.X, size)
+		jwk.Y = encodeCoordinate(k.Y, size)
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(k)
+	default:
+		return jwk, false
+	}
+	return jwk, true
+}
+
+// JWKS 返回用于验证 token 的公钥集合，包括密钥文件中所有有效的密钥，使用 HS 系列算法时为空
+func (ja *JWTAuth) JWKS() *JSONWebKeySet {
+	keys := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	if ja.signer == nil {
+		return keys
+	}
+	seen := map[string]bool{}
+	if ja.keys != nil {
+		set := ja.keys.Value().(*keySet)
+		for _, key := range set.ring.Active(time.Now()) {
+			if jwk, ok := ToJSONWebKey(ja.signer.Alg(), key.ID, set.keys[key.ID].verifyKey); ok {
+				keys.Keys = append(keys.Keys, jwk)
+				seen[key.ID] = true
+			}
+		}
+	}
+
+	verifyKey := ja.verifyKey
+	if verifyKey == nil {
+		verifyKey = PublicKeyOf(ja.signKey)
+	}
+	if seen[ja.keyID] {
+		return keys
+	}
+	if jwk, ok := ToJSONWebKey(ja.signer.Alg(), ja.keyID, verifyKey); ok {
+		keys.Keys = append(keys.Keys, jwk)
+	}
+	return keys
+}
+
+// GenerateKey 按签名算法生成一个新的签名密钥， HS 系列算法返回随机的字符串，
+// 其它算法返回 PEM 格式的私钥，它用于轮换密钥
+func GenerateKey(alg string) (string, error) {
+	var der []byte
+	var err error
+	switch {
+	case isHMAC(alg):
+		return keyring.GenerateSecret()
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		var key *rsa.PrivateKey
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err == nil {
+			der, err = x509.MarshalPKCS8PrivateKey(key)
+		}
+	case strings.HasPrefix(alg, "ES"):
+		var curve elliptic.Curve
+		switch alg {
+		case "ES256":
+			curve = elliptic.P256()
+		case "ES384":
+			curve = elliptic.P384()
+		case "ES512":
+			curve = elliptic.P521()
+		default:
+			return "", errors.New("jwt 的签名算法 '" + alg + "' 不支持")
+		}
+		var key *ecdsa.PrivateKey
+		key, err = ecdsa.GenerateKey(curve, rand.Reader)
+		if err == nil {
+			der, err = x509.MarshalPKCS8PrivateKey(key)
+		}
+	case alg == "EdDSA":
+		var key ed25519.PrivateKey
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+		if err == nil {
+			der, err = x509.MarshalPKCS8PrivateKey(key)
+		}
+	case alg == signingMethodSM2.Alg():
+		var key *sm2.PrivateKey
+		key, err = sm2.GenerateKey(rand.Reader)
+		if err == nil {
+			der, err = smx509.MarshalPKCS8PrivateKey(key)
+		}
+	default:
+		return "", errors.New("jwt 的签名算法 '" + alg + "' 不支持")
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "生成 jwt 的密钥失败")
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
@@ -0,0 +1,63 @@
+package jwt_auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Revocations 是已吊销的 token 列表， id 为 token 的 jti，
+// 过期时间之后 token 本身已失效，不需要再保存它
+type Revocations interface {
+	// Revoke 吊销 token，返回 false 表示 token 在这之前已经被吊销了
+	Revoke(ctx context.Context, id string, expiresAt time.Time) (bool, error)
+	IsRevoked(ctx context.Context, id string) (bool, error)
+}
+
+// RevokeClaims 吊销 claims 对应的 token
+func RevokeClaims(ctx context.Context, revocations Revocations, claims *UserClaims) error {
+	if revocations == nil || claims == nil || claims.ID == "" {
+		return nil
+	}
+	_, err := revocations.Revoke(ctx, claims.ID, claims.ExpiresTime())
+	return err
+}
+
+// NewInmemRevocations 创建一个保存在内存中的吊销列表，它只适用于单个实例
+func NewInmemRevocations() *InmemRevocations {
+	return &InmemRevocations{
+		list: map[string]time.Time{},
+	}
+}
+
+var _ Revocations = &InmemRevocations{}
+
+type InmemRevocations struct {
+	mu   sync.Mutex
+	list map[string]time.Time
+}
+
+func (r *InmemRevocations) Revoke(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for key, t := range r.list {
+		if !t.IsZero() && t.Before(now) {
+			delete(r.list, key)
+		}
+	}
+	if _, ok := r.list[id]; ok {
+		return false, nil
+	}
+	r.list[id] = expiresAt
+	return true, nil
+}
+
+func (r *InmemRevocations) IsRevoked(ctx context.Context, id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.list[id]
+	return ok, nil
+}
@@ -0,0 +1,67 @@
+package jwt_auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+
+	"github.com/boo-admin/boo/errors"
+	"github.com/emmansun/gmsm/sm2"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+// ErrSM2Verification 签名不正确
+var ErrSM2Verification = errors.New("crypto/sm2: verification error")
+
+// SigningMethodSM2 是国密 SM2 签名算法，签名使用缺省的用户 ID 并按 ASN.1 编码
+type SigningMethodSM2 struct{}
+
+var signingMethodSM2 = &SigningMethodSM2{}
+
+func init() {
+	jwt.RegisterSigningMethod(signingMethodSM2.Alg(), func() jwt.SigningMethod {
+		return signingMethodSM2
+	})
+}
+
+func (m *SigningMethodSM2) Alg() string {
+	return "SM2"
+}
+
+// Verify 校验签名， key 必须为 SM2 曲线上的 *ecdsa.PublicKey
+func (m *SigningMethodSM2) Verify(signingString, signature string, key interface{}) error {
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	var pub *ecdsa.PublicKey
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		pub = k
+	case *sm2.PrivateKey:
+		pub = &k.PublicKey
+	default:
+		return jwt.ErrInvalidKeyType
+	}
+	if !sm2.IsSM2PublicKey(pub) {
+		return jwt.ErrInvalidKeyType
+	}
+
+	if !sm2.VerifyASN1WithSM2(pub, nil, []byte(signingString), sig) {
+		return ErrSM2Verification
+	}
+	return nil
+}
+
+// Sign 签名， key 必须为 *sm2.PrivateKey
+func (m *SigningMethodSM2) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(*sm2.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	sig, err := sm2.SignASN1(rand.Reader, priv, []byte(signingString), sm2.DefaultSM2SignerOpts)
+	if err != nil {
+		return "", err
+	}
+	return jwt.EncodeSegment(sig), nil
+}
@@ -0,0 +1,296 @@
+// Package keyring 管理 cookie 和 jwt 的签名密钥，它支持同时存在多个密钥，
+// 签名时使用最新的密钥，校验时按密钥 ID 查找，轮换密钥后旧的密钥在宽限期内仍然可以校验，
+// 这样更换密钥时不会让所有用户同时退出登录。
+//
+// 密钥保存在一个 json 文件中，用 bookeys 命令轮换和停用，服务会自动重新加载修改后的文件。
+package keyring
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boo-admin/boo/errors"
+	"golang.org/x/exp/slog"
+)
+
+// DefaultCheckInterval 是检查密钥文件是否被修改的间隔
+const DefaultCheckInterval = 10 * time.Second
+
+// Key 是一个签名密钥， ID 会作为 cookie 的前缀或 jwt 的 kid,
+// Secret 对于 hmac 算法是密钥本身，对于其它算法是 PEM 格式的私钥，
+// ExpiresAt 为空表示它一直有效，过期后不再用于校验
+type Key struct {
+	ID        string     `json:"id"`
+	Secret    string     `json:"secret"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// IsActive 密钥在 now 时是否有效
+func (key *Key) IsActive(now time.Time) bool {
+	return key.ExpiresAt == nil || now.Before(*key.ExpiresAt)
+}
+
+type Keyring struct {
+	Keys []Key `json:"keys"`
+}
+
+// Active 返回有效的密钥，最新的在前面
+func (r *Keyring) Active(now time.Time) []Key {
+	var keys []Key
+	for _, key := range r.Keys {
+		if key.IsActive(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys
+}
+
+// Current 返回用于签名的密钥，即最新的有效密钥
+func (r *Keyring) Current(now time.Time) (Key, bool) {
+	keys := r.Active(now)
+	if len(keys) == 0 {
+		return Key{}, false
+	}
+	return keys[0], true
+}
+
+// Lookup 按 ID 查找有效的密钥
+func (r *Keyring) Lookup(id string, now time.Time) (Key, bool) {
+	for _, key := range r.Keys {
+		if key.ID == id {
+			if key.IsActive(now) {
+				return key, true
+			}
+			break
+		}
+	}
+	return Key{}, false
+}
+
+// Prune 删除已过期的密钥
+func (r *Keyring) Prune(now time.Time) int {
+	keys := r.Keys[:0]
+	for _, key := range r.Keys {
+		if key.IsActive(now) {
+			keys = append(keys, key)
+		}
+	}
+	count := len(r.Keys) - len(keys)
+	r.Keys = keys
+	return count
+}
+
+// Rotate 添加一个新的密钥并将它作为签名密钥，原来的密钥在 grace 之后过期，
+// 已过期的密钥会被删除
+func (r *Keyring) Rotate(secret string, grace time.Duration, now time.Time) Key {
+	r.Prune(now)
+
+	expiresAt := now.Add(grace)
+	for idx := range r.Keys {
+		if r.Keys[idx].ExpiresAt == nil || r.Keys[idx].ExpiresAt.After(expiresAt) {
+			r.Keys[idx].ExpiresAt = &expiresAt
+		}
+	}
+
+	key := Key{
+		ID:        NewKeyID(),
+		Secret:    secret,
+		CreatedAt: now,
+	}
+	r.Keys = append(r.Keys, key)
+	return key
+}
+
+// Retire 让指定的密钥在 grace 之后过期，当前的签名密钥不能停用，必须先轮换
+func (r *Keyring) Retire(id string, grace time.Duration, now time.Time) error {
+	if current, ok := r.Current(now); ok && current.ID == id {
+		return errors.New("密钥 '" + id + "' 是当前的签名密钥，不能停用，请先轮换密钥")
+	}
+	for idx := range r.Keys {
+		if r.Keys[idx].ID == id {
+			expiresAt := now.Add(grace)
+			r.Keys[idx].ExpiresAt = &expiresAt
+			return nil
+		}
+	}
+	return errors.New("密钥 '" + id + "' 不存在")
+}
+
+// NewKeyID 生成一个密钥 ID, 它只包含小写的十六进制字符，可以放在 cookie 的前缀中
+func NewKeyID() string {
+	var bs [6]byte
+	if _, err := rand.Read(bs[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(bs[:])
+}
+
+// GenerateSecret 生成一个随机的 hmac 密钥
+func GenerateSecret() (string, error) {
+	var bs [32]byte
+	if _, err := rand.Read(bs[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs[:]), nil
+}
+
+// ReadFile 读密钥文件，文件不存在时返回的错误可以用 os.IsNotExist 判断
+func ReadFile(filename string) (*Keyring, error) {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var ring Keyring
+	if err := json.Unmarshal(bs, &ring); err != nil {
+		return nil, errors.Wrap(err, "解析密钥文件 '"+filename+"' 失败")
+	}
+	return &ring, nil
+}
+
+// WriteFile 写密钥文件，它先写到临时文件中再改名，避免服务读到写了一半的文件
+func WriteFile(filename string, ring *Keyring) error {
+	bs, err := json.MarshalIndent(ring, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return errors.Wrap(err, "写密钥文件 '"+filename+"' 失败")
+	}
+	tmpname := tmp.Name()
+	_, err = tmp.Write(bs)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpname, 0o600)
+	}
+	if err == nil {
+		err = os.Rename(tmpname, filename)
+	}
+	if err != nil {
+		os.Remove(tmpname)
+		return errors.Wrap(err, "写密钥文件 '"+filename+"' 失败")
+	}
+	return nil
+}
+
+// File 是从文件中加载的密钥，文件被修改后会自动重新加载，
+// parse 用于将密钥解析为使用者需要的格式，它只在文件被修改后调用一次
+type File struct {
+	logger   *slog.Logger
+	filename string
+	parse    func(ring *Keyring) (interface{}, error)
+	interval time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	modTime   time.Time
+	size      int64
+	ring      *Keyring
+	value     interface{}
+}
+
+// NewFile 加载密钥文件， parse 可以为 nil
+func NewFile(logger *slog.Logger, filename string, parse func(ring *Keyring) (interface{}, error)) (*File, error) {
+	f := &File{
+		logger:   logger,
+		filename: filename,
+		parse:    parse,
+		interval: DefaultCheckInterval,
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "读密钥文件 '"+filename+"' 失败")
+	}
+	if err := f.load(fi); err != nil {
+		return nil, err
+	}
+	f.checkedAt = time.Now()
+	return f, nil
+}
+
+func (f *File) load(fi os.FileInfo) error {
+	ring, err := ReadFile(f.filename)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if f.parse != nil {
+		value, err = f.parse(ring)
+		if err != nil {
+			return errors.Wrap(err, "解析密钥文件 '"+f.filename+"' 失败")
+		}
+	}
+	f.ring = ring
+	f.value = value
+	f.modTime = fi.ModTime()
+	f.size = fi.Size()
+	return nil
+}
+
+func (f *File) reload() {
+	now := time.Now()
+	if now.Sub(f.checkedAt) < f.interval {
+		return
+	}
+	f.checkedAt = now
+
+	fi, err := os.Stat(f.filename)
+	if err != nil {
+		f.logger.Warn("检查密钥文件失败，继续使用原来的密钥", slog.String("filename", f.filename), slog.Any("error", err))
+		return
+	}
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return
+	}
+	if err := f.load(fi); err != nil {
+		f.logger.Warn("重新加载密钥文件失败，继续使用原来的密钥", slog.String("filename", f.filename), slog.Any("error", err))
+		return
+	}
+	f.logger.Info("密钥文件已重新加载", slog.String("filename", f.filename))
+}
+
+// SetCheckInterval 设置检查密钥文件是否被修改的间隔
+func (f *File) SetCheckInterval(interval time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.interval = interval
+}
+
+// Keyring 返回当前的密钥，返回值不能修改
+func (f *File) Keyring() *Keyring {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reload()
+	return f.ring
+}
+
+// Value 返回 parse 解析后的值
+func (f *File) Value() interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reload()
+	return f.value
+}
+
+// Current 返回用于签名的密钥
+func (f *File) Current() (Key, bool) {
+	return f.Keyring().Current(time.Now())
+}
+
+// Lookup 按 ID 查找有效的密钥
+func (f *File) Lookup(id string) (Key, bool) {
+	return f.Keyring().Lookup(id, time.Now())
+}
@@ -0,0 +1,106 @@
+package keyring
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestRotate(t *testing.T) {
+	now := time.Now()
+	ring := &Keyring{}
+
+	first := ring.Rotate("a", time.Hour, now)
+	if current, ok := ring.Current(now); !ok || current.ID != first.ID {
+		t.Error("want", first.ID, "got", current.ID)
+	}
+
+	second := ring.Rotate("b", time.Hour, now.Add(time.Second))
+	if current, _ := ring.Current(now.Add(time.Second)); current.ID != second.ID {
+		t.Error("want", second.ID, "got", current.ID)
+	}
+	if _, ok := ring.Lookup(first.ID, now.Add(30*time.Minute)); !ok {
+		t.Error("want first key is active in grace period")
+	}
+	if _, ok := ring.Lookup(first.ID, now.Add(2*time.Hour)); ok {
+		t.Error("want first key is expired after grace period")
+	}
+	if err := ring.Retire(second.ID, 0, now.Add(time.Second)); err == nil {
+		t.Error("want error got ok")
+	}
+
+	// 再次轮换时已过期的密钥会被删除
+	third := ring.Rotate("c", time.Minute, now.Add(2*time.Hour))
+	if len(ring.Keys) != 2 || ring.Keys[0].ID != second.ID || ring.Keys[1].ID != third.ID {
+		t.Errorf("keys is invalid: %#v", ring.Keys)
+	}
+	if err := ring.Retire(second.ID, 0, now.Add(2*time.Hour)); err != nil {
+		t.Error(err)
+	}
+	if keys := ring.Active(now.Add(2 * time.Hour)); len(keys) != 1 || keys[0].ID != third.ID {
+		t.Errorf("active keys is invalid: %#v", keys)
+	}
+	if count := ring.Prune(now.Add(2 * time.Hour)); count != 1 {
+		t.Error("want 1 got", count)
+	}
+}
+
+func TestFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "keys.json")
+	if _, err := NewFile(slog.Default(), filename, nil); err == nil {
+		t.Error("want error got ok")
+	}
+
+	ring := &Keyring{}
+	first := ring.Rotate("a", time.Hour, time.Now())
+	if err := WriteFile(filename, ring); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed := 0
+	f, err := NewFile(slog.Default(), filename, func(ring *Keyring) (interface{}, error) {
+		parsed++
+		return len(ring.Keys), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, ok := f.Current(); !ok || current.ID != first.ID {
+		t.Error("want", first.ID, "got", current.ID)
+	}
+
+	second := ring.Rotate("b", time.Hour, time.Now())
+	if err := WriteFile(filename, ring); err != nil {
+		t.Fatal(err)
+	}
+	// 让修改时间和原来的不一样
+	os.Chtimes(filename, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+
+	// 检查间隔之内不会重新加载
+	if current, _ := f.Current(); current.ID != first.ID {
+		t.Error("want", first.ID, "got", current.ID)
+	}
+
+	f.SetCheckInterval(0)
+	if current, _ := f.Current(); current.ID != second.ID {
+		t.Error("want", second.ID, "got", current.ID)
+	}
+	if _, ok := f.Lookup(first.ID); !ok {
+		t.Error("want first key is active")
+	}
+	if count := f.Value().(int); count != 2 || parsed != 2 {
+		t.Error("want 2 got", count, parsed)
+	}
+
+	// 文件内容错误时继续使用原来的密钥
+	if err := os.WriteFile(filename, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filename, time.Now().Add(2*time.Minute), time.Now().Add(2*time.Minute))
+	if current, _ := f.Current(); current.ID != second.ID {
+		t.Error("want", second.ID, "got", current.ID)
+	}
+}
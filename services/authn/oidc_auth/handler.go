@@ -0,0 +1,151 @@
+package oidc_auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn/session_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+)
+
+// StateCookieName 是登录过程中保存 state, nonce 和 code_verifier 的 cookie
+const StateCookieName = "boo_oidc"
+
+const stateCookieMaxAge = 10 * 60
+
+// Handler 处理 OIDC 的登录跳转和回调，跳转前将 state 等信息签名后保存在 cookie 中
+type Handler struct {
+	Provider *Provider
+	Cookie   *session_auth.Option
+}
+
+func NewHandlerFromEnv(env *booclient.Environment) (*Handler, error) {
+	config, err := NewConfigFromEnv(env)
+	if err != nil {
+		return nil, err
+	}
+	cookieOpt, err := session_auth.NewOption(env)
+	if err != nil {
+		return nil, errors.Wrap(err, "init session cookie")
+	}
+	return &Handler{
+		Provider: NewProvider(*config),
+		Cookie:   cookieOpt,
+	}, nil
+}
+
+func (h *Handler) stateCookie(value string, maxAge int) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     StateCookieName,
+		Value:    value,
+		Domain:   h.Cookie.SessionDomain,
+		Path:     h.Cookie.SessionPath,
+		HttpOnly: true,
+		Secure:   h.Cookie.SessionSecure,
+		MaxAge:   maxAge,
+		// 回调是从认证服务跳转回来的，必须是 Lax 浏览器才会带上 cookie
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge < 0 {
+		cookie.Expires = time.Unix(0, 0)
+	}
+	return cookie
+}
+
+// Redirect 生成 state, nonce 和 code_verifier 并跳转到认证服务的登录页面
+func (h *Handler) Redirect(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	state, err := GenerateRandomString()
+	if err != nil {
+		return errors.Wrap(err, "生成 state 失败")
+	}
+	nonce, err := GenerateRandomString()
+	if err != nil {
+		return errors.Wrap(err, "生成 nonce 失败")
+	}
+	codeVerifier, err := GenerateRandomString()
+	if err != nil {
+		return errors.Wrap(err, "生成 code_verifier 失败")
+	}
+
+	authURL, err := h.Provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+	if err != nil {
+		return err
+	}
+
+	values := url.Values{}
+	values.Set("state", state)
+	values.Set("nonce", nonce)
+	values.Set("code_verifier", codeVerifier)
+	values.Set(session_auth.SESSION_EXPIRE_KEY, session_auth.GetExpiration(time.Now().Add(stateCookieMaxAge*time.Second)))
+	if returnURL := req.URL.Query().Get("return_url"); session_auth.IsSafeReturnURL(returnURL) {
+		values.Set("return_url", returnURL)
+	}
+	value, err := h.Cookie.Encode(values)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, h.stateCookie(value, stateCookieMaxAge))
+	http.Redirect(w, req, authURL, http.StatusFound)
+	return nil
+}
+
+// readState 读 Redirect 中保存的 cookie, 它不是会话所以不能用 session_auth.GetValues
+func (h *Handler) readState(req *http.Request) (url.Values, error) {
+	cookie, err := req.Cookie(StateCookieName)
+	if err != nil {
+		if err == http.ErrNoCookie {
+			return nil, session_auth.ErrCookieNotFound
+		}
+		return nil, err
+	}
+
+	values, err := h.Cookie.Decode(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	if session_auth.TimeoutExpiredOrMissing(values) {
+		return nil, session_auth.ErrSessionExpiredOrMissing
+	}
+	return values, nil
+}
+
+// Callback 校验回调中的 state, 用授权码换取 id_token 并校验它，返回用户身份和登录后要跳转的地址
+func (h *Handler) Callback(ctx context.Context, w http.ResponseWriter, req *http.Request) (*session_core.ExternalIdentity, string, error) {
+	values, err := h.readState(req)
+	// state 只能用一次
+	http.SetCookie(w, h.stateCookie("", -1))
+	if err != nil {
+		return nil, "", errors.WithHTTPCode(errors.Wrap(err, "读登录状态失败"), http.StatusUnauthorized)
+	}
+
+	query := req.URL.Query()
+	if s := query.Get("error"); s != "" {
+		return nil, "", errors.WithHTTPCode(errors.New("认证服务返回错误，"+s+": "+query.Get("error_description")), http.StatusUnauthorized)
+	}
+	state := values.Get("state")
+	if state == "" || state != query.Get("state") {
+		return nil, "", errors.WithHTTPCode(errors.New("登录状态不正确，state 不一致"), http.StatusUnauthorized)
+	}
+	code := query.Get("code")
+	if code == "" {
+		return nil, "", errors.WithHTTPCode(errors.New("认证服务没有返回授权码"), http.StatusUnauthorized)
+	}
+
+	token, err := h.Provider.Exchange(ctx, code, values.Get("code_verifier"))
+	if err != nil {
+		return nil, "", errors.WithHTTPCode(err, http.StatusUnauthorized)
+	}
+	claims, err := h.Provider.VerifyIDToken(ctx, token.IDToken, values.Get("nonce"))
+	if err != nil {
+		return nil, "", err
+	}
+	identity, err := h.Provider.Identity(claims)
+	if err != nil {
+		return nil, "", err
+	}
+	return identity, values.Get("return_url"), nil
+}
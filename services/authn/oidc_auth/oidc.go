@@ -0,0 +1,471 @@
+package oidc_auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn/session_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	CfgOidcEnabled       = "users.oidc.enabled"
+	CfgOidcIssuer        = "users.oidc.issuer"
+	CfgOidcClientID      = "users.oidc.client_id"
+	CfgOidcClientSecret  = "users.oidc.client_secret"
+	CfgOidcRedirectURL   = "users.oidc.redirect_url"
+	CfgOidcScopes        = "users.oidc.scopes"
+	CfgOidcUsernameClaim = "users.oidc.username_claim"
+	CfgOidcNicknameClaim = "users.oidc.nickname_claim"
+	CfgOidcRolesClaim    = "users.oidc.roles_claim"
+	CfgOidcClaimFields   = "users.oidc.claim_fields"
+	CfgOidcDefaultRoles  = "users.oidc.default_roles"
+)
+
+// Source 是通过 OIDC 登录的用户的来源， UserService.ValidateUser 不会检查这类用户的密码
+const Source = "oauth"
+
+// Config 是 OIDC 的配置
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// UsernameClaim 是用户名对应的 claim, NicknameClaim 是中文名对应的 claim,
+	// RolesClaim 是角色列表对应的 claim
+	UsernameClaim string
+	NicknameClaim string
+	RolesClaim    string
+
+	// ClaimFields 是 claim 到用户自定义字段的映射
+	ClaimFields  map[string]string
+	DefaultRoles []string
+
+	HTTPClient *http.Client
+}
+
+// NewConfigFromEnv 从配置中读取 OIDC 的配置，格式如下
+//
+//	users.oidc.issuer=https://idp.example.com
+//	users.oidc.client_id=boo
+//	users.oidc.client_secret=secret
+//	users.oidc.redirect_url=https://boo.example.com/boo/login/oidc/callback
+//	users.oidc.claim_fields=email:email,phone_number:phone
+func NewConfigFromEnv(env *booclient.Environment) (*Config, error) {
+	config := &Config{
+		Issuer:        strings.TrimSuffix(env.Config.StringWithDefault(CfgOidcIssuer, ""), "/"),
+		ClientID:      env.Config.StringWithDefault(CfgOidcClientID, ""),
+		ClientSecret:  env.Config.StringWithDefault(CfgOidcClientSecret, ""),
+		RedirectURL:   env.Config.StringWithDefault(CfgOidcRedirectURL, ""),
+		Scopes:        session_auth.SplitList(env.Config.StringWithDefault(CfgOidcScopes, "openid,profile,email")),
+		UsernameClaim: env.Config.StringWithDefault(CfgOidcUsernameClaim, "preferred_username"),
+		NicknameClaim: env.Config.StringWithDefault(CfgOidcNicknameClaim, "name"),
+		RolesClaim:    env.Config.StringWithDefault(CfgOidcRolesClaim, ""),
+		DefaultRoles:  session_auth.SplitList(env.Config.StringWithDefault(CfgOidcDefaultRoles, "")),
+	}
+	if config.Issuer == "" {
+		return nil, errors.New("读 " + CfgOidcIssuer + " 失败，没有在配置中找到它")
+	}
+	if config.ClientID == "" {
+		return nil, errors.New("读 " + CfgOidcClientID + " 失败，没有在配置中找到它")
+	}
+	if config.RedirectURL == "" {
+		return nil, errors.New("读 " + CfgOidcRedirectURL + " 失败，没有在配置中找到它")
+	}
+
+	for _, s := range session_auth.SplitList(env.Config.StringWithDefault(CfgOidcClaimFields, "")) {
+		ss := strings.SplitN(s, ":", 2)
+		if len(ss) != 2 || strings.TrimSpace(ss[0]) == "" || strings.TrimSpace(ss[1]) == "" {
+			return nil, errors.New("参数 '" + CfgOidcClaimFields + "' 的值 '" + s + "' 格式不正确，它应该是 'claim:field'")
+		}
+		if config.ClaimFields == nil {
+			config.ClaimFields = map[string]string{}
+		}
+		config.ClaimFields[strings.TrimSpace(ss[0])] = strings.TrimSpace(ss[1])
+	}
+	return config, nil
+}
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// Token 是用授权码换回来的 token
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Provider 是 OIDC 认证服务的客户端，它会缓存认证服务的元数据和公钥
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu           sync.Mutex
+	metadata     *providerMetadata
+	keys         map[string]interface{}
+	keysLoadedAt time.Time
+}
+
+func NewProvider(config Config) *Provider {
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Provider{
+		config: config,
+		client: client,
+	}
+}
+
+func (p *Provider) getJSON(ctx context.Context, urlstr string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlstr, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status + ": " + string(bs))
+	}
+	return json.Unmarshal(bs, result)
+}
+
+func (p *Provider) discover(ctx context.Context) (*providerMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata providerMetadata
+	err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &metadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "读 OIDC 认证服务 '"+p.config.Issuer+"' 的配置失败")
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.config.Issuer {
+		return nil, errors.New("OIDC 认证服务的 issuer '" + metadata.Issuer + "' 与配置 '" + p.config.Issuer + "' 不一致")
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JwksURI == "" {
+		return nil, errors.New("OIDC 认证服务 '" + p.config.Issuer + "' 的配置不完整")
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// AuthCodeURL 返回认证服务的登录地址， codeVerifier 用于 PKCE
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", strings.Join(p.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", CodeChallengeS256(codeVerifier))
+	params.Set("code_challenge_method", "S256")
+
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		return metadata.AuthorizationEndpoint + "&" + params.Encode(), nil
+	}
+	return metadata.AuthorizationEndpoint + "?" + params.Encode(), nil
+}
+
+// Exchange 用授权码换取 token
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (*Token, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", code)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("client_id", p.config.ClientID)
+	params.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, errors.Wrap(err, "用授权码换取 token 失败")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "用授权码换取 token 失败")
+	}
+	defer resp.Body.Close()
+
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "用授权码换取 token 失败")
+	}
+	var token Token
+	if err := json.Unmarshal(bs, &token); err != nil {
+		return nil, errors.Wrap(err, "用授权码换取 token 失败，"+resp.Status+": "+string(bs))
+	}
+	if token.Error != "" {
+		return nil, errors.New("用授权码换取 token 失败，" + token.Error + ": " + token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("用授权码换取 token 失败，" + resp.Status + ": " + string(bs))
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("用授权码换取 token 失败，返回中没有 id_token")
+	}
+	return &token, nil
+}
+
+var validMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// VerifyIDToken 校验 id_token 的签名， issuer, audience, 有效期和 nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (jwt.MapClaims, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &jwt.Parser{ValidMethods: validMethods}
+	claims := jwt.MapClaims{}
+	_, err = parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.lookupKey(ctx, metadata, kid)
+	})
+	if err != nil {
+		return nil, errors.WithHTTPCode(errors.Wrap(err, "校验 id_token 失败"), http.StatusUnauthorized)
+	}
+
+	if !claims.VerifyIssuer(metadata.Issuer, true) {
+		return nil, errors.WithHTTPCode(errors.New("校验 id_token 失败，issuer 不正确"), http.StatusUnauthorized)
+	}
+	if !claims.VerifyAudience(p.config.ClientID, true) {
+		return nil, errors.WithHTTPCode(errors.New("校验 id_token 失败，audience 不正确"), http.StatusUnauthorized)
+	}
+	if s, _ := claims["nonce"].(string); s != nonce {
+		return nil, errors.WithHTTPCode(errors.New("校验 id_token 失败，nonce 不正确"), http.StatusUnauthorized)
+	}
+	return claims, nil
+}
+
+// lookupKey 按 kid 查找公钥，找不到时重新加载 jwks, 但最多每分钟一次
+func (p *Provider) lookupKey(ctx context.Context, metadata *providerMetadata, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	find := func() interface{} {
+		if kid != "" {
+			return p.keys[kid]
+		}
+		if len(p.keys) == 1 {
+			for _, key := range p.keys {
+				return key
+			}
+		}
+		return nil
+	}
+	if key := find(); key != nil {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysLoadedAt) < time.Minute {
+		return nil, errors.New("公钥 '" + kid + "' 没有找到")
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, metadata.JwksURI, &jwks); err != nil {
+		return nil, errors.Wrap(err, "读 OIDC 认证服务的公钥失败")
+	}
+	keys := map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, errors.Wrap(err, "读 OIDC 认证服务的公钥 '"+jwk.Kid+"' 失败")
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	p.keys = keys
+	p.keysLoadedAt = time.Now()
+
+	if key := find(); key != nil {
+		return key, nil
+	}
+	return nil, errors.New("公钥 '" + kid + "' 没有找到")
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bs), nil
+}
+
+// publicKey 返回 jwk 中的公钥，不支持的类型返回 nil
+func (jwk *jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("不支持的椭圆曲线 '" + jwk.Crv + "'")
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+// Identity 按配置将 id_token 中的 claims 转换为用户身份
+func (p *Provider) Identity(claims jwt.MapClaims) (*session_core.ExternalIdentity, error) {
+	username := claimString(claims, p.config.UsernameClaim)
+	if username == "" {
+		return nil, errors.WithHTTPCode(errors.New("id_token 中没有用户名 '"+p.config.UsernameClaim+"'"), http.StatusUnauthorized)
+	}
+
+	identity := &session_core.ExternalIdentity{
+		Source:   Source,
+		Username: username,
+		Nickname: claimString(claims, p.config.NicknameClaim),
+	}
+	for claim, field := range p.config.ClaimFields {
+		value, ok := claims[claim]
+		if !ok || value == nil {
+			continue
+		}
+		if identity.Fields == nil {
+			identity.Fields = map[string]interface{}{}
+		}
+		identity.Fields[field] = value
+	}
+
+	if p.config.RolesClaim != "" {
+		switch value := claims[p.config.RolesClaim].(type) {
+		case string:
+			identity.Roles = append(identity.Roles, session_auth.SplitList(value)...)
+		case []interface{}:
+			for _, item := range value {
+				if s := strings.TrimSpace(fmt.Sprint(item)); s != "" {
+					identity.Roles = append(identity.Roles, s)
+				}
+			}
+		}
+	}
+	identity.Roles = append(identity.Roles, p.config.DefaultRoles...)
+	return identity, nil
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	if name == "" {
+		return ""
+	}
+	switch value := claims[name].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// GenerateRandomString 生成一个用于 state, nonce 和 code_verifier 的随机字符串
+func GenerateRandomString() (string, error) {
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+// CodeChallengeS256 按 PKCE 的 S256 方法计算 code_challenge
+func CodeChallengeS256(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,278 @@
+package oidc_auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boo-admin/boo/services/authn/session_auth"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+// testIdP 是一个用于测试的 OIDC 认证服务
+type testIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]url.Values
+	claims jwt.MapClaims
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &testIdP{t: t, key: key, codes: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if clientID != "boo" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		idp.mu.Lock()
+		params, ok := idp.codes[r.FormValue("code")]
+		delete(idp.codes, r.FormValue("code"))
+		idp.mu.Unlock()
+
+		if !ok || CodeChallengeS256(r.FormValue("code_verifier")) != params.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   idp.server.URL,
+			"aud":   "boo",
+			"sub":   "1234",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": params.Get("nonce"),
+		}
+		for k, v := range idp.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+			"expires_in":   3600,
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+// authorize 模拟用户在认证服务上登录成功，返回跳转回来的地址
+func (idp *testIdP) authorize(authURL string) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	params := u.Query()
+	if params.Get("code_challenge_method") != "S256" {
+		idp.t.Fatal("want S256 got", params.Get("code_challenge_method"))
+	}
+
+	code, _ := GenerateRandomString()
+	idp.mu.Lock()
+	idp.codes[code] = params
+	idp.mu.Unlock()
+
+	return params.Get("redirect_uri") + "?" + url.Values{
+		"code":  []string{code},
+		"state": []string{params.Get("state")},
+	}.Encode()
+}
+
+func newTestHandler(idp *testIdP) *Handler {
+	return &Handler{
+		Provider: NewProvider(Config{
+			Issuer:        idp.server.URL,
+			ClientID:      "boo",
+			ClientSecret:  "secret",
+			RedirectURL:   "http://boo.example.com/boo/login/oidc/callback",
+			Scopes:        []string{"openid", "profile"},
+			UsernameClaim: "preferred_username",
+			NicknameClaim: "name",
+			RolesClaim:    "groups",
+			ClaimFields:   map[string]string{"email": "email"},
+			DefaultRoles:  []string{"guest"},
+		}),
+		Cookie: &session_auth.Option{
+			SessionPath:       "/boo",
+			SessionHashFunc:   sha1.New,
+			SessionHashSecret: []byte("abc"),
+		},
+	}
+}
+
+func login(t *testing.T, h *Handler, idp *testIdP, modify func(callbackURL string) string) (*httptest.ResponseRecorder, *http.Request) {
+	ctx := context.Background()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/boo/login/oidc?return_url=/boo/users", nil)
+	if err := h.Redirect(ctx, w, req); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusFound {
+		t.Fatal("want 302 got", w.Code)
+	}
+	authURL := w.Header().Get("Location")
+	if !strings.HasPrefix(authURL, idp.server.URL+"/authorize?") {
+		t.Fatal("want redirect to idp got", authURL)
+	}
+
+	callbackURL := idp.authorize(authURL)
+	if modify != nil {
+		callbackURL = modify(callbackURL)
+	}
+	req = httptest.NewRequest("GET", callbackURL, nil)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	return httptest.NewRecorder(), req
+}
+
+func TestLogin(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	idp.claims = jwt.MapClaims{
+		"preferred_username": "oidcuser",
+		"name":               "外部用户",
+		"email":              "oidcuser@example.com",
+		"groups":             []interface{}{"admin"},
+	}
+
+	h := newTestHandler(idp)
+	w, req := login(t, h, idp, nil)
+	identity, returnURL, err := h.Callback(context.Background(), w, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if returnURL != "/boo/users" {
+		t.Error("want /boo/users got", returnURL)
+	}
+	if identity.Source != Source {
+		t.Error("want", Source, "got", identity.Source)
+	}
+	if identity.Username != "oidcuser" {
+		t.Error("want oidcuser got", identity.Username)
+	}
+	if identity.Nickname != "外部用户" {
+		t.Error("want 外部用户 got", identity.Nickname)
+	}
+	if identity.Fields["email"] != "oidcuser@example.com" {
+		t.Error("want oidcuser@example.com got", identity.Fields["email"])
+	}
+	if strings.Join(identity.Roles, ",") != "admin,guest" {
+		t.Error("want admin,guest got", identity.Roles)
+	}
+
+	// 授权码只能用一次
+	_, _, err = h.Callback(context.Background(), httptest.NewRecorder(), req)
+	if err == nil {
+		t.Error("want error got ok")
+	}
+}
+
+func TestLoginFail(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	idp.claims = jwt.MapClaims{
+		"preferred_username": "oidcuser",
+	}
+
+	for _, test := range []struct {
+		name   string
+		modify func(callbackURL string) string
+		claims jwt.MapClaims
+	}{
+		{
+			name: "state",
+			modify: func(callbackURL string) string {
+				return strings.Replace(callbackURL, "state=", "state=x", 1)
+			},
+		},
+		{
+			name: "code",
+			modify: func(callbackURL string) string {
+				return strings.Replace(callbackURL, "code=", "code=x", 1)
+			},
+		},
+		{
+			name:   "audience",
+			claims: jwt.MapClaims{"aud": "other"},
+		},
+		{
+			name:   "expired",
+			claims: jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()},
+		},
+		{
+			name:   "nonce",
+			claims: jwt.MapClaims{"nonce": "abc"},
+		},
+		{
+			name:   "username",
+			claims: jwt.MapClaims{"preferred_username": ""},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			idp.claims = jwt.MapClaims{"preferred_username": "oidcuser"}
+			for k, v := range test.claims {
+				idp.claims[k] = v
+			}
+
+			h := newTestHandler(idp)
+			w, req := login(t, h, idp, test.modify)
+			_, _, err := h.Callback(context.Background(), w, req)
+			if err == nil {
+				t.Error("want error got ok")
+			}
+		})
+	}
+}
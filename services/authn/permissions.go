@@ -0,0 +1,90 @@
+package authn
+
+import (
+	"sync"
+)
+
+// Permission 是可以授予角色的一个权限
+type Permission struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Group       string `json:"group,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+var (
+	permissionLock sync.RWMutex
+	permissionList []Permission
+	permissionByID = map[string]int{}
+)
+
+// RegisterPermission 注册权限，下游的应用可以用它注册自已的权限，
+// 已注册的权限才可以授予角色。重复注册时后注册的会覆盖先注册的
+func RegisterPermission(permissions ...Permission) {
+	permissionLock.Lock()
+	defer permissionLock.Unlock()
+
+	for _, p := range permissions {
+		if idx, ok := permissionByID[p.ID]; ok {
+			permissionList[idx] = p
+			continue
+		}
+		permissionByID[p.ID] = len(permissionList)
+		permissionList = append(permissionList, p)
+	}
+}
+
+// GetPermission 按 ID 查询已注册的权限
+func GetPermission(id string) (Permission, bool) {
+	permissionLock.RLock()
+	defer permissionLock.RUnlock()
+
+	idx, ok := permissionByID[id]
+	if !ok {
+		return Permission{}, false
+	}
+	return permissionList[idx], true
+}
+
+// GetPermissions 返回所有已注册的权限
+func GetPermissions() []Permission {
+	permissionLock.RLock()
+	defer permissionLock.RUnlock()
+
+	results := make([]Permission, len(permissionList))
+	copy(results, permissionList)
+	return results
+}
+
+func init() {
+	RegisterPermission(
+		Permission{ID: OpViewUser, Title: "查看用户", Group: "用户管理"},
+		Permission{ID: OpCreateUser, Title: "新建用户", Group: "用户管理"},
+		Permission{ID: OpUpdateUser, Title: "修改用户", Group: "用户管理"},
+		Permission{ID: OpResetPassword, Title: "重置密码", Group: "用户管理"},
+		Permission{ID: OpDeleteUser, Title: "删除用户", Group: "用户管理"},
+		Permission{ID: OpUnlockUser, Title: "解锁用户", Group: "用户管理"},
+		Permission{ID: OpResetTwoFactor, Title: "重置两步验证", Group: "用户管理"},
+		Permission{ID: OpManageAccessToken, Title: "管理访问令牌", Group: "用户管理"},
+		Permission{ID: OpViewOnlineUser, Title: "查看在线会话", Group: "用户管理"},
+		Permission{ID: OpLogoutOnlineUser, Title: "注销在线会话", Group: "用户管理"},
+		Permission{ID: OpSyncLdapUsers, Title: "同步 LDAP 用户", Group: "用户管理"},
+
+		Permission{ID: OpViewDepartment, Title: "查看部门", Group: "部门管理"},
+		Permission{ID: OpCreateDepartment, Title: "新建部门", Group: "部门管理"},
+		Permission{ID: OpUpdateDepartment, Title: "修改部门", Group: "部门管理"},
+		Permission{ID: OpDeleteDepartment, Title: "删除部门", Group: "部门管理"},
+
+		Permission{ID: OpViewEmployee, Title: "查看员工", Group: "员工管理"},
+		Permission{ID: OpCreateEmployee, Title: "新建员工", Group: "员工管理"},
+		Permission{ID: OpUpdateEmployee, Title: "修改员工", Group: "员工管理"},
+		Permission{ID: OpDeleteEmployee, Title: "删除员工", Group: "员工管理"},
+
+		Permission{ID: OpViewRole, Title: "查看角色", Group: "角色管理"},
+		Permission{ID: OpCreateRole, Title: "新建角色", Group: "角色管理"},
+		Permission{ID: OpUpdateRole, Title: "修改角色", Group: "角色管理"},
+		Permission{ID: OpDeleteRole, Title: "删除角色", Group: "角色管理"},
+
+		Permission{ID: OpUpdateCustomField, Title: "修改自定义字段", Group: "系统管理"},
+	)
+}
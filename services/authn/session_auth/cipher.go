@@ -0,0 +1,120 @@
+package session_auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"strings"
+
+	"github.com/emmansun/gmsm/sm3"
+	"github.com/emmansun/gmsm/sm4"
+)
+
+// ErrCookieDecrypt cookie 解密失败，可能是密钥已过期或内容被篡改
+var ErrCookieDecrypt = errors.New("session cookie decrypt failed")
+
+// encryptedPrefix 是加密的 cookie 的前缀，签名的 cookie 以十六进制的签名开头，
+// 所以可以用它区分两种格式，加密的 cookie 格式为 "~密钥ID~base64(nonce+密文)"
+const encryptedPrefix = "~"
+
+// CookieCipher 用认证加密算法加密 cookie 的内容，这样持有 cookie 的人也看不到其中的用户名和会话 ID
+type CookieCipher struct {
+	name    string
+	keySize int
+	hash    func() hash.Hash
+	block   func(key []byte) (cipher.Block, error)
+}
+
+// GetCookieCipher 按名称返回 cookie 的加密算法，支持 aes-gcm 和 sm4-gcm,
+// 名称为空或 none 时返回 nil, 表示 cookie 只签名不加密
+func GetCookieCipher(name string) (*CookieCipher, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return nil, nil
+	case "aes-gcm", "aes":
+		return &CookieCipher{name: "aes-gcm", keySize: 32, hash: sha256.New, block: aes.NewCipher}, nil
+	case "sm4-gcm", "sm4":
+		return &CookieCipher{name: "sm4-gcm", keySize: 16, hash: sm3.New, block: sm4.NewCipher}, nil
+	}
+	return nil, errors.New("cookie encryption '" + name + "' is unsupported")
+}
+
+func (c *CookieCipher) Name() string {
+	return c.name
+}
+
+// aead 从签名密钥中派生出加密密钥，避免和其它用途共用同一个密钥
+func (c *CookieCipher) aead(secret []byte) (cipher.AEAD, error) {
+	mac := hmac.New(c.hash, secret)
+	mac.Write([]byte("boo session cookie encryption"))
+	block, err := c.block(mac.Sum(nil)[:c.keySize])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal 加密 message, keyID 为 secret 对应的密钥 ID, 没有时为空
+func (c *CookieCipher) Seal(keyID string, secret []byte, message string) (string, error) {
+	aead, err := c.aead(secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	bs := aead.Seal(nonce, nonce, []byte(message), []byte(c.name))
+	return encryptedPrefix + keyID + encryptedPrefix + base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+// Open 解密 Seal 的结果， lookup 用于按密钥 ID 查找密钥
+func (c *CookieCipher) Open(value string, lookup func(keyID string) ([]byte, bool)) (string, error) {
+	keyID, data, ok := splitEncrypted(value)
+	if !ok {
+		return "", errors.New("session cookie has invalid value")
+	}
+	secret, ok := lookup(keyID)
+	if !ok {
+		return "", ErrCookieDecrypt
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return "", errors.New("session cookie has invalid value")
+	}
+	aead, err := c.aead(secret)
+	if err != nil {
+		return "", err
+	}
+	if len(bs) < aead.NonceSize() {
+		return "", ErrCookieDecrypt
+	}
+	nonce, bs := bs[:aead.NonceSize()], bs[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, bs, []byte(c.name))
+	if err != nil {
+		return "", ErrCookieDecrypt
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted cookie 的值是否是加密格式
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+func splitEncrypted(value string) (keyID, data string, ok bool) {
+	if !IsEncrypted(value) {
+		return "", "", false
+	}
+	value = value[len(encryptedPrefix):]
+	idx := strings.Index(value, encryptedPrefix)
+	if idx < 0 {
+		return "", "", false
+	}
+	return value[:idx], value[idx+len(encryptedPrefix):], true
+}
@@ -22,6 +22,7 @@ var ErrSessionInvalid = errors.New("session is invalid")
 var ErrSessionUserMissing = errors.New("session user is missing")
 var ErrSessionNotExists = errors.New("session isnot exists")
 var ErrSessionExpired = errors.New("session expired")
+var ErrCookieNoSecret = errors.New("session cookie secret isnot configured")
 
 const (
 	SESSION_ID_KEY     = "session_id"
@@ -99,6 +100,19 @@ func Verify(message, sig string, h func() hash.Hash, secretKey []byte) bool {
 }
 
 func Encode(values url.Values, h func() hash.Hash, secretKey []byte) string {
+	return EncodeWith(values, func(message string) string {
+		return Sign(message, h, secretKey)
+	})
+}
+
+// EncodeWith 和 Encode 一样，但是用 sign 来生成签名
+func EncodeWith(values url.Values, sign func(message string) string) string {
+	s := encodeValues(values)
+	return sign(s) + "-" + s
+}
+
+// encodeValues 补上会话 ID 等缺省值后编码 values
+func encodeValues(values url.Values) string {
 	if id := values.Get(SESSION_ID_KEY); id == "" {
 		values.Set(SESSION_ID_KEY, GenerateID())
 	}
@@ -112,12 +126,22 @@ func Encode(values url.Values, h func() hash.Hash, secretKey []byte) string {
 	if _, ok := values[SESSION_VALID_KEY]; !ok {
 		values.Set(SESSION_VALID_KEY, "false")
 	}
-
-	s := values.Encode()
-	return Sign(s, h, secretKey) + "-" + s
+	return values.Encode()
 }
 
 func GetValuesFromString(value string, verify func(data, sig string) bool) (url.Values, error) {
+	values, err := DecodeSigned(value, verify)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckValues(values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// DecodeSigned 校验签名并解码 cookie 的内容，它不检查会话是否有效
+func DecodeSigned(value string, verify func(data, sig string) bool) (url.Values, error) {
 	if value == "" {
 		return nil, ErrCookieEmpty
 	}
@@ -135,24 +159,31 @@ func GetValuesFromString(value string, verify func(data, sig string) bool) (url.
 			return nil, errors.New("session cookie signature failed")
 		}
 	}
+	return decodeValues(data)
+}
 
+func decodeValues(data string) (url.Values, error) {
 	values, e := url.ParseQuery(data)
 	if nil != e {
 		return nil, errors.New("session cookie decode fail, " + e.Error())
 	}
+	return values, nil
+}
 
+// CheckValues 检查会话是否有效
+func CheckValues(values url.Values) error {
 	if IsInvalid(values) {
-		return nil, ErrSessionInvalid
+		return ErrSessionInvalid
 	}
 
 	if TimeoutExpiredOrMissing(values) {
-		return nil, ErrSessionExpiredOrMissing
+		return ErrSessionExpiredOrMissing
 	}
 
 	if user := values.Get(SESSION_USER_KEY); user == "" {
-		return nil, ErrSessionUserMissing
+		return ErrSessionUserMissing
 	}
-	return values, nil
+	return nil
 }
 
 func GetValuesFromCookie(req *http.Request, sessionKey string, verify func(data, sig string) bool) (url.Values, error) {
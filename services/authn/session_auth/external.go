@@ -0,0 +1,36 @@
+package session_auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+)
+
+// ExternalLogin 是外部认证服务（如 OIDC 和 CAS）的登录处理，
+// Redirect 跳转到认证服务的登录页面， Callback 处理认证服务的回调，
+// 返回用户身份和登录后要跳转的地址
+type ExternalLogin interface {
+	Redirect(ctx context.Context, w http.ResponseWriter, req *http.Request) error
+	Callback(ctx context.Context, w http.ResponseWriter, req *http.Request) (*session_core.ExternalIdentity, string, error)
+}
+
+// IsSafeReturnURL 只允许跳转到本站的相对路径，防止被用作任意跳转
+func IsSafeReturnURL(s string) bool {
+	return strings.HasPrefix(s, "/") &&
+		!strings.HasPrefix(s, "//") &&
+		!strings.HasPrefix(s, "/\\")
+}
+
+// SplitList 按逗号拆分配置中的列表，并去掉空白项
+func SplitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
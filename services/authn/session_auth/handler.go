@@ -12,18 +12,22 @@ import (
 	"github.com/boo-admin/boo/booclient"
 	"github.com/boo-admin/boo/errors"
 	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/services/authn/keyring"
 )
 
 const (
-	CfgUserSessionPath       = "users.cookie.path"
-	CfgUserSessionName       = "users.cookie.name"
-	CfgUserSessionDomain     = "users.cookie.domain"
-	CfgUserSessionHashFunc   = "users.cookie.hash_method"
-	CfgUserSessionHashSecret = "users.cookie.hash_secret"
-	CfgUserSessionMaxAge     = "users.cookie.maxage"
-	CfgUserSessionSecure     = "users.cookie.secure"
-	CfgUserSessionHttpOnly   = "users.cookie.httponly"
-	CfgUserSessionSameSite   = "users.cookie.samesite"
+	CfgUserSessionPath         = "users.cookie.path"
+	CfgUserSessionName         = "users.cookie.name"
+	CfgUserSessionDomain       = "users.cookie.domain"
+	CfgUserSessionHashFunc     = "users.cookie.hash_method"
+	CfgUserSessionHashSecret   = "users.cookie.hash_secret"
+	CfgUserSessionKeysFile     = "users.cookie.keys_file"
+	CfgUserSessionEncryption   = "users.cookie.encryption"
+	CfgUserSessionRejectSigned = "users.cookie.reject_signed"
+	CfgUserSessionMaxAge       = "users.cookie.maxage"
+	CfgUserSessionSecure       = "users.cookie.secure"
+	CfgUserSessionHttpOnly     = "users.cookie.httponly"
+	CfgUserSessionSameSite     = "users.cookie.samesite"
 )
 
 type Option struct {
@@ -32,6 +36,18 @@ type Option struct {
 	SessionHashFunc   func() hash.Hash
 	SessionHashSecret []byte
 
+	// SessionKeys 是可轮换的签名密钥，配置了它时 cookie 的签名前会加上 "密钥ID.",
+	// 没有前缀的 cookie 仍然用 SessionHashSecret 校验
+	SessionKeys *keyring.File
+
+	// SessionCipher 不为 nil 时 cookie 的内容会被加密，
+	// 迁移期间仍然可以读只签名的 cookie, 除非 SessionRejectSigned 为 true
+	SessionCipher       *CookieCipher
+	SessionRejectSigned bool
+
+	// SessionTimeouts 不为 nil 时会话有空闲超时和最长有效时间，有活动时会自动续期
+	SessionTimeouts *Timeouts
+
 	SessionDomain   string
 	SessionHttpOnly bool
 	SessionSecure   bool
@@ -39,18 +55,23 @@ type Option struct {
 	SessionSameSite http.SameSite
 }
 
-func CreateCookie(opt *Option, values url.Values) *http.Cookie {
-	var ts = "session"
-	values.Set(SESSION_EXPIRE_KEY, ts)
-	values.Set("_TS", ts)
-	// if sessionTimeout > 0 {
-	// 	ts = strconv.FormatInt(time.Now().Add(time.Duration(sessionTimeout)*time.Minute).Unix(), 10)
-	// }
-	values.Set("issued_at", time.Now().Format(time.RFC3339))
+// CreateCookie 创建会话的 cookie, values 中没有过期时间时它和浏览器的会话一样长,
+// 续期时 values 中已有签发时间，会保留原来的值
+func CreateCookie(opt *Option, values url.Values) (*http.Cookie, error) {
+	if _, ok := values[SESSION_EXPIRE_KEY]; !ok {
+		values.Set(SESSION_EXPIRE_KEY, "session")
+	}
+	if _, ok := values[SESSION_ISSUED_KEY]; !ok {
+		values.Set(SESSION_ISSUED_KEY, time.Now().Format(time.RFC3339))
+	}
 
+	value, err := opt.Encode(values)
+	if err != nil {
+		return nil, err
+	}
 	return &http.Cookie{
 		Name:     opt.SessionName,
-		Value:    Encode(values, opt.SessionHashFunc, opt.SessionHashSecret),
+		Value:    value,
 		Domain:   opt.SessionDomain,
 		Path:     opt.SessionPath,
 		HttpOnly: opt.SessionHttpOnly,
@@ -59,48 +80,174 @@ func CreateCookie(opt *Option, values url.Values) *http.Cookie {
 		SameSite: opt.SessionSameSite,
 
 		// Expires: ts.UTC(), // 不指定过期时间，那么关闭浏览器后 cookie 会删除
+	}, nil
+}
+
+func (opt *Option) hashFunc() func() hash.Hash {
+	if opt.SessionHashFunc == nil {
+		return sha1.New
 	}
+	return opt.SessionHashFunc
 }
 
-func SessionVerify(opt *Option, handle func(ctx context.Context, req *http.Request, values url.Values) (context.Context, error)) authn.AuthValidateFunc {
-	sessionKey := opt.SessionName
-	sessionPath := opt.SessionPath
-	secretKey := opt.SessionHashSecret
+// currentSecret 返回签名或加密用的密钥，配置了 SessionKeys 时用最新的密钥
+func (opt *Option) currentSecret() (string, []byte) {
+	if opt.SessionKeys != nil {
+		if key, ok := opt.SessionKeys.Current(); ok {
+			return key.ID, []byte(key.Secret)
+		}
+	}
+	return "", opt.SessionHashSecret
+}
+
+// lookupSecret 按密钥 ID 查找密钥， ID 为空时为 SessionHashSecret
+func (opt *Option) lookupSecret(keyID string) ([]byte, bool) {
+	if keyID == "" {
+		return opt.SessionHashSecret, len(opt.SessionHashSecret) > 0
+	}
+	if opt.SessionKeys == nil {
+		return nil, false
+	}
+	key, ok := opt.SessionKeys.Lookup(keyID)
+	return []byte(key.Secret), ok
+}
+
+// Sign 签名 cookie 的内容，配置了 SessionKeys 时用最新的密钥签名并在签名前加上 "密钥ID."
+func (opt *Option) Sign(message string) string {
+	keyID, secret := opt.currentSecret()
+	if keyID != "" {
+		return keyID + "." + Sign(message, opt.hashFunc(), secret)
+	}
+	return Sign(message, opt.hashFunc(), secret)
+}
 
-	if sessionPath == "" {
-		sessionPath = "/" // 必须指定 Path, 否则会被自动赋成当前请求的 url 中的 path
-	} else if !strings.HasPrefix(sessionPath, "/") {
-		sessionPath = "/" + sessionPath
+// Verify 校验 cookie 的签名，有前缀时按密钥 ID 查找密钥，
+// 没有前缀的是启用 SessionKeys 之前签发的 cookie, 用 SessionHashSecret 校验
+func (opt *Option) Verify(message, sig string) bool {
+	keyID := ""
+	if dot := strings.IndexByte(sig, '.'); dot >= 0 {
+		keyID, sig = sig[:dot], sig[dot+1:]
 	}
+	secret, ok := opt.lookupSecret(keyID)
+	return ok && Verify(message, sig, opt.hashFunc(), secret)
+}
+
+// hasSecret 判断是否配置了签名或加密 cookie 的密钥
+func (opt *Option) hasSecret() bool {
+	return opt.SessionKeys != nil || len(opt.SessionHashSecret) > 0
+}
 
-	// currentURL := opt.CurrentURL
-	// if currentURL == nil {
-	// 	currentURL = func(req *http.Request) url.URL {
-	// 		return *req.URL
-	// 	}
-	// }
+// Encode 编码 cookie 的内容，配置了 SessionCipher 时加密，否则签名
+func (opt *Option) Encode(values url.Values) (string, error) {
+	if !opt.hasSecret() {
+		return "", ErrCookieNoSecret
+	}
+	if opt.SessionCipher == nil {
+		return EncodeWith(values, opt.Sign), nil
+	}
+	keyID, secret := opt.currentSecret()
+	value, err := opt.SessionCipher.Seal(keyID, secret, encodeValues(values))
+	if err != nil {
+		return "", errors.Wrap(err, "加密 cookie 失败")
+	}
+	return value, nil
+}
+
+// Decode 解密或校验签名后解码 cookie 的内容，它不检查会话是否有效，
+// 加密的和只签名的 cookie 都可以读，这样启用或关闭加密时用户不需要重新登录
+func (opt *Option) Decode(value string) (url.Values, error) {
+	if IsEncrypted(value) {
+		cc := opt.SessionCipher
+		if cc == nil {
+			return nil, ErrCookieDecrypt
+		}
+		data, err := cc.Open(value, opt.lookupSecret)
+		if err != nil {
+			return nil, err
+		}
+		return decodeValues(data)
+	}
+	if opt.SessionCipher != nil && opt.SessionRejectSigned {
+		if value == "" {
+			return nil, ErrCookieEmpty
+		}
+		return nil, errors.New("session cookie isnot encrypted")
+	}
+	if !opt.hasSecret() {
+		return nil, ErrCookieNoSecret
+	}
+	return DecodeSigned(value, opt.Verify)
+}
 
-	h := opt.SessionHashFunc
-	if h == nil {
-		h = sha1.New
+// GetValues 读会话 cookie 并检查会话是否有效
+func (opt *Option) GetValues(req *http.Request) (url.Values, error) {
+	cookie, err := req.Cookie(opt.SessionName)
+	if err != nil {
+		if err == http.ErrNoCookie {
+			return nil, ErrCookieNotFound
+		}
+		return nil, err
+	}
+	values, err := opt.Decode(cookie.Value)
+	if err != nil {
+		return nil, err
 	}
+	if err := CheckValues(values); err != nil {
+		return nil, err
+	}
+	if opt.SessionTimeouts != nil {
+		if err := opt.SessionTimeouts.CheckLegacy(values, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
 
+func SessionVerify(opt *Option, handle func(ctx context.Context, req *http.Request, values url.Values) (context.Context, error)) authn.AuthValidateFunc {
 	return func(ctx context.Context, req *http.Request) (context.Context, error) {
-		values, err := GetValues(req, sessionKey, h, secretKey)
+		values, err := opt.GetValues(req)
 		if err != nil {
 			if err == ErrCookieNotFound || err == ErrCookieEmpty {
 				return nil, authn.ErrTokenNotFound
 			}
+			if err == ErrSessionExpiredOrMissing {
+				return nil, authn.ErrTokenExpired
+			}
 			return nil, err
 		}
 
-		return handle(ctx, req, values)
+		ctx, err = handle(ctx, req, values)
+		if err != nil {
+			return ctx, err
+		}
+		if opt.SessionTimeouts != nil && opt.SessionTimeouts.Renew(values, time.Now()) {
+			cookie, err := CreateCookie(opt, values)
+			if err != nil {
+				return ctx, err
+			}
+			ctx = authn.ContextWithSetCookie(ctx, cookie)
+		}
+		return ctx, nil
 	}
 }
 
 func New(env *booclient.Environment, sessionUser func(ctx context.Context, req *http.Request, values url.Values) (context.Context, error)) (authn.AuthValidateFunc, error) {
+	sessionOpt, err := NewOption(env)
+	if err != nil {
+		return nil, err
+	}
+	return SessionVerify(sessionOpt, sessionUser), nil
+}
+
+// NewOption 从配置中读取会话 cookie 的选项
+func NewOption(env *booclient.Environment) (*Option, error) {
 	var sessionOpt Option
 	sessionOpt.SessionPath = env.Config.StringWithDefault(CfgUserSessionPath, env.AppPathWithoutSlash)
+	if sessionOpt.SessionPath == "" {
+		sessionOpt.SessionPath = "/" // 必须指定 Path, 否则会被自动赋成当前请求的 url 中的 path
+	} else if !strings.HasPrefix(sessionOpt.SessionPath, "/") {
+		sessionOpt.SessionPath = "/" + sessionOpt.SessionPath
+	}
 	sessionOpt.SessionName = env.Config.StringWithDefault(CfgUserSessionName, "boo_session")
 	sessionOpt.SessionDomain = env.Config.StringWithDefault(CfgUserSessionDomain, "")
 
@@ -111,10 +258,26 @@ func New(env *booclient.Environment, sessionUser func(ctx context.Context, req *
 		sessionOpt.SessionHashFunc = h
 	}
 	sessionOpt.SessionHashSecret = []byte(env.Config.StringWithDefault(CfgUserSessionHashSecret, ""))
-	if len(sessionOpt.SessionHashSecret) == 0 {
+	if filename := env.Config.StringWithDefault(CfgUserSessionKeysFile, ""); filename != "" {
+		keys, err := keyring.NewFile(env.Logger, booclient.GetRealDir(context.Background(), env, filename), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "读参数 '"+CfgUserSessionKeysFile+"' 指定的密钥文件失败")
+		}
+		sessionOpt.SessionKeys = keys
+	} else if len(sessionOpt.SessionHashSecret) == 0 {
 		return nil, errors.New("读 " + CfgUserSessionHashSecret + " 失败，没有在配置中找到它")
 	}
 
+	var encryption = env.Config.StringWithDefault(CfgUserSessionEncryption, "")
+	if cc, err := GetCookieCipher(encryption); err != nil {
+		return nil, errors.Wrap(err, "参数 '"+CfgUserSessionEncryption+"' 的值 '"+encryption+"' 是未知的加密算法")
+	} else {
+		sessionOpt.SessionCipher = cc
+	}
+	sessionOpt.SessionRejectSigned = env.Config.BoolWithDefault(CfgUserSessionRejectSigned, false)
+
+	sessionOpt.SessionTimeouts = NewTimeouts(env)
+
 	sessionOpt.SessionMaxAge = env.Config.IntWithDefault(CfgUserSessionMaxAge, 0)
 	sessionOpt.SessionSecure = env.Config.BoolWithDefault(CfgUserSessionSecure, false)
 	sessionOpt.SessionHttpOnly = env.Config.BoolWithDefault(CfgUserSessionHttpOnly, false)
@@ -125,8 +288,7 @@ func New(env *booclient.Environment, sessionUser func(ctx context.Context, req *
 	} else {
 		sessionOpt.SessionSameSite = sameSite
 	}
-
-	return SessionVerify(&sessionOpt, sessionUser), nil
+	return &sessionOpt, nil
 }
 
 func ParseHttpSameSite(s string) (http.SameSite, error) {
@@ -0,0 +1,323 @@
+package session_auth
+
+import (
+	"context"
+	"crypto/sha1"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/services/authn/keyring"
+	"golang.org/x/exp/slog"
+)
+
+func mustEncode(t *testing.T, opt *Option, values url.Values) string {
+	t.Helper()
+	value, err := opt.Encode(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func mustCreateCookie(t *testing.T, opt *Option, values url.Values) *http.Cookie {
+	t.Helper()
+	cookie, err := CreateCookie(opt, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cookie
+}
+
+func TestOptionWithoutSecret(t *testing.T) {
+	signed := mustEncode(t, &Option{
+		SessionName:       "boo_session",
+		SessionHashFunc:   sha1.New,
+		SessionHashSecret: []byte("abc"),
+	}, url.Values{SESSION_USER_KEY: {"admin"}, SESSION_VALID_KEY: {"true"}})
+
+	// 没有配置密钥时不能签发 cookie, 也不能跳过签名读 cookie
+	opt := &Option{SessionName: "boo_session", SessionHashFunc: sha1.New}
+	if _, err := opt.Encode(url.Values{SESSION_USER_KEY: {"admin"}}); err != ErrCookieNoSecret {
+		t.Error("want ErrCookieNoSecret got", err)
+	}
+	if _, err := opt.Decode(signed); err != ErrCookieNoSecret {
+		t.Error("want ErrCookieNoSecret got", err)
+	}
+	unsigned := "-" + url.Values{SESSION_USER_KEY: {"admin"}, SESSION_VALID_KEY: {"true"}}.Encode()
+	if _, err := opt.Decode(unsigned); err == nil {
+		t.Error("want error got ok")
+	}
+}
+
+func TestOptionRotateKeys(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "keys.json")
+	ring := &keyring.Keyring{}
+	first := ring.Rotate("first", time.Hour, time.Now())
+	if err := keyring.WriteFile(filename, ring); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.NewFile(slog.Default(), filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := &Option{
+		SessionName:       "boo_session",
+		SessionHashFunc:   sha1.New,
+		SessionHashSecret: []byte("legacy"),
+	}
+	opt := *legacy
+	opt.SessionKeys = keys
+
+	read := func(opt *Option, value string) (url.Values, error) {
+		req, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+		req.AddCookie(&http.Cookie{Name: "boo_session", Value: value})
+		return opt.GetValues(req)
+	}
+	newValues := func() url.Values {
+		values := url.Values{}
+		values.Set(SESSION_USER_KEY, "admin")
+		values.Set(SESSION_VALID_KEY, "true")
+		values.Set(SESSION_EXPIRE_KEY, "session")
+		return values
+	}
+
+	// 启用密钥文件之前签发的 cookie 仍然有效
+	old := mustEncode(t, legacy, newValues())
+	if _, err := read(&opt, old); err != nil {
+		t.Error(err)
+	}
+
+	signed := mustEncode(t, &opt, newValues())
+	if signed[:len(first.ID)+1] != first.ID+"." {
+		t.Error("want prefix", first.ID, "got", signed)
+	}
+	if _, err := read(&opt, signed); err != nil {
+		t.Error(err)
+	}
+	if _, err := read(legacy, signed); err == nil {
+		t.Error("want error got ok")
+	}
+
+	// 轮换后旧密钥签名的 cookie 在宽限期内仍然有效，停用后失效
+	ring.Rotate("second", time.Hour, time.Now())
+	if err := keyring.WriteFile(filename, ring); err != nil {
+		t.Fatal(err)
+	}
+	keys2, err := keyring.NewFile(slog.Default(), filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt.SessionKeys = keys2
+	if _, err := read(&opt, signed); err != nil {
+		t.Error(err)
+	}
+	if err := ring.Retire(first.ID, 0, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.WriteFile(filename, ring); err != nil {
+		t.Fatal(err)
+	}
+	keys3, err := keyring.NewFile(slog.Default(), filename, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt.SessionKeys = keys3
+	if _, err := read(&opt, signed); err == nil {
+		t.Error("want error got ok")
+	}
+	if _, err := read(&opt, mustEncode(t, &opt, newValues())); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOptionEncrypt(t *testing.T) {
+	newValues := func() url.Values {
+		values := url.Values{}
+		values.Set(SESSION_USER_KEY, "admin")
+		values.Set(SESSION_VALID_KEY, "true")
+		values.Set(SESSION_EXPIRE_KEY, "session")
+		return values
+	}
+	read := func(opt *Option, value string) (url.Values, error) {
+		req, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+		req.AddCookie(&http.Cookie{Name: "boo_session", Value: value})
+		return opt.GetValues(req)
+	}
+
+	for _, name := range []string{"aes-gcm", "sm4-gcm"} {
+		t.Run(name, func(t *testing.T) {
+			cc, err := GetCookieCipher(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			legacy := &Option{
+				SessionName:       "boo_session",
+				SessionHashFunc:   sha1.New,
+				SessionHashSecret: []byte("legacy"),
+			}
+			opt := *legacy
+			opt.SessionCipher = cc
+
+			encrypted := mustEncode(t, &opt, newValues())
+			if !IsEncrypted(encrypted) || strings.Contains(encrypted, "admin") {
+				t.Error("cookie isnot encrypted:", encrypted)
+			}
+			values, err := read(&opt, encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if values.Get(SESSION_USER_KEY) != "admin" {
+				t.Error("want admin got", values.Get(SESSION_USER_KEY))
+			}
+
+			// 内容被篡改
+			tampered := []byte(encrypted)
+			tampered[len(tampered)-2] ^= 1
+			if _, err := read(&opt, string(tampered)); err == nil {
+				t.Error("want error got ok")
+			}
+
+			// 其它密钥或算法不能解密
+			other := opt
+			other.SessionHashSecret = []byte("other")
+			if _, err := read(&other, encrypted); err != ErrCookieDecrypt {
+				t.Error("want ErrCookieDecrypt got", err)
+			}
+			if _, err := read(legacy, encrypted); err == nil {
+				t.Error("want error got ok")
+			}
+
+			// 迁移期间仍然可以读只签名的 cookie
+			signed := mustEncode(t, legacy, newValues())
+			if _, err := read(&opt, signed); err != nil {
+				t.Error(err)
+			}
+			opt.SessionRejectSigned = true
+			if _, err := read(&opt, signed); err == nil {
+				t.Error("want error got ok")
+			}
+		})
+	}
+
+	if _, err := GetCookieCipher("des"); err == nil {
+		t.Error("want error got ok")
+	}
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	timeouts := &Timeouts{
+		Idle:         30 * time.Minute,
+		Absolute:     8 * time.Hour,
+		RoleIdle:     map[string]time.Duration{"admin": 10 * time.Minute, "auditor": 20 * time.Minute},
+		RoleAbsolute: map[string]time.Duration{"guest": time.Hour},
+	}
+	if idle, absolute := timeouts.For([]string{"admin", "auditor"}); idle != 10*time.Minute || absolute != 8*time.Hour {
+		t.Error("want 10m and 8h got", idle, absolute)
+	}
+	if idle, absolute := timeouts.For([]string{"guest"}); idle != 30*time.Minute || absolute != time.Hour {
+		t.Error("want 30m and 1h got", idle, absolute)
+	}
+
+	opt := &Option{
+		SessionName:       "boo_session",
+		SessionHashFunc:   sha1.New,
+		SessionHashSecret: []byte("abc"),
+		SessionTimeouts:   timeouts,
+	}
+	verify := SessionVerify(opt, func(ctx context.Context, req *http.Request, values url.Values) (context.Context, error) {
+		return ctx, nil
+	})
+	call := func(cookie *http.Cookie) (context.Context, error) {
+		req, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+		req.AddCookie(cookie)
+		return verify(context.Background(), req)
+	}
+	login := func(roles []string, now time.Time) *http.Cookie {
+		values := url.Values{}
+		values.Set(SESSION_USER_KEY, "admin")
+		values.Set(SESSION_VALID_KEY, "true")
+		timeouts.Apply(values, roles, now)
+		return mustCreateCookie(t, opt, values)
+	}
+
+	// 刚登录的会话不需要续期
+	ctx, err := call(login([]string{"admin"}, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authn.SetCookieFromContext(ctx) != nil {
+		t.Error("want no renew")
+	}
+
+	// 空闲超时
+	if _, err := call(login([]string{"admin"}, time.Now().Add(-11*time.Minute))); err != authn.ErrTokenExpired {
+		t.Error("want ErrTokenExpired got", err)
+	}
+
+	// 有活动时续期，续期后的 cookie 保留原来的签发时间
+	cookie := login([]string{"admin"}, time.Now().Add(-5*time.Minute))
+	old, _ := opt.Decode(cookie.Value)
+	ctx, err = call(cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed := authn.SetCookieFromContext(ctx)
+	if renewed == nil {
+		t.Fatal("want renew")
+	}
+	values, err := opt.Decode(renewed.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values.Get(SESSION_ISSUED_KEY) != old.Get(SESSION_ISSUED_KEY) || values.Get(SESSION_ID_KEY) != old.Get(SESSION_ID_KEY) {
+		t.Error("want issued_at and session id are unchanged")
+	}
+	if values.Get(SESSION_EXPIRE_KEY) <= old.Get(SESSION_EXPIRE_KEY) {
+		t.Error("want expire is extended", values.Get(SESSION_EXPIRE_KEY), old.Get(SESSION_EXPIRE_KEY))
+	}
+
+	// 续期不能超过最长有效时间
+	values = url.Values{}
+	values.Set(SESSION_USER_KEY, "admin")
+	values.Set(SESSION_VALID_KEY, "true")
+	values.Set(SESSION_IDLE_KEY, "600")
+	values.Set(SESSION_DEADLINE_KEY, strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+	values.Set(SESSION_EXPIRE_KEY, strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+	if timeouts.Renew(values, time.Now()) {
+		t.Error("want no renew after deadline")
+	}
+	values.Set(SESSION_DEADLINE_KEY, strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10))
+	values.Set(SESSION_EXPIRE_KEY, values.Get(SESSION_DEADLINE_KEY))
+	if _, err := call(mustCreateCookie(t, opt, values)); err != authn.ErrTokenExpired {
+		t.Error("want ErrTokenExpired got", err)
+	}
+
+	// 启用超时配置之前签发的 cookie 按签发时间和最严格的最长有效时间判断
+	legacy := func(issuedAt time.Time) *http.Cookie {
+		values := url.Values{}
+		values.Set(SESSION_USER_KEY, "admin")
+		values.Set(SESSION_VALID_KEY, "true")
+		if !issuedAt.IsZero() {
+			values.Set(SESSION_ISSUED_KEY, issuedAt.Format(time.RFC3339))
+		}
+		value := mustEncode(t, opt, values)
+		return &http.Cookie{Name: opt.SessionName, Value: value}
+	}
+	if _, err := call(legacy(time.Now().Add(-30 * time.Minute))); err != nil {
+		t.Error(err)
+	}
+	if _, err := call(legacy(time.Now().Add(-2 * time.Hour))); err != authn.ErrTokenExpired {
+		t.Error("want ErrTokenExpired got", err)
+	}
+	if _, err := call(legacy(time.Time{})); err != authn.ErrTokenExpired {
+		t.Error("want ErrTokenExpired got", err)
+	}
+}
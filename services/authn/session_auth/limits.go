@@ -0,0 +1,105 @@
+package session_auth
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+)
+
+const (
+	// CfgUserSessionMaxConcurrent 每个用户最多可以同时有多少个在线会话， 0 表示不限制，
+	// 可以在后面加上 ".角色名" 为角色单独配置
+	CfgUserSessionMaxConcurrent = "users.session.max_concurrent"
+	// CfgUserSessionLimitMode 在线会话超过限制时的处理方式，可以为 reject_new, evict_oldest 和 evict_others
+	CfgUserSessionLimitMode = "users.session.limit_mode"
+)
+
+const (
+	// LimitRejectNew 拒绝新的登录
+	LimitRejectNew = "reject_new"
+	// LimitEvictOldest 注销最早登录的会话，直到会话数不超过限制
+	LimitEvictOldest = "evict_oldest"
+	// LimitEvictOthers 注销其它所有的会话
+	LimitEvictOthers = "evict_others"
+)
+
+// SessionLimits 是用户并发会话数的限制
+type SessionLimits struct {
+	Max     int
+	RoleMax map[string]int
+	Mode    string
+}
+
+// NewSessionLimits 从配置中读取并发会话数的限制，没有配置时返回 nil
+func NewSessionLimits(env *booclient.Environment) (*SessionLimits, error) {
+	limits := &SessionLimits{
+		Max:  env.Config.IntWithDefault(CfgUserSessionMaxConcurrent, 0),
+		Mode: strings.ToLower(env.Config.StringWithDefault(CfgUserSessionLimitMode, LimitRejectNew)),
+	}
+	env.Config.ForEachWithPrefix(CfgUserSessionMaxConcurrent+".", func(k string, value interface{}) {
+		role := strings.TrimPrefix(k, CfgUserSessionMaxConcurrent+".")
+		max := env.Config.IntWithDefault(k, 0)
+		if role == "" || max <= 0 {
+			return
+		}
+		if limits.RoleMax == nil {
+			limits.RoleMax = map[string]int{}
+		}
+		limits.RoleMax[role] = max
+	})
+	switch limits.Mode {
+	case "":
+		limits.Mode = LimitRejectNew
+	case LimitRejectNew, LimitEvictOldest, LimitEvictOthers:
+	default:
+		return nil, errors.New("配置 '" + CfgUserSessionLimitMode + "' 的值 '" + limits.Mode + "' 是无效的")
+	}
+	if limits.Max <= 0 && len(limits.RoleMax) == 0 {
+		return nil, nil
+	}
+	return limits, nil
+}
+
+// For 返回用户最多可以有多少个在线会话，用户有多个角色单独配置了限制时取最小的，
+// 都没有配置时用全局的配置， 0 表示不限制
+func (l *SessionLimits) For(roles []string) int {
+	found := 0
+	for _, role := range roles {
+		if max, ok := l.RoleMax[role]; ok && (found == 0 || max < found) {
+			found = max
+		}
+	}
+	if found > 0 {
+		return found
+	}
+	return l.Max
+}
+
+// Check 在创建新的会话之前检查用户已有的在线会话 sessions, 超过限制时按 Mode 拒绝登录
+// 或者返回要注销的旧会话。每次登录都会创建新的会话，所以已有的会话都计算在内
+func (l *SessionLimits) Check(sessions []booclient.OnlineInfo, roles []string) ([]booclient.OnlineInfo, error) {
+	max := l.For(roles)
+	if max <= 0 {
+		return nil, nil
+	}
+	// 加上新的会话后不超过限制
+	if len(sessions) < max {
+		return nil, nil
+	}
+
+	switch l.Mode {
+	case LimitEvictOldest:
+		others := append([]booclient.OnlineInfo(nil), sessions...)
+		sort.SliceStable(others, func(i, j int) bool {
+			return others[i].CreatedAt.Before(others[j].CreatedAt)
+		})
+		return others[:len(others)-max+1], nil
+	case LimitEvictOthers:
+		return sessions, nil
+	default:
+		return nil, session_core.ErrTooManySessions
+	}
+}
@@ -0,0 +1,183 @@
+package session_auth
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+)
+
+type testOnlines struct {
+	list []booclient.OnlineInfo
+}
+
+func (o *testOnlines) GetBySessionID(ctx context.Context, uuid string) (*booclient.OnlineInfo, error) {
+	for idx := range o.list {
+		if o.list[idx].UUID == uuid {
+			return &o.list[idx], nil
+		}
+	}
+	return nil, nil
+}
+
+func (o *testOnlines) List(ctx context.Context) ([]booclient.OnlineInfo, error) {
+	return append([]booclient.OnlineInfo(nil), o.list...), nil
+}
+
+func (o *testOnlines) Count(ctx context.Context) (int64, error) {
+	return int64(len(o.list)), nil
+}
+
+func (o *testOnlines) LogoutByUsername(ctx context.Context, username string) error {
+	var list []booclient.OnlineInfo
+	for _, s := range o.list {
+		if s.Username != username {
+			list = append(list, s)
+		}
+	}
+	o.list = list
+	return nil
+}
+
+func (o *testOnlines) LogoutBySessionID(ctx context.Context, uuid string) error {
+	var list []booclient.OnlineInfo
+	for _, s := range o.list {
+		if s.UUID != uuid {
+			list = append(list, s)
+		}
+	}
+	o.list = list
+	return nil
+}
+
+func (o *testOnlines) IsOnlineExists(ctx context.Context, username, loginAddress string) error {
+	return nil
+}
+
+func (o *testOnlines) QueryByUsername(ctx context.Context, username string) ([]booclient.OnlineInfo, error) {
+	var list []booclient.OnlineInfo
+	for _, s := range o.list {
+		if s.Username == username {
+			list = append(list, s)
+		}
+	}
+	return list, nil
+}
+
+func (o *testOnlines) LoginWithCheck(ctx context.Context, username, address string, metadata *booclient.SessionMetadata, apiKey string, check OnlineCheckFunc) (string, []booclient.OnlineInfo, error) {
+	var evicted []booclient.OnlineInfo
+	if check != nil {
+		sessions, _ := o.QueryByUsername(ctx, username)
+		var err error
+		evicted, err = check(sessions)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, s := range evicted {
+			o.LogoutBySessionID(ctx, s.UUID)
+		}
+	}
+	uuid, err := o.Login(ctx, username, address, metadata, apiKey)
+	return uuid, evicted, err
+}
+
+func (o *testOnlines) Login(ctx context.Context, username, address string, metadata *booclient.SessionMetadata, apiKey string) (string, error) {
+	uuid := strconv.Itoa(len(o.list) + 1)
+	info := booclient.OnlineInfo{UUID: uuid, Username: username, Address: address, CreatedAt: time.Now()}
+	if metadata != nil {
+		info.SessionMetadata = *metadata
+	}
+	o.list = append(o.list, info)
+	return uuid, nil
+}
+
+func (o *testOnlines) UpdateNow(ctx context.Context, uuid, apiKey string) error {
+	return nil
+}
+
+func TestSessionLimits(t *testing.T) {
+	newOnlines := func() *testOnlines {
+		now := time.Now()
+		return &testOnlines{list: []booclient.OnlineInfo{
+			{UUID: "s2", Username: "admin", Address: "192.168.1.2", CreatedAt: now.Add(-2 * time.Hour)},
+			{UUID: "s1", Username: "admin", Address: "192.168.1.1", CreatedAt: now.Add(-3 * time.Hour)},
+			{UUID: "s3", Username: "admin", Address: "192.168.1.3", CreatedAt: now.Add(-1 * time.Hour)},
+			{UUID: "g1", Username: "guest", Address: "192.168.1.9", CreatedAt: now.Add(-4 * time.Hour)},
+		}}
+	}
+	uuids := func(list []booclient.OnlineInfo) []string {
+		var results []string
+		for _, s := range list {
+			results = append(results, s.UUID)
+		}
+		return results
+	}
+
+	limits := &SessionLimits{
+		Max:     3,
+		RoleMax: map[string]int{"operator": 2, "auditor": 1},
+		Mode:    LimitRejectNew,
+	}
+	if max := limits.For([]string{"operator", "auditor"}); max != 1 {
+		t.Error("want 1 got", max)
+	}
+	if max := limits.For([]string{"guest"}); max != 3 {
+		t.Error("want 3 got", max)
+	}
+
+	ctx := context.Background()
+
+	login := func(onlines *testOnlines, address string, roles []string) (string, []booclient.OnlineInfo, error) {
+		return onlines.LoginWithCheck(ctx, "admin", address, nil, "", func(sessions []booclient.OnlineInfo) ([]booclient.OnlineInfo, error) {
+			return limits.Check(sessions, roles)
+		})
+	}
+
+	// 同一个地址上登录时也会创建新的会话
+	onlines := newOnlines()
+	onlines.list = onlines.list[1:]
+	if id, _, err := login(onlines, "192.168.1.1", nil); err != nil {
+		t.Error(err)
+	} else if id != "4" {
+		t.Error("want 4 got", id)
+	}
+	if _, _, err := login(onlines, "192.168.1.1", nil); err != session_core.ErrTooManySessions {
+		t.Error("want ErrTooManySessions got", err)
+	}
+	if len(onlines.list) != 4 {
+		t.Error("want sessions are unchanged")
+	}
+
+	onlines = newOnlines()
+
+	limits.Mode = LimitEvictOldest
+	_, evicted, err := login(onlines, "192.168.1.4", []string{"operator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := uuids(evicted); len(ids) != 2 || ids[0] != "s1" || ids[1] != "s2" {
+		t.Error("want [s1 s2] got", ids)
+	}
+	if ids := uuids(onlines.list); len(ids) != 3 || ids[0] != "s3" || ids[1] != "g1" || ids[2] != "3" {
+		t.Error("want [s3 g1 3] got", ids)
+	}
+
+	limits.Mode = LimitEvictOthers
+	onlines = newOnlines()
+	id, evicted, err := login(onlines, "192.168.1.2", []string{"operator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "2" {
+		t.Error("want 2 got", id)
+	}
+	if ids := uuids(evicted); len(ids) != 3 || ids[0] != "s2" || ids[1] != "s1" || ids[2] != "s3" {
+		t.Error("want [s2 s1 s3] got", ids)
+	}
+	if ids := uuids(onlines.list); len(ids) != 2 || ids[0] != "g1" || ids[1] != "2" {
+		t.Error("want [g1 2] got", ids)
+	}
+}
@@ -0,0 +1,388 @@
+package session_auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/goutils/as"
+	"github.com/boo-admin/boo/services/authn/jwt_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	CfgUserLoginConflict       = "users.login_conflict"
+	CfgUserMaxLoginFailCount   = "users.max_login_fail_count"
+	CfgUserPasswordExpiredDays = "users.password_expired_days"
+)
+
+type sessionIDKey string
+
+func (s sessionIDKey) constSessionIDKey() {} // nolint:unused
+
+const SessionIDKey = sessionIDKey("boo-session-id-key")
+
+func ContextWithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, SessionIDKey, sessionID)
+}
+
+func SessionIDFromContext(ctx context.Context) string {
+	o := ctx.Value(SessionIDKey)
+	if o == nil {
+		return ""
+	}
+	s, _ := o.(string)
+	return s
+}
+
+// Lockouts 记录用户登录失败的次数，并在失败次数过多时锁定用户
+type Lockouts interface {
+	session_core.FailCounter
+	session_core.Locker
+	session_core.IsLocker
+	booclient.LockedUsers
+}
+
+// OperationLogger 用于在操作日志中记录解锁用户，被拒绝的登录和被注销的会话等操作
+type OperationLogger interface {
+	LogRecord(ctx context.Context, ol *booclient.OperationLog) error
+}
+
+// NewAuthService 按配置创建一个带有常用插件的 AuthService， lockouts 为 nil 时
+// 登录失败的次数只记录在内存中
+func NewAuthService(env *booclient.Environment, um session_core.UserManager, onlines Onlines, lockouts Lockouts, opts ...session_core.AuthOption) (*session_core.AuthService, error) {
+	logger := env.Logger.WithGroup("login")
+
+	var lockCheck session_core.AuthOption
+	var locker session_core.Locker = NoneLocker{}
+	var counter session_core.FailCounter
+	if lockouts != nil {
+		lockCheck = session_core.LockCheck(lockouts)
+		locker = lockouts
+		counter = lockouts
+	} else {
+		lockCheck = session_core.LockCheck(nil)
+		if l, ok := um.(session_core.Locker); ok {
+			locker = l
+		}
+		counter = session_core.CreateFailCounter()
+	}
+
+	var options = []session_core.AuthOption{
+		// 必须在 ErrorCountCheck 之前
+		session_core.TwoFactorCheck(counter),
+		lockCheck,
+		session_core.ErrorCountCheck(locker, counter,
+			env.Config.IntWithDefault(CfgUserMaxLoginFailCount, 5)),
+		session_core.CanLogin(),
+		session_core.Whitelist(),
+		session_core.LoginTypeCheck(),
+		session_core.PasswordExpiredCheck(time.Duration(env.Config.IntWithDefault(CfgUserPasswordExpiredDays, 0)) * 24 * time.Hour),
+		session_core.PasswordChangeCheck(),
+		session_core.ExternalUserCheck(),
+	}
+	if onlines != nil {
+		options = append(options, session_core.OnlineCheck(onlines,
+			env.Config.StringWithDefault(CfgUserLoginConflict, "auto")))
+	}
+	if env.Config.BoolWithDefault(session_core.CfgUserLdapEnabled, false) {
+		syncer, _ := um.(session_core.RoleSyncer)
+		options = append(options, session_core.LdapUserCheck(env, logger, syncer))
+	}
+	options = append(options, opts...)
+	return session_core.NewAuthService(um, options...)
+}
+
+// LoginHandler 将 AuthService 包装为登录和注销的处理过程，它负责
+// 在认证成功后登记在线会话，并生成 cookie 或 jwt token
+type LoginHandler struct {
+	Logger       *slog.Logger
+	Auth         *session_core.AuthService
+	Users        session_core.UserManager
+	Onlines      Onlines
+	OnlineApiKey string
+	Cookie       *Option
+
+	// Limits 为 nil 时不限制用户的并发会话数
+	Limits *SessionLimits
+
+	// OperationLogger 用于记录因为并发会话数限制而拒绝的登录和注销的会话
+	OperationLogger OperationLogger
+
+	// IssueToken 当 LoginType 为 TokenJWT 时用于生成访问令牌和刷新令牌
+	IssueToken func(ctx context.Context, userID interface{}, username string, roles []string, sessionID string) (*jwt_auth.TokenPair, error)
+
+	// RevokeToken 注销时用于吊销当前请求中的 token
+	RevokeToken func(ctx context.Context, req *http.Request) error
+}
+
+func (h *LoginHandler) Login(ctx context.Context, w http.ResponseWriter, req *http.Request, request *session_core.LoginRequest) (*session_core.LoginResult, error) {
+	if request.Username == "" {
+		return nil, session_core.ErrUsernameEmpty
+	}
+	// 客户端的地址总是以服务端看到的为准，不能由请求指定
+	request.Address = booclient.RealIP(req)
+
+	authCtx := &session_core.AuthContext{
+		Logger:  h.Logger.With(slog.String("username", request.Username), slog.String("address", request.Address)),
+		Ctx:     ctx,
+		Request: *request,
+	}
+	return h.login(ctx, w, req, authCtx)
+}
+
+// LoginWithIdentity 用外部认证服务（如 OIDC 和 CAS）验证过的用户身份登录，
+// 它和 Login 一样会经过 AuthService 的各个插件的检查
+func (h *LoginHandler) LoginWithIdentity(ctx context.Context, w http.ResponseWriter, req *http.Request, identity *session_core.ExternalIdentity, loginType session_core.LoginType) (*session_core.LoginResult, error) {
+	if identity.Username == "" {
+		return nil, session_core.ErrUsernameEmpty
+	}
+	request := session_core.LoginRequest{
+		Username:  identity.Username,
+		Address:   booclient.RealIP(req),
+		LoginType: loginType,
+	}
+
+	authCtx := &session_core.AuthContext{
+		Logger: h.Logger.With(slog.String("username", request.Username),
+			slog.String("address", request.Address),
+			slog.String("source", identity.Source)),
+		Ctx:      ctx,
+		Request:  request,
+		Identity: identity,
+	}
+	return h.login(ctx, w, req, authCtx)
+}
+
+func (h *LoginHandler) login(ctx context.Context, w http.ResponseWriter, req *http.Request, authCtx *session_core.AuthContext) (*session_core.LoginResult, error) {
+	// 用户不存在和密码不正确只在日志中区分，返回给客户端的都是 ErrInvalidCredentials
+	err := h.Auth.Auth(authCtx)
+	if err != nil {
+		authCtx.Logger.InfoContext(ctx, "用户登录失败", slog.Any("error", err))
+		if errors.Is(err, session_core.ErrUserNotFound) || errors.Is(err, session_core.ErrPasswordNotMatch) {
+			return nil, session_core.ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if !authCtx.Response.IsOK {
+		if authCtx.Authentication == nil {
+			authCtx.Logger.InfoContext(ctx, "用户登录失败，用户不存在")
+		} else {
+			authCtx.Logger.InfoContext(ctx, "用户登录失败，密码不正确", slog.Int("error_count", authCtx.ErrorCount))
+		}
+		return nil, session_core.ErrInvalidCredentials
+	}
+
+	if authCtx.Response.IsNewUser {
+		var source string
+		if u, ok := authCtx.Authentication.(session_core.HasSource); ok {
+			source = u.Source()
+		}
+		var roles []string
+		if u, ok := authCtx.Authentication.(session_core.HasRoles); ok {
+			roles = u.RoleNames()
+		}
+		nickname := authCtx.Request.Username
+		var fields map[string]interface{}
+		if u, ok := authCtx.Authentication.(session_core.HasProfile); ok {
+			if s := u.Nickname(); s != "" {
+				nickname = s
+			}
+			fields = u.Fields()
+		}
+		id, err := h.Users.Create(ctx, authCtx.Request.Username, nickname, source, "", fields, roles, true)
+		if err != nil {
+			authCtx.Logger.WarnContext(ctx, "用户登录成功，但创建用户失败", slog.Any("error", err))
+			return nil, errors.Wrap(err, "创建用户 '"+authCtx.Request.Username+"' 失败")
+		}
+		authCtx.Request.UserID = id
+	}
+
+	var roles []string
+	if u, ok := authCtx.Authentication.(session_core.HasRoles); ok {
+		roles = u.RoleNames()
+	}
+
+	var check OnlineCheckFunc
+	if h.Limits != nil {
+		check = func(sessions []booclient.OnlineInfo) ([]booclient.OnlineInfo, error) {
+			return h.Limits.Check(sessions, roles)
+		}
+	}
+	sessionID, evicted, err := h.Onlines.LoginWithCheck(ctx, authCtx.Request.Username, authCtx.Request.Address, NewSessionMetadata(req, authCtx), h.OnlineApiKey, check)
+	if err != nil {
+		if errors.Is(err, session_core.ErrTooManySessions) {
+			authCtx.Logger.InfoContext(ctx, "用户登录失败，在线会话数已达到上限", slog.Int("max_sessions", h.Limits.For(roles)))
+			h.logSessionLimit(ctx, authCtx, "rejectsession", false, "用户的在线会话数已达到上限，拒绝新的登录", nil)
+			return nil, session_core.ErrTooManySessions
+		}
+		authCtx.Logger.WarnContext(ctx, "用户登录成功，但创建在线会话失败", slog.Any("error", err))
+		return nil, errors.Wrap(err, "创建在线会话失败")
+	}
+	if len(evicted) > 0 {
+		authCtx.Logger.InfoContext(ctx, "用户的在线会话数已达到上限，注销了旧的会话", slog.Int("evicted", len(evicted)))
+		h.logSessionLimit(ctx, authCtx, "evictsession", true, "用户的在线会话数已达到上限，注销了旧的会话", evicted)
+	}
+	authCtx.Response.SessionID = sessionID
+
+	if authCtx.Request.LoginType == session_core.TokenJWT {
+		if h.IssueToken == nil {
+			return nil, errors.New("不支持 jwt 方式登录")
+		}
+		pair, err := h.IssueToken(ctx, authCtx.Request.UserID, authCtx.Request.Username, roles, sessionID)
+		if err != nil {
+			return nil, errors.Wrap(err, "生成 token 失败")
+		}
+		if authCtx.Response.Data == nil {
+			authCtx.Response.Data = map[string]interface{}{}
+		}
+		// token 是为了兼容以前的客户端
+		authCtx.Response.Data["token"] = pair.AccessToken
+		authCtx.Response.Data["access_token"] = pair.AccessToken
+		authCtx.Response.Data["refresh_token"] = pair.RefreshToken
+		authCtx.Response.Data["token_type"] = pair.TokenType
+		authCtx.Response.Data["expires_in"] = pair.ExpiresIn
+		authCtx.Response.Data["refresh_expires_in"] = pair.RefreshExpiresIn
+	} else {
+		values := url.Values{}
+		values.Set(SESSION_ID_KEY, sessionID)
+		values.Set(SESSION_USER_KEY, authCtx.Request.Username)
+		values.Set(SESSION_VALID_KEY, "true")
+		if h.Cookie.SessionTimeouts != nil {
+			h.Cookie.SessionTimeouts.Apply(values, roles, time.Now())
+		}
+		cookie, err := CreateCookie(h.Cookie, values)
+		if err != nil {
+			return nil, err
+		}
+		http.SetCookie(w, cookie)
+	}
+
+	authCtx.Logger.InfoContext(ctx, "用户登录成功",
+		slog.String("session_id", sessionID),
+		slog.String("login_type", authCtx.Request.LoginType.String()),
+		slog.Bool("is_new_user", authCtx.Response.IsNewUser),
+		slog.Bool("is_password_expired", authCtx.Response.IsPasswordExpired),
+		slog.Bool("must_change_password", authCtx.Response.MustChangePassword))
+	return &authCtx.Response, nil
+}
+
+// clientHintHeaders 是登录时记录的 User-Agent Client Hints 请求头，
+// 浏览器只有在服务端用 Accept-CH 请求后才会发送低熵以外的头
+var clientHintHeaders = []string{
+	"Sec-CH-UA",
+	"Sec-CH-UA-Mobile",
+	"Sec-CH-UA-Platform",
+	"Sec-CH-UA-Platform-Version",
+	"Sec-CH-UA-Model",
+	"Sec-CH-UA-Arch",
+}
+
+// NewSessionMetadata 从登录请求中读取客户端信息
+func NewSessionMetadata(req *http.Request, authCtx *session_core.AuthContext) *booclient.SessionMetadata {
+	metadata := &booclient.SessionMetadata{
+		UserAgent: req.UserAgent(),
+		LoginType: "session",
+	}
+	if authCtx.Request.LoginType != session_core.TokenNone {
+		metadata.LoginType = authCtx.Request.LoginType.String()
+	}
+	if authCtx.Identity != nil && authCtx.Identity.Source != "" {
+		metadata.LoginType = authCtx.Identity.Source
+	}
+	for _, name := range clientHintHeaders {
+		if value := req.Header.Get(name); value != "" {
+			if metadata.ClientHints == nil {
+				metadata.ClientHints = map[string]string{}
+			}
+			metadata.ClientHints[strings.ToLower(name)] = value
+		}
+	}
+	return metadata
+}
+
+func (h *LoginHandler) logSessionLimit(ctx context.Context, authCtx *session_core.AuthContext, typ string, successful bool, content string, evicted []booclient.OnlineInfo) {
+	if h.OperationLogger == nil {
+		return
+	}
+	records := []booclient.ChangeRecord{
+		{
+			Name:        "address",
+			DisplayName: "登录地址",
+			NewValue:    authCtx.Request.Address,
+		},
+	}
+	for _, s := range evicted {
+		records = append(records, booclient.ChangeRecord{
+			Name:        "session",
+			DisplayName: "会话",
+			OldValue:    s.UUID,
+			NewValue:    s.Address,
+		})
+	}
+
+	userID := as.Int64WithDefault(authCtx.Request.UserID, 0)
+	err := h.OperationLogger.LogRecord(ctx, &booclient.OperationLog{
+		UserID:     userID,
+		Username:   authCtx.Request.Username,
+		Successful: successful,
+		Type:       typ,
+		Content:    content,
+		Fields: &booclient.OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   userID,
+			Records:    records,
+		},
+	})
+	if err != nil {
+		authCtx.Logger.WarnContext(ctx, "记录操作日志失败", slog.Any("error", err))
+	}
+}
+
+func (h *LoginHandler) Logout(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	sessionID := SessionIDFromContext(ctx)
+	if sessionID == "" && h.Cookie != nil {
+		values, err := h.Cookie.GetValues(req)
+		if err == nil {
+			sessionID = values.Get(SESSION_ID_KEY)
+		}
+	}
+
+	if h.RevokeToken != nil {
+		if err := h.RevokeToken(ctx, req); err != nil {
+			return errors.Wrap(err, "吊销 token 失败")
+		}
+	}
+
+	if sessionID != "" {
+		if err := h.Onlines.LogoutBySessionID(ctx, sessionID); err != nil {
+			return errors.Wrap(err, "删除在线会话失败")
+		}
+		h.Logger.InfoContext(ctx, "用户注销成功", slog.String("session_id", sessionID))
+	}
+
+	if h.Cookie != nil {
+		http.SetCookie(w, RemoveCookie(h.Cookie))
+	}
+	return nil
+}
+
+// RemoveCookie 创建一个用于删除会话的 cookie
+func RemoveCookie(opt *Option) *http.Cookie {
+	return &http.Cookie{
+		Name:     opt.SessionName,
+		Value:    "",
+		Domain:   opt.SessionDomain,
+		Path:     opt.SessionPath,
+		HttpOnly: opt.SessionHttpOnly,
+		Secure:   opt.SessionSecure,
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+		SameSite: opt.SessionSameSite,
+	}
+}
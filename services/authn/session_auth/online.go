@@ -41,12 +41,13 @@ type OnlineAdder interface {
 	// @Tags     Inner
 	// @Param username body string   true        "用户的名称"
 	// @Param address  body string   true        "登录地址"
+	// @Param metadata body booclient.SessionMetadata false "客户端信息"
 	// @Param api_key  body string   true        "访问本接口时的 password"
 	// @Accept  json
 	// @Produce  json
 	// @Router /online-users [post]
 	// @Success 200 {string}  string  "返回新建会话的 ID"
-	Login(ctx context.Context, username, address, apiKey string) (string, error)
+	Login(ctx context.Context, username, address string, metadata *booclient.SessionMetadata, apiKey string) (string, error)
 
 	// @Summary 更新一下在线会话的存活时间(内部使用，客户将无法使用)
 	// @Description 访问本接口时需要额外的 password
@@ -66,6 +67,22 @@ type Onlines interface {
 	session_core.OnlineChecker
 
 	OnlineAdder
+
+	UserOnlines
+}
+
+// OnlineCheckFunc 在创建新的会话之前检查用户已有的在线会话，返回要注销的会话，
+// 返回错误时不创建新的会话
+type OnlineCheckFunc func(sessions []booclient.OnlineInfo) ([]booclient.OnlineInfo, error)
+
+// UserOnlines 按用户查询和创建在线会话，不需要查询全部的会话
+type UserOnlines interface {
+	// QueryByUsername 返回用户的在线会话
+	QueryByUsername(ctx context.Context, username string) ([]booclient.OnlineInfo, error)
+
+	// LoginWithCheck 同 Login, 它在同一个事务中查询用户的在线会话，调用 check 检查它们并注销
+	// check 返回的会话，然后创建新的会话，返回新会话的 ID 和被注销的会话， check 为 nil 时不检查
+	LoginWithCheck(ctx context.Context, username, address string, metadata *booclient.SessionMetadata, apiKey string, check OnlineCheckFunc) (string, []booclient.OnlineInfo, error)
 }
 
 type OnlineStore interface {
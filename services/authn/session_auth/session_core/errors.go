@@ -29,6 +29,10 @@ var (
 	// ErrPasswordNotMatch 密码不正确
 	ErrPasswordNotMatch = newHTTPError(http.StatusUnauthorized, "password isn't match")
 
+	// ErrInvalidCredentials 用户名或密码不正确，返回给客户端时用它代替 ErrUserNotFound 和 ErrPasswordNotMatch，
+	// 以免泄露用户是否存在
+	ErrInvalidCredentials = newHTTPError(http.StatusUnauthorized, "username or password is invalid")
+
 	// ErrMutiUsers 找到多个用户
 	ErrMutiUsers = newHTTPError(http.StatusUnauthorized, "muti users is found")
 
@@ -50,6 +54,9 @@ var (
 	// ErrUserAlreadyOnline 用户已登录
 	ErrUserAlreadyOnline = newHTTPError(http.StatusUnauthorized, "user is already online")
 
+	// ErrTooManySessions 用户的在线会话数已达到上限
+	ErrTooManySessions = newHTTPError(http.StatusUnauthorized, "user has too many sessions")
+
 	// ErrPermissionDenied 没有权限
 	ErrPermissionDenied = newHTTPError(http.StatusUnauthorized, "permission is denied")
 
@@ -61,6 +68,12 @@ var (
 
 	// ErrCaptchaMissing
 	ErrCaptchaMissing = newHTTPError(http.StatusUnauthorized, "captcha is missing")
+
+	// ErrTwoFactorRequired 用户开通了两步验证，但没有提供验证码
+	ErrTwoFactorRequired = newHTTPError(http.StatusUnauthorized, "two factor code is required")
+
+	// ErrTwoFactorCodeInvalid 两步验证的验证码不正确
+	ErrTwoFactorCodeInvalid = newHTTPError(http.StatusUnauthorized, "two factor code is invalid")
 )
 
 type errAddress string
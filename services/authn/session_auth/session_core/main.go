@@ -2,6 +2,7 @@ package session_core
 
 import (
 	"context"
+	"errors"
 	"strconv"
 	"strings"
 
@@ -31,12 +32,18 @@ type UserManager interface {
 }
 
 type LoginResult struct {
-	IsOK              bool
-	SessionID         string
-	IsNewUser         bool
-	IsPasswordExpired bool
+	IsOK              bool   `json:"is_ok"`
+	SessionID         string `json:"session_id,omitempty"`
+	IsNewUser         bool   `json:"is_new_user,omitempty"`
+	IsPasswordExpired bool   `json:"is_password_expired,omitempty"`
 
-	Data map[string]interface{}
+	// MustChangePassword 表示用户必须先修改密码才能使用其它的功能
+	MustChangePassword bool `json:"must_change_password,omitempty"`
+
+	// IsTwoFactorEnrollRequired 表示用户的角色要求两步验证，但用户还没有开通
+	IsTwoFactorEnrollRequired bool `json:"is_two_factor_enroll_required,omitempty"`
+
+	Data map[string]interface{} `json:"data,omitempty"`
 }
 
 type LoginType int
@@ -57,6 +64,16 @@ func (t LoginType) String() string {
 	}
 }
 
+func ParseLoginType(s string) (LoginType, error) {
+	switch strings.ToLower(s) {
+	case "", "none", "session", "cookie":
+		return TokenNone, nil
+	case "jwt", "token":
+		return TokenJWT, nil
+	}
+	return TokenNone, errors.New("login type '" + s + "' is invalid")
+}
+
 type LoginRequest struct {
 	UserID       interface{} `json:"userid" xml:"userid" form:"-" query:"-"`
 	Username     string      `json:"username" xml:"username" form:"username" query:"username"`
@@ -65,9 +82,11 @@ type LoginRequest struct {
 	ForceLogin   string      `json:"force,omitempty" xml:"force" form:"force" query:"force"`
 	CaptchaKey   string      `json:"captcha_key,omitempty" xml:"captcha_key" form:"captcha_key" query:"captcha_key"`
 	CaptchaValue string      `json:"captcha_value,omitempty" xml:"captcha_value" form:"captcha_value" query:"captcha_value"`
+	TOTPCode     string      `json:"totp_code,omitempty" xml:"totp_code" form:"totp_code" query:"totp_code"`
 
-	Address   string
-	LoginType LoginType
+	// Address 和 LoginType 由服务端设置，不能从请求中读取
+	Address   string    `json:"-" xml:"-" form:"-" query:"-"`
+	LoginType LoginType `json:"-" xml:"-" form:"-" query:"-"`
 }
 
 func (u *LoginRequest) IsForce() bool {
@@ -98,6 +117,9 @@ type AuthContext struct {
 	SkipCaptcha    bool
 	Authentication interface{}
 	ErrorCount     int
+
+	// Identity 不为空时表示用户已经通过外部认证服务（如 OIDC 和 CAS）的验证
+	Identity *ExternalIdentity
 }
 
 type AuthFunc func(*AuthContext) error
@@ -0,0 +1,90 @@
+package session_core
+
+import (
+	"github.com/mei-rune/iprange"
+	"golang.org/x/exp/slog"
+)
+
+// ExternalIdentity 是外部认证服务（如 OIDC 和 CAS）已经验证过的用户身份，
+// 它不需要再校验密码
+type ExternalIdentity struct {
+	Source   string
+	Username string
+	Nickname string
+	Fields   map[string]interface{}
+	Roles    []string
+}
+
+// HasProfile 是新建用户时使用的用户信息
+type HasProfile interface {
+	Nickname() string
+	Fields() map[string]interface{}
+}
+
+// ExternalUserCheck 处理 AuthContext.Identity 不为空的登录请求，系统中已有同名
+// 用户时，它的来源必须和 Identity.Source 一致，否则拒绝登录， 没有同名用户时作为新
+// 用户登录
+func ExternalUserCheck() AuthOption {
+	return AuthOptionFunc(func(auth *AuthService) error {
+		auth.OnBeforeAuth(AuthFunc(func(ctx *AuthContext) error {
+			if ctx.Identity == nil || ctx.Authentication == nil {
+				return nil
+			}
+			u, ok := ctx.Authentication.(HasSource)
+			if !ok || u.Source() != ctx.Identity.Source {
+				var source string
+				if ok {
+					source = u.Source()
+				}
+				ctx.Logger.Warn("用户的来源和外部认证服务不一致",
+					slog.String("source", source),
+					slog.String("identity_source", ctx.Identity.Source))
+				return ErrPermissionDenied
+			}
+			return nil
+		}))
+
+		auth.OnAuth(func(ctx *AuthContext) (bool, error) {
+			if ctx.Identity == nil {
+				return false, nil
+			}
+			if ctx.Authentication == nil {
+				ctx.Response.IsNewUser = true
+				ctx.Authentication = &externalUser{identity: ctx.Identity}
+			}
+			return true, nil
+		})
+		return nil
+	})
+}
+
+var _ User = &externalUser{}
+var _ HasProfile = &externalUser{}
+
+type externalUser struct {
+	identity *ExternalIdentity
+}
+
+func (*externalUser) IsLocked() bool {
+	return false
+}
+
+func (u *externalUser) Source() string {
+	return u.identity.Source
+}
+
+func (*externalUser) IngressIPList() ([]iprange.Checker, error) {
+	return nil, nil
+}
+
+func (u *externalUser) RoleNames() []string {
+	return u.identity.Roles
+}
+
+func (u *externalUser) Nickname() string {
+	return u.identity.Nickname
+}
+
+func (u *externalUser) Fields() map[string]interface{} {
+	return u.identity.Fields
+}
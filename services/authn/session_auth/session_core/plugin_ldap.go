@@ -22,8 +22,99 @@ const (
 	CfgUserLdapDefaultRoles   = "users.ldap_default_roles"
 	CfgUserLdapLoginRoleField = "users.ldap_login_role_field"
 	CfgUserLdapLoginRoleName  = "users.ldap_login_role"
+
+	// CfgUserLdapGroupRoles 是 LDAP 组和角色的映射，如 users.ldap_group_roles.admins = 管理员,运维，
+	// 组名为组的 DN 中第一个 RDN 的值，配置了映射时，LDAP 用户每次登录都会按它更新用户的角色
+	CfgUserLdapGroupRoles = "users.ldap_group_roles"
 )
 
+// RoleSyncer 是 UserManager 的可选接口，LDAP 用户登录时用它把组映射的角色同步到系统中，
+// 只有 managedRoles 中的角色会被添加或删除
+type RoleSyncer interface {
+	SyncRoles(ctx *AuthContext, roles, managedRoles []string) error
+}
+
+// LdapGroupRoles 是 LDAP 组名（小写）和角色的映射
+type LdapGroupRoles map[string][]string
+
+// NewLdapGroupRoles 从配置中读取 LDAP 组和角色的映射，没有配置时返回 nil
+func NewLdapGroupRoles(env *booclient.Environment) LdapGroupRoles {
+	var groupRoles LdapGroupRoles
+	env.Config.ForEachWithPrefix(CfgUserLdapGroupRoles+".", func(k string, value interface{}) {
+		group := strings.ToLower(strings.TrimPrefix(k, CfgUserLdapGroupRoles+"."))
+		if group == "" {
+			return
+		}
+		var roles []string
+		for _, role := range strings.Split(env.Config.StringWithDefault(k, ""), ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				roles = append(roles, role)
+			}
+		}
+		if len(roles) == 0 {
+			return
+		}
+		if groupRoles == nil {
+			groupRoles = LdapGroupRoles{}
+		}
+		groupRoles[group] = roles
+	})
+	return groupRoles
+}
+
+// Roles 返回组对应的角色
+func (m LdapGroupRoles) Roles(groups []string) []string {
+	var roles []string
+	for _, group := range groups {
+		for _, role := range m[strings.ToLower(group)] {
+			if !containsString(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// ManagedRoles 返回映射中所有的角色，同步时只会添加或删除这些角色
+func (m LdapGroupRoles) ManagedRoles() []string {
+	var roles []string
+	for _, list := range m {
+		for _, role := range list {
+			if !containsString(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// LdapGroupNames 将 memberOf 等属性中组的 DN 转换为组名，即 DN 中第一个 RDN 的值，
+// 不是 DN 的值原样返回
+func LdapGroupNames(values []string) []string {
+	names := make([]string, 0, len(values))
+	for _, value := range values {
+		dn, err := ldap.ParseDN(value)
+		if err != nil {
+			names = append(names, value)
+			continue
+		}
+		if len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+			continue
+		}
+		names = append(names, dn.RDNs[0].Attributes[0].Value)
+	}
+	return names
+}
+
 type HasSource interface {
 	Source() string
 }
@@ -37,7 +128,17 @@ func isConnectError(err error) bool {
 	return false
 }
 
-func LdapUserCheck(env *booclient.Environment, logger *slog.Logger) AuthOption {
+// DialLdap 连接到 LDAP 服务器
+func DialLdap(address string, useTLS bool) (*ldap.Conn, error) {
+	if useTLS {
+		return ldap.DialTLS("tcp", address, &tls.Config{InsecureSkipVerify: true})
+	}
+	return ldap.Dial("tcp", address)
+}
+
+// LdapUserCheck 用 LDAP 校验用户的密码，syncer 不为 nil 并且配置了组和角色的映射时，
+// 已有的 LDAP 用户每次登录都会同步它的角色
+func LdapUserCheck(env *booclient.Environment, logger *slog.Logger, syncer RoleSyncer) AuthOption {
 	return AuthOptionFunc(func(auth *AuthService) error {
 		ldapServer := env.Config.StringWithDefault(CfgUserLdapAddress, "")
 		if ldapServer == "" {
@@ -59,6 +160,8 @@ func LdapUserCheck(env *booclient.Environment, logger *slog.Logger) AuthOption {
 		ldapRoles := env.Config.StringWithDefault(CfgUserLdapLoginRoleField,
 			env.Config.StringWithDefault("users.ldap_roles", "memberOf"))
 		exceptedRole := env.Config.StringWithDefault(CfgUserLdapLoginRoleName, "")
+		groupRoles := NewLdapGroupRoles(env)
+		syncRoles := syncer != nil && len(groupRoles) > 0
 
 		auth.OnAuth(func(ctx *AuthContext) (bool, error) {
 			isLdap := false
@@ -82,13 +185,7 @@ func LdapUserCheck(env *booclient.Environment, logger *slog.Logger) AuthOption {
 				isNew = true
 			}
 
-			var l *ldap.Conn
-			var err error
-			if ldapTLS {
-				l, err = ldap.DialTLS("tcp", ldapServer, &tls.Config{InsecureSkipVerify: true})
-			} else {
-				l, err = ldap.Dial("tcp", ldapServer)
-			}
+			l, err := DialLdap(ldapServer, ldapTLS)
 			if err != nil {
 				logger.Info("尝试 LDAP 验证时，无法连接到 LDAP 服务器", slog.Any("error", err))
 
@@ -120,7 +217,7 @@ func LdapUserCheck(env *booclient.Environment, logger *slog.Logger) AuthOption {
 			logger.Info("尝试 ldap 验证, 用户名和密码正确")
 
 			if !isNew {
-				if exceptedRole == "" {
+				if exceptedRole == "" && !syncRoles {
 					return true, nil
 				}
 			}
@@ -137,30 +234,29 @@ func LdapUserCheck(env *booclient.Environment, logger *slog.Logger) AuthOption {
 
 			// dn := "cn=" + username + "," + ldapDN
 			//获取数据
-			searchResult, err := l.Search(ldap.NewSearchRequest(
-				ldapDN,
-				ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-				ldapFilterForUser, nil, nil,
-			))
+			var searchRequest *ldap.SearchRequest
+			if ldapFilterForUser != "" {
+				searchRequest = ldap.NewSearchRequest(
+					ldapDN,
+					ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+					ldapFilterForUser, nil, nil,
+				)
+			} else {
+				// 没有配置过滤条件时，直接读绑定的用户自已的条目
+				searchRequest = ldap.NewSearchRequest(
+					username,
+					ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+					"(objectClass=*)", []string{ldapRoles}, nil,
+				)
+			}
+			searchResult, err := l.Search(searchRequest)
 			if err == nil {
 				userRoles = make([]string, 0, 4)
 				for _, ent := range searchResult.Entries {
 					for _, attr := range ent.Attributes {
 						if len(attr.Values) > 0 {
-							if ldapRoles == attr.Name {
-								for _, roleName := range attr.Values {
-									dn, err := ldap.ParseDN(roleName)
-									if err != nil {
-										userRoles = append(userRoles, roleName)
-										continue
-									}
-
-									if len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
-										continue
-									}
-
-									userRoles = append(userRoles, dn.RDNs[0].Attributes[0].Value)
-								}
+							if strings.EqualFold(ldapRoles, attr.Name) {
+								userRoles = append(userRoles, LdapGroupNames(attr.Values)...)
 								// userData["roles"] = userRoles
 								// userData["raw_roles"] = attr.Values
 							}
@@ -187,6 +283,16 @@ func LdapUserCheck(env *booclient.Environment, logger *slog.Logger) AuthOption {
 						return true, ErrPermissionDenied
 					}
 				}
+
+				if len(groupRoles) > 0 {
+					userRoles = groupRoles.Roles(userRoles)
+				}
+				if !isNew && syncRoles {
+					if err := syncer.SyncRoles(ctx, userRoles, groupRoles.ManagedRoles()); err != nil {
+						logger.Warn("同步 ldap 用户的角色失败", slog.Any("error", err))
+						return true, err
+					}
+				}
 			} else {
 				logger.Warn("search user and role fail", slog.Any("error", err))
 
@@ -0,0 +1,69 @@
+package session_core
+
+// TwoFactorAuthenticator 是支持两步验证的用户
+type TwoFactorAuthenticator interface {
+	IsTwoFactorEnabled(ctx *AuthContext) (bool, error)
+
+	// IsTwoFactorRequired 用户的角色是否要求两步验证
+	IsTwoFactorRequired(ctx *AuthContext) (bool, error)
+
+	// VerifyTwoFactor 校验验证码或恢复码
+	VerifyTwoFactor(ctx *AuthContext, code string) (bool, error)
+}
+
+// TwoFactorCheck 在密码认证成功后要求用户输入第二个因素的验证码，用户的角色
+// 要求两步验证但没有开通时，设置 IsTwoFactorEnrollRequired 让前端引导用户开通，
+// 在开通之前会话只能访问开通两步验证的功能。
+//
+// 它必须在 ErrorCountCheck 之前注册，这样验证码错误会被计入失败次数，
+// 而不是被密码正确时的清零覆盖
+func TwoFactorCheck(counter FailCounter) AuthOption {
+	return AuthOptionFunc(func(auth *AuthService) error {
+		auth.OnAfterAuth(func(ctx *AuthContext) error {
+			if !ctx.Response.IsOK {
+				return nil
+			}
+			// 外部认证服务（如 OIDC 和 CAS）自已负责多因素认证
+			if ctx.Identity != nil {
+				return nil
+			}
+
+			au, ok := ctx.Authentication.(TwoFactorAuthenticator)
+			if !ok {
+				return nil
+			}
+			enabled, err := au.IsTwoFactorEnabled(ctx)
+			if err != nil {
+				return err
+			}
+			if !enabled {
+				required, err := au.IsTwoFactorRequired(ctx)
+				if err != nil {
+					return err
+				}
+				if required {
+					ctx.Logger.Info("用户的角色要求开通两步验证")
+					ctx.Response.IsTwoFactorEnrollRequired = true
+				}
+				return nil
+			}
+
+			if ctx.Request.TOTPCode == "" {
+				return ErrTwoFactorRequired
+			}
+			ok, err = au.VerifyTwoFactor(ctx, ctx.Request.TOTPCode)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				if counter != nil {
+					counter.Fail(ctx.Request.Username)
+				}
+				ctx.Logger.Info("两步验证的验证码不正确")
+				return ErrTwoFactorCodeInvalid
+			}
+			return nil
+		})
+		return nil
+	})
+}
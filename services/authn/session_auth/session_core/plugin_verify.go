@@ -46,6 +46,30 @@ func PasswordExpiredCheck(interval time.Duration) AuthOption {
 	})
 }
 
+// PasswordChangeRequired 由需要用户在登录后必须修改密码的 User 实现，
+// 如管理员重置了密码或密码超过了策略规定的最长有效时间
+type PasswordChangeRequired interface {
+	IsPasswordChangeRequired() bool
+}
+
+func PasswordChangeCheck() AuthOption {
+	return AuthOptionFunc(func(auth *AuthService) error {
+		auth.OnAfterAuth(func(ctx *AuthContext) error {
+			au, ok := ctx.Authentication.(PasswordChangeRequired)
+			if !ok {
+				return nil
+			}
+
+			if au.IsPasswordChangeRequired() {
+				ctx.Response.IsPasswordExpired = true
+				ctx.Response.MustChangePassword = true
+			}
+			return nil
+		})
+		return nil
+	})
+}
+
 // func TptUserCheck() AuthOption {
 // 	return AuthOptionFunc(func(auth *AuthService) error {
 // 		verify, initerr := CreateVerify(tptMethod.Alg(), nil)
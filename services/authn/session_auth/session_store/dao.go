@@ -0,0 +1,205 @@
+//go:generate gobatis dao.go
+
+package session_store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	gobatis "github.com/runner-mei/GoBatis"
+)
+
+type OnlineSession struct {
+	TableName   struct{}          `json:"-" xorm:"boo_online_sessions"`
+	UUID        string            `json:"uuid" xorm:"uuid pk"`
+	Username    string            `json:"username" xorm:"username notnull"`
+	Address     string            `json:"address" xorm:"address null"`
+	UserAgent   string            `json:"user_agent,omitempty" xorm:"user_agent null"`
+	LoginType   string            `json:"login_type,omitempty" xorm:"login_type null"`
+	ClientHints map[string]string `json:"client_hints,omitempty" xorm:"client_hints json null"`
+	CreatedAt   time.Time         `json:"created_at" xorm:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at" xorm:"updated_at"`
+}
+
+// @gobatis.namespace boo
+type OnlineSessionDao interface {
+	Insert(ctx context.Context, session *OnlineSession) error
+
+	Update(ctx context.Context, uuid string, session *OnlineSession) (int64, error)
+
+	// @default SELECT * FROM <tablename type="OnlineSession" /> WHERE uuid = #{uuid}
+	FindByUUID(ctx context.Context, uuid string) (*OnlineSession, error)
+
+	// @default SELECT * FROM <tablename type="OnlineSession" /> WHERE username = #{username}
+	//   <if test="isNotZero(expiredAt)"> AND updated_at &gt; #{expiredAt} </if>
+	QueryByUsername(ctx context.Context, username string, expiredAt time.Time) ([]OnlineSession, error)
+
+	// @default SELECT count(*) FROM <tablename type="OnlineSession" />
+	//   <if test="isNotZero(expiredAt)"> WHERE updated_at &gt; #{expiredAt} </if>
+	Count(ctx context.Context, expiredAt time.Time) (int64, error)
+
+	// @default SELECT * FROM <tablename type="OnlineSession" />
+	//   <if test="isNotZero(expiredAt)"> WHERE updated_at &gt; #{expiredAt} </if>
+	List(ctx context.Context, expiredAt time.Time) ([]OnlineSession, error)
+
+	// @default UPDATE <tablename type="OnlineSession" /> SET updated_at = #{now} WHERE uuid = #{uuid}
+	UpdateNow(ctx context.Context, uuid string, now time.Time) (int64, error)
+
+	// @default DELETE FROM <tablename type="OnlineSession" /> WHERE uuid = #{uuid}
+	DeleteByUUID(ctx context.Context, uuid string) error
+
+	// @default DELETE FROM <tablename type="OnlineSession" /> WHERE username = #{username}
+	DeleteByUsername(ctx context.Context, username string) error
+
+	// @default DELETE FROM <tablename type="OnlineSession" /> WHERE updated_at &lt; #{expiredAt}
+	DeleteExpired(ctx context.Context, expiredAt time.Time) (int64, error)
+}
+
+var NewOnlineSessionDaoHook func(ref gobatis.SqlSession) OnlineSessionDao
+
+func NewOnlineSessionDaoWith(ref gobatis.SqlSession) OnlineSessionDao {
+	if NewOnlineSessionDaoHook != nil {
+		return NewOnlineSessionDaoHook(ref)
+	}
+	return NewOnlineSessionDao(ref)
+}
+
+func (s *OnlineSession) ToOnlineInfo() booclient.OnlineInfo {
+	return booclient.OnlineInfo{
+		UUID:      s.UUID,
+		Username:  s.Username,
+		Address:   s.Address,
+		CreatedAt: s.CreatedAt,
+		UpdatedAt: s.UpdatedAt,
+		SessionMetadata: booclient.SessionMetadata{
+			UserAgent:   s.UserAgent,
+			LoginType:   s.LoginType,
+			ClientHints: s.ClientHints,
+		},
+	}
+}
+
+func (s *OnlineSession) setMetadata(metadata *booclient.SessionMetadata) {
+	if metadata == nil {
+		return
+	}
+	s.UserAgent = metadata.UserAgent
+	s.LoginType = metadata.LoginType
+	s.ClientHints = metadata.ClientHints
+}
+
+type LoginLockout struct {
+	TableName struct{}     `json:"-" xorm:"boo_login_lockouts"`
+	Username  string       `json:"username" xorm:"username pk"`
+	Address   string       `json:"address" xorm:"address null"`
+	FailCount int          `json:"fail_count" xorm:"fail_count"`
+	LockedAt  sql.NullTime `json:"locked_at" xorm:"locked_at null"`
+	CreatedAt time.Time    `json:"created_at" xorm:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at" xorm:"updated_at"`
+}
+
+// @gobatis.namespace boo
+type LoginLockoutDao interface {
+	// @type insert
+	// @default INSERT INTO <tablename type="LoginLockout" /> (username, fail_count, created_at, updated_at)
+	//   VALUES(#{username}, 1, #{now}, #{now})
+	//   ON CONFLICT (username) DO UPDATE SET fail_count = boo_login_lockouts.fail_count + 1, updated_at = #{now}
+	// @mysql INSERT INTO <tablename type="LoginLockout" /> (username, fail_count, created_at, updated_at)
+	//   VALUES(#{username}, 1, #{now}, #{now})
+	//   ON DUPLICATE KEY UPDATE fail_count = fail_count + 1, updated_at = #{now}
+	// @dm MERGE INTO <tablename type="LoginLockout" /> t USING dual ON (t.username = #{username})
+	//   WHEN MATCHED THEN UPDATE SET fail_count = t.fail_count + 1, updated_at = #{now}
+	//   WHEN NOT MATCHED THEN INSERT (username, fail_count, created_at, updated_at) VALUES(#{username}, 1, #{now}, #{now})
+	IncFailCount(ctx context.Context, username string, now time.Time) error
+
+	// @type update
+	// @default UPDATE <tablename type="LoginLockout" /> SET fail_count = 0 WHERE username = #{username}
+	ResetFailCount(ctx context.Context, username string) error
+
+	// @default SELECT username FROM <tablename type="LoginLockout" /> WHERE fail_count &gt; 0
+	QueryFailUsernames(ctx context.Context) ([]string, error)
+
+	// @type insert
+	// @default INSERT INTO <tablename type="LoginLockout" /> (username, address, fail_count, locked_at, created_at, updated_at)
+	//   VALUES(#{username}, #{address}, 0, #{now}, #{now}, #{now})
+	//   ON CONFLICT (username) DO UPDATE SET locked_at = #{now}, address = #{address}, updated_at = #{now}
+	// @mysql INSERT INTO <tablename type="LoginLockout" /> (username, address, fail_count, locked_at, created_at, updated_at)
+	//   VALUES(#{username}, #{address}, 0, #{now}, #{now}, #{now})
+	//   ON DUPLICATE KEY UPDATE locked_at = #{now}, address = #{address}, updated_at = #{now}
+	// @dm MERGE INTO <tablename type="LoginLockout" /> t USING dual ON (t.username = #{username})
+	//   WHEN MATCHED THEN UPDATE SET locked_at = #{now}, address = #{address}, updated_at = #{now}
+	//   WHEN NOT MATCHED THEN INSERT (username, address, fail_count, locked_at, created_at, updated_at)
+	//     VALUES(#{username}, #{address}, 0, #{now}, #{now}, #{now})
+	Lock(ctx context.Context, username, address string, now time.Time) error
+
+	// @type delete
+	// @default DELETE FROM <tablename type="LoginLockout" /> WHERE username = #{username}
+	Unlock(ctx context.Context, username string) (int64, error)
+
+	// @default SELECT * FROM <tablename type="LoginLockout" /> WHERE username = #{username}
+	FindByUsername(ctx context.Context, username string) (*LoginLockout, error)
+
+	// @default SELECT * FROM <tablename type="LoginLockout" /> WHERE locked_at IS NOT NULL
+	//   <if test="isNotZero(expiredAt)"> AND locked_at &gt; #{expiredAt} </if>
+	ListLocked(ctx context.Context, expiredAt time.Time) ([]LoginLockout, error)
+}
+
+var NewLoginLockoutDaoHook func(ref gobatis.SqlSession) LoginLockoutDao
+
+func NewLoginLockoutDaoWith(ref gobatis.SqlSession) LoginLockoutDao {
+	if NewLoginLockoutDaoHook != nil {
+		return NewLoginLockoutDaoHook(ref)
+	}
+	return NewLoginLockoutDao(ref)
+}
+
+func (l *LoginLockout) ToLockedUser() booclient.LockedUser {
+	return booclient.LockedUser{
+		Username:  l.Username,
+		Address:   l.Address,
+		CreatedAt: l.LockedAt.Time,
+		UpdatedAt: l.UpdatedAt,
+	}
+}
+
+// RevokedToken 是已吊销的 jwt， ID 为 token 的 jti
+type RevokedToken struct {
+	TableName struct{}     `json:"-" xorm:"boo_revoked_tokens"`
+	ID        string       `json:"id" xorm:"id pk"`
+	ExpiresAt sql.NullTime `json:"expires_at" xorm:"expires_at null"`
+	CreatedAt time.Time    `json:"created_at" xorm:"created_at"`
+}
+
+// @gobatis.namespace boo
+type RevokedTokenDao interface {
+	// Revoke 返回插入的行数，为 0 时表示 id 已经被吊销过了
+	//
+	// @type update
+	// @default INSERT INTO <tablename type="RevokedToken" /> (id, expires_at, created_at)
+	//   VALUES(#{id}, #{expiresAt}, #{now})
+	//   ON CONFLICT (id) DO NOTHING
+	// @mysql INSERT IGNORE INTO <tablename type="RevokedToken" /> (id, expires_at, created_at)
+	//   VALUES(#{id}, #{expiresAt}, #{now})
+	// @dm MERGE INTO <tablename type="RevokedToken" /> t USING dual ON (t.id = #{id})
+	//   WHEN NOT MATCHED THEN INSERT (id, expires_at, created_at) VALUES(#{id}, #{expiresAt}, #{now})
+	Revoke(ctx context.Context, id string, expiresAt sql.NullTime, now time.Time) (int64, error)
+
+	// @type select
+	// @postgres SELECT true FROM <tablename type="RevokedToken" /> WHERE id = #{id} LIMIT 1
+	// @default SELECT 1 FROM <tablename type="RevokedToken" /> WHERE id = #{id} LIMIT 1
+	IsRevoked(ctx context.Context, id string) (bool, error)
+
+	// @default DELETE FROM <tablename type="RevokedToken" /> WHERE expires_at IS NOT NULL AND expires_at &lt; #{now}
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+var NewRevokedTokenDaoHook func(ref gobatis.SqlSession) RevokedTokenDao
+
+func NewRevokedTokenDaoWith(ref gobatis.SqlSession) RevokedTokenDao {
+	if NewRevokedTokenDaoHook != nil {
+		return NewRevokedTokenDaoHook(ref)
+	}
+	return NewRevokedTokenDao(ref)
+}
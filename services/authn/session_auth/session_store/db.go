@@ -0,0 +1,279 @@
+package session_store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn/session_auth"
+	gobatis "github.com/runner-mei/GoBatis"
+)
+
+const (
+	// CfgSessionStore 在线会话的存储方式， 可选值为 inmem 和 db， 缺省为 inmem
+	CfgSessionStore = "sessions.store"
+
+	CfgSessionDbExpires       = "sessions.db.expires"
+	CfgSessionDbCheckInterval = "sessions.db.check_interval"
+)
+
+// Create 按配置创建在线会话的存储
+func Create(env *booclient.Environment, factory *gobatis.SessionFactory) session_auth.Onlines {
+	switch env.Config.StringWithDefault(CfgSessionStore, "inmem") {
+	case "db", "database":
+		return CreateDb(env, factory)
+	case "none", "empty":
+		return CreateEmpty(env)
+	default:
+		return CreateInmem(env)
+	}
+}
+
+// CreateDb 创建一个保存在数据库中的在线会话存储，多个实例共享同一个数据库时，
+// 它们可以看到彼此的会话，在任一个实例上注销会话对所有实例都生效
+func CreateDb(env *booclient.Environment, factory *gobatis.SessionFactory) session_auth.Onlines {
+	return &DbSessions{
+		apiKey:        env.Config.StringWithDefault(CfgSessionRemoteApiKey, ""),
+		expires:       time.Duration(env.Config.Int64WithDefault(CfgSessionDbExpires, 0)) * time.Second,
+		checkInterval: time.Duration(env.Config.Int64WithDefault(CfgSessionDbCheckInterval, 60)) * time.Second,
+		db:            factory,
+		dao:           NewOnlineSessionDaoWith(factory.SessionReference()),
+	}
+}
+
+var _ session_auth.OnlineStore = &DbSessions{}
+
+type DbSessions struct {
+	apiKey        string
+	expires       time.Duration
+	checkInterval time.Duration
+	lastCheckAt   int64
+	db            *gobatis.SessionFactory
+	dao           OnlineSessionDao
+}
+
+// Load 会话直接保存在数据库中，不需要加载
+func (mgr *DbSessions) Load(context.Context) error {
+	return nil
+}
+
+// Store 会话直接保存在数据库中，不需要保存
+func (mgr *DbSessions) Store(context.Context) error {
+	return nil
+}
+
+// expiredAt 返回过期的时间点，在它之前更新的会话都已过期，不会过期时返回零值
+func (mgr *DbSessions) expiredAt(now time.Time) time.Time {
+	if mgr.expires <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-mgr.expires)
+}
+
+// sweep 清除过期的会话，它最多每隔 checkInterval 执行一次，查询时已经
+// 过滤了过期的会话，所以清除失败时也不会影响结果
+func (mgr *DbSessions) sweep(ctx context.Context) {
+	if mgr.expires <= 0 {
+		return
+	}
+
+	now := time.Now()
+	last := atomic.LoadInt64(&mgr.lastCheckAt)
+	if now.UnixNano()-last < int64(mgr.checkInterval) {
+		return
+	}
+	if !atomic.CompareAndSwapInt64(&mgr.lastCheckAt, last, now.UnixNano()) {
+		return
+	}
+	_ = mgr.DeleteExpired(ctx)
+}
+
+func (mgr *DbSessions) Count(ctx context.Context) (int64, error) {
+	mgr.sweep(ctx)
+
+	return mgr.dao.Count(ctx, mgr.expiredAt(time.Now()))
+}
+
+func (mgr *DbSessions) UpdateNow(ctx context.Context, uuid, apiKey string) error {
+	if apiKey != mgr.apiKey {
+		return errors.New("session api key is invalid")
+	}
+
+	s, err := mgr.dao.FindByUUID(ctx, uuid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return session_auth.ErrSessionNotExists
+		}
+		return err
+	}
+
+	now := time.Now()
+	if mgr.expires > 0 && now.Sub(s.UpdatedAt) > mgr.expires {
+		if err := mgr.dao.DeleteByUUID(ctx, uuid); err != nil {
+			return err
+		}
+		return session_auth.ErrSessionExpired
+	}
+
+	rowsAffected, err := mgr.dao.UpdateNow(ctx, uuid, now)
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		// 在查询和更新之间，会话在其它的实例上被注销了
+		return session_auth.ErrSessionNotExists
+	}
+	return nil
+}
+
+func (mgr *DbSessions) GetBySessionID(ctx context.Context, uuid string) (*booclient.OnlineInfo, error) {
+	s, err := mgr.dao.FindByUUID(ctx, uuid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if mgr.expires > 0 && time.Since(s.UpdatedAt) > mgr.expires {
+		return nil, nil
+	}
+	info := s.ToOnlineInfo()
+	return &info, nil
+}
+
+func (mgr *DbSessions) List(ctx context.Context) ([]booclient.OnlineInfo, error) {
+	mgr.sweep(ctx)
+
+	list, err := mgr.dao.List(ctx, mgr.expiredAt(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	var results = make([]booclient.OnlineInfo, 0, len(list))
+	for idx := range list {
+		results = append(results, list[idx].ToOnlineInfo())
+	}
+	return results, nil
+}
+
+func (mgr *DbSessions) QueryByUsername(ctx context.Context, username string) ([]booclient.OnlineInfo, error) {
+	list, err := mgr.dao.QueryByUsername(ctx, username, mgr.expiredAt(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	var results = make([]booclient.OnlineInfo, 0, len(list))
+	for idx := range list {
+		results = append(results, list[idx].ToOnlineInfo())
+	}
+	return results, nil
+}
+
+func (mgr *DbSessions) Login(ctx context.Context, username, loginAddress string, metadata *booclient.SessionMetadata, apiKey string) (string, error) {
+	sessionID, _, err := mgr.LoginWithCheck(ctx, username, loginAddress, metadata, apiKey, nil)
+	return sessionID, err
+}
+
+func (mgr *DbSessions) LoginWithCheck(ctx context.Context, username, loginAddress string, metadata *booclient.SessionMetadata, apiKey string, check session_auth.OnlineCheckFunc) (string, []booclient.OnlineInfo, error) {
+	if apiKey != mgr.apiKey {
+		return "", nil, errors.New("session api key is invalid")
+	}
+	mgr.sweep(ctx)
+
+	var sessionID string
+	var evicted []booclient.OnlineInfo
+	err := mgr.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		now := time.Now()
+		list, err := mgr.dao.QueryByUsername(ctx, username, mgr.expiredAt(now))
+		if err != nil {
+			return err
+		}
+
+		if check != nil {
+			var sessions = make([]booclient.OnlineInfo, 0, len(list))
+			for idx := range list {
+				sessions = append(sessions, list[idx].ToOnlineInfo())
+			}
+			evicted, err = check(sessions)
+			if err != nil {
+				return err
+			}
+			for _, s := range evicted {
+				if err := mgr.dao.DeleteByUUID(ctx, s.UUID); err != nil {
+					return err
+				}
+			}
+		}
+
+		uuid, err := generateSessionID()
+		if err != nil {
+			return err
+		}
+		session := &OnlineSession{
+			UUID:      uuid,
+			Username:  username,
+			Address:   loginAddress,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		session.setMetadata(metadata)
+		if err := mgr.dao.Insert(ctx, session); err != nil {
+			return err
+		}
+		sessionID = session.UUID
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return sessionID, evicted, nil
+}
+
+func (mgr *DbSessions) LogoutByUsername(ctx context.Context, username string) error {
+	return mgr.dao.DeleteByUsername(ctx, username)
+}
+
+func (mgr *DbSessions) LogoutBySessionID(ctx context.Context, id string) error {
+	return mgr.dao.DeleteByUUID(ctx, id)
+}
+
+func (mgr *DbSessions) IsOnlineExists(ctx context.Context, username, loginAddress string) error {
+	// 判断用户是不是已经在其它主机上登录
+	list, err := mgr.dao.QueryByUsername(ctx, username, mgr.expiredAt(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	var onlineList = make([]booclient.OnlineInfo, 0, len(list))
+	for idx := range list {
+		if list[idx].Address == loginAddress {
+			return nil
+		}
+		onlineList = append(onlineList, list[idx].ToOnlineInfo())
+	}
+
+	if len(onlineList) > 0 {
+		return &session_auth.ErrOnline{OnlineList: onlineList}
+	}
+	return nil
+}
+
+func (mgr *DbSessions) DeleteExpired(ctx context.Context) error {
+	if mgr.expires <= 0 {
+		return nil
+	}
+	_, err := mgr.dao.DeleteExpired(ctx, mgr.expiredAt(time.Now()))
+	return err
+}
+
+// generateSessionID 生成在线会话的 ID，它也是会话的凭证，所以必须是不可猜测的随机数
+func generateSessionID() (string, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
@@ -16,7 +16,7 @@ type EmptySessions struct{}
 func (sess EmptySessions) Count(ctx context.Context) (int64, error) {
 	return 0, nil
 }
-func (sess EmptySessions) Login(ctx context.Context, username, address, apiKey string) (string, error) {
+func (sess EmptySessions) Login(ctx context.Context, username, address string, metadata *booclient.SessionMetadata, apiKey string) (string, error) {
 	return "", nil
 }
 func (sess EmptySessions) LogoutBySessionID(ctx context.Context, key string) error {
@@ -31,6 +31,12 @@ func (sess EmptySessions) GetBySessionID(ctx context.Context, id string) (*boocl
 func (sess EmptySessions) Query(ctx context.Context, username string) ([]booclient.OnlineInfo, error) {
 	return nil, nil
 }
+func (sess EmptySessions) QueryByUsername(ctx context.Context, username string) ([]booclient.OnlineInfo, error) {
+	return nil, nil
+}
+func (sess EmptySessions) LoginWithCheck(ctx context.Context, username, address string, metadata *booclient.SessionMetadata, apiKey string, check session_auth.OnlineCheckFunc) (string, []booclient.OnlineInfo, error) {
+	return "", nil, nil
+}
 func (sess EmptySessions) List(ctx context.Context) ([]booclient.OnlineInfo, error) {
 	return nil, nil
 }
@@ -12,7 +12,6 @@ import (
 	"time"
 
 	"github.com/boo-admin/boo/booclient"
-	"github.com/boo-admin/boo/goutils/tid"
 	"github.com/boo-admin/boo/services/authn/session_auth"
 )
 
@@ -188,28 +187,62 @@ func (mgr *SessionManager) List(ctx context.Context) ([]booclient.OnlineInfo, er
 	return results, nil
 }
 
-func (mgr *SessionManager) Login(ctx context.Context, username, loginAddress, apiKey string) (string, error) {
+func (mgr *SessionManager) QueryByUsername(ctx context.Context, username string) ([]booclient.OnlineInfo, error) {
+	mgr.mu.RLock()
+	defer mgr.mu.RUnlock()
+
+	mgr.cleanExpiredInReadlock(ctx)
+
+	var results []booclient.OnlineInfo
+	for _, s := range mgr.list {
+		if s.Username == username {
+			results = append(results, s.GetOnlineInfo())
+		}
+	}
+	return results, nil
+}
+
+func (mgr *SessionManager) Login(ctx context.Context, username, loginAddress string, metadata *booclient.SessionMetadata, apiKey string) (string, error) {
+	sessionID, _, err := mgr.LoginWithCheck(ctx, username, loginAddress, metadata, apiKey, nil)
+	return sessionID, err
+}
+
+func (mgr *SessionManager) LoginWithCheck(ctx context.Context, username, loginAddress string, metadata *booclient.SessionMetadata, apiKey string, check session_auth.OnlineCheckFunc) (string, []booclient.OnlineInfo, error) {
 	if apiKey != mgr.apiKey {
-		return "", errors.New("session api key is invalid")
+		return "", nil, errors.New("session api key is invalid")
 	}
 	mgr.mu.Lock()
 	defer mgr.mu.Unlock()
 
-	var old *booclient.OnlineInfo
+	var evicted []booclient.OnlineInfo
+	if check != nil {
+		now := time.Now()
+		var sessions []booclient.OnlineInfo
+		for _, s := range mgr.list {
+			if s.Username != username {
+				continue
+			}
+			if mgr.expires > 0 && now.Sub(s.GetUpdatedAt()) > mgr.expires {
+				continue
+			}
+			sessions = append(sessions, s.GetOnlineInfo())
+		}
 
-	for _, s := range mgr.list {
-		if s.Username == username && s.Address == loginAddress {
-			o := s.GetOnlineInfo()
-			old = &o
-			break
+		var err error
+		evicted, err = check(sessions)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, s := range evicted {
+			delete(mgr.list, s.UUID)
 		}
-	}
-	if old != nil {
-		return old.UUID, nil
 	}
 
-	uuid := tid.GenerateID()
-	mgr.list[uuid] = &onlineInfo{
+	uuid, err := generateSessionID()
+	if err != nil {
+		return "", nil, err
+	}
+	info := &onlineInfo{
 		OnlineInfo: booclient.OnlineInfo{
 			UUID:      uuid,
 			Username:  username,
@@ -219,7 +252,11 @@ func (mgr *SessionManager) Login(ctx context.Context, username, loginAddress, ap
 		},
 		t: time.Now().UnixNano(),
 	}
-	return uuid, nil
+	if metadata != nil {
+		info.SessionMetadata = *metadata
+	}
+	mgr.list[uuid] = info
+	return uuid, evicted, nil
 }
 
 func (mgr *SessionManager) LogoutByUsername(ctx context.Context, username string) error {
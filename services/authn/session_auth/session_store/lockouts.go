@@ -0,0 +1,231 @@
+package session_store
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/services/authn/session_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+	gobatis "github.com/runner-mei/GoBatis"
+	"golang.org/x/exp/slog"
+)
+
+// CfgUserLockDuration 用户被锁定的时长，超过这个时长后自动解锁，为 0 时只能手动解锁
+const CfgUserLockDuration = "users.lock_duration"
+
+// CreateDbLockouts 创建一个保存在数据库中的登录失败计数和用户锁定的存储，
+// 它在重启后和多个实例之间都是一致的， oplogger 为 nil 时解锁用户不记录操作日志
+func CreateDbLockouts(env *booclient.Environment, factory *gobatis.SessionFactory, oplogger session_auth.OperationLogger) *DbLockouts {
+	return &DbLockouts{
+		logger:   env.Logger.WithGroup("lockouts"),
+		oplogger: oplogger,
+		duration: env.Config.DurationWithDefault(CfgUserLockDuration, 30*time.Minute),
+		dao:      NewLoginLockoutDaoWith(factory.SessionReference()),
+	}
+}
+
+var _ session_auth.Lockouts = &DbLockouts{}
+
+type DbLockouts struct {
+	logger     *slog.Logger
+	oplogger   session_auth.OperationLogger
+	duration   time.Duration
+	dao        LoginLockoutDao
+	userExists func(ctx context.Context, username string) (bool, error)
+}
+
+// SetUserExists 设置判断用户是否存在的函数，设置后只记录已存在的用户的登录失败次数，
+// 避免用不存在的用户名不断登录时往表中写入无用的记录
+func (lo *DbLockouts) SetUserExists(userExists func(ctx context.Context, username string) (bool, error)) {
+	lo.userExists = userExists
+}
+
+// expiredAt 返回锁定过期的时间点，在它之前锁定的用户都已自动解锁，不会过期时返回零值
+func (lo *DbLockouts) expiredAt(now time.Time) time.Time {
+	if lo.duration <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-lo.duration)
+}
+
+func (lo *DbLockouts) isExpired(l *LoginLockout, now time.Time) bool {
+	return lo.duration > 0 && now.Sub(l.LockedAt.Time) > lo.duration
+}
+
+// Users 实现 session_core.FailCounter
+func (lo *DbLockouts) Users() []string {
+	ctx := context.Background()
+	usernames, err := lo.dao.QueryFailUsernames(ctx)
+	if err != nil {
+		lo.logger.WarnContext(ctx, "查询登录失败的用户失败", slog.Any("error", err))
+		return nil
+	}
+	return usernames
+}
+
+// Fail 实现 session_core.FailCounter
+func (lo *DbLockouts) Fail(username string) {
+	ctx := context.Background()
+	if lo.userExists != nil {
+		exists, err := lo.userExists(ctx, username)
+		if err != nil {
+			lo.logger.WarnContext(ctx, "查询用户是否存在失败", slog.String("username", username), slog.Any("error", err))
+			return
+		}
+		if !exists {
+			return
+		}
+	}
+	err := lo.dao.IncFailCount(ctx, username, time.Now())
+	if err != nil {
+		lo.logger.WarnContext(ctx, "记录用户登录失败的次数失败", slog.String("username", username), slog.Any("error", err))
+	}
+}
+
+// Count 实现 session_core.FailCounter
+func (lo *DbLockouts) Count(username string) int {
+	ctx := context.Background()
+	l, err := lo.dao.FindByUsername(ctx, username)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			lo.logger.WarnContext(ctx, "查询用户登录失败的次数失败", slog.String("username", username), slog.Any("error", err))
+		}
+		return 0
+	}
+	return l.FailCount
+}
+
+// Zero 实现 session_core.FailCounter
+func (lo *DbLockouts) Zero(username string) {
+	ctx := context.Background()
+	err := lo.dao.ResetFailCount(ctx, username)
+	if err != nil {
+		lo.logger.WarnContext(ctx, "清除用户登录失败的次数失败", slog.String("username", username), slog.Any("error", err))
+	}
+}
+
+// Lock 实现 session_core.Locker
+func (lo *DbLockouts) Lock(ctx *session_core.AuthContext) error {
+	return lo.dao.Lock(ctx.Ctx, ctx.Request.Username, ctx.Request.Address, time.Now())
+}
+
+// IsLocked 实现 session_core.IsLocker， 锁定已过期时会自动解锁
+func (lo *DbLockouts) IsLocked(ctx *session_core.AuthContext) error {
+	l, err := lo.dao.FindByUsername(ctx.Ctx, ctx.Request.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return errors.Wrap(err, "查询用户 '"+ctx.Request.Username+"' 是否被锁定失败")
+	}
+	if !l.LockedAt.Valid {
+		return nil
+	}
+	if lo.isExpired(l, time.Now()) {
+		if _, err := lo.dao.Unlock(ctx.Ctx, l.Username); err != nil {
+			return errors.Wrap(err, "自动解锁用户 '"+l.Username+"' 失败")
+		}
+		ctx.Logger.InfoContext(ctx.Ctx, "用户的锁定已过期，自动解锁")
+		return nil
+	}
+	return session_core.ErrUserLocked
+}
+
+func (lo *DbLockouts) List(ctx context.Context) ([]booclient.LockedUser, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpViewUser); err != nil {
+		return nil, errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else if !ok {
+		return nil, errors.NewOperationReject(authn.OpViewUser)
+	}
+
+	list, err := lo.dao.ListLocked(ctx, lo.expiredAt(time.Now()))
+	if err != nil {
+		return nil, errors.Wrap(err, "查询被锁定的用户失败")
+	}
+	results := make([]booclient.LockedUser, 0, len(list))
+	for idx := range list {
+		results = append(results, list[idx].ToLockedUser())
+	}
+	return results, nil
+}
+
+func (lo *DbLockouts) FindByID(ctx context.Context, username string) (*booclient.LockedUser, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if currentUser.Name() != username {
+		if ok, err := currentUser.HasPermission(ctx, authn.OpViewUser); err != nil {
+			return nil, errors.Wrap(err, "判断当前用户是否有权限失败")
+		} else if !ok {
+			return nil, errors.NewOperationReject(authn.OpViewUser)
+		}
+	}
+
+	l, err := lo.dao.FindByUsername(ctx, username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.WithCode(errors.New("用户 '"+username+"' 没有被锁定"), http.StatusNotFound)
+		}
+		return nil, errors.Wrap(err, "查询用户 '"+username+"' 是否被锁定失败")
+	}
+	if !l.LockedAt.Valid || lo.isExpired(l, time.Now()) {
+		return nil, errors.WithCode(errors.New("用户 '"+username+"' 没有被锁定"), http.StatusNotFound)
+	}
+	u := l.ToLockedUser()
+	return &u, nil
+}
+
+func (lo *DbLockouts) Unlock(ctx context.Context, username string) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpUnlockUser); err != nil {
+		return errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpUnlockUser)
+	}
+
+	if _, err := lo.dao.Unlock(ctx, username); err != nil {
+		return errors.Wrap(err, "解锁用户 '"+username+"' 失败")
+	}
+	lo.logger.InfoContext(ctx, "解锁用户成功", slog.String("username", username), slog.String("operator", currentUser.Name()))
+	lo.logUnlock(ctx, currentUser, username)
+	return nil
+}
+
+func (lo *DbLockouts) logUnlock(ctx context.Context, currentUser authn.AuthUser, username string) {
+	if lo.oplogger == nil {
+		return
+	}
+	err := lo.oplogger.LogRecord(ctx, &booclient.OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Nickname(),
+		Successful: true,
+		Type:       authn.OpUnlockUser,
+		Content:    "解锁用户 '" + username + "' 成功",
+		Fields: &booclient.OperationLogRecord{
+			ObjectType: "user",
+			Records: []booclient.ChangeRecord{
+				{
+					Name:        "username",
+					DisplayName: "用户名",
+					OldValue:    username,
+				},
+			},
+		},
+	})
+	if err != nil {
+		lo.logger.WarnContext(ctx, "记录操作日志失败", slog.Any("error", err))
+	}
+}
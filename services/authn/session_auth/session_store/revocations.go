@@ -0,0 +1,76 @@
+package session_store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn/jwt_auth"
+	gobatis "github.com/runner-mei/GoBatis"
+)
+
+// CreateRevocations 按在线会话的存储方式创建 jwt 的吊销列表，会话保存在数据库中时
+// 吊销列表也保存在数据库中，这样多个实例可以共享它
+func CreateRevocations(env *booclient.Environment, factory *gobatis.SessionFactory) jwt_auth.Revocations {
+	switch env.Config.StringWithDefault(CfgSessionStore, "inmem") {
+	case "db", "database":
+		return CreateDbRevocations(env, factory)
+	default:
+		return jwt_auth.NewInmemRevocations()
+	}
+}
+
+func CreateDbRevocations(env *booclient.Environment, factory *gobatis.SessionFactory) *DbRevocations {
+	return &DbRevocations{
+		checkInterval: time.Duration(env.Config.Int64WithDefault(CfgSessionDbCheckInterval, 60)) * time.Second,
+		dao:           NewRevokedTokenDaoWith(factory.SessionReference()),
+	}
+}
+
+var _ jwt_auth.Revocations = &DbRevocations{}
+
+type DbRevocations struct {
+	checkInterval time.Duration
+	lastCheckAt   int64
+	dao           RevokedTokenDao
+}
+
+// sweep 清除已过期的 token，它最多每隔 checkInterval 执行一次
+func (r *DbRevocations) sweep(ctx context.Context) {
+	now := time.Now()
+	last := atomic.LoadInt64(&r.lastCheckAt)
+	if now.UnixNano()-last < int64(r.checkInterval) {
+		return
+	}
+	if !atomic.CompareAndSwapInt64(&r.lastCheckAt, last, now.UnixNano()) {
+		return
+	}
+	_, _ = r.dao.DeleteExpired(ctx, now)
+}
+
+func (r *DbRevocations) Revoke(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	if id == "" {
+		return false, errors.New("token id is empty")
+	}
+	r.sweep(ctx)
+
+	n, err := r.dao.Revoke(ctx, id, sql.NullTime{Time: expiresAt, Valid: !expiresAt.IsZero()}, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *DbRevocations) IsRevoked(ctx context.Context, id string) (bool, error) {
+	revoked, err := r.dao.IsRevoked(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return revoked, nil
+}
@@ -0,0 +1,167 @@
+package session_auth
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn"
+	"golang.org/x/exp/slog"
+)
+
+// NewSessions 创建面向用户的在线会话接口，它在 Onlines 之上增加了权限检查，
+// 并在注销会话时记录操作日志， oplogger 为 nil 时不记录操作日志
+func NewSessions(logger *slog.Logger, onlines Onlines, oplogger OperationLogger) booclient.Sessions {
+	return &sessionService{
+		logger:   logger,
+		onlines:  onlines,
+		oplogger: oplogger,
+	}
+}
+
+type sessionService struct {
+	logger   *slog.Logger
+	onlines  Onlines
+	oplogger OperationLogger
+}
+
+func (svc *sessionService) checkPermission(ctx context.Context, currentUser authn.AuthUser, permissionID string) error {
+	if ok, err := currentUser.HasPermission(ctx, permissionID); err != nil {
+		return errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(permissionID)
+	}
+	return nil
+}
+
+// list 返回指定用户的会话， username 为空时返回所有的会话，最近登录的会话排在前面
+func (svc *sessionService) list(ctx context.Context, username string) ([]booclient.OnlineInfo, error) {
+	var list []booclient.OnlineInfo
+	var err error
+	if username != "" {
+		list, err = svc.onlines.QueryByUsername(ctx, username)
+	} else {
+		list, err = svc.onlines.List(ctx)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "查询在线会话失败")
+	}
+
+	current := SessionIDFromContext(ctx)
+	results := make([]booclient.OnlineInfo, 0, len(list))
+	for _, s := range list {
+		s.Current = current != "" && s.UUID == current
+		results = append(results, s)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+	return results, nil
+}
+
+func (svc *sessionService) ListMine(ctx context.Context) ([]booclient.OnlineInfo, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return svc.list(ctx, currentUser.Name())
+}
+
+func (svc *sessionService) RevokeMine(ctx context.Context, uuid string) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return svc.revoke(ctx, currentUser, uuid, currentUser.Name())
+}
+
+func (svc *sessionService) List(ctx context.Context, username string) ([]booclient.OnlineInfo, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := svc.checkPermission(ctx, currentUser, authn.OpViewOnlineUser); err != nil {
+		return nil, err
+	}
+	return svc.list(ctx, username)
+}
+
+func (svc *sessionService) Revoke(ctx context.Context, uuid string) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if err := svc.checkPermission(ctx, currentUser, authn.OpLogoutOnlineUser); err != nil {
+		return err
+	}
+	return svc.revoke(ctx, currentUser, uuid, "")
+}
+
+// revoke 注销会话， owner 不为空时会话必须属于这个用户
+func (svc *sessionService) revoke(ctx context.Context, currentUser authn.AuthUser, uuid, owner string) error {
+	info, err := svc.onlines.GetBySessionID(ctx, uuid)
+	if err != nil {
+		return errors.Wrap(err, "查询会话 '"+uuid+"' 失败")
+	}
+	// 不是自已的会话时也返回不存在，避免泄露其它用户的会话
+	if info == nil || (owner != "" && info.Username != owner) {
+		return errors.WithCode(errors.New("会话 '"+uuid+"' 不存在"), http.StatusNotFound)
+	}
+
+	if err := svc.onlines.LogoutBySessionID(ctx, uuid); err != nil {
+		return errors.Wrap(err, "注销会话 '"+uuid+"' 失败")
+	}
+	svc.logger.InfoContext(ctx, "注销会话成功",
+		slog.String("session_id", uuid),
+		slog.String("username", info.Username),
+		slog.String("operator", currentUser.Name()))
+
+	typ, content := "logoutsession", "注销用户 '"+info.Username+"' 在 "+info.Address+" 上的会话成功"
+	if owner != "" {
+		typ, content = "revokesession", "注销自已在 "+info.Address+" 上的会话成功"
+	}
+	svc.logRevoke(ctx, currentUser, typ, content, info)
+	return nil
+}
+
+func (svc *sessionService) logRevoke(ctx context.Context, currentUser authn.AuthUser, typ, content string, info *booclient.OnlineInfo) {
+	if svc.oplogger == nil {
+		return
+	}
+	records := []booclient.ChangeRecord{
+		{
+			Name:        "username",
+			DisplayName: "用户名",
+			OldValue:    info.Username,
+		},
+		{
+			Name:        "address",
+			DisplayName: "登录地址",
+			OldValue:    info.Address,
+		},
+	}
+	if info.UserAgent != "" {
+		records = append(records, booclient.ChangeRecord{
+			Name:        "user_agent",
+			DisplayName: "客户端",
+			OldValue:    info.UserAgent,
+		})
+	}
+
+	err := svc.oplogger.LogRecord(ctx, &booclient.OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Nickname(),
+		Successful: true,
+		Type:       typ,
+		Content:    content,
+		Fields: &booclient.OperationLogRecord{
+			ObjectType: "session",
+			Records:    records,
+		},
+	})
+	if err != nil {
+		svc.logger.WarnContext(ctx, "记录操作日志失败", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,141 @@
+package session_auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+	"golang.org/x/exp/slog"
+)
+
+type testUser struct {
+	authn.AuthUser
+
+	name        string
+	permissions []string
+}
+
+func (u *testUser) ID() int64        { return 1 }
+func (u *testUser) Name() string     { return u.name }
+func (u *testUser) Nickname() string { return u.name }
+func (u *testUser) HasPermission(ctx context.Context, permissionID string) (bool, error) {
+	for _, p := range u.permissions {
+		if p == permissionID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type testOperationLogger struct {
+	logs []*booclient.OperationLog
+}
+
+func (l *testOperationLogger) LogRecord(ctx context.Context, ol *booclient.OperationLog) error {
+	l.logs = append(l.logs, ol)
+	return nil
+}
+
+func TestSessions(t *testing.T) {
+	now := time.Now()
+	onlines := &testOnlines{list: []booclient.OnlineInfo{
+		{UUID: "s1", Username: "tom", Address: "192.168.1.1", CreatedAt: now.Add(-2 * time.Hour)},
+		{UUID: "s2", Username: "tom", Address: "192.168.1.2", CreatedAt: now.Add(-1 * time.Hour),
+			SessionMetadata: booclient.SessionMetadata{UserAgent: "Mozilla/5.0", LoginType: "jwt"}},
+		{UUID: "j1", Username: "jerry", Address: "192.168.1.9", CreatedAt: now},
+	}}
+	oplogger := &testOperationLogger{}
+	sessions := NewSessions(slog.Default(), onlines, oplogger)
+
+	tom := &testUser{name: "tom"}
+	ctx := ContextWithSessionID(authn.ContextWithUser(context.Background(), tom), "s1")
+
+	list, err := sessions.ListMine(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].UUID != "s2" || list[1].UUID != "s1" {
+		t.Fatalf("want [s2 s1] got %#v", list)
+	}
+	if list[0].Current || !list[1].Current {
+		t.Error("want s1 is current session")
+	}
+	if list[0].UserAgent != "Mozilla/5.0" || list[0].LoginType != "jwt" {
+		t.Errorf("metadata is invalid: %#v", list[0].SessionMetadata)
+	}
+
+	// 不能注销其它用户的会话，也不能使用管理接口
+	if err := sessions.RevokeMine(ctx, "j1"); err == nil {
+		t.Error("want error got ok")
+	}
+	if _, err := sessions.List(ctx, "jerry"); err == nil {
+		t.Error("want error got ok")
+	}
+	if err := sessions.Revoke(ctx, "j1"); err == nil {
+		t.Error("want error got ok")
+	}
+
+	if err := sessions.RevokeMine(ctx, "s2"); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := onlines.GetBySessionID(ctx, "s2"); info != nil {
+		t.Error("want s2 is removed")
+	}
+	if len(oplogger.logs) != 1 || oplogger.logs[0].Type != "revokesession" {
+		t.Errorf("oplog is invalid: %#v", oplogger.logs)
+	}
+
+	admin := &testUser{name: "admin", permissions: []string{authn.OpViewOnlineUser, authn.OpLogoutOnlineUser}}
+	ctx = authn.ContextWithUser(context.Background(), admin)
+	list, err = sessions.List(ctx, "jerry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].UUID != "j1" {
+		t.Errorf("want [j1] got %#v", list)
+	}
+	if list, _ := sessions.List(ctx, ""); len(list) != 2 {
+		t.Error("want 2 got", len(list))
+	}
+	if err := sessions.Revoke(ctx, "j1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(oplogger.logs) != 2 || oplogger.logs[1].Type != "logoutsession" {
+		t.Errorf("oplog is invalid: %#v", oplogger.logs)
+	}
+	if err := sessions.Revoke(ctx, "j1"); err == nil {
+		t.Error("want error got ok")
+	}
+}
+
+func TestNewSessionMetadata(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://127.0.0.1/login", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Sec-CH-UA-Platform", `"Windows"`)
+	req.Header.Set("Sec-CH-UA-Mobile", "?0")
+
+	metadata := NewSessionMetadata(req, &session_core.AuthContext{})
+	if metadata.UserAgent != "Mozilla/5.0" || metadata.LoginType != "session" {
+		t.Errorf("metadata is invalid: %#v", metadata)
+	}
+	if len(metadata.ClientHints) != 2 || metadata.ClientHints["sec-ch-ua-platform"] != `"Windows"` {
+		t.Errorf("client hints is invalid: %#v", metadata.ClientHints)
+	}
+
+	metadata = NewSessionMetadata(req, &session_core.AuthContext{
+		Request: session_core.LoginRequest{LoginType: session_core.TokenJWT},
+	})
+	if metadata.LoginType != "jwt" {
+		t.Error("want jwt got", metadata.LoginType)
+	}
+	metadata = NewSessionMetadata(req, &session_core.AuthContext{
+		Identity: &session_core.ExternalIdentity{Source: "oidc"},
+	})
+	if metadata.LoginType != "oidc" {
+		t.Error("want oidc got", metadata.LoginType)
+	}
+}
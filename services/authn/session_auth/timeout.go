@@ -0,0 +1,178 @@
+package session_auth
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+)
+
+const (
+	// CfgUserSessionIdleTimeout 会话在没有活动多长时间后失效，可以在后面加上 ".角色名" 为角色单独配置
+	CfgUserSessionIdleTimeout = "users.session.idle_timeout"
+	// CfgUserSessionAbsoluteTimeout 会话从登录开始最长的有效时间，可以在后面加上 ".角色名" 为角色单独配置
+	CfgUserSessionAbsoluteTimeout = "users.session.absolute_timeout"
+	// CfgUserSessionRenewInterval 会话有活动时至少间隔多长时间才续期 cookie, 缺省为空闲超时的四分之一
+	CfgUserSessionRenewInterval = "users.session.renew_interval"
+)
+
+const (
+	SESSION_ISSUED_KEY   = "issued_at"
+	SESSION_IDLE_KEY     = "_idle"
+	SESSION_DEADLINE_KEY = "_deadline"
+)
+
+// Timeouts 是会话的超时配置，它在登录时按用户的角色计算出会话的空闲超时和最长有效时间，
+// 并写在 cookie 中， cookie 是签名或加密的，所以客户端不能修改它们，
+// 角色配置修改后要在用户重新登录后才生效
+type Timeouts struct {
+	Idle          time.Duration
+	Absolute      time.Duration
+	RoleIdle      map[string]time.Duration
+	RoleAbsolute  map[string]time.Duration
+	RenewInterval time.Duration
+}
+
+// NewTimeouts 从配置中读取会话的超时配置，没有配置超时时返回 nil
+func NewTimeouts(env *booclient.Environment) *Timeouts {
+	t := &Timeouts{
+		Idle:         env.Config.DurationWithDefault(CfgUserSessionIdleTimeout, 0),
+		Absolute:     env.Config.DurationWithDefault(CfgUserSessionAbsoluteTimeout, 0),
+		RoleIdle:     readRoleDurations(env, CfgUserSessionIdleTimeout),
+		RoleAbsolute: readRoleDurations(env, CfgUserSessionAbsoluteTimeout),
+	}
+	if t.Idle <= 0 && t.Absolute <= 0 && len(t.RoleIdle) == 0 && len(t.RoleAbsolute) == 0 {
+		return nil
+	}
+	t.RenewInterval = env.Config.DurationWithDefault(CfgUserSessionRenewInterval, 0)
+	return t
+}
+
+func readRoleDurations(env *booclient.Environment, key string) map[string]time.Duration {
+	var results map[string]time.Duration
+	env.Config.ForEachWithPrefix(key+".", func(k string, value interface{}) {
+		role := strings.TrimPrefix(k, key+".")
+		d := env.Config.DurationWithDefault(k, 0)
+		if role == "" || d <= 0 {
+			return
+		}
+		if results == nil {
+			results = map[string]time.Duration{}
+		}
+		results[role] = d
+	})
+	return results
+}
+
+func minDuration(roles []string, byRole map[string]time.Duration, defaultValue time.Duration) time.Duration {
+	var found time.Duration
+	for _, role := range roles {
+		if d, ok := byRole[role]; ok && (found == 0 || d < found) {
+			found = d
+		}
+	}
+	if found > 0 {
+		return found
+	}
+	return defaultValue
+}
+
+// For 返回用户的空闲超时和最长有效时间，用户有多个角色单独配置了超时时取最小的，
+// 都没有配置时用全局的配置， 0 表示不限制
+func (t *Timeouts) For(roles []string) (idle, absolute time.Duration) {
+	return minDuration(roles, t.RoleIdle, t.Idle), minDuration(roles, t.RoleAbsolute, t.Absolute)
+}
+
+// Apply 在登录时将超时写到 cookie 的内容中，空闲超时为 0 时也会写上，
+// 用于和启用超时配置之前签发的 cookie 区分开
+func (t *Timeouts) Apply(values url.Values, roles []string, now time.Time) {
+	idle, absolute := t.For(roles)
+	values.Set(SESSION_IDLE_KEY, strconv.FormatInt(int64(idle/time.Second), 10))
+	if absolute > 0 {
+		values.Set(SESSION_DEADLINE_KEY, strconv.FormatInt(now.Add(absolute).Unix(), 10))
+	}
+	if expireAt := nextExpiration(values, now); !expireAt.IsZero() {
+		values.Set(SESSION_EXPIRE_KEY, GetExpiration(expireAt))
+	}
+}
+
+// legacyLimit 返回启用超时配置之前签发的 cookie 的最长有效时间，这种 cookie 中没有角色，
+// 所以取所有配置中最严格的最长有效时间，没有配置最长有效时间时取最严格的空闲超时
+func (t *Timeouts) legacyLimit() time.Duration {
+	limit := minPositive(t.Absolute, t.RoleAbsolute)
+	if limit <= 0 {
+		limit = minPositive(t.Idle, t.RoleIdle)
+	}
+	return limit
+}
+
+func minPositive(value time.Duration, byRole map[string]time.Duration) time.Duration {
+	for _, d := range byRole {
+		if d > 0 && (value <= 0 || d < value) {
+			value = d
+		}
+	}
+	return value
+}
+
+// CheckLegacy 检查启用超时配置之前签发的 cookie, 它们没有空闲超时和最长有效时间，
+// 过期时间也是 "session"，所以只能按签发时间判断它是否已超过最长有效时间，
+// 没有签发时间时直接拒绝
+func (t *Timeouts) CheckLegacy(values url.Values, now time.Time) error {
+	if _, ok := values[SESSION_IDLE_KEY]; ok {
+		return nil
+	}
+	issuedAt, err := time.Parse(time.RFC3339, values.Get(SESSION_ISSUED_KEY))
+	if err != nil {
+		return ErrSessionExpiredOrMissing
+	}
+	if limit := t.legacyLimit(); limit > 0 && now.Sub(issuedAt) > limit {
+		return ErrSessionExpiredOrMissing
+	}
+	return nil
+}
+
+// nextExpiration 返回在 now 有活动时会话新的过期时间，不会过期时返回零值
+func nextExpiration(values url.Values, now time.Time) time.Time {
+	var expireAt time.Time
+	if idle, _ := strconv.ParseInt(values.Get(SESSION_IDLE_KEY), 10, 64); idle > 0 {
+		expireAt = now.Add(time.Duration(idle) * time.Second)
+	}
+	if deadline, _ := strconv.ParseInt(values.Get(SESSION_DEADLINE_KEY), 10, 64); deadline > 0 {
+		if t := time.Unix(deadline, 0); expireAt.IsZero() || t.Before(expireAt) {
+			expireAt = t
+		}
+	}
+	return expireAt
+}
+
+// Renew 会话有活动时延长它的过期时间，不需要续期时返回 false,
+// 为了避免每个请求都重写 cookie, 距离上次续期不到 RenewInterval 时也不续期
+func (t *Timeouts) Renew(values url.Values, now time.Time) bool {
+	idle, _ := strconv.ParseInt(values.Get(SESSION_IDLE_KEY), 10, 64)
+	if idle <= 0 {
+		return false
+	}
+	oldExpireAt, err := strconv.ParseInt(values.Get(SESSION_EXPIRE_KEY), 10, 64)
+	if err != nil {
+		return false
+	}
+
+	interval := t.RenewInterval
+	if interval <= 0 {
+		interval = time.Duration(idle) * time.Second / 4
+	}
+	lastRenewAt := time.Unix(oldExpireAt, 0).Add(-time.Duration(idle) * time.Second)
+	if now.Sub(lastRenewAt) < interval {
+		return false
+	}
+
+	expireAt := nextExpiration(values, now)
+	if expireAt.Unix() <= oldExpireAt {
+		return false
+	}
+	values.Set(SESSION_EXPIRE_KEY, GetExpiration(expireAt))
+	return true
+}
@@ -0,0 +1,40 @@
+package token_auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/boo-admin/boo/services/authn"
+)
+
+const (
+	HeaderAuthorization = "Authorization"
+	HeaderApiKey        = "X-Api-Key"
+)
+
+const scheme = "token"
+
+// TokenFromRequest 从请求中读取访问令牌，依次查找
+//  1. 'Authorization: Token T' 请求头
+//  2. 'X-Api-Key: T' 请求头
+func TokenFromRequest(req *http.Request) string {
+	auth := req.Header.Get(HeaderAuthorization)
+	l := len(scheme)
+	if len(auth) > l+1 && strings.EqualFold(auth[:l], scheme) && auth[l] == ' ' {
+		return strings.TrimSpace(auth[l+1:])
+	}
+	return strings.TrimSpace(req.Header.Get(HeaderApiKey))
+}
+
+// Verify 校验访问令牌，请求中没有访问令牌时返回 authn.ErrTokenNotFound，
+// 让后面的校验函数继续处理
+func Verify(validator func(ctx context.Context, req *http.Request, token string) (context.Context, error)) authn.AuthValidateFunc {
+	return func(ctx context.Context, req *http.Request) (context.Context, error) {
+		token := TokenFromRequest(req)
+		if token == "" {
+			return nil, authn.ErrTokenNotFound
+		}
+		return validator(ctx, req, token)
+	}
+}
@@ -0,0 +1,48 @@
+package token_auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/boo-admin/boo/services/authn"
+)
+
+func TestVerify(t *testing.T) {
+	var got string
+	verify := Verify(func(ctx context.Context, req *http.Request, token string) (context.Context, error) {
+		got = token
+		return ctx, nil
+	})
+
+	for _, test := range []struct {
+		header string
+		value  string
+		token  string
+	}{
+		{header: HeaderAuthorization, value: "Token boo_abc", token: "boo_abc"},
+		{header: HeaderAuthorization, value: "token boo_abc", token: "boo_abc"},
+		{header: HeaderApiKey, value: "boo_abc", token: "boo_abc"},
+		{header: HeaderAuthorization, value: "Bearer boo_abc"},
+		{header: HeaderAuthorization, value: "Tokenboo_abc"},
+		{header: HeaderAuthorization, value: "Token "},
+	} {
+		got = ""
+		req, _ := http.NewRequest("GET", "http://127.0.0.1/", nil)
+		req.Header.Set(test.header, test.value)
+		_, err := verify(context.Background(), req)
+		if test.token == "" {
+			if err != authn.ErrTokenNotFound {
+				t.Error(test.value, ": want ErrTokenNotFound got", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error(test.value, ":", err)
+			continue
+		}
+		if got != test.token {
+			t.Error(test.value, ": want", test.token, "got", got)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Package notify 提供了给用户发送通知的渠道，如邮件和短信。
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// SenderSMTP 用 SMTP 服务器发送邮件
+	SenderSMTP = "smtp"
+	// SenderFile 将消息写到一个文件中，用于测试
+	SenderFile = "file"
+	// SenderLog 将消息写到日志中，用于测试
+	SenderLog = "log"
+)
+
+// Message 是发送给用户的消息， To 是邮箱或手机号
+type Message struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject,omitempty"`
+	Content string    `json:"content"`
+	SentAt  time.Time `json:"sent_at,omitempty"`
+}
+
+// Sender 是发送消息的渠道
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// SMSProvider 是短信服务商的接口，接入新的服务商时实现它并用 RegisterSMSProvider 注册
+type SMSProvider interface {
+	SendSMS(ctx context.Context, mobile, content string) error
+}
+
+var smsProviders = map[string]func(env *booclient.Environment, prefix string) (SMSProvider, error){}
+
+// RegisterSMSProvider 注册一个短信服务商， prefix 为它的配置项的前缀
+func RegisterSMSProvider(name string, create func(env *booclient.Environment, prefix string) (SMSProvider, error)) {
+	smsProviders[name] = create
+}
+
+// NewSMSSender 将 SMSProvider 包装为 Sender，短信没有标题，所以忽略 Message.Subject
+func NewSMSSender(provider SMSProvider) Sender {
+	return &smsSender{provider: provider}
+}
+
+type smsSender struct {
+	provider SMSProvider
+}
+
+func (s *smsSender) Send(ctx context.Context, msg *Message) error {
+	return s.provider.SendSMS(ctx, msg.To, msg.Content)
+}
+
+// NewEmailSenderFromEnv 按配置创建邮件的发送渠道，配置项为 prefix+".sender"，可以为 smtp, file 和 log，
+// 没有配置时返回 nil
+func NewEmailSenderFromEnv(env *booclient.Environment, prefix string) (Sender, error) {
+	typ := env.Config.StringWithDefault(prefix+".sender", "")
+	switch typ {
+	case "":
+		return nil, nil
+	case SenderSMTP:
+		return NewSMTPSenderFromEnv(env, prefix+".smtp")
+	case SenderFile:
+		return NewFileSender(env.Config.StringWithDefault(prefix+".filename", "")), nil
+	case SenderLog:
+		return NewLogSender(env.Logger.WithGroup("email")), nil
+	default:
+		return nil, errors.New("配置 '" + prefix + ".sender' 的值 '" + typ + "' 是无效的")
+	}
+}
+
+// NewSMSSenderFromEnv 按配置创建短信的发送渠道，配置项为 prefix+".sender"，可以为 file, log 或
+// 用 RegisterSMSProvider 注册的服务商，没有配置时返回 nil
+func NewSMSSenderFromEnv(env *booclient.Environment, prefix string) (Sender, error) {
+	typ := env.Config.StringWithDefault(prefix+".sender", "")
+	switch typ {
+	case "":
+		return nil, nil
+	case SenderFile:
+		return NewFileSender(env.Config.StringWithDefault(prefix+".filename", "")), nil
+	case SenderLog:
+		return NewLogSender(env.Logger.WithGroup("sms")), nil
+	}
+	create, ok := smsProviders[typ]
+	if !ok {
+		return nil, errors.New("配置 '" + prefix + ".sender' 的值 '" + typ + "' 是无效的")
+	}
+	provider, err := create(env, prefix+"."+typ)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建短信服务商 '"+typ+"' 失败")
+	}
+	return NewSMSSender(provider), nil
+}
+
+// NewFileSender 创建一个将消息以 json 格式逐行追加到文件中的 Sender，用于测试
+func NewFileSender(filename string) Sender {
+	return &fileSender{filename: filename}
+}
+
+type fileSender struct {
+	mu       sync.Mutex
+	filename string
+}
+
+func (s *fileSender) Send(ctx context.Context, msg *Message) error {
+	if s.filename == "" {
+		return errors.New("没有配置保存消息的文件名")
+	}
+
+	copied := *msg
+	if copied.SentAt.IsZero() {
+		copied.SentAt = time.Now()
+	}
+	bs, err := json.Marshal(&copied)
+	if err != nil {
+		return errors.Wrap(err, "序列化消息失败")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.filename), 0o755); err != nil {
+		return errors.Wrap(err, "创建目录 '"+filepath.Dir(s.filename)+"' 失败")
+	}
+	out, err := os.OpenFile(s.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "打开文件 '"+s.filename+"' 失败")
+	}
+	defer out.Close()
+
+	if _, err := out.Write(append(bs, '\n')); err != nil {
+		return errors.Wrap(err, "写文件 '"+s.filename+"' 失败")
+	}
+	return nil
+}
+
+// ReadFile 读取 NewFileSender 写的文件，用于测试
+func ReadFile(filename string) ([]Message, error) {
+	bs, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var messages []Message
+	decoder := json.NewDecoder(bytes.NewReader(bs))
+	for decoder.More() {
+		var msg Message
+		if err := decoder.Decode(&msg); err != nil {
+			return nil, errors.Wrap(err, "读文件 '"+filename+"' 失败")
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// NewLogSender 创建一个将消息写到日志中的 Sender，用于测试
+func NewLogSender(logger *slog.Logger) Sender {
+	return &logSender{logger: logger}
+}
+
+type logSender struct {
+	logger *slog.Logger
+}
+
+func (s *logSender) Send(ctx context.Context, msg *Message) error {
+	s.logger.InfoContext(ctx, "发送消息",
+		slog.String("to", msg.To),
+		slog.String("subject", msg.Subject),
+		slog.String("content", msg.Content))
+	return nil
+}
@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"context"
+	"encoding/base64"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSender(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "messages", "sms.txt")
+	sender := NewFileSender(filename)
+
+	ctx := context.Background()
+	for _, to := range []string{"13800000001", "13800000002"} {
+		if err := sender.Send(ctx, &Message{To: to, Content: "验证码 123456"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages, err := ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[1].To != "13800000002" || messages[1].Content != "验证码 123456" {
+		t.Errorf("messages is invalid: %#v", messages)
+	}
+	if messages[0].SentAt.IsZero() {
+		t.Error("sent_at is empty")
+	}
+}
+
+type testSMSProvider struct {
+	mobile, content string
+}
+
+func (p *testSMSProvider) SendSMS(ctx context.Context, mobile, content string) error {
+	p.mobile, p.content = mobile, content
+	return nil
+}
+
+func TestSMSSender(t *testing.T) {
+	provider := &testSMSProvider{}
+	err := NewSMSSender(provider).Send(context.Background(), &Message{
+		To:      "13800000001",
+		Subject: "重置密码",
+		Content: "验证码 123456",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.mobile != "13800000001" || provider.content != "验证码 123456" {
+		t.Errorf("sms is invalid: %#v", provider)
+	}
+}
+
+func TestBuildMail(t *testing.T) {
+	from := &mail.Address{Name: "boo", Address: "boo@example.com"}
+	to := &mail.Address{Address: "tom@example.com"}
+	content := strings.Repeat("请点击下面的链接重置密码\n", 5)
+	bs := buildMail(from, to, &Message{Subject: "重置密码", Content: content}, time.Now())
+
+	parts := strings.SplitN(string(bs), "\r\n\r\n", 2)
+	if len(parts) != 2 {
+		t.Fatal("mail is invalid:", string(bs))
+	}
+	if !strings.Contains(parts[0], "Subject: =?utf-8?b?") || !strings.Contains(parts[0], "To: <tom@example.com>") {
+		t.Error("header is invalid:", parts[0])
+	}
+	for _, line := range strings.Split(strings.TrimSpace(parts[1]), "\r\n") {
+		if len(line) > 76 {
+			t.Error("line is too long:", line)
+		}
+	}
+	body, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(parts[1], "\r\n", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != strings.ReplaceAll(content, "\n", "\r\n") {
+		t.Error("body is invalid:", string(body))
+	}
+}
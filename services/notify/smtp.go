@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+)
+
+// SMTPSender 用 SMTP 服务器发送邮件，服务器支持 STARTTLS 时会自动使用它，
+// 端口为 465 或 TLS 为 true 时直接用 TLS 连接
+type SMTPSender struct {
+	Address  string
+	Username string
+	Password string
+	From     string
+	TLS      bool
+	Timeout  time.Duration
+}
+
+// NewSMTPSenderFromEnv 从配置中创建 SMTPSender，配置项为 prefix 加上
+// ".address", ".username", ".password", ".from" 和 ".tls"
+func NewSMTPSenderFromEnv(env *booclient.Environment, prefix string) (*SMTPSender, error) {
+	s := &SMTPSender{
+		Address:  env.Config.StringWithDefault(prefix+".address", ""),
+		Username: env.Config.StringWithDefault(prefix+".username", ""),
+		Password: env.Config.PasswordWithDefault(prefix+".password", ""),
+		From:     env.Config.StringWithDefault(prefix+".from", ""),
+		TLS:      env.Config.BoolWithDefault(prefix+".tls", false),
+		Timeout:  env.Config.DurationWithDefault(prefix+".timeout", 30*time.Second),
+	}
+	if s.Address == "" {
+		return nil, errors.New("配置 '" + prefix + ".address' 不能为空")
+	}
+	if s.From == "" {
+		s.From = s.Username
+	}
+	if _, err := mail.ParseAddress(s.From); err != nil {
+		return nil, errors.Wrap(err, "配置 '"+prefix+".from' 的值 '"+s.From+"' 不是有效的邮箱")
+	}
+	return s, nil
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg *Message) error {
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return errors.Wrap(err, "发件人 '"+s.From+"' 不是有效的邮箱")
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return errors.Wrap(err, "收件人 '"+msg.To+"' 不是有效的邮箱")
+	}
+	host, port, err := net.SplitHostPort(s.Address)
+	if err != nil {
+		return errors.Wrap(err, "邮件服务器的地址 '"+s.Address+"' 格式不正确")
+	}
+
+	deadline := time.Now().Add(s.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+
+	var conn net.Conn
+	if s.TLS || port == "465" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.Address, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.Address)
+	}
+	if err != nil {
+		return errors.Wrap(err, "连接邮件服务器 '"+s.Address+"' 失败")
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "连接邮件服务器 '"+s.Address+"' 失败")
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !s.TLS && port != "465" {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return errors.Wrap(err, "与邮件服务器协商 TLS 失败")
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return errors.Wrap(err, "登录邮件服务器失败")
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return errors.Wrap(err, "设置发件人失败")
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return errors.Wrap(err, "设置收件人 '"+to.Address+"' 失败")
+	}
+	w, err := client.Data()
+	if err != nil {
+		return errors.Wrap(err, "发送邮件失败")
+	}
+	if _, err := w.Write(buildMail(from, to, msg, time.Now())); err != nil {
+		w.Close()
+		return errors.Wrap(err, "发送邮件失败")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "发送邮件失败")
+	}
+	return client.Quit()
+}
+
+// buildMail 生成邮件的内容，标题和正文都用 utf-8 编码
+func buildMail(from, to *mail.Address, msg *Message, now time.Time) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from.String() + "\r\n")
+	buf.WriteString("To: " + to.String() + "\r\n")
+	buf.WriteString("Subject: " + mime.BEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	buf.WriteString("Date: " + now.Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+	buf.WriteString("\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(strings.ReplaceAll(msg.Content, "\n", "\r\n")))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes()
+}
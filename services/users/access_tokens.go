@@ -0,0 +1,388 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/validation"
+	gobatis "github.com/runner-mei/GoBatis"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	accessTokenPrefix       = "boo_"
+	accessTokenPrefixLength = len(accessTokenPrefix) + 8
+
+	// 最后使用时间只用于显示，不需要每次请求都更新数据库
+	accessTokenLastUsedInterval = time.Minute
+)
+
+var NewUserAccessTokenDaoHook func(ref gobatis.SqlSession) UserAccessTokenDao
+
+func NewUserAccessTokenDaoWith(ref gobatis.SqlSession) UserAccessTokenDao {
+	if NewUserAccessTokenDaoHook != nil {
+		return NewUserAccessTokenDaoHook(ref)
+	}
+	return NewUserAccessTokenDao(ref)
+}
+
+// AccessTokens 是访问令牌的服务， Verify 用于在 api 请求中校验令牌
+type AccessTokens interface {
+	booclient.AccessTokens
+
+	// Verify 校验令牌，成功时返回令牌对应的用户，用户的权限受限于令牌的 Scopes
+	Verify(ctx context.Context, token, address string) (authn.AuthUser, error)
+}
+
+func NewAccessTokens(env *booclient.Environment,
+	db *gobatis.SessionFactory,
+	users *UserService,
+	operationLogger OperationLogger) (AccessTokens, error) {
+	return &accessTokenService{
+		logger:          env.Logger.WithGroup("access_tokens"),
+		operationLogger: operationLogger,
+		db:              db,
+		dao:             NewUserAccessTokenDaoWith(db.SessionReference()),
+		users:           users,
+		authUsers:       NewAuthUsers(users),
+	}, nil
+}
+
+type accessTokenService struct {
+	logger          *slog.Logger
+	operationLogger OperationLogger
+	db              *gobatis.SessionFactory
+	dao             UserAccessTokenDao
+	users           *UserService
+	authUsers       *AuthUsers
+}
+
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAccessToken() (string, error) {
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return accessTokenPrefix + base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+func toAccessToken(token *UserAccessToken) booclient.AccessToken {
+	result := booclient.AccessToken{
+		ID:              token.ID,
+		UserID:          token.UserID,
+		Name:            token.Name,
+		Prefix:          token.TokenPrefix,
+		Scopes:          token.Scopes,
+		LastUsedAddress: token.LastUsedAddress,
+		CreatedAt:       token.CreatedAt,
+	}
+	if token.ExpiresAt.Valid {
+		t := token.ExpiresAt.Time
+		result.ExpiresAt = &t
+	}
+	if token.LastUsedAt.Valid {
+		t := token.LastUsedAt.Time
+		result.LastUsedAt = &t
+	}
+	return result
+}
+
+// checkPermission 用户可以管理自已的令牌，管理其它用户的令牌需要有 OpManageAccessToken 权限。
+// 用令牌访问时不能管理令牌，否则一个受限的令牌可以创建出不受限的令牌
+func (svc *accessTokenService) checkPermission(ctx context.Context, currentUser authn.AuthUser, userID int64) error {
+	if _, ok := currentUser.(*accessTokenUser); ok {
+		return errors.WithCode(errors.New("不能用访问令牌管理访问令牌"), http.StatusForbidden)
+	}
+	if currentUser.ID() == userID {
+		return nil
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpManageAccessToken); err != nil {
+		return errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpManageAccessToken)
+	}
+	return nil
+}
+
+func (svc *accessTokenService) findUser(ctx context.Context, userID int64) (*User, error) {
+	user, err := svc.users.userDao.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.WithCode(errors.New("用户 '"+strconv.FormatInt(userID, 10)+"' 不存在"), http.StatusNotFound)
+		}
+		return nil, errors.Wrap(err, "查询用户 '"+strconv.FormatInt(userID, 10)+"' 失败")
+	}
+	return user, nil
+}
+
+func (svc *accessTokenService) Create(ctx context.Context, userID int64, token *booclient.AccessToken) (*booclient.AccessToken, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := svc.checkPermission(ctx, currentUser, userID); err != nil {
+		return nil, err
+	}
+	user, err := svc.findUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	token.Name = strings.TrimSpace(token.Name)
+
+	v := validation.Default.New()
+	if v.Required("name", token.Name).Ok {
+		v.MaxSize("name", token.Name, 100)
+
+		if exists, err := svc.dao.NameExists(ctx, userID, token.Name); err != nil {
+			return nil, errors.Wrap(err, "查询令牌 '"+token.Name+"' 是否已存在失败")
+		} else if exists {
+			v.Error("name", "令牌 '"+token.Name+"' 已存在")
+		}
+	}
+	if token.ExpiresAt != nil && !token.ExpiresAt.IsZero() && !token.ExpiresAt.After(time.Now()) {
+		v.Error("expires_at", "令牌的过期时间必须晚于当前时间")
+	}
+	scopes := make([]string, 0, len(token.Scopes))
+	for _, scope := range token.Scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if _, ok := authn.GetPermission(scope); !ok {
+			v.Error("scopes", "权限 '"+scope+"' 不存在")
+			continue
+		}
+		scopes = append(scopes, scope)
+	}
+	if v.HasErrors() {
+		return nil, v.ToError()
+	}
+
+	plaintext, err := generateAccessToken()
+	if err != nil {
+		return nil, errors.Wrap(err, "生成访问令牌失败")
+	}
+	record := &UserAccessToken{
+		UserID:      userID,
+		Name:        token.Name,
+		TokenHash:   hashAccessToken(plaintext),
+		TokenPrefix: plaintext[:accessTokenPrefixLength],
+		Scopes:      scopes,
+		CreatedAt:   time.Now(),
+	}
+	if token.ExpiresAt != nil && !token.ExpiresAt.IsZero() {
+		record.ExpiresAt = sql.NullTime{Time: *token.ExpiresAt, Valid: true}
+	}
+
+	err = svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		id, err := svc.dao.Insert(ctx, record)
+		if err != nil {
+			return errors.Wrap(err, "创建访问令牌失败")
+		}
+		record.ID = id
+
+		svc.logChange(ctx, tx, currentUser, user, "createaccesstoken", "为用户 '"+user.Nickname+"' 创建访问令牌 '"+record.Name+"' 成功", record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := toAccessToken(record)
+	result.Token = plaintext
+	return &result, nil
+}
+
+func (svc *accessTokenService) List(ctx context.Context, userID int64) ([]booclient.AccessToken, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := svc.checkPermission(ctx, currentUser, userID); err != nil {
+		return nil, err
+	}
+
+	list, err := svc.dao.QueryByUserID(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户的访问令牌失败")
+	}
+	results := make([]booclient.AccessToken, 0, len(list))
+	for idx := range list {
+		results = append(results, toAccessToken(&list[idx]))
+	}
+	return results, nil
+}
+
+func (svc *accessTokenService) Revoke(ctx context.Context, userID, id int64) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if err := svc.checkPermission(ctx, currentUser, userID); err != nil {
+		return err
+	}
+	user, err := svc.findUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	token, err := svc.dao.FindByID(ctx, userID, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.WithCode(errors.New("访问令牌 '"+strconv.FormatInt(id, 10)+"' 不存在"), http.StatusNotFound)
+		}
+		return errors.Wrap(err, "查询访问令牌 '"+strconv.FormatInt(id, 10)+"' 失败")
+	}
+
+	return svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		if _, err := svc.dao.DeleteByID(ctx, userID, id); err != nil {
+			return errors.Wrap(err, "吊销访问令牌 '"+token.Name+"' 失败")
+		}
+		svc.logChange(ctx, tx, currentUser, user, "revokeaccesstoken", "吊销用户 '"+user.Nickname+"' 的访问令牌 '"+token.Name+"' 成功", token)
+		return nil
+	})
+}
+
+func (svc *accessTokenService) Verify(ctx context.Context, plaintext, address string) (authn.AuthUser, error) {
+	if !strings.HasPrefix(plaintext, accessTokenPrefix) {
+		return nil, authn.ErrInvalidCredentials
+	}
+	token, err := svc.dao.FindByHash(ctx, hashAccessToken(plaintext))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, authn.ErrInvalidCredentials
+		}
+		return nil, errors.Wrap(err, "查询访问令牌失败")
+	}
+	now := time.Now()
+	if token.ExpiresAt.Valid && !token.ExpiresAt.Time.After(now) {
+		return nil, authn.ErrTokenExpired
+	}
+
+	user, err := svc.users.userDao.FindByID(ctx, token.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, authn.ErrInvalidCredentials
+		}
+		return nil, errors.Wrap(err, "查询用户 '"+strconv.FormatInt(token.UserID, 10)+"' 失败")
+	}
+	if user.DeletedAt != nil {
+		return nil, authn.ErrInvalidCredentials
+	}
+	u, err := svc.authUsers.toAuthUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.LastUsedAt.Valid ||
+		token.LastUsedAddress != address ||
+		now.Sub(token.LastUsedAt.Time) > accessTokenLastUsedInterval {
+		if err := svc.dao.UpdateLastUsed(ctx, token.ID, address, now); err != nil {
+			svc.logger.WarnContext(ctx, "更新访问令牌的最后使用时间失败",
+				slog.Int64("token_id", token.ID),
+				slog.Any("err", err))
+		}
+	}
+
+	return &accessTokenUser{
+		AuthUser: u,
+		scopes:   token.Scopes,
+	}, nil
+}
+
+func (svc *accessTokenService) logChange(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, user *User, typ, content string, token *UserAccessToken) {
+	if !enableOplog {
+		return
+	}
+	records := make([]ChangeRecord, 0, 10)
+	records = append(records, ChangeRecord{
+		Name:        "name",
+		DisplayName: "用户名",
+		OldValue:    user.Name,
+	})
+	records = append(records, ChangeRecord{
+		Name:        "access_token",
+		DisplayName: "访问令牌",
+		OldValue:    token.Name,
+	})
+	if len(token.Scopes) > 0 {
+		records = append(records, ChangeRecord{
+			Name:        "scopes",
+			DisplayName: "权限范围",
+			OldValue:    strings.Join(token.Scopes, ","),
+		})
+	}
+
+	oplogger := svc.operationLogger
+	if tx != nil {
+		oplogger = oplogger.WithTx(tx.DB())
+	}
+	err := oplogger.LogRecord(ctx, &OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Nickname(),
+		Successful: true,
+		Type:       typ,
+		Content:    content,
+		Fields: &OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   user.ID,
+			Records:    records,
+		},
+	})
+	if err != nil {
+		svc.logger.WarnContext(ctx, "记录访问令牌的操作失败", slog.Any("err", err))
+	}
+}
+
+var _ authn.AuthUser = &accessTokenUser{}
+
+// accessTokenUser 是用令牌访问时的用户，它的权限是用户权限和令牌的 Scopes 的交集
+type accessTokenUser struct {
+	authn.AuthUser
+	scopes []string
+}
+
+func (u *accessTokenUser) inScopes(permissionID string) bool {
+	if len(u.scopes) == 0 {
+		return true
+	}
+	for _, scope := range u.scopes {
+		if scope == permissionID {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *accessTokenUser) HasPermission(ctx context.Context, permissionID string) (bool, error) {
+	if !u.inScopes(permissionID) {
+		return false, nil
+	}
+	return u.AuthUser.HasPermission(ctx, permissionID)
+}
+
+func (u *accessTokenUser) HasPermissionAny(ctx context.Context, permissionIDs []string) (bool, error) {
+	for _, id := range permissionIDs {
+		ok, err := u.HasPermission(ctx, id)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,150 @@
+package users_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/boo-admin/boo/app_tests"
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn"
+)
+
+func TestAccessTokens(t *testing.T) {
+	app := app_tests.NewTestApp(t, nil)
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	roles := booclient.NewRemoteRoles(pxy)
+	roleID, err := roles.Create(ctx, &booclient.Role{
+		Title: "access_token_test",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = roles.GrantPermissions(ctx, roleID, []string{authn.OpCreateUser, authn.OpViewUser})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	users := booclient.NewRemoteUsers(pxy)
+	userID, err := users.Create(ctx, &booclient.User{
+		Name:     "apitest",
+		Nickname: "令牌测试用户",
+		Password: "Abcd!12345",
+		Source:   "api",
+		Roles:    []booclient.Role{{ID: roleID, Title: "access_token_test"}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	accessTokens := booclient.NewRemoteAccessTokens(pxy)
+	_, err = accessTokens.Create(ctx, userID, &booclient.AccessToken{
+		Name:   "bad",
+		Scopes: []string{"notexists"},
+	})
+	if err == nil {
+		t.Error("want error got ok")
+	}
+
+	expired := time.Now().Add(-time.Hour)
+	_, err = accessTokens.Create(ctx, userID, &booclient.AccessToken{
+		Name:      "expired",
+		ExpiresAt: &expired,
+	})
+	if err == nil {
+		t.Error("want error got ok")
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	token, err := accessTokens.Create(ctx, userID, &booclient.AccessToken{
+		Name:      "readonly",
+		Scopes:    []string{authn.OpViewUser},
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if token.Token == "" {
+		t.Error("want token got empty")
+		return
+	}
+
+	tokenPxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tokenPxy.SetHeader("Authorization", "Token "+token.Token)
+	tokenUsers := booclient.NewRemoteUsers(tokenPxy)
+
+	if _, err := tokenUsers.FindByID(ctx, userID); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 用户有 OpCreateUser 权限，但令牌的范围中没有
+	_, err = tokenUsers.Create(ctx, &booclient.User{
+		Name:     "apitest2",
+		Nickname: "令牌测试用户2",
+		Password: "Abcd!12345",
+	})
+	if err == nil {
+		t.Error("want error got ok")
+	}
+
+	// 不能用令牌管理令牌
+	if _, err := booclient.NewRemoteAccessTokens(tokenPxy).List(ctx, userID); err == nil {
+		t.Error("want error got ok")
+	}
+
+	list, err := accessTokens.List(ctx, userID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(list) != 1 {
+		t.Error("want 1 got", len(list))
+		return
+	}
+	if list[0].Token != "" {
+		t.Error("want token is empty got", list[0].Token)
+	}
+	if list[0].Prefix == "" || list[0].Prefix != token.Token[:len(list[0].Prefix)] {
+		t.Error("want prefix of", token.Token, "got", list[0].Prefix)
+	}
+	if list[0].LastUsedAt == nil {
+		t.Error("want last_used_at got nil")
+	}
+
+	if err := accessTokens.Revoke(ctx, userID, token.ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := tokenUsers.FindByID(ctx, userID); err == nil {
+		t.Error("want error got ok")
+	}
+
+	badPxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	badPxy.SetHeader("Authorization", "Token boo_notexists")
+	if _, err := booclient.NewRemoteUsers(badPxy).FindByID(ctx, userID); err == nil {
+		t.Error("want error got ok")
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 type TimeRange = booclient.TimeRange
 type Department = booclient.Department
+type DepartmentOrder = booclient.DepartmentOrder
 type User = booclient.User
 type Role = booclient.Role
 type Employee = booclient.Employee
@@ -21,6 +22,31 @@ type OperationLogRecord = booclient.OperationLogRecord
 type ChangeRecord = booclient.ChangeRecord
 type CustomField = booclient.CustomField
 
+type Departments interface {
+	booclient.Departments
+	DepartmentsForHTTP
+}
+
+type DepartmentsForHTTP interface {
+	// @Summary 下载一个部门列表
+	// @Param   format             path  string                     false     "下载文件要格式" enums(csv,xlsx)
+	// @Param   inline             query bool                       false     "是否作为 body 返回"
+	// @Param   sort               query string                       false        "排序字段"
+	// @Param   offset             query int                          false        "offset"
+	// @Param   limit              query int                          false        "limit"
+	// @Accept  json
+	// @Produce json
+	// @Router  /departments/export/{format} [get]
+	// @x-gogen-noreturn true
+	Export(ctx context.Context, format string, inline bool, sort string, offset, limit int64, writer http.ResponseWriter) error
+
+	// @Summary 上传一份部门列表，并创建（或更新）部门信息，部门的层级用 '部门路径' 列表示，如 '总部/研发/平台组'
+	// @Accept  json
+	// @Produce json
+	// @Router  /departments/import [post]
+	Import(ctx context.Context, request *http.Request) error
+}
+
 type Users interface {
 	booclient.Users
 	UsersForHTTP
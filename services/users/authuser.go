@@ -0,0 +1,367 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+	gobatis "github.com/runner-mei/GoBatis"
+)
+
+// AdministratorRoleName 超级管理员的角色名，拥有这个角色的用户拥有所有的权限
+const AdministratorRoleName = "administrator"
+
+var NewUserProfileDaoHook func(ref gobatis.SqlSession) UserProfileDao
+
+func NewUserProfileDaoWith(ref gobatis.SqlSession) UserProfileDao {
+	if NewUserProfileDaoHook != nil {
+		return NewUserProfileDaoHook(ref)
+	}
+	return NewUserProfileDao(ref)
+}
+
+const (
+	CfgUserAdminName     = "users.admin_name"
+	CfgUserAdminPassword = "users.admin_password"
+)
+
+// AuthUsers 从数据库中读取 authn.AuthUser
+type AuthUsers struct {
+	svc           *UserService
+	profileDao    UserProfileDao
+	permissionDao RolePermissionDao
+}
+
+func NewAuthUsers(svc *UserService) *AuthUsers {
+	return &AuthUsers{
+		svc:           svc,
+		profileDao:    NewUserProfileDaoWith(svc.db.SessionReference()),
+		permissionDao: NewRolePermissionDaoWith(svc.db.SessionReference()),
+	}
+}
+
+// InitAdministrator 当配置了管理员的密码，且管理员还不存在时创建它
+func (au *AuthUsers) InitAdministrator(ctx context.Context) error {
+	password := au.svc.env.Config.PasswordWithDefault(CfgUserAdminPassword, "")
+	if password == "" {
+		return nil
+	}
+	name := au.svc.env.Config.StringWithDefault(CfgUserAdminName, "admin")
+
+	if exists, err := au.svc.userDao.UsernameExists(ctx, name); err != nil {
+		return errors.Wrap(err, "查询用户名 '"+name+"' 是否已存在失败")
+	} else if exists {
+		return nil
+	}
+
+	_, err := au.svc.insert(ctx, authn.NewMockUser(name), &User{
+		Name:     name,
+		Nickname: name,
+		Password: password,
+		Roles:    []Role{{Title: AdministratorRoleName}},
+	}, actionNormal)
+	if err != nil {
+		return errors.Wrap(err, "创建管理员 '"+name+"' 失败")
+	}
+	return nil
+}
+
+func (au *AuthUsers) UserByName(ctx context.Context, name string) (authn.AuthUser, error) {
+	user, err := au.svc.userDao.FindByName(ctx, name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, authn.ErrUserNotFound
+		}
+		return nil, errors.Wrap(err, "查询用户 '"+name+"' 失败")
+	}
+	return au.toAuthUser(ctx, user)
+}
+
+func (au *AuthUsers) UserByID(ctx context.Context, id int64) (authn.AuthUser, error) {
+	user, err := au.svc.userDao.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, authn.ErrUserNotFound
+		}
+		return nil, errors.Wrap(err, "查询用户 '"+strconv.FormatInt(id, 10)+"' 失败")
+	}
+	return au.toAuthUser(ctx, user)
+}
+
+// Verify 校验用户名和密码，用于 basic auth， address 为客户端的地址。
+// 密码错误时和登录共用同一个失败计数，次数过多时用户会被锁定
+func (au *AuthUsers) Verify(ctx context.Context, name, password, address string) (authn.AuthUser, error) {
+	if err := au.svc.checkLocked(ctx, name, address); err != nil {
+		return nil, err
+	}
+	user, err := au.svc.userDao.FindByName(ctx, name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, authn.ErrInvalidCredentials
+		}
+		return nil, errors.Wrap(err, "查询用户 '"+name+"' 失败")
+	}
+	if user.Password == "" {
+		return nil, authn.ErrInvalidCredentials
+	}
+	if err := au.svc.passwordHasher.Compare(ctx, password, user.Password); err != nil {
+		au.svc.passwordFailed(ctx, name, address)
+		return nil, authn.ErrInvalidCredentials
+	}
+	// 开通了两步验证的用户只用密码是不够的，必须走登录流程。
+	// 这时返回和密码错误相同的错误，以免泄露密码是否正确
+	if enabled, err := au.svc.twoFactors.isEnabled(ctx, user.ID); err != nil {
+		return nil, err
+	} else if enabled {
+		return nil, authn.ErrInvalidCredentials
+	}
+	au.svc.passwordSucceeded(name)
+	au.svc.rehashIfNeeded(ctx, user, password)
+	return au.toAuthUser(ctx, user)
+}
+
+func (au *AuthUsers) toAuthUser(ctx context.Context, user *User) (authn.AuthUser, error) {
+	if user.Disabled {
+		return nil, session_core.ErrUserDisabled
+	}
+	user, err := au.svc.loadUser(ctx, user, []string{"department", "roles"})
+	if err != nil {
+		return nil, err
+	}
+	u := &authUser{
+		profileDao:         au.profileDao,
+		user:               user,
+		mustChangePassword: au.svc.isPasswordChangeRequired(user, time.Now()),
+	}
+	u.mustEnrollTwoFactor, err = au.svc.twoFactors.mustEnroll(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if !u.isAdministrator() {
+		permissions, err := au.permissionDao.QueryByUserID(ctx, user.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "加载用户 '"+user.Name+"' 的权限失败")
+		}
+		u.permissions = make(map[string]struct{}, len(permissions))
+		for _, permission := range permissions {
+			u.permissions[permission] = struct{}{}
+		}
+	}
+	return u, nil
+}
+
+// ContextWithUserByName 在 ctx 中设置当前用户，用户只会在第一次使用时从数据库中读取,
+// 同一个请求中后面再使用时会直接返回缓存的结果
+func (au *AuthUsers) ContextWithUserByName(ctx context.Context, name string) context.Context {
+	return authn.ContextWithReadCurrentUser(ctx, cacheReadCurrentUser(func(ctx context.Context) (authn.AuthUser, error) {
+		return au.UserByName(ctx, name)
+	}))
+}
+
+// ContextWithUserByID 同 ContextWithUserByName
+func (au *AuthUsers) ContextWithUserByID(ctx context.Context, id int64) context.Context {
+	return authn.ContextWithReadCurrentUser(ctx, cacheReadCurrentUser(func(ctx context.Context) (authn.AuthUser, error) {
+		return au.UserByID(ctx, id)
+	}))
+}
+
+func cacheReadCurrentUser(read authn.ReadCurrentUserFunc) authn.ReadCurrentUserFunc {
+	var once sync.Once
+	var u authn.AuthUser
+	var err error
+	return func(ctx context.Context) (authn.AuthUser, error) {
+		once.Do(func() {
+			u, err = read(ctx)
+		})
+		return u, err
+	}
+}
+
+var _ authn.AuthUser = &authUser{}
+var _ authn.PasswordChangeChecker = &authUser{}
+var _ authn.TwoFactorEnrollChecker = &authUser{}
+
+type authUser struct {
+	profileDao          UserProfileDao
+	user                *User
+	permissions         map[string]struct{}
+	mustChangePassword  bool
+	mustEnrollTwoFactor bool
+}
+
+func (u *authUser) IsPasswordChangeRequired() bool {
+	return u.mustChangePassword
+}
+
+func (u *authUser) IsTwoFactorEnrollRequired() bool {
+	return u.mustEnrollTwoFactor
+}
+
+func (u *authUser) ID() int64 {
+	return u.user.ID
+}
+
+func (u *authUser) Name() string {
+	return u.user.Name
+}
+
+func (u *authUser) Nickname() string {
+	return u.user.Nickname
+}
+
+// DisplayName 返回显示名称， fmt 为显示格式，如 "{{nickname}}({{name}})"
+func (u *authUser) DisplayName(ctx context.Context, fmt ...string) string {
+	if len(fmt) == 0 || fmt[0] == "" {
+		if u.user.Nickname != "" {
+			return u.user.Nickname
+		}
+		return u.user.Name
+	}
+	return strings.NewReplacer("{{name}}", u.user.Name,
+		"{{nickname}}", u.user.Nickname).Replace(fmt[0])
+}
+
+func (u *authUser) WriteProfile(key, value string) error {
+	ctx := context.Background()
+	if value == "" {
+		_, err := u.profileDao.DeleteProfile(ctx, u.user.ID, key)
+		return err
+	}
+	return u.profileDao.WriteProfileByKey(ctx, u.user.ID, key, value)
+}
+
+func (u *authUser) ReadProfile(key string) (string, error) {
+	value, err := u.profileDao.ReadProfile(context.Background(), u.user.ID, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return value, nil
+}
+
+func (u *authUser) Data(ctx context.Context, key string) interface{} {
+	switch key {
+	case "id":
+		return u.user.ID
+	case "name":
+		return u.user.Name
+	case "nickname":
+		return u.user.Nickname
+	case "description":
+		return u.user.Description
+	case "source":
+		return u.user.Source
+	case "department_id":
+		return u.user.DepartmentID
+	case "department":
+		return u.user.Department
+	case "department_name":
+		if u.user.Department == nil {
+			return nil
+		}
+		return u.user.Department.Name
+	}
+	if u.user.Fields == nil {
+		return nil
+	}
+	return u.user.Fields[key]
+}
+
+func (u *authUser) RoleIDs() []int64 {
+	ids := make([]int64, 0, len(u.user.Roles))
+	for idx := range u.user.Roles {
+		ids = append(ids, u.user.Roles[idx].ID)
+	}
+	return ids
+}
+
+func (u *authUser) RoleNames() []string {
+	names := make([]string, 0, len(u.user.Roles))
+	for idx := range u.user.Roles {
+		names = append(names, u.user.Roles[idx].Title)
+	}
+	return names
+}
+
+func (u *authUser) isAdministrator() bool {
+	return u.user.IsDefault || u.HasRole(AdministratorRoleName)
+}
+
+// isAdministrator 判断用户是不是管理员，访问令牌的用户按令牌所属的用户判断
+func isAdministrator(currentUser authn.AuthUser) bool {
+	if u, ok := currentUser.(*accessTokenUser); ok {
+		currentUser = u.AuthUser
+	}
+	if u, ok := currentUser.(*authUser); ok {
+		return u.isAdministrator()
+	}
+	return currentUser.HasRole(AdministratorRoleName)
+}
+
+// canAssignRole 判断当前用户能否将角色关联到用户，只有管理员或者拥有这个角色的用户
+// 才能关联它，以免通过关联角色提升权限。新建的角色还没有任何权限，但不能是管理员角色
+func canAssignRole(currentUser authn.AuthUser, role *Role, isNewRole bool) bool {
+	if isAdministrator(currentUser) {
+		return true
+	}
+	if isNewRole {
+		return role.Title != AdministratorRoleName
+	}
+	return currentUser.HasRoleID(role.ID)
+}
+
+func (u *authUser) HasPermission(ctx context.Context, permissionID string) (bool, error) {
+	if u.isAdministrator() {
+		return true, nil
+	}
+	_, ok := u.permissions[permissionID]
+	return ok, nil
+}
+
+func (u *authUser) HasPermissionAny(ctx context.Context, permissionIDs []string) (bool, error) {
+	for _, id := range permissionIDs {
+		ok, err := u.HasPermission(ctx, id)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (u *authUser) HasRole(name string) bool {
+	for idx := range u.user.Roles {
+		if u.user.Roles[idx].Title == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *authUser) HasRoleID(id int64) bool {
+	for idx := range u.user.Roles {
+		if u.user.Roles[idx].ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *authUser) ForEach(cb func(string, interface{})) {
+	cb("id", u.user.ID)
+	cb("name", u.user.Name)
+	cb("nickname", u.user.Nickname)
+	cb("description", u.user.Description)
+	cb("source", u.user.Source)
+	cb("department_id", u.user.DepartmentID)
+	for key, value := range u.user.Fields {
+		cb(key, value)
+	}
+}
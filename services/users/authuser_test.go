@@ -0,0 +1,101 @@
+package users_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/boo-admin/boo/app_tests"
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/goutils/totp"
+	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/services/authn/session_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+)
+
+func TestAuthUsersVerify(t *testing.T) {
+	app := app_tests.NewTestApp(t, map[string]string{
+		session_auth.CfgUserMaxLoginFailCount: "2",
+	})
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	users := booclient.NewRemoteUsers(pxy)
+	for _, u := range []booclient.User{
+		{Name: "authtest", Nickname: "authtest", Password: "Abcd!12345"},
+		{Name: "authtest_disabled", Nickname: "authtest_disabled", Password: "Abcd!12345", Disabled: true},
+		{Name: "authtest_lock", Nickname: "authtest_lock", Password: "Abcd!12345"},
+	} {
+		_, err = users.Create(ctx, &u)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	authUsers := app.Server.AuthUsers
+
+	u, err := authUsers.Verify(ctx, "authtest", "Abcd!12345", "127.0.0.1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if u.Name() != "authtest" {
+		t.Error("want authtest got", u.Name())
+	}
+
+	if _, err := authUsers.Verify(ctx, "authtest", "Abcd!123456", "127.0.0.1"); err != authn.ErrInvalidCredentials {
+		t.Error("want ErrInvalidCredentials got", err)
+	}
+	if _, err := authUsers.Verify(ctx, "authtest_notexists", "Abcd!12345", "127.0.0.1"); err != authn.ErrInvalidCredentials {
+		t.Error("want ErrInvalidCredentials got", err)
+	}
+	if _, err := authUsers.Verify(ctx, "authtest_disabled", "Abcd!12345", "127.0.0.1"); err != session_core.ErrUserDisabled {
+		t.Error("want ErrUserDisabled got", err)
+	}
+
+	// 密码错误和登录共用同一个失败计数，次数过多时用户被锁定
+	for i := 0; i < 2; i++ {
+		if _, err := authUsers.Verify(ctx, "authtest_lock", "Abcd!123456", "127.0.0.1"); err != authn.ErrInvalidCredentials {
+			t.Error("want ErrInvalidCredentials got", err)
+		}
+	}
+	if _, err := authUsers.Verify(ctx, "authtest_lock", "Abcd!12345", "127.0.0.1"); err != session_core.ErrUserLocked {
+		t.Error("want ErrUserLocked got", err)
+	}
+
+	// 开通了两步验证后只用密码不能通过
+	userPxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	userPxy.SetBasicAuth("authtest", "Abcd!12345")
+	twoFactors := booclient.NewRemoteTwoFactors(userPxy)
+
+	enrollment, err := twoFactors.Enroll(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := twoFactors.Activate(ctx, code); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := authUsers.Verify(ctx, "authtest", "Abcd!12345", "127.0.0.1"); err != authn.ErrInvalidCredentials {
+		t.Error("want ErrInvalidCredentials got", err)
+	}
+}
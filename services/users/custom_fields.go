@@ -0,0 +1,546 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/validation"
+	"github.com/hjson/hjson-go/v4"
+	gobatis "github.com/runner-mei/GoBatis"
+	"golang.org/x/exp/slog"
+)
+
+var NewCustomFieldDaoHook func(ref gobatis.SqlSession) CustomFieldDao
+
+func NewCustomFieldDaoWith(ref gobatis.SqlSession) CustomFieldDao {
+	if NewCustomFieldDaoHook != nil {
+		return NewCustomFieldDaoHook(ref)
+	}
+	return NewCustomFieldDao(ref)
+}
+
+// customFieldSet 缓存了某一类对象的自定义字段，字段保存在数据库中，
+// Get() 时会按 reloadInterval 重新加载，修改字段后会立即重新加载
+type customFieldSet struct {
+	objectType     string
+	title          string
+	logger         *slog.Logger
+	dao            CustomFieldDao
+	reloadInterval time.Duration
+	lastLoadAt     int64
+	value          atomic.Value
+}
+
+func (set *customFieldSet) Get() []CustomField {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&set.lastLoadAt)
+	if set.reloadInterval > 0 && now-last >= int64(set.reloadInterval) &&
+		atomic.CompareAndSwapInt64(&set.lastLoadAt, last, now) {
+		if err := set.Reload(context.Background()); err != nil {
+			set.logger.Warn("重新加载"+set.title+"字段失败", slog.Any("error", err))
+		}
+	}
+
+	fields, _ := set.value.Load().([]CustomField)
+	return fields
+}
+
+func (set *customFieldSet) Reload(ctx context.Context) error {
+	list, err := set.dao.QueryByObjectType(ctx, set.objectType)
+	if err != nil {
+		return errors.Wrap(err, "加载"+set.title+"字段的配置失败")
+	}
+	fields := make([]CustomField, 0, len(list))
+	for idx := range list {
+		fields = append(fields, list[idx].ToCustomField())
+	}
+	set.value.Store(fields)
+	atomic.StoreInt64(&set.lastLoadAt, time.Now().UnixNano())
+	return nil
+}
+
+// newCustomFieldSet 从数据库中加载字段，数据库中没有时用配置文件（cfgName 指定）
+// 或缺省值初始化数据库
+func newCustomFieldSet(env *booclient.Environment, db *gobatis.SessionFactory,
+	objectType, title, cfgName string, defaultFields []CustomField) (*customFieldSet, error) {
+	set := &customFieldSet{
+		objectType:     objectType,
+		title:          title,
+		logger:         env.Logger.WithGroup("customfields"),
+		dao:            NewCustomFieldDaoWith(db.SessionReference()),
+		reloadInterval: env.Config.DurationWithDefault("customfields.reload_interval", time.Minute),
+	}
+
+	ctx := context.Background()
+	if err := set.Reload(ctx); err != nil {
+		return nil, err
+	}
+	if len(set.Get()) > 0 {
+		return set, nil
+	}
+
+	var fields []CustomField
+	if s := env.Config.StringWithDefault(cfgName, ""); s != "" {
+		filename := booclient.GetRealDir(ctx, env, s)
+		bs, err := ioutil.ReadFile(filename)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return nil, errors.Wrap(err, "加载"+title+"字段的配置失败")
+			}
+		} else if err = hjson.Unmarshal(bs, &fields); err != nil {
+			return nil, errors.Wrap(err, "加载"+title+"字段的配置失败")
+		}
+	}
+	if fields == nil {
+		fields = defaultFields
+	}
+	if len(fields) == 0 {
+		return set, nil
+	}
+
+	seedErr := db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		for idx := range fields {
+			definition := ToCustomFieldDefinition(objectType, &fields[idx])
+			definition.OrderNum = idx
+			if _, err := set.dao.Insert(ctx, definition); err != nil {
+				return errors.Wrap(err, "初始化"+title+"字段 '"+fields[idx].ID+"' 失败")
+			}
+		}
+		return nil
+	})
+
+	// 多个实例同时启动时可能已经被别的实例初始化了
+	if err := set.Reload(ctx); err != nil {
+		return nil, err
+	}
+	if seedErr != nil && len(set.Get()) == 0 {
+		return nil, seedErr
+	}
+	return set, nil
+}
+
+// validateCustomFields 检查字段值的类型和枚举值
+func validateCustomFields(v *validation.Validation, fields []CustomField, values map[string]interface{}) bool {
+	if len(values) == 0 {
+		return v.HasErrors()
+	}
+	for _, f := range fields {
+		if f.Type == "" && len(f.Values) == 0 {
+			continue
+		}
+		value := values[f.ID]
+		if value == nil {
+			continue
+		}
+		s := fmt.Sprint(value)
+		if s == "" {
+			continue
+		}
+
+		if len(f.Values) > 0 {
+			found := false
+			for _, ev := range f.Values {
+				if s == fmt.Sprint(ev.Value) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				if _, err := booclient.ParseCustomFieldValue(f, s); err != nil {
+					v.Error("fields."+f.ID, "字段 '"+f.Name+"' 的值 '"+s+"' 不在可选值中")
+				}
+			}
+			continue
+		}
+
+		if _, err := booclient.ParseCustomFieldValue(f, s); err != nil {
+			v.Error("fields."+f.ID, "字段 '"+f.Name+"' 的值 '"+s+"' 不是合法的 "+f.Type+" 类型")
+		}
+	}
+	return v.HasErrors()
+}
+
+func isValidCustomFieldType(typ string) bool {
+	switch strings.ToLower(typ) {
+	case "", "string", "int", "integer", "float", "bool", "boolean":
+		return true
+	}
+	return false
+}
+
+func NewCustomFields(env *booclient.Environment,
+	db *gobatis.SessionFactory,
+	users *UserService,
+	operationLogger OperationLogger) (booclient.CustomFields, error) {
+	return customFieldService{
+		env:             env,
+		logger:          env.Logger.WithGroup("customfields"),
+		operationLogger: operationLogger,
+		db:              db,
+		dao:             NewCustomFieldDaoWith(db.SessionReference()),
+		users:           users,
+	}, nil
+}
+
+type customFieldService struct {
+	env             *booclient.Environment
+	logger          *slog.Logger
+	operationLogger OperationLogger
+	db              *gobatis.SessionFactory
+	dao             CustomFieldDao
+	users           *UserService
+}
+
+func (svc customFieldService) fieldSet(objectType string) (*customFieldSet, error) {
+	switch objectType {
+	case booclient.CustomFieldUser:
+		return svc.users.fields, nil
+	case booclient.CustomFieldEmployee:
+		return svc.users.employeeFields, nil
+	case booclient.CustomFieldDepartment:
+		return svc.users.departmentFields, nil
+	}
+	return nil, errors.WithCode(errors.New("对象类型 '"+objectType+"' 不支持自定义字段"), http.StatusBadRequest)
+}
+
+func (svc customFieldService) findByID(ctx context.Context, objectType, id string) (*CustomFieldDefinition, error) {
+	old, err := svc.dao.FindByFieldID(ctx, objectType, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.WithCode(errors.New("自定义字段 '"+id+"' 不存在"), http.StatusNotFound)
+		}
+		return nil, errors.Wrap(err, "查询自定义字段 '"+id+"' 失败")
+	}
+	return old, nil
+}
+
+func (svc customFieldService) validate(v *validation.Validation, field *CustomField) {
+	v.Required("id", field.ID)
+	v.Required("name", field.Name)
+	if !isValidCustomFieldType(field.Type) {
+		v.Error("type", "字段类型 '"+field.Type+"' 不正确")
+	}
+}
+
+func (svc customFieldService) Create(ctx context.Context, objectType string, field *CustomField) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpUpdateCustomField); err != nil {
+		return errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpUpdateCustomField)
+	}
+	set, err := svc.fieldSet(objectType)
+	if err != nil {
+		return err
+	}
+
+	v := validation.Default.New()
+	svc.validate(v, field)
+	if field.ID != "" {
+		if _, err := svc.dao.FindByFieldID(ctx, objectType, field.ID); err == nil {
+			v.Error("id", "无法新建字段 '"+field.ID+"'，该字段已存在")
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return errors.Wrap(err, "查询自定义字段 '"+field.ID+"' 是否已存在失败")
+		}
+	}
+	if v.HasErrors() {
+		return v.ToError()
+	}
+
+	definition := ToCustomFieldDefinition(objectType, field)
+	definition.OrderNum = len(set.Get())
+
+	err = svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		id, err := svc.dao.Insert(ctx, definition)
+		if err != nil {
+			return errors.Wrap(err, "新建自定义字段 '"+field.ID+"' 失败")
+		}
+		definition.ID = id
+		svc.logCreate(ctx, tx, currentUser, definition)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return set.Reload(ctx)
+}
+
+func (svc customFieldService) UpdateByID(ctx context.Context, objectType, id string, field *CustomField) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpUpdateCustomField); err != nil {
+		return errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpUpdateCustomField)
+	}
+	set, err := svc.fieldSet(objectType)
+	if err != nil {
+		return err
+	}
+
+	if field.ID == "" {
+		field.ID = id
+	}
+	v := validation.Default.New()
+	svc.validate(v, field)
+	if field.ID != id {
+		v.Error("id", "无法更新字段 '"+id+"'，字段 ID 不可修改")
+	}
+	if v.HasErrors() {
+		return v.ToError()
+	}
+
+	old, err := svc.findByID(ctx, objectType, id)
+	if err != nil {
+		return err
+	}
+
+	definition := ToCustomFieldDefinition(objectType, field)
+	definition.ID = old.ID
+	definition.OrderNum = old.OrderNum
+	definition.CreatedAt = old.CreatedAt
+
+	err = svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		if err := svc.dao.UpdateByID(ctx, old.ID, definition); err != nil {
+			return errors.Wrap(err, "更新自定义字段 '"+id+"' 失败")
+		}
+		svc.logUpdate(ctx, tx, currentUser, definition, old)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return set.Reload(ctx)
+}
+
+func (svc customFieldService) DeleteByID(ctx context.Context, objectType, id string) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpUpdateCustomField); err != nil {
+		return errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpUpdateCustomField)
+	}
+	set, err := svc.fieldSet(objectType)
+	if err != nil {
+		return err
+	}
+
+	old, err := svc.findByID(ctx, objectType, id)
+	if err != nil {
+		return err
+	}
+
+	err = svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		if err := svc.dao.DeleteByID(ctx, old.ID); err != nil {
+			return errors.Wrap(err, "删除自定义字段 '"+id+"' 失败")
+		}
+		svc.logDelete(ctx, tx, currentUser, old)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return set.Reload(ctx)
+}
+
+func (svc customFieldService) FindByID(ctx context.Context, objectType, id string) (*CustomField, error) {
+	_, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := svc.fieldSet(objectType); err != nil {
+		return nil, err
+	}
+
+	old, err := svc.findByID(ctx, objectType, id)
+	if err != nil {
+		return nil, err
+	}
+	field := old.ToCustomField()
+	return &field, nil
+}
+
+func (svc customFieldService) List(ctx context.Context, objectType string) ([]CustomField, error) {
+	_, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := svc.fieldSet(objectType); err != nil {
+		return nil, err
+	}
+
+	list, err := svc.dao.QueryByObjectType(ctx, objectType)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询自定义字段失败")
+	}
+	fields := make([]CustomField, 0, len(list))
+	for idx := range list {
+		fields = append(fields, list[idx].ToCustomField())
+	}
+	return fields, nil
+}
+
+func (svc customFieldService) logCreate(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, field *CustomFieldDefinition) {
+	if !enableOplog {
+		return
+	}
+	records := make([]ChangeRecord, 0, 10)
+	records = append(records, ChangeRecord{
+		Name:        "object_type",
+		DisplayName: "对象类型",
+		NewValue:    field.ObjectType,
+	})
+	records = append(records, ChangeRecord{
+		Name:        "field_id",
+		DisplayName: "字段编号",
+		NewValue:    field.FieldID,
+	})
+	records = append(records, ChangeRecord{
+		Name:        "name",
+		DisplayName: "字段名称",
+		NewValue:    field.Name,
+	})
+	if field.Type != "" {
+		records = append(records, ChangeRecord{
+			Name:        "type",
+			DisplayName: "字段类型",
+			NewValue:    field.Type,
+		})
+	}
+
+	oplogger := svc.operationLogger
+	if tx != nil {
+		oplogger = oplogger.WithTx(tx.DB())
+	}
+	err := oplogger.LogRecord(ctx, &OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Nickname(),
+		Successful: true,
+		Type:       authn.OpUpdateCustomField,
+		Content:    "创建自定义字段成功",
+		Fields: &OperationLogRecord{
+			ObjectType: "custom_field",
+			ObjectID:   field.ID,
+			Records:    records,
+		},
+	})
+	if err != nil {
+		svc.logger.WarnContext(ctx, "记录新建自定义字段的操作失败", slog.Any("err", err))
+	}
+}
+
+func (svc customFieldService) logUpdate(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, field, old *CustomFieldDefinition) {
+	if !enableOplog {
+		return
+	}
+	records := make([]ChangeRecord, 0, 10)
+	records = append(records, ChangeRecord{
+		Name:        "field_id",
+		DisplayName: "字段编号",
+		NewValue:    field.FieldID,
+	})
+	if field.Name != old.Name {
+		records = append(records, ChangeRecord{
+			Name:        "name",
+			DisplayName: "字段名称",
+			OldValue:    old.Name,
+			NewValue:    field.Name,
+		})
+	}
+	if field.Type != old.Type {
+		records = append(records, ChangeRecord{
+			Name:        "type",
+			DisplayName: "字段类型",
+			OldValue:    old.Type,
+			NewValue:    field.Type,
+		})
+	}
+	if field.DefaultValue != old.DefaultValue {
+		records = append(records, ChangeRecord{
+			Name:        "default_value",
+			DisplayName: "缺省值",
+			OldValue:    old.DefaultValue,
+			NewValue:    field.DefaultValue,
+		})
+	}
+
+	oplogger := svc.operationLogger
+	if tx != nil {
+		oplogger = oplogger.WithTx(tx.DB())
+	}
+	err := oplogger.LogRecord(ctx, &OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Nickname(),
+		Successful: true,
+		Type:       authn.OpUpdateCustomField,
+		Content:    "修改自定义字段成功",
+		Fields: &OperationLogRecord{
+			ObjectType: "custom_field",
+			ObjectID:   old.ID,
+			Records:    records,
+		},
+	})
+	if err != nil {
+		svc.logger.WarnContext(ctx, "记录修改自定义字段的操作失败", slog.Any("err", err))
+	}
+}
+
+func (svc customFieldService) logDelete(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, old *CustomFieldDefinition) {
+	if !enableOplog {
+		return
+	}
+	records := make([]ChangeRecord, 0, 10)
+	records = append(records, ChangeRecord{
+		Name:        "object_type",
+		DisplayName: "对象类型",
+		OldValue:    old.ObjectType,
+	})
+	records = append(records, ChangeRecord{
+		Name:        "field_id",
+		DisplayName: "字段编号",
+		OldValue:    old.FieldID,
+	})
+	records = append(records, ChangeRecord{
+		Name:        "name",
+		DisplayName: "字段名称",
+		OldValue:    old.Name,
+	})
+
+	oplogger := svc.operationLogger
+	if tx != nil {
+		oplogger = oplogger.WithTx(tx.DB())
+	}
+	err := oplogger.LogRecord(ctx, &OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Nickname(),
+		Successful: true,
+		Type:       authn.OpUpdateCustomField,
+		Content:    "删除自定义字段成功",
+		Fields: &OperationLogRecord{
+			ObjectType: "custom_field",
+			ObjectID:   old.ID,
+			Records:    records,
+		},
+	})
+	if err != nil {
+		svc.logger.WarnContext(ctx, "记录删除自定义字段的操作失败", slog.Any("err", err))
+	}
+}
@@ -0,0 +1,108 @@
+package users_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boo-admin/boo/app_tests"
+	"github.com/boo-admin/boo/booclient"
+)
+
+func TestCustomFields(t *testing.T) {
+	app := app_tests.NewTestApp(t, nil)
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	customFields := booclient.NewRemoteCustomFields(pxy)
+	users := booclient.NewRemoteUsers(pxy)
+
+	err = customFields.Create(ctx, booclient.CustomFieldUser, &booclient.CustomField{
+		ID:   "level",
+		Name: "级别",
+		Values: []booclient.EnumerationValue{
+			{Label: "高", Value: "high"},
+			{Label: "低", Value: "low"},
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = customFields.Create(ctx, booclient.CustomFieldUser, &booclient.CustomField{
+		ID:   "level",
+		Name: "级别",
+	})
+	if err == nil {
+		t.Error("want error got ok")
+	}
+
+	_, err = users.Create(ctx, &booclient.User{
+		Name:     "customfield_bad",
+		Nickname: "customfield_bad",
+		Password: "Abc!123456",
+		Fields:   map[string]interface{}{"level": "middle"},
+	})
+	if err == nil {
+		t.Error("want error got ok")
+	}
+
+	_, err = users.Create(ctx, &booclient.User{
+		Name:     "customfield_ok",
+		Nickname: "customfield_ok",
+		Password: "Abc!123456",
+		Fields:   map[string]interface{}{"level": "high"},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = customFields.UpdateByID(ctx, booclient.CustomFieldUser, "level", &booclient.CustomField{
+		Name: "等级",
+		Type: "int",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	field, err := customFields.FindByID(ctx, booclient.CustomFieldUser, "level")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if field.Name != "等级" || field.Type != "int" || len(field.Values) != 0 {
+		t.Error("want 等级 got", field.Name, field.Type, field.Values)
+	}
+
+	err = customFields.DeleteByID(ctx, booclient.CustomFieldUser, "level")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	list, err := customFields.List(ctx, booclient.CustomFieldUser)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, f := range list {
+		if f.ID == "level" {
+			t.Error("field 'level' is still exists")
+		}
+	}
+
+	_, err = customFields.FindByID(ctx, booclient.CustomFieldUser, "level")
+	if err == nil {
+		t.Error("want error got ok")
+	}
+}
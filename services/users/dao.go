@@ -38,6 +38,10 @@ type DepartmentDao interface {
 	DeleteByID(ctx context.Context, id int64) error
 	FindByID(ctx context.Context, id int64) (*Department, error)
 	FindByName(ctx context.Context, name string) (*Department, error)
+	// @default SELECT * FROM <tablename type="Department" /> WHERE
+	//   <if test="parentID &gt; 0">parent_id = #{parentID}<else/>(parent_id IS NULL OR parent_id = 0)</if>
+	//   AND name = #{name}
+	FindByParentAndName(ctx context.Context, parentID int64, name string) (*Department, error)
 	// @default SELECT count(*) from <tablename /> <if test="isNotEmpty(keyword)"> WHERE
 	//   name like <like value="keyword" /> or uuid like <like value="keyword" /> </if>
 	Count(ctx context.Context, keyword string) (int64, error)
@@ -47,6 +51,25 @@ type DepartmentDao interface {
 	List(ctx context.Context, keyword string, sort string, offset, limit int64) ([]Department, error)
 
 	FindByIDList(ctx context.Context, id []int64) ([]Department, error)
+
+	// @default WITH RECURSIVE subtree(id) AS (
+	//   SELECT id FROM <tablename type="Department" /> WHERE id in (<foreach collection="id" item="item" separator="," >#{item}</foreach>)
+	//   UNION
+	//   SELECT d.id FROM <tablename type="Department" as="d" /> INNER JOIN subtree ON d.parent_id = subtree.id
+	// ) SELECT id FROM subtree
+	// @dm SELECT id FROM <tablename type="Department" /> START WITH id in (<foreach collection="id" item="item" separator="," >#{item}</foreach>)
+	//   CONNECT BY PRIOR id = parent_id
+	QuerySubtreeIDList(ctx context.Context, id []int64) ([]int64, error)
+
+	// @default SELECT * FROM <tablename type="Department" /> WHERE
+	//   <if test="parentID &gt; 0">parent_id = #{parentID}<else/>(parent_id IS NULL OR parent_id = 0)</if>
+	//   ORDER BY order_num, id
+	QueryChildren(ctx context.Context, parentID int64) ([]Department, error)
+
+	// @default UPDATE <tablename type="Department" /> SET
+	//   parent_id = <if test="parentID &gt; 0">#{parentID}<else/>NULL</if>, order_num = #{orderNum},
+	//   updated_at = CURRENT_TIMESTAMP WHERE id = #{id}
+	UpdateParentAndOrder(ctx context.Context, id, parentID int64, orderNum int) error
 }
 
 
@@ -67,6 +90,21 @@ type DepartmentDao interface {
 	
 
 // @gobatis.namespace boo
+// @gobatis.sql keywordQuery default
+//   name like <like value="keyword" />
+//   OR nickname like <like value="keyword" />
+//   OR fields->>'<print value="constants.user_mobile" />' like <like value="keyword" />
+//   OR fields->>'<print value="constants.user_email" />' like <like value="keyword" />
+// @gobatis.sql keywordQuery mysql
+//   name like <like value="keyword" />
+//   OR nickname like <like value="keyword" />
+//   OR fields->>'$.<print value="constants.user_mobile" />' like <like value="keyword" />
+//   OR fields->>'$.<print value="constants.user_email" />' like <like value="keyword" />
+// @gobatis.sql keywordQuery dm
+//   name like <like value="keyword" />
+//   OR nickname like <like value="keyword" />
+//   OR JSON_VALUE(fields, '$.<print value="constants.user_mobile" />') like <like value="keyword" />
+//   OR JSON_VALUE(fields, '$.<print value="constants.user_email" />') like <like value="keyword" />
 type UserDao interface {
 	// @type select
 	// @postgres SELECT true FROM <tablename type="User" /> WHERE lower(name) = lower(#{name})  LIMIT 1
@@ -80,70 +118,51 @@ type UserDao interface {
 
 	Insert(ctx context.Context, user *User) (int64, error)
 	UpdateByID(ctx context.Context, id int64, u *User) error
-	// @default UPDATE <tablename /> SET password = #{password}, last_password_modified_at = now() WHERE id = #{id}
-	UpdateUserPassword(ctx context.Context, id int64, password string) error
+	// @default UPDATE <tablename /> SET password = #{password}, last_password_modified_at = CURRENT_TIMESTAMP,
+	//   must_change_password = #{mustChange} WHERE id = #{id}
+	UpdateUserPassword(ctx context.Context, id int64, password string, mustChange bool) error
+	// @default UPDATE <tablename /> SET password = #{password} WHERE id = #{id}
+	UpdatePasswordHash(ctx context.Context, id int64, password string) error
 	DeleteByID(ctx context.Context, id int64, force bool) error
 	DeleteByIDList(ctx context.Context, id []int64, force bool) error
 
 	FindByID(ctx context.Context, id int64) (*User, error)
 	FindByName(ctx context.Context, name string) (*User, error)
+	// @postgres SELECT * FROM <tablename /> WHERE deleted_at IS NULL AND fields->>'<print value="constants.user_email" />' = #{email}
+	// @mysql SELECT * FROM <tablename /> WHERE deleted_at IS NULL AND fields->>'$.<print value="constants.user_email" />' = #{email}
+	// @dm SELECT * FROM <tablename /> WHERE deleted_at IS NULL AND JSON_VALUE(fields, '$.<print value="constants.user_email" />') = #{email}
+	// @default SELECT * FROM <tablename /> WHERE deleted_at IS NULL AND json_extract(fields, '$.<print value="constants.user_email" />') = #{email}
+	QueryByEmail(ctx context.Context, email string) ([]User, error)
+	// @postgres SELECT * FROM <tablename /> WHERE deleted_at IS NULL AND fields->>'<print value="constants.user_mobile" />' = #{mobile}
+	// @mysql SELECT * FROM <tablename /> WHERE deleted_at IS NULL AND fields->>'$.<print value="constants.user_mobile" />' = #{mobile}
+	// @dm SELECT * FROM <tablename /> WHERE deleted_at IS NULL AND JSON_VALUE(fields, '$.<print value="constants.user_mobile" />') = #{mobile}
+	// @default SELECT * FROM <tablename /> WHERE deleted_at IS NULL AND json_extract(fields, '$.<print value="constants.user_mobile" />') = #{mobile}
+	QueryByMobile(ctx context.Context, mobile string) ([]User, error)
+	// @default SELECT * FROM <tablename /> WHERE deleted_at IS NULL AND source = #{source}
+	QueryBySource(ctx context.Context, source string) ([]User, error)
 	// @default SELECT count(*) from <tablename /> <where>
 	//   <if test="departmentID &gt; 0" >department_id = #{departmentID} AND </if>
-	//   <if test="roleID &gt; 0" >id in (select user_id from <tablename type="User2Role" as="u2r" /> where u2r.role_id =#{roleID})) AND </if>
+	//   <if test="isNotEmpty(scopeDepartmentIDs)" >department_id in (<foreach collection="scopeDepartmentIDs" item="item" separator="," >#{item}</foreach>) AND </if>
+	//   <if test="roleID &gt; 0" >id in (select user_id from <tablename type="User2Role" as="u2r" /> where u2r.role_id = #{roleID}) AND </if>
 	//   <if test="isNotEmpty(role)" >id in (select user_id from <tablename type="User2Role" as="u2r" /> where u2r.role_id in (select id from <tablename type="Role" /> where uuid=#{role})) AND </if>
-	//   <if test="tagID &gt; 0" >id in (select user_id from <tablename type="User2Tag" as="u2t" /> where u2t.tag_id =#{tagID})) AND </if>
+	//   <if test="tagID &gt; 0" >id in (select user_id from <tablename type="User2Tag" as="u2t" /> where u2t.tag_id = #{tagID}) AND </if>
 	//   <if test="isNotEmpty(tag)" >id in (select user_id from <tablename type="User2Tag" as="u2t" /> where u2t.tag_id in (select id from <tablename type="UserTag" as="tag" /> where uuid=#{tag})) AND </if>
 	//   <if test="deleted.Valid"><if test="deleted.Bool">deleted_at IS NOT NULL AND<else/>deleted_at IS NULL AND</if></if>
-	//   <if test="isNotEmpty(keyword)">
-	//   name like <like value="keyword" />
-	//   OR nickname like <like value="keyword" />
-	//   OR fields->>'<print value="constants.user_mobile" />' like <like value="keyword" />
-	//   OR fields->>'<print value="constants.user_email" />' like <like value="keyword" /></if>
+	//   <if test="isNotEmpty(keyword)">(<include refid="keywordQuery" />)</if>
 	//   </where>
-	// @mysql SELECT count(*) from <tablename /> <where>
-	//   <if test="departmentID &gt; 0" >department_id = #{departmentID} AND </if>
-	//   <if test="roleID &gt; 0" >id in (select user_id from <tablename type="User2Role" as="u2r" /> where u2r.role_id =#{roleID})) AND </if>
-	//   <if test="isNotEmpty(role)" >id in (select user_id from <tablename type="User2Role" as="u2r" /> where u2r.role_id in (select id from <tablename type="Role" /> where uuid=#{role})) AND </if>
-	//   <if test="tagID &gt; 0" >id in (select user_id from <tablename type="User2Tag" as="u2t" /> where u2t.tag_id =#{tagID})) AND </if>
-	//   <if test="isNotEmpty(tag)" >id in (select user_id from <tablename type="User2Tag" as="u2t" /> where u2t.tag_id in (select id from <tablename type="UserTag" as="tag" /> where uuid=#{tag})) AND </if>
-	//   <if test="deleted.Valid"><if test="deleted.Bool">deleted_at IS NOT NULL AND<else/>deleted_at IS NULL AND</if></if>
-	//   <if test="isNotEmpty(keyword)">
-	//   name like <like value="keyword" />
-	//   OR nickname like <like value="keyword" />
-	//   OR fields->>'$.<print value="constants.user_mobile" />' like <like value="keyword" />
-	//   OR fields->>'$.<print value="constants.user_email" />' like <like value="keyword" /></if>
-	//   </where>
-	Count(ctx context.Context, departmentID int64, roleID int64, role string, tagID int64, tag, keyword string, deleted sql.NullBool) (int64, error)
+	Count(ctx context.Context, departmentID int64, scopeDepartmentIDs []int64, roleID int64, role string, tagID int64, tag, keyword string, deleted sql.NullBool) (int64, error)
 	// @default SELECT * from <tablename /> <where>
 	//   <if test="departmentID &gt; 0" >department_id = #{departmentID} AND </if>
-	//   <if test="roleID &gt; 0" >id in (select user_id from <tablename type="User2Role" as="u2r" /> where u2r.role_id =#{roleID})) AND </if>
-	//   <if test="isNotEmpty(role)" >id in (select user_id from <tablename type="User2Role" as="u2r" /> where u2r.role_id in (select id from <tablename type="Role" /> where uuid=#{role})) AND </if>
-	//   <if test="tagID &gt; 0" >id in (select user_id from <tablename type="User2Tag" as="u2t" /> where u2t.tag_id =#{tagID})) AND </if>
-	//   <if test="isNotEmpty(tag)" >id in (select user_id from <tablename type="User2Tag" as="u2t" /> where u2t.tag_id in (select id from <tablename type="UserTag" /> where uuid=#{tag})) AND </if>
-	//   <if test="deleted.Valid"><if test="deleted.Bool">deleted_at IS NOT NULL AND<else/>deleted_at IS NULL AND</if></if>
-	//   <if test="isNotEmpty(keyword)">
-	//   name like <like value="keyword" />
-	//   OR nickname like <like value="keyword" />
-	//   OR fields->>'<print value="constants.user_mobile" />' like <like value="keyword" />
-	//   OR fields->>'<print value="constants.user_email" />' like <like value="keyword" />
-	//   </if>
-	//   </where>
-	// <pagination /> <sort_by />
-	// @mysql SELECT * from <tablename /> <where>
-	//   <if test="departmentID &gt; 0" >department_id = #{departmentID} AND </if>
-	//   <if test="roleID &gt; 0" >id in (select user_id from <tablename type="User2Role" as="u2r" /> where u2r.role_id =#{roleID})) AND </if>
+	//   <if test="isNotEmpty(scopeDepartmentIDs)" >department_id in (<foreach collection="scopeDepartmentIDs" item="item" separator="," >#{item}</foreach>) AND </if>
+	//   <if test="roleID &gt; 0" >id in (select user_id from <tablename type="User2Role" as="u2r" /> where u2r.role_id = #{roleID}) AND </if>
 	//   <if test="isNotEmpty(role)" >id in (select user_id from <tablename type="User2Role" as="u2r" /> where u2r.role_id in (select id from <tablename type="Role" /> where uuid=#{role})) AND </if>
-	//   <if test="tagID &gt; 0" >id in (select user_id from <tablename type="User2Tag" as="u2t" /> where u2t.tag_id =#{tagID})) AND </if>
+	//   <if test="tagID &gt; 0" >id in (select user_id from <tablename type="User2Tag" as="u2t" /> where u2t.tag_id = #{tagID}) AND </if>
 	//   <if test="isNotEmpty(tag)" >id in (select user_id from <tablename type="User2Tag" as="u2t" /> where u2t.tag_id in (select id from <tablename type="UserTag" as="tag" /> where uuid=#{tag})) AND </if>
 	//   <if test="deleted.Valid"><if test="deleted.Bool">deleted_at IS NOT NULL AND<else/>deleted_at IS NULL AND</if></if>
-	//   <if test="isNotEmpty(keyword)">
-	//   name like <like value="keyword" />
-	//   OR nickname like <like value="keyword" />
-	//   OR fields->>'$.<print value="constants.user_mobile" />' like <like value="keyword" />
-	//   OR fields->>'$.<print value="constants.user_email" />' like <like value="keyword" /></if>
+	//   <if test="isNotEmpty(keyword)">(<include refid="keywordQuery" />)</if>
 	//   </where>
 	// <pagination /> <sort_by />
-	List(ctx context.Context, departmentID int64, roleID int64, role string, tagID int64, tag, keyword string, deleted sql.NullBool, sort string, offset, limit int64) ([]User, error)
+	List(ctx context.Context, departmentID int64, scopeDepartmentIDs []int64, roleID int64, role string, tagID int64, tag, keyword string, deleted sql.NullBool, sort string, offset, limit int64) ([]User, error)
 	FindByIDList(ctx context.Context, id []int64) ([]User, error)
 }
 
@@ -202,6 +221,8 @@ type UserTagDao interface {
 // @gobatis.namespace boo
 type User2TagDao interface {
 	// @record_type User2Tag
+	// @sqlite INSERT INTO <tablename type="User2Tag" /> (user_id, tag_id) VALUES (#{userID}, #{tagID})
+	//   ON CONFLICT (user_id, tag_id) DO NOTHING
 	Upsert(ctx context.Context, userID, tagID int64) error
 	// @record_type User2Tag
 	Delete(ctx context.Context, userID, tagID int64) error
@@ -227,6 +248,9 @@ type UserProfileDao interface {
 
 	// @type upsert
 	// @record_type UserProfile
+	// @sqlite INSERT INTO <tablename type="UserProfile" /> (user_id, name, value, created_at, updated_at)
+	//   VALUES (#{userID}, #{name}, #{value}, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	//   ON CONFLICT (user_id, name) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
 	WriteProfileByKey(ctx context.Context, userID int64, name, value string) error
 
 	// @record_type UserProfile
@@ -292,6 +316,8 @@ type User2Role struct {
 // @gobatis.namespace boo
 type User2RoleDao interface {
 	// @record_type User2Role
+	// @sqlite INSERT INTO <tablename type="User2Role" /> (user_id, role_id) VALUES (#{userID}, #{roleID})
+	//   ON CONFLICT (user_id, role_id) DO NOTHING
 	Upsert(ctx context.Context, userID, roleID int64) error
 	// @record_type User2Role
 	Delete(ctx context.Context, userID, roleID int64) error
@@ -303,6 +329,271 @@ type User2RoleDao interface {
 	QueryByUserID(ctx context.Context, userID int64) ([]User2Role, error)
 }
 
+type RolePermission struct {
+	TableName  struct{} `json:"-" xorm:"boo_role_permissions"`
+	RoleID     int64    `json:"role_id" xorm:"role_id unique(role_permission)"`
+	Permission string   `json:"permission" xorm:"permission unique(role_permission)"`
+}
+
+// @gobatis.namespace boo
+type RolePermissionDao interface {
+	// @record_type RolePermission
+	// @sqlite INSERT INTO <tablename type="RolePermission" /> (role_id, permission) VALUES (#{roleID}, #{permission})
+	//   ON CONFLICT (role_id, permission) DO NOTHING
+	Upsert(ctx context.Context, roleID int64, permission string) error
+	// @record_type RolePermission
+	Delete(ctx context.Context, roleID int64, permission string) error
+	// @record_type RolePermission
+	DeleteByRoleID(ctx context.Context, roleID int64) error
+
+	// @default SELECT permission FROM <tablename type="RolePermission" /> WHERE role_id = #{roleID}
+	QueryByRoleID(ctx context.Context, roleID int64) ([]string, error)
+
+	// @default SELECT DISTINCT permission FROM <tablename type="RolePermission" /> WHERE role_id in (select role_id from <tablename type="User2Role" /> where user_id = #{userID})
+	QueryByUserID(ctx context.Context, userID int64) ([]string, error)
+}
+
+type RoleDepartment struct {
+	TableName    struct{} `json:"-" xorm:"boo_role_departments"`
+	RoleID       int64    `json:"role_id" xorm:"role_id unique(role_department)"`
+	DepartmentID int64    `json:"department_id" xorm:"department_id unique(role_department)"`
+}
+
+// @gobatis.namespace boo
+type RoleDepartmentDao interface {
+	// @record_type RoleDepartment
+	// @sqlite INSERT INTO <tablename type="RoleDepartment" /> (role_id, department_id) VALUES (#{roleID}, #{departmentID})
+	//   ON CONFLICT (role_id, department_id) DO NOTHING
+	Upsert(ctx context.Context, roleID int64, departmentID int64) error
+	// @record_type RoleDepartment
+	Delete(ctx context.Context, roleID int64, departmentID int64) error
+	// @record_type RoleDepartment
+	DeleteByRoleID(ctx context.Context, roleID int64) error
+
+	// @default SELECT department_id FROM <tablename type="RoleDepartment" /> WHERE role_id = #{roleID}
+	QueryByRoleID(ctx context.Context, roleID int64) ([]int64, error)
+
+	// @default SELECT DISTINCT department_id FROM <tablename type="RoleDepartment" /> WHERE role_id in (<foreach collection="roleIDs" item="item" separator="," >#{item}</foreach>)
+	QueryByRoleIDList(ctx context.Context, roleIDs []int64) ([]int64, error)
+}
+
+// CustomFieldDefinition 是保存在数据库中的自定义字段
+type CustomFieldDefinition struct {
+	TableName    struct{}                     `json:"-" xorm:"boo_custom_fields"`
+	ID           int64                        `json:"id" xorm:"id pk autoincr"`
+	ObjectType   string                       `json:"object_type" xorm:"object_type unique(object_field) notnull"`
+	FieldID      string                       `json:"field_id" xorm:"field_id unique(object_field) notnull"`
+	Name         string                       `json:"name" xorm:"name notnull"`
+	Aliases      []string                     `json:"aliases,omitempty" xorm:"aliases json null"`
+	DefaultValue string                       `json:"default_value,omitempty" xorm:"default_value null"`
+	Type         string                       `json:"type,omitempty" xorm:"type null"`
+	EnumValues   []booclient.EnumerationValue `json:"enum_values,omitempty" xorm:"enum_values json null"`
+	OrderNum     int                          `json:"order_num" xorm:"order_num null"`
+	CreatedAt    time.Time                    `json:"created_at,omitempty" xorm:"created_at created"`
+	UpdatedAt    time.Time                    `json:"updated_at,omitempty" xorm:"updated_at updated"`
+}
+
+func (f *CustomFieldDefinition) ToCustomField() CustomField {
+	return CustomField{
+		ID:           f.FieldID,
+		Name:         f.Name,
+		Alias:        f.Aliases,
+		DefaultValue: f.DefaultValue,
+		Type:         f.Type,
+		Values:       f.EnumValues,
+	}
+}
+
+func ToCustomFieldDefinition(objectType string, f *CustomField) *CustomFieldDefinition {
+	return &CustomFieldDefinition{
+		ObjectType:   objectType,
+		FieldID:      f.ID,
+		Name:         f.Name,
+		Aliases:      f.Alias,
+		DefaultValue: f.DefaultValue,
+		Type:         f.Type,
+		EnumValues:   f.Values,
+	}
+}
+
+// @gobatis.namespace boo
+type CustomFieldDao interface {
+	Insert(ctx context.Context, field *CustomFieldDefinition) (int64, error)
+	UpdateByID(ctx context.Context, id int64, field *CustomFieldDefinition) error
+	DeleteByID(ctx context.Context, id int64) error
+
+	// @default SELECT * FROM <tablename type="CustomFieldDefinition" /> WHERE object_type = #{objectType} AND field_id = #{fieldID}
+	FindByFieldID(ctx context.Context, objectType, fieldID string) (*CustomFieldDefinition, error)
+
+	// @default SELECT * FROM <tablename type="CustomFieldDefinition" /> WHERE object_type = #{objectType} ORDER BY order_num, id
+	QueryByObjectType(ctx context.Context, objectType string) ([]CustomFieldDefinition, error)
+}
+
+// UserTwoFactor 是用户的两步验证配置， Secret 是加密后的 TOTP 密钥，
+// RecoveryCodes 是以逗号分隔的恢复码的 sha256 值
+type UserTwoFactor struct {
+	TableName     struct{}     `json:"-" xorm:"boo_user_two_factors"`
+	UserID        int64        `json:"user_id" xorm:"user_id pk"`
+	Secret        string       `json:"-" xorm:"secret notnull"`
+	RecoveryCodes string       `json:"-" xorm:"recovery_codes null"`
+	LastUsedStep  int64        `json:"last_used_step,omitempty" xorm:"last_used_step null"`
+	Enabled       bool         `json:"enabled" xorm:"enabled null"`
+	EnabledAt     sql.NullTime `json:"enabled_at" xorm:"enabled_at null"`
+	CreatedAt     time.Time    `json:"created_at,omitempty" xorm:"created_at created"`
+	UpdatedAt     time.Time    `json:"updated_at,omitempty" xorm:"updated_at updated"`
+}
+
+// @gobatis.namespace boo
+type UserTwoFactorDao interface {
+	Insert(ctx context.Context, tf *UserTwoFactor) error
+
+	// @default SELECT * FROM <tablename type="UserTwoFactor" /> WHERE user_id = #{userID}
+	FindByUserID(ctx context.Context, userID int64) (*UserTwoFactor, error)
+
+	// @type update
+	// @default UPDATE <tablename type="UserTwoFactor" />
+	//   SET enabled = true, enabled_at = #{now}, last_used_step = #{step}, updated_at = #{now}
+	//   WHERE user_id = #{userID}
+	// @dm UPDATE <tablename type="UserTwoFactor" />
+	//   SET enabled = 1, enabled_at = #{now}, last_used_step = #{step}, updated_at = #{now}
+	//   WHERE user_id = #{userID}
+	Enable(ctx context.Context, userID, step int64, now time.Time) error
+
+	// UseStep 记录最后一次使用的时间步，返回 0 时表示这个时间步已被使用过，用于防止重放
+	//
+	// @type update
+	// @default UPDATE <tablename type="UserTwoFactor" /> SET last_used_step = #{step}, updated_at = #{now}
+	//   WHERE user_id = #{userID} AND (last_used_step IS NULL OR last_used_step &lt; #{step})
+	UseStep(ctx context.Context, userID, step int64, now time.Time) (int64, error)
+
+	// UpdateRecoveryCodes 更新恢复码， oldRecoveryCodes 不为空时用于防止同一个恢复码被并发使用
+	//
+	// @default UPDATE <tablename type="UserTwoFactor" /> SET recovery_codes = #{recoveryCodes}, updated_at = #{now}
+	//   WHERE user_id = #{userID} <if test="isNotEmpty(oldRecoveryCodes)"> AND recovery_codes = #{oldRecoveryCodes} </if>
+	UpdateRecoveryCodes(ctx context.Context, userID int64, oldRecoveryCodes, recoveryCodes string, now time.Time) (int64, error)
+
+	// @default DELETE FROM <tablename type="UserTwoFactor" /> WHERE user_id = #{userID}
+	DeleteByUserID(ctx context.Context, userID int64) (int64, error)
+}
+
+// UserAccessToken 是用户的访问令牌， TokenHash 是令牌明文的 sha256 值
+type UserAccessToken struct {
+	TableName       struct{}     `json:"-" xorm:"boo_access_tokens"`
+	ID              int64        `json:"id" xorm:"id pk autoincr"`
+	UserID          int64        `json:"user_id" xorm:"user_id notnull"`
+	Name            string       `json:"name" xorm:"name notnull"`
+	TokenHash       string       `json:"-" xorm:"token_hash unique notnull"`
+	TokenPrefix     string       `json:"prefix,omitempty" xorm:"token_prefix null"`
+	Scopes          []string     `json:"scopes,omitempty" xorm:"scopes json null"`
+	ExpiresAt       sql.NullTime `json:"expires_at" xorm:"expires_at null"`
+	LastUsedAt      sql.NullTime `json:"last_used_at" xorm:"last_used_at null"`
+	LastUsedAddress string       `json:"last_used_address,omitempty" xorm:"last_used_address null"`
+	CreatedAt       time.Time    `json:"created_at,omitempty" xorm:"created_at created"`
+}
+
+// @gobatis.namespace boo
+type UserAccessTokenDao interface {
+	Insert(ctx context.Context, token *UserAccessToken) (int64, error)
+
+	// @default SELECT * FROM <tablename type="UserAccessToken" /> WHERE token_hash = #{tokenHash}
+	FindByHash(ctx context.Context, tokenHash string) (*UserAccessToken, error)
+
+	// @default SELECT * FROM <tablename type="UserAccessToken" /> WHERE user_id = #{userID} AND id = #{id}
+	FindByID(ctx context.Context, userID, id int64) (*UserAccessToken, error)
+
+	// @type select
+	// @postgres SELECT true FROM <tablename type="UserAccessToken" /> WHERE user_id = #{userID} AND name = #{name} LIMIT 1
+	// @default SELECT 1 FROM <tablename type="UserAccessToken" /> WHERE user_id = #{userID} AND name = #{name} LIMIT 1
+	NameExists(ctx context.Context, userID int64, name string) (bool, error)
+
+	// @default SELECT * FROM <tablename type="UserAccessToken" /> WHERE user_id = #{userID} ORDER BY id
+	QueryByUserID(ctx context.Context, userID int64) ([]UserAccessToken, error)
+
+	// @default UPDATE <tablename type="UserAccessToken" /> SET last_used_at = #{now}, last_used_address = #{address}
+	//   WHERE id = #{id}
+	UpdateLastUsed(ctx context.Context, id int64, address string, now time.Time) error
+
+	// @default DELETE FROM <tablename type="UserAccessToken" /> WHERE user_id = #{userID} AND id = #{id}
+	DeleteByID(ctx context.Context, userID, id int64) (int64, error)
+
+	// @default DELETE FROM <tablename type="UserAccessToken" /> WHERE user_id = #{userID}
+	DeleteByUserID(ctx context.Context, userID int64) (int64, error)
+}
+
+// PasswordHistory 是用户使用过的密码， Password 是密码的 hash 值
+type PasswordHistory struct {
+	TableName struct{}  `json:"-" xorm:"boo_password_history"`
+	ID        int64     `json:"id" xorm:"id pk autoincr"`
+	UserID    int64     `json:"user_id" xorm:"user_id notnull"`
+	Password  string    `json:"-" xorm:"password notnull"`
+	CreatedAt time.Time `json:"created_at,omitempty" xorm:"created_at created"`
+}
+
+// @gobatis.namespace boo
+type PasswordHistoryDao interface {
+	Insert(ctx context.Context, history *PasswordHistory) (int64, error)
+
+	// @default SELECT * FROM <tablename type="PasswordHistory" /> WHERE user_id = #{userID} ORDER BY id DESC LIMIT #{limit}
+	QueryByUserID(ctx context.Context, userID int64, limit int) ([]PasswordHistory, error)
+
+	// @default DELETE FROM <tablename type="PasswordHistory" /> WHERE user_id = #{userID} AND id &lt;= #{id}
+	DeleteBefore(ctx context.Context, userID, id int64) error
+}
+
+// PasswordReset 是忘记密码时重置密码的令牌， TokenHash 是令牌明文的 sha256 值
+type PasswordReset struct {
+	TableName struct{}     `json:"-" xorm:"boo_password_resets"`
+	ID        int64        `json:"id" xorm:"id pk autoincr"`
+	UserID    int64        `json:"user_id" xorm:"user_id notnull"`
+	TokenHash string       `json:"-" xorm:"token_hash unique notnull"`
+	Channel   string       `json:"channel" xorm:"channel notnull"`
+	Address   string       `json:"address,omitempty" xorm:"address null"`
+	ExpiresAt time.Time    `json:"expires_at" xorm:"expires_at notnull"`
+	UsedAt    sql.NullTime `json:"used_at" xorm:"used_at null"`
+	CreatedAt time.Time    `json:"created_at,omitempty" xorm:"created_at created"`
+}
+
+// @gobatis.namespace boo
+type PasswordResetDao interface {
+	Insert(ctx context.Context, reset *PasswordReset) (int64, error)
+
+	// @default SELECT * FROM <tablename type="PasswordReset" /> WHERE token_hash = #{tokenHash}
+	FindByHash(ctx context.Context, tokenHash string) (*PasswordReset, error)
+
+	// @default SELECT count(*) FROM <tablename type="PasswordReset" /> WHERE user_id = #{userID} AND created_at &gt; #{since}
+	CountByUserID(ctx context.Context, userID int64, since time.Time) (int64, error)
+
+	// @type update
+	// @default UPDATE <tablename type="PasswordReset" /> SET used_at = #{now} WHERE id = #{id} AND used_at IS NULL
+	MarkUsed(ctx context.Context, id int64, now time.Time) (int64, error)
+
+	// @type update
+	// @default UPDATE <tablename type="PasswordReset" /> SET used_at = #{now} WHERE user_id = #{userID} AND used_at IS NULL
+	MarkUsedByUserID(ctx context.Context, userID int64, now time.Time) (int64, error)
+
+	// @default DELETE FROM <tablename type="PasswordReset" /> WHERE created_at &lt; #{before}
+	DeleteBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// PasswordResetAttempt 是一次重置密码的申请，用户不存在的申请也会记录，用于限制每个地址的申请频率
+type PasswordResetAttempt struct {
+	TableName struct{}  `json:"-" xorm:"boo_password_reset_attempts"`
+	ID        int64     `json:"id" xorm:"id pk autoincr"`
+	Address   string    `json:"address" xorm:"address notnull"`
+	CreatedAt time.Time `json:"created_at,omitempty" xorm:"created_at created"`
+}
+
+// @gobatis.namespace boo
+type PasswordResetAttemptDao interface {
+	Insert(ctx context.Context, attempt *PasswordResetAttempt) (int64, error)
+
+	// @default SELECT count(*) FROM <tablename type="PasswordResetAttempt" /> WHERE address = #{address} AND created_at &gt; #{since}
+	CountByAddress(ctx context.Context, address string, since time.Time) (int64, error)
+
+	// @default DELETE FROM <tablename type="PasswordResetAttempt" /> WHERE created_at &lt; #{before}
+	DeleteBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
 func init() {
 	gobatis.Constants["user_class_normal"] = "__class_normal"
 	gobatis.Constants["user_class_support"] = "__class_support"
@@ -319,21 +610,45 @@ func init() {
 // @gobatis.sql tagQuery default
 //   <if test="isNotEmpty(tag)" >
 //     <chose>
-//      <when test="tag == constants.user_class_normal">( NOT (fields ? '<print value="constants.employee_class" />') OR fields->>'<print value="constants.employee_class" />' = '0') AND </when>
+//      <when test="tag == constants.user_class_normal">(fields->>'<print value="constants.employee_class" />' IS NULL OR fields->>'<print value="constants.employee_class" />' = '0') AND </when>
 //      <when test="tag == constants.user_class_support">fields->>'<print value="constants.employee_class" />' = '1' AND </when>
 //      <when test="tag == constants.user_class_nonsupport">fields->>'<print value="constants.employee_class" />' = '2' AND </when>
-//      <otherwise>id in (select user_id from <tablename type="Employee2Tag" as="e2t" /> where e2t.tag_id in (select id from <tablename type="EmployeeTag" as="tag" /> where uuid=#{tag})) AND </otherwise>
+//      <otherwise>id in (select employee_id from <tablename type="Employee2Tag" as="e2t" /> where e2t.tag_id in (select id from <tablename type="EmployeeTag" as="tag" /> where uuid=#{tag})) AND </otherwise>
 //     </chose>
 //   </if>
 // @gobatis.sql tagQuery mysql
 //   <if test="isNotEmpty(tag)" >
 //     <chose>
-//      <when test="tag == constants.user_class_normal">( NOT (fields ? '$.<print value="constants.employee_class" />') OR fields->>'<print value="constants.employee_class" />' = '0') AND </when>
+//      <when test="tag == constants.user_class_normal">(fields->>'$.<print value="constants.employee_class" />' IS NULL OR fields->>'$.<print value="constants.employee_class" />' = '0') AND </when>
 //      <when test="tag == constants.user_class_support">fields->>'$.<print value="constants.employee_class" />' = '1' AND </when>
 //      <when test="tag == constants.user_class_nonsupport">fields->>'$.<print value="constants.employee_class" />' = '2' AND </when>
-//      <otherwise>id in (select user_id from <tablename type="Employee2Tag" as="e2t" /> where e2t.tag_id in (select id from <tablename type="EmployeeTag" as="tag" /> where uuid=#{tag})) AND </otherwise>
+//      <otherwise>id in (select employee_id from <tablename type="Employee2Tag" as="e2t" /> where e2t.tag_id in (select id from <tablename type="EmployeeTag" as="tag" /> where uuid=#{tag})) AND </otherwise>
 //     </chose>
 //   </if>
+// @gobatis.sql tagQuery dm
+//   <if test="isNotEmpty(tag)" >
+//     <chose>
+//      <when test="tag == constants.user_class_normal">(JSON_VALUE(fields, '$.<print value="constants.employee_class" />') IS NULL OR JSON_VALUE(fields, '$.<print value="constants.employee_class" />') = '0') AND </when>
+//      <when test="tag == constants.user_class_support">JSON_VALUE(fields, '$.<print value="constants.employee_class" />') = '1' AND </when>
+//      <when test="tag == constants.user_class_nonsupport">JSON_VALUE(fields, '$.<print value="constants.employee_class" />') = '2' AND </when>
+//      <otherwise>id in (select employee_id from <tablename type="Employee2Tag" as="e2t" /> where e2t.tag_id in (select id from <tablename type="EmployeeTag" as="tag" /> where uuid=#{tag})) AND </otherwise>
+//     </chose>
+//   </if>
+// @gobatis.sql keywordQuery default
+//   name like <like value="keyword" />
+//   OR nickname like <like value="keyword" />
+//   OR fields->>'<print value="constants.user_mobile" />' like <like value="keyword" />
+//   OR fields->>'<print value="constants.user_email" />' like <like value="keyword" />
+// @gobatis.sql keywordQuery mysql
+//   name like <like value="keyword" />
+//   OR nickname like <like value="keyword" />
+//   OR fields->>'$.<print value="constants.user_mobile" />' like <like value="keyword" />
+//   OR fields->>'$.<print value="constants.user_email" />' like <like value="keyword" />
+// @gobatis.sql keywordQuery dm
+//   name like <like value="keyword" />
+//   OR nickname like <like value="keyword" />
+//   OR JSON_VALUE(fields, '$.<print value="constants.user_mobile" />') like <like value="keyword" />
+//   OR JSON_VALUE(fields, '$.<print value="constants.user_email" />') like <like value="keyword" />
 type EmployeeDao interface {
 	// @type select
 	// @postgres SELECT true FROM <tablename type="Employee" /> WHERE lower(name) = lower(#{name})  LIMIT 1
@@ -358,58 +673,28 @@ type EmployeeDao interface {
 	FindByName(ctx context.Context, name string) (*Employee, error)
 	// @default SELECT count(*) from <tablename /> <where>
 	//   <if test="departmentID &gt; 0" >department_id = #{departmentID} AND </if>
-	//   <if test="tagID &gt; 0" >id in (select user_id from <tablename type="Employee2Tag" as="e2t" /> where e2t.tag_id =#{tagID})) AND </if>
+	//   <if test="isNotEmpty(scopeDepartmentIDs)" >department_id in (<foreach collection="scopeDepartmentIDs" item="item" separator="," >#{item}</foreach>) AND </if>
+	//   <if test="tagID &gt; 0" >id in (select employee_id from <tablename type="Employee2Tag" as="e2t" /> where e2t.tag_id = #{tagID}) AND </if>
 	//   <if test="isNotEmpty(tag)" ><include refid="tagQuery" /></if>
 	//   <if test="deleted.Valid"><if test="deleted.Bool">deleted_at IS NOT NULL AND<else/>deleted_at IS NULL AND</if></if>
-	//   <if test="isNotEmpty(keyword)">
-	//   name like <like value="keyword" />
-	//   OR nickname like <like value="keyword" />
-	//   OR fields->>'<print value="constants.user_mobile" />' like <like value="keyword" />
-	//   OR fields->>'<print value="constants.user_email" />' like <like value="keyword" /></if>
+	//   <if test="isNotEmpty(keyword)">(<include refid="keywordQuery" />)</if>
 	//   </where>
-	// @mysql SELECT count(*) from <tablename /> <where>
-	//   <if test="departmentID &gt; 0" >department_id = #{departmentID} AND </if>
-	//   <if test="tagID &gt; 0" >id in (select user_id from <tablename type="Employee2Tag" as="e2t" /> where e2t.tag_id =#{tagID})) AND </if>
-	//   <if test="isNotEmpty(tag)" ><include refid="tagQuery" /></if>
-	//   <if test="deleted.Valid"><if test="deleted.Bool">deleted_at IS NOT NULL AND<else/>deleted_at IS NULL AND</if></if>
-	//   <if test="isNotEmpty(keyword)">
-	//   name like <like value="keyword" />
-	//   OR nickname like <like value="keyword" />
-	//   OR fields->>'$.<print value="constants.user_mobile" />' like <like value="keyword" />
-	//   OR fields->>'$.<print value="constants.user_email" />' like <like value="keyword" /></if>
-	//   </where>
-	Count(ctx context.Context, departmentID int64, tagID int64, tag, keyword string, deleted sql.NullBool) (int64, error)
+	Count(ctx context.Context, departmentID int64, scopeDepartmentIDs []int64, tagID int64, tag, keyword string, deleted sql.NullBool) (int64, error)
 
 	// @default SELECT * from <tablename /> <where>
 	//   <if test="departmentID &gt; 0" >department_id = #{departmentID} AND </if>
-	//   <if test="tagID &gt; 0" >id in (select user_id from <tablename type="Employee2Tag" as="e2t" /> where e2t.tag_id =#{tagID})) AND </if>
-	//   <if test="isNotEmpty(tag)" ><include refid="tagQuery" /></if>
-	//   <if test="deleted.Valid"><if test="deleted.Bool">deleted_at IS NOT NULL AND<else/>deleted_at IS NULL AND</if></if>
-	//   <if test="isNotEmpty(keyword)">
-	//   name like <like value="keyword" />
-	//   OR nickname like <like value="keyword" />
-	//   OR fields->>'<print value="constants.user_mobile" />' like <like value="keyword" />
-	//   OR fields->>'<print value="constants.user_email" />' like <like value="keyword" />
-	//   </if>
-	//   </where>
-	// <pagination /> <sort_by />
-	// @mysql SELECT * from <tablename /> <where>
-	//   <if test="departmentID &gt; 0" >department_id = #{departmentID} AND </if>
-	//   <if test="tagID &gt; 0" >id in (select user_id from <tablename type="Employee2Tag" as="e2t" /> where e2t.tag_id =#{tagID})) AND </if>
+	//   <if test="isNotEmpty(scopeDepartmentIDs)" >department_id in (<foreach collection="scopeDepartmentIDs" item="item" separator="," >#{item}</foreach>) AND </if>
+	//   <if test="tagID &gt; 0" >id in (select employee_id from <tablename type="Employee2Tag" as="e2t" /> where e2t.tag_id = #{tagID}) AND </if>
 	//   <if test="isNotEmpty(tag)" ><include refid="tagQuery" /></if>
 	//   <if test="deleted.Valid"><if test="deleted.Bool">deleted_at IS NOT NULL AND<else/>deleted_at IS NULL AND</if></if>
-	//   <if test="isNotEmpty(keyword)">
-	//   name like <like value="keyword" />
-	//   OR nickname like <like value="keyword" />
-	//   OR fields->>'$.<print value="constants.user_mobile" />' like <like value="keyword" />
-	//   OR fields->>'$.<print value="constants.user_email" />' like <like value="keyword" /></if>
+	//   <if test="isNotEmpty(keyword)">(<include refid="keywordQuery" />)</if>
 	//   </where>
 	// <pagination /> <sort_by />
-	List(ctx context.Context, departmentID int64, tagID int64, tag, keyword string, deleted sql.NullBool, sort string, offset, limit int64) ([]Employee, error)
+	List(ctx context.Context, departmentID int64, scopeDepartmentIDs []int64, tagID int64, tag, keyword string, deleted sql.NullBool, sort string, offset, limit int64) ([]Employee, error)
 	FindByIDList(ctx context.Context, id []int64) ([]Employee, error)
 
 	// @default SELECT u.id as user_id, emp.id as employee_id, u.nickname as user_nickname, emp.nickname as employee_nickname, u.department_id as user_department_id, emp.department_id as employee_department_id
-	// FROM <tablename type="User" alias="u" /> INNER JOIN  <tablename type="Employee" alias="emp" /> ON u.id == emp.user_id
+	// FROM <tablename type="User" alias="u" /> INNER JOIN  <tablename type="Employee" alias="emp" /> ON u.id = emp.user_id
 	GetUserEmployeeDiff(ctx context.Context) ([]booclient.UserEmployeeDiff, error)
 }
 
@@ -468,6 +753,8 @@ type EmployeeTagDao interface {
 // @gobatis.namespace boo
 type Employee2TagDao interface {
 	// @record_type Employee2Tag
+	// @sqlite INSERT INTO <tablename type="Employee2Tag" /> (employee_id, tag_id) VALUES (#{employeeID}, #{tagID})
+	//   ON CONFLICT (employee_id, tag_id) DO NOTHING
 	Upsert(ctx context.Context, employeeID, tagID int64) error
 	// @record_type Employee2Tag
 	Delete(ctx context.Context, employeeID, tagID int64) error
@@ -0,0 +1,118 @@
+package users
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn"
+)
+
+// readDataScope 按当前用户的角色计算它可以访问的部门。
+//
+// 返回的 departmentIDs 为 nil 时表示不受限制， visible 为 false 时表示
+// 当前用户不能访问任何部门的数据。用户有多个角色时取各个角色的数据范围的并集，
+// 没有角色或角色的数据范围为空时不受限制。
+func (svc UserService) readDataScope(ctx context.Context, currentUser authn.AuthUser) (departmentIDs []int64, visible bool, err error) {
+	if u, ok := currentUser.(*authUser); ok && u.isAdministrator() {
+		return nil, true, nil
+	}
+	if currentUser.HasRole(AdministratorRoleName) {
+		return nil, true, nil
+	}
+
+	roleIDs := currentUser.RoleIDs()
+	if len(roleIDs) == 0 {
+		return nil, true, nil
+	}
+	roles, err := svc.roleDao.FindByIDList(ctx, roleIDs)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "查询用户 '"+currentUser.Name()+"' 的角色失败")
+	}
+
+	var includeOwn, includeChildren bool
+	var customRoleIDs []int64
+	for idx := range roles {
+		switch roles[idx].DataScope {
+		case "", booclient.DataScopeAll:
+			return nil, true, nil
+		case booclient.DataScopeDepartment:
+			includeOwn = true
+		case booclient.DataScopeDepartmentAndChildren:
+			includeChildren = true
+		case booclient.DataScopeCustom:
+			customRoleIDs = append(customRoleIDs, roles[idx].ID)
+		}
+	}
+
+	departmentID, _ := currentUser.Data(ctx, "department_id").(int64)
+	if departmentID > 0 {
+		if includeChildren {
+			departmentIDs, err = svc.departmentDao.QuerySubtreeIDList(ctx, []int64{departmentID})
+			if err != nil {
+				return nil, false, errors.Wrap(err, "查询用户 '"+currentUser.Name()+"' 所在部门的下级部门失败")
+			}
+		} else if includeOwn {
+			departmentIDs = []int64{departmentID}
+		}
+	}
+
+	if len(customRoleIDs) > 0 {
+		customIDs, err := svc.roleDepartmentDao.QueryByRoleIDList(ctx, customRoleIDs)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "查询用户 '"+currentUser.Name()+"' 的自定义数据范围失败")
+		}
+		for _, id := range customIDs {
+			if !containsInt64(departmentIDs, id) {
+				departmentIDs = append(departmentIDs, id)
+			}
+		}
+	}
+	return departmentIDs, len(departmentIDs) > 0, nil
+}
+
+// readDataScopeFilter 同 readDataScope，返回一个判断部门的数据是否在当前用户的数据范围内的函数
+func (svc UserService) readDataScopeFilter(ctx context.Context, currentUser authn.AuthUser) (func(departmentID int64) bool, error) {
+	departmentIDs, visible, err := svc.readDataScope(ctx, currentUser)
+	if err != nil {
+		return nil, err
+	}
+	return func(departmentID int64) bool {
+		if !visible {
+			return false
+		}
+		return departmentIDs == nil || containsInt64(departmentIDs, departmentID)
+	}, nil
+}
+
+// checkDataScope 检查部门 departmentID 的数据是否在当前用户的数据范围内，不在时返回 404 错误，
+// 这样当前用户无法知道数据范围之外的记录是否存在
+func (svc UserService) checkDataScope(ctx context.Context, currentUser authn.AuthUser, departmentID int64, msg string) error {
+	inScope, err := svc.readDataScopeFilter(ctx, currentUser)
+	if err != nil {
+		return err
+	}
+	if !inScope(departmentID) {
+		return errDataScopeNotFound(msg)
+	}
+	return nil
+}
+
+// errDataScopeForbidden 是新建数据或将数据移到当前用户的数据范围之外的部门时的错误
+func errDataScopeForbidden(msg string) error {
+	return errors.WithCode(errors.New(msg), http.StatusForbidden)
+}
+
+func errDataScopeNotFound(msg string) error {
+	return errors.WithCode(errors.Wrap(errors.ErrNotFound, msg), http.StatusNotFound)
+}
+
+func containsInt64(list []int64, value int64) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package users_test
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"testing"
+
+	"github.com/boo-admin/boo/app_tests"
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn"
+)
+
+func TestUserDataScope(t *testing.T) {
+	app := app_tests.NewTestApp(t, nil)
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	departments := booclient.NewRemoteDepartments(pxy)
+	roles := booclient.NewRemoteRoles(pxy)
+	users := booclient.NewRemoteUsers(pxy)
+
+	parentID, err := departments.Create(ctx, &booclient.Department{Name: "dstest_parent"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	childID, err := departments.Create(ctx, &booclient.Department{Name: "dstest_child", ParentID: parentID})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	otherID, err := departments.Create(ctx, &booclient.Department{Name: "dstest_other"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	roleID, err := roles.Create(ctx, &booclient.Role{
+		Title:     "dstest_role",
+		DataScope: booclient.DataScopeDepartmentAndChildren,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = roles.GrantPermissions(ctx, roleID, []string{authn.OpViewUser, authn.OpCreateUser})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, u := range []booclient.User{
+		{Name: "dstest_viewer", Nickname: "dstest_viewer", Password: "Abcd!12345", DepartmentID: parentID, Roles: []booclient.Role{{Title: "dstest_role"}}},
+		{Name: "dstest_child", Nickname: "dstest_child", DepartmentID: childID},
+		{Name: "dstest_other", Nickname: "dstest_other", DepartmentID: otherID},
+	} {
+		_, err = users.Create(ctx, &u)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	viewerPxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	viewerPxy.SetBasicAuth("dstest_viewer", "Abcd!12345")
+	viewerUsers := booclient.NewRemoteUsers(viewerPxy)
+
+	assertVisible := func(expected ...string) {
+		t.Helper()
+
+		list, err := viewerUsers.List(ctx, 0, "", "", "dstest", sql.NullBool{}, nil, "", 0, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var names []string
+		for _, u := range list {
+			names = append(names, u.Name)
+		}
+		sort.Strings(names)
+		sort.Strings(expected)
+		if len(names) != len(expected) {
+			t.Error("want", expected, "got", names)
+			return
+		}
+		for idx := range names {
+			if names[idx] != expected[idx] {
+				t.Error("want", expected, "got", names)
+				return
+			}
+		}
+
+		count, err := viewerUsers.Count(ctx, 0, "", "", "dstest", sql.NullBool{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if count != int64(len(expected)) {
+			t.Error("want", len(expected), "got", count)
+		}
+	}
+
+	assertVisible("dstest_viewer", "dstest_child")
+
+	// 查询单个用户时也受数据范围的限制
+	if _, err := viewerUsers.FindByName(ctx, "dstest_child"); err != nil {
+		t.Error(err)
+	}
+	other, err := viewerUsers.FindByName(ctx, "dstest_other")
+	if err == nil {
+		t.Error("want error got", other)
+	}
+
+	err = roles.UpdateByID(ctx, roleID, &booclient.Role{
+		Title:     "dstest_role",
+		DataScope: booclient.DataScopeDepartment,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assertVisible("dstest_viewer")
+
+	err = roles.UpdateByID(ctx, roleID, &booclient.Role{
+		Title:     "dstest_role",
+		DataScope: booclient.DataScopeCustom,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = roles.SetDataDepartments(ctx, roleID, []int64{otherID})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	assertVisible("dstest_other")
+
+	departmentIDs, err := roles.GetDataDepartments(ctx, roleID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(departmentIDs) != 1 || departmentIDs[0] != otherID {
+		t.Error("want", []int64{otherID}, "got", departmentIDs)
+	}
+
+	// 新建用户时也受数据范围的限制
+	_, err = viewerUsers.Create(ctx, &booclient.User{Name: "dstest_create_parent", Nickname: "dstest_create_parent", DepartmentID: parentID})
+	if err == nil {
+		t.Error("want error got ok")
+	}
+	_, err = viewerUsers.Create(ctx, &booclient.User{Name: "dstest_create_other", Nickname: "dstest_create_other", DepartmentID: otherID})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = roles.UpdateByID(ctx, roleID, &booclient.Role{
+		Title:     "dstest_role",
+		DataScope: "notexists",
+	})
+	if err == nil {
+		t.Error("want error got ok")
+	}
+}
@@ -2,11 +2,16 @@ package users
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/boo-admin/boo/booclient"
 	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/goutils/importer"
 	"github.com/boo-admin/boo/services/authn"
 	"github.com/boo-admin/boo/validation"
 	"github.com/google/uuid"
@@ -25,13 +30,15 @@ func NewDepartmentDaoWith(ref gobatis.SqlSession) DepartmentDao {
 
 func NewDepartments(env *booclient.Environment,
 	db *gobatis.SessionFactory,
-	operationLogger OperationLogger) (booclient.Departments, error) {
+	users *UserService,
+	operationLogger OperationLogger) (Departments, error) {
 	return departmentService{
 		env:             env,
 		logger:          env.Logger.WithGroup("departments"),
 		operationLogger: operationLogger,
 		db:              db,
 		dao:             NewDepartmentDaoWith(db.SessionReference()),
+		fields:          users.departmentFields,
 	}, nil
 }
 
@@ -41,6 +48,7 @@ type departmentService struct {
 	operationLogger OperationLogger
 	db              *gobatis.SessionFactory
 	dao             DepartmentDao
+	fields          *customFieldSet
 }
 
 func (svc departmentService) Create(ctx context.Context, department *Department) (int64, error) {
@@ -66,6 +74,7 @@ func (svc departmentService) Create(ctx context.Context, department *Department)
 	} else if exists {
 		v.Error("name", "无法新建部门 '"+department.Name+"'，该部门已存在")
 	}
+	validateCustomFields(v, svc.fields.Get(), department.Fields)
 	if v.HasErrors() {
 		return 0, v.ToError()
 	}
@@ -75,7 +84,7 @@ func (svc departmentService) Create(ctx context.Context, department *Department)
 		return 0, err
 	}
 
-	svc.logCreate(ctx, nil, currentUser, id, department)
+	svc.logCreate(ctx, nil, currentUser, id, department, actionNormal)
 	return id, nil
 }
 
@@ -94,6 +103,7 @@ func (svc departmentService) UpdateByID(ctx context.Context, id int64, departmen
 	if department.Name == "" {
 		v.Error("name", "无法新建部门 '"+department.Name+"'，该部门名为空")
 	}
+	validateCustomFields(v, svc.fields.Get(), department.Fields)
 	if v.HasErrors() {
 		return v.ToError()
 	}
@@ -119,13 +129,21 @@ func (svc departmentService) UpdateByID(ctx context.Context, id int64, departmen
 		v.Error("uuid", "部门的 'uuid' 不可修改")
 		return v.ToError()
 	}
+	if department.ParentID > 0 && department.ParentID != old.ParentID {
+		if err := svc.checkParent(ctx, v, id, department.ParentID, department.Name); err != nil {
+			return err
+		}
+		if v.HasErrors() {
+			return v.ToError()
+		}
+	}
 
 	err = svc.dao.UpdateByID(ctx, id, department)
 	if err != nil {
 		return err
 	}
 
-	svc.logUpdate(ctx, nil, currentUser, id, department, old)
+	svc.logUpdate(ctx, nil, currentUser, id, department, old, actionNormal)
 	return nil
 }
 func (svc departmentService) DeleteByID(ctx context.Context, id int64) error {
@@ -227,6 +245,614 @@ func (svc departmentService) GetTree(ctx context.Context) ([]*Department, error)
 	return toDepartmentsTree(results), nil
 }
 
+// checkParent 检查 parentID 能否作为部门 id 的上级部门，它不能是部门自己或它的下级部门
+func (svc departmentService) checkParent(ctx context.Context, v *validation.Validation, id, parentID int64, name string) error {
+	if parentID == id {
+		v.Error("parent_id", "无法移动部门 '"+name+"'，上级部门不能是它自己")
+		return nil
+	}
+	if _, err := svc.dao.FindByID(ctx, parentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			v.Error("parent_id", "无法移动部门 '"+name+"'，上级部门 '"+strconv.FormatInt(parentID, 10)+"' 不存在")
+			return nil
+		}
+		return errors.Wrap(err, "查询部门 '"+strconv.FormatInt(parentID, 10)+"' 失败")
+	}
+
+	subtree, err := svc.dao.QuerySubtreeIDList(ctx, []int64{id})
+	if err != nil {
+		return errors.Wrap(err, "查询部门 '"+name+"' 的下级部门失败")
+	}
+	for _, subID := range subtree {
+		if subID == parentID {
+			v.Error("parent_id", "无法移动部门 '"+name+"'，上级部门不能是它的下级部门")
+			return nil
+		}
+	}
+	return nil
+}
+
+func (svc departmentService) Move(ctx context.Context, id, newParentID int64, position int) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpUpdateDepartment); err != nil {
+		return errors.Wrap(err, "判断当前部门是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpUpdateDepartment)
+	}
+
+	old, err := svc.dao.FindByID(ctx, id)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return errors.WithCode(errors.New("部门 '"+strconv.FormatInt(id, 10)+"' 不存在"), http.StatusNotFound)
+		}
+		return errors.Wrap(err, "移动部门 '"+strconv.FormatInt(id, 10)+"' 失败")
+	}
+	if newParentID < 0 {
+		newParentID = 0
+	}
+
+	v := validation.Default.New()
+	if newParentID > 0 {
+		if err := svc.checkParent(ctx, v, id, newParentID, old.Name); err != nil {
+			return err
+		}
+		if v.HasErrors() {
+			return v.ToError()
+		}
+	}
+
+	children, err := svc.dao.QueryChildren(ctx, newParentID)
+	if err != nil {
+		return errors.Wrap(err, "查询部门 '"+strconv.FormatInt(newParentID, 10)+"' 的下级部门失败")
+	}
+	siblings := make([]Department, 0, len(children)+1)
+	for idx := range children {
+		if children[idx].ID != id {
+			siblings = append(siblings, children[idx])
+		}
+	}
+	if position < 0 || position > len(siblings) {
+		position = len(siblings)
+	}
+	siblings = append(siblings, Department{})
+	copy(siblings[position+1:], siblings[position:])
+	siblings[position] = *old
+
+	return svc.db.InTx(ctx, nil, true, func(ctx context.Context, tx *gobatis.Tx) error {
+		for idx := range siblings {
+			if siblings[idx].ID != id && siblings[idx].OrderNum == idx {
+				continue
+			}
+			if err := svc.dao.UpdateParentAndOrder(ctx, siblings[idx].ID, newParentID, idx); err != nil {
+				return errors.Wrap(err, "更新部门 '"+siblings[idx].Name+"' 的顺序失败")
+			}
+		}
+
+		department := *old
+		department.ParentID = newParentID
+		department.OrderNum = position
+		svc.logUpdate(ctx, tx, currentUser, id, &department, old, actionNormal)
+		return nil
+	})
+}
+
+func (svc departmentService) Reorder(ctx context.Context, orders []DepartmentOrder) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpUpdateDepartment); err != nil {
+		return errors.Wrap(err, "判断当前部门是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpUpdateDepartment)
+	}
+	if len(orders) == 0 {
+		return nil
+	}
+
+	list, err := svc.dao.List(ctx, "", "", 0, 0)
+	if err != nil {
+		return errors.Wrap(err, "查询所有部门失败")
+	}
+	byID := map[int64]*Department{}
+	parents := map[int64]int64{}
+	for idx := range list {
+		byID[list[idx].ID] = &list[idx]
+		parents[list[idx].ID] = list[idx].ParentID
+	}
+
+	v := validation.Default.New()
+	for idx := range orders {
+		if orders[idx].ParentID < 0 {
+			orders[idx].ParentID = 0
+		}
+		if byID[orders[idx].ID] == nil {
+			v.Error("orders", "部门 '"+strconv.FormatInt(orders[idx].ID, 10)+"' 不存在")
+			continue
+		}
+		if orders[idx].ParentID > 0 && byID[orders[idx].ParentID] == nil {
+			v.Error("orders", "上级部门 '"+strconv.FormatInt(orders[idx].ParentID, 10)+"' 不存在")
+			continue
+		}
+		parents[orders[idx].ID] = orders[idx].ParentID
+	}
+	if v.HasErrors() {
+		return v.ToError()
+	}
+	for idx := range orders {
+		if isDepartmentCycle(parents, orders[idx].ID) {
+			v.Error("orders", "无法调整部门 '"+byID[orders[idx].ID].Name+"'，上级部门不能是它自己或它的下级部门")
+		}
+	}
+	if v.HasErrors() {
+		return v.ToError()
+	}
+
+	return svc.db.InTx(ctx, nil, true, func(ctx context.Context, tx *gobatis.Tx) error {
+		for idx := range orders {
+			old := byID[orders[idx].ID]
+			if err := svc.dao.UpdateParentAndOrder(ctx, old.ID, orders[idx].ParentID, orders[idx].OrderNum); err != nil {
+				return errors.Wrap(err, "更新部门 '"+old.Name+"' 的顺序失败")
+			}
+
+			department := *old
+			department.ParentID = orders[idx].ParentID
+			department.OrderNum = orders[idx].OrderNum
+			svc.logUpdate(ctx, tx, currentUser, old.ID, &department, old, actionNormal)
+		}
+		return nil
+	})
+}
+
+func (svc departmentService) GetSubtree(ctx context.Context, id int64) (*Department, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpViewDepartment); err != nil {
+		return nil, errors.Wrap(err, "判断当前部门是否有权限失败")
+	} else if !ok {
+		return nil, errors.NewOperationReject(authn.OpViewDepartment)
+	}
+
+	idList, err := svc.dao.QuerySubtreeIDList(ctx, []int64{id})
+	if err != nil {
+		return nil, errors.Wrap(err, "查询部门 '"+strconv.FormatInt(id, 10)+"' 的下级部门失败")
+	}
+	if len(idList) == 0 {
+		return nil, errors.WithCode(errors.New("部门 '"+strconv.FormatInt(id, 10)+"' 不存在"), http.StatusNotFound)
+	}
+	list, err := svc.dao.FindByIDList(ctx, idList)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询部门 '"+strconv.FormatInt(id, 10)+"' 的下级部门失败")
+	}
+
+	// 以 id 为根的子树中，只有 id 的上级部门不在 list 中，所以它一定会是 roots 中的一个
+	for _, root := range toDepartmentsTree(list) {
+		if root.ID == id {
+			return root, nil
+		}
+	}
+	return nil, errors.WithCode(errors.New("部门 '"+strconv.FormatInt(id, 10)+"' 不存在"), http.StatusNotFound)
+}
+
+func (svc departmentService) GetAncestors(ctx context.Context, id int64) ([]Department, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpViewDepartment); err != nil {
+		return nil, errors.Wrap(err, "判断当前部门是否有权限失败")
+	} else if !ok {
+		return nil, errors.NewOperationReject(authn.OpViewDepartment)
+	}
+
+	list, err := svc.dao.List(ctx, "", "", 0, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询所有部门失败")
+	}
+	byID := map[int64]*Department{}
+	for idx := range list {
+		byID[list[idx].ID] = &list[idx]
+	}
+	current := byID[id]
+	if current == nil {
+		return nil, errors.WithCode(errors.New("部门 '"+strconv.FormatInt(id, 10)+"' 不存在"), http.StatusNotFound)
+	}
+
+	var ancestors []Department
+	visited := map[int64]bool{id: true}
+	for parentID := current.ParentID; parentID > 0; {
+		parent := byID[parentID]
+		if parent == nil || visited[parentID] {
+			break
+		}
+		visited[parentID] = true
+		ancestors = append(ancestors, *parent)
+		parentID = parent.ParentID
+	}
+	for i, j := 0, len(ancestors)-1; i < j; i, j = i+1, j-1 {
+		ancestors[i], ancestors[j] = ancestors[j], ancestors[i]
+	}
+	if ancestors == nil {
+		ancestors = []Department{}
+	}
+	return ancestors, nil
+}
+
+// departmentPath 返回部门的路径，如 '总部/研发/平台组'
+func departmentPath(byID map[int64]*Department, department *Department) string {
+	names := []string{department.Name}
+	visited := map[int64]bool{department.ID: true}
+	for parentID := department.ParentID; parentID > 0; {
+		parent := byID[parentID]
+		if parent == nil || visited[parentID] {
+			break
+		}
+		visited[parentID] = true
+		names = append(names, parent.Name)
+		parentID = parent.ParentID
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return strings.Join(names, "/")
+}
+
+func splitDepartmentPath(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, "/") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (svc departmentService) Export(ctx context.Context, format string, inline bool, sort string, offset, limit int64, writer http.ResponseWriter) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpViewDepartment); err != nil {
+		return errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpViewDepartment)
+	}
+
+	return importer.WriteHTTP(ctx, "departments", format, inline, writer,
+		importer.RecorderFunc(func(ctx context.Context) (importer.RecordIterator, []string, error) {
+			fields := svc.fields.Get()
+			all, err := svc.dao.List(ctx, "", "", 0, 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			byID := map[int64]*Department{}
+			for idx := range all {
+				byID[all[idx].ID] = &all[idx]
+			}
+
+			list := all
+			if sort != "" || offset > 0 || limit > 0 {
+				list, err = svc.dao.List(ctx, "", sort, offset, limit)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+
+			titles := []string{
+				"部门路径",
+				"部门名称",
+				"排序",
+			}
+			for _, f := range fields {
+				titles = append(titles, f.Name)
+			}
+			titles = append(titles, []string{
+				"创建时间",
+				"更新时间",
+			}...)
+			index := -1
+
+			return importer.RecorderFuncIterator{
+				CloseFunc: func() error {
+					return nil
+				},
+				NextFunc: func(ctx context.Context) bool {
+					index++
+					return index < len(list)
+				},
+				ReadFunc: func(ctx context.Context) ([]string, error) {
+					var values = make([]string, 0, 5+len(fields))
+					values = append(values, departmentPath(byID, &list[index]))
+					values = append(values, list[index].Name)
+					values = append(values, strconv.Itoa(list[index].OrderNum))
+
+					for _, f := range fields {
+						var value interface{}
+						if list[index].Fields != nil {
+							value = list[index].Fields[f.ID]
+						}
+						values = append(values, booclient.CustomFieldValueToString(f, value))
+					}
+					values = append(values,
+						formatTime(list[index].CreatedAt),
+						formatTime(list[index].UpdatedAt))
+					return values, nil
+				},
+			}, titles, nil
+		}))
+}
+
+func (svc departmentService) Import(ctx context.Context, request *http.Request) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	canCreate := false
+	if ok, err := currentUser.HasPermission(ctx, authn.OpCreateDepartment); err != nil {
+		return errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else {
+		canCreate = ok
+	}
+
+	canUpdate := false
+	if ok, err := currentUser.HasPermission(ctx, authn.OpUpdateDepartment); err != nil {
+		return errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else {
+		canUpdate = ok
+	}
+
+	mode, err := booclient.ParseUpdateMode(request.URL.Query().Get("mode"))
+	if err != nil {
+		return errors.WithCode(err, http.StatusBadRequest)
+	}
+
+	ctx = context.WithValue(ctx, importer.ContextToRealDirKey, booclient.ToRealDirFunc(svc.env))
+	reader, closer, err := importer.ReadHTTP(ctx, request)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	fields := svc.fields.Get()
+	return importer.Import(ctx, "", reader, func(ctx context.Context, lineNumber int) (importer.Row, error) {
+		record := &Department{}
+		var path []string
+		hasOrderNum := false
+
+		var columns = make([]importer.Column, 0, 3+len(fields))
+		columns = append(columns, importer.StrColumn([]string{"path", "部门路径", "路径"}, false,
+			func(ctx context.Context, lineNumber int, origin, value string) error {
+				path = splitDepartmentPath(value)
+				return nil
+			}))
+		columns = append(columns, importer.StrColumn([]string{"name", "部门名称", "部门", "名称"}, false,
+			func(ctx context.Context, lineNumber int, origin, value string) error {
+				record.Name = strings.TrimSpace(value)
+				return nil
+			}))
+		columns = append(columns, importer.StrColumn([]string{"order_num", "排序"}, false,
+			func(ctx context.Context, lineNumber int, origin, value string) error {
+				if value == "" {
+					return nil
+				}
+				i, err := strconv.Atoi(value)
+				if err != nil {
+					return errors.Wrap(err, origin+" '"+value+"' 转换失败，不可识别的值")
+				}
+				record.OrderNum = i
+				hasOrderNum = true
+				return nil
+			}))
+
+		for _, f := range fields {
+			func(f booclient.CustomField) {
+				columns = append(columns, importer.StrColumn(append([]string{f.ID, f.Name}, f.Alias...), false,
+					func(ctx context.Context, lineNumber int, origin, value string) error {
+						if record.Fields == nil {
+							record.Fields = map[string]interface{}{}
+						}
+						// 值为 nil 表示单元格为空，覆盖模式时会清除这个字段
+						if value == "" {
+							record.Fields[f.ID] = nil
+							return nil
+						}
+						v, err := booclient.ParseCustomFieldValue(f, value)
+						if err != nil {
+							return errors.Wrap(err, origin+" '"+value+"' 转换失败，不可识别的值")
+						}
+						record.Fields[f.ID] = v
+						return nil
+					}))
+			}(f)
+		}
+
+		return importer.Row{
+			Columns: columns,
+			Commit: func(ctx context.Context) error {
+				if len(path) == 0 {
+					if record.Name == "" {
+						return errors.New("第 " + strconv.Itoa(lineNumber) + " 行的部门路径和部门名称都为空")
+					}
+					path = []string{record.Name}
+				} else if record.Name != "" && record.Name != path[len(path)-1] {
+					return errors.New("部门名称 '" + record.Name + "' 与部门路径 '" + strings.Join(path, "/") + "' 不一致")
+				}
+
+				return svc.db.InTx(ctx, nil, true, func(ctx context.Context, tx *gobatis.Tx) error {
+					var parentID int64
+					for idx := 0; idx < len(path)-1; idx++ {
+						id, err := svc.importAncestor(ctx, tx, currentUser, canCreate, path[idx], parentID, strings.Join(path[:idx], "/"))
+						if err != nil {
+							return err
+						}
+						parentID = id
+					}
+					record.Name = path[len(path)-1]
+					record.ParentID = parentID
+
+					// 部门按路径中的上级部门和名称查找，部门名称是唯一的，同名的部门在其它上级部门下时
+					// 只有覆盖模式才把它移到路径指定的位置
+					old, err := svc.findChild(ctx, record.Name, parentID)
+					if err != nil {
+						return err
+					}
+					moved := false
+					if old == nil {
+						old, err = svc.dao.FindByName(ctx, record.Name)
+						if err != nil && !errors.IsNotFound(err) {
+							return errors.Wrap(err, "查询部门 '"+record.Name+"' 失败")
+						}
+						if old != nil {
+							if mode != booclient.UpdateModeOverride {
+								return errDepartmentNotInPath(record.Name, strings.Join(path[:len(path)-1], "/"))
+							}
+							moved = true
+						}
+					}
+					if old == nil {
+						if !canCreate {
+							return errors.New("没有新建部门的权限，部门 '" + record.Name + "' 没有创建")
+						}
+						for key, value := range record.Fields {
+							if value == nil {
+								delete(record.Fields, key)
+							}
+						}
+						v := validation.Default.New()
+						if validateCustomFields(v, fields, record.Fields) {
+							return v.ToError()
+						}
+						record.UUID = uuid.NewString()
+						id, err := svc.dao.Insert(ctx, record)
+						if err != nil {
+							return errors.Wrap(err, "创建部门 '"+record.Name+"' 失败")
+						}
+						svc.logCreate(ctx, tx, currentUser, id, record, actionImport)
+						return nil
+					}
+
+					if mode == booclient.UpdateModeSkip {
+						return nil
+					}
+					if !canUpdate {
+						return errors.New("没有更新部门的权限，部门 '" + record.Name + "' 没有更新")
+					}
+
+					newDepartment := *old
+					newDepartment.Fields = map[string]interface{}{}
+					for key, value := range old.Fields {
+						newDepartment.Fields[key] = value
+					}
+					for key, value := range record.Fields {
+						if value != nil {
+							newDepartment.Fields[key] = value
+						} else if mode == booclient.UpdateModeOverride {
+							delete(newDepartment.Fields, key)
+						}
+					}
+
+					v := validation.Default.New()
+					if mode == booclient.UpdateModeOverride {
+						if hasOrderNum {
+							newDepartment.OrderNum = record.OrderNum
+						}
+						if moved {
+							if parentID > 0 {
+								if err := svc.checkParent(ctx, v, old.ID, parentID, old.Name); err != nil {
+									return err
+								}
+							}
+							newDepartment.ParentID = parentID
+						}
+					}
+					if validateCustomFields(v, fields, newDepartment.Fields) {
+						return v.ToError()
+					}
+
+					err = svc.dao.UpdateByID(ctx, old.ID, &newDepartment)
+					if err != nil {
+						return errors.Wrap(err, "更新部门 '"+record.Name+"' 失败")
+					}
+					svc.logUpdate(ctx, tx, currentUser, old.ID, &newDepartment, old, actionImport)
+					return nil
+				})
+			},
+		}, nil
+	})
+}
+
+// importAncestor 查找导入路径中的上级部门，不存在时创建它
+func (svc departmentService) importAncestor(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, canCreate bool, name string, parentID int64, parentPath string) (int64, error) {
+	old, err := svc.findChild(ctx, name, parentID)
+	if err != nil {
+		return 0, err
+	}
+	if old != nil {
+		return old.ID, nil
+	}
+	if exists, err := svc.dao.NameExists(ctx, name); err != nil {
+		return 0, errors.Wrap(err, "查询部门 '"+name+"' 失败")
+	} else if exists {
+		return 0, errDepartmentNotInPath(name, parentPath)
+	}
+
+	if !canCreate {
+		return 0, errors.New("没有创建部门的权限，部门 '" + name + "' 不存在")
+	}
+	department := &Department{
+		ParentID: parentID,
+		UUID:     uuid.NewString(),
+		Name:     name,
+	}
+	id, err := svc.dao.Insert(ctx, department)
+	if err != nil {
+		return 0, errors.Wrap(err, "创建部门 '"+name+"' 失败")
+	}
+	svc.logCreate(ctx, tx, currentUser, id, department, actionImport)
+	return id, nil
+}
+
+// findChild 查找上级部门下指定名称的部门，不存在时返回 nil
+func (svc departmentService) findChild(ctx context.Context, name string, parentID int64) (*Department, error) {
+	old, err := svc.dao.FindByParentAndName(ctx, parentID, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "查询部门 '"+name+"' 失败")
+	}
+	return old, nil
+}
+
+func errDepartmentNotInPath(name, parentPath string) error {
+	if parentPath == "" {
+		return errors.New("部门 '" + name + "' 已存在，但它不是顶层部门")
+	}
+	return errors.New("部门 '" + name + "' 已存在，但它不在 '" + parentPath + "' 下")
+}
+
+// isDepartmentCycle 判断从 id 沿着上级部门向上查找时是否会回到 id
+func isDepartmentCycle(parents map[int64]int64, id int64) bool {
+	visited := map[int64]bool{}
+	for parentID := parents[id]; parentID > 0; parentID = parents[parentID] {
+		if parentID == id {
+			return true
+		}
+		if visited[parentID] {
+			return false
+		}
+		visited[parentID] = true
+	}
+	return false
+}
+
 func toDepartmentsTree(list []Department) []*Department {
 	byID := map[int64]*Department{}
 	for idx := range list {
@@ -257,7 +883,7 @@ func toDepartmentsTree(list []Department) []*Department {
 	return roots
 }
 
-func (svc departmentService) logCreate(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, id int64, department *Department) {
+func (svc departmentService) logCreate(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, id int64, department *Department, importDepartment int) {
 	if !enableOplog {
 		return
 	}
@@ -281,17 +907,24 @@ func (svc departmentService) logCreate(ctx context.Context, tx *gobatis.Tx, curr
 		DisplayName: "部门名称",
 		NewValue:    department.Name,
 	})
-	// for _, field := range svc.fields {
-	// 	fv, _ := department.Fields[field.ID]
-	// 	if fv == nil {
-	// 		continue
-	// 	}
-	// 	records = append(records, ChangeRecord{
-	// 		Name:        field.ID,
-	// 		DisplayName: field.Name,
-	// 		NewValue:    fv,
-	// 	})
-	// }
+	for _, field := range svc.fields.Get() {
+		fv := department.Fields[field.ID]
+		if fv == nil {
+			continue
+		}
+		records = append(records, ChangeRecord{
+			Name:        field.ID,
+			DisplayName: field.Name,
+			NewValue:    fv,
+		})
+	}
+
+	typeStr := authn.OpCreateDepartment
+	content := "创建部门成功"
+	if importDepartment == actionImport {
+		typeStr = "importcreatedepartment"
+		content = "导入部门成功"
+	}
 
 	oplogger := svc.operationLogger
 	if tx != nil {
@@ -301,8 +934,8 @@ func (svc departmentService) logCreate(ctx context.Context, tx *gobatis.Tx, curr
 		UserID:     currentUser.ID(),
 		Username:   currentUser.Nickname(),
 		Successful: true,
-		Type:       authn.OpCreateDepartment,
-		Content:    "创建部门成功",
+		Type:       typeStr,
+		Content:    content,
 		Fields: &OperationLogRecord{
 			ObjectType: "user",
 			ObjectID:   id,
@@ -314,7 +947,7 @@ func (svc departmentService) logCreate(ctx context.Context, tx *gobatis.Tx, curr
 	}
 }
 
-func (svc departmentService) logUpdate(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, id int64, department, old *Department) {
+func (svc departmentService) logUpdate(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, id int64, department, old *Department, importDepartment int) {
 	if !enableOplog {
 		return
 	}
@@ -355,31 +988,46 @@ func (svc departmentService) logUpdate(ctx context.Context, tx *gobatis.Tx, curr
 			NewValue:    department.Name,
 		})
 	}
+	if department.OrderNum != old.OrderNum {
+		records = append(records, ChangeRecord{
+			Name:        "order_num",
+			DisplayName: "排序",
+			OldValue:    old.OrderNum,
+			NewValue:    department.OrderNum,
+		})
+	}
 
-	// for _, field := range svc.fields {
-	// 	var oldfv, newfv interface{}
-	// 	if len(old.Fields) > 0 {
-	// 		oldfv = old.Fields[field.ID]
-	// 	}
-	// 	if len(department.Fields) > 0 {
-	// 		newfv = department.Fields[field.ID]
-	// 	}
-	// 	if oldfv == nil && newfv == nil {
-	// 		continue
-	// 	}
-	// 	if oldfv != nil && newfv != nil {
-	// 		if fmt.Sprint(oldfv) == fmt.Sprint(newfv) {
-	// 			continue
-	// 		}
-	// 	}
-	//
-	// 	records = append(records, ChangeRecord{
-	// 		Name:        field.ID,
-	// 		DisplayName: field.Name,
-	// 		OldValue:    oldfv,
-	// 		NewValue:    newfv,
-	// 	})
-	// }
+	for _, field := range svc.fields.Get() {
+		var oldfv, newfv interface{}
+		if len(old.Fields) > 0 {
+			oldfv = old.Fields[field.ID]
+		}
+		if len(department.Fields) > 0 {
+			newfv = department.Fields[field.ID]
+		}
+		if oldfv == nil && newfv == nil {
+			continue
+		}
+		if oldfv != nil && newfv != nil {
+			if fmt.Sprint(oldfv) == fmt.Sprint(newfv) {
+				continue
+			}
+		}
+
+		records = append(records, ChangeRecord{
+			Name:        field.ID,
+			DisplayName: field.Name,
+			OldValue:    oldfv,
+			NewValue:    newfv,
+		})
+	}
+
+	typeStr := authn.OpUpdateDepartment
+	content := "更新部门成功"
+	if importDepartment == actionImport {
+		typeStr = "importupdatedepartment"
+		content = "导入部门成功"
+	}
 
 	oplogger := svc.operationLogger
 	if tx != nil {
@@ -389,8 +1037,8 @@ func (svc departmentService) logUpdate(ctx context.Context, tx *gobatis.Tx, curr
 		UserID:     currentUser.ID(),
 		Username:   currentUser.Nickname(),
 		Successful: true,
-		Type:       authn.OpUpdateDepartment,
-		Content:    "更新部门成功",
+		Type:       typeStr,
+		Content:    content,
 		Fields: &OperationLogRecord{
 			ObjectType: "department",
 			ObjectID:   id,
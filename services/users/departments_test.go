@@ -0,0 +1,217 @@
+package users_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/boo-admin/boo/app_tests"
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/goutils/importer"
+)
+
+func TestDepartmentMove(t *testing.T) {
+	app := app_tests.NewTestApp(t, nil)
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	departments := booclient.NewRemoteDepartments(pxy)
+
+	rootID, err := departments.Create(ctx, &booclient.Department{Name: "movetest_root"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	childID, err := departments.Create(ctx, &booclient.Department{Name: "movetest_child", ParentID: rootID})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	grandchildID, err := departments.Create(ctx, &booclient.Department{Name: "movetest_grandchild", ParentID: childID})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	otherID, err := departments.Create(ctx, &booclient.Department{Name: "movetest_other", ParentID: rootID})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = departments.Move(ctx, rootID, grandchildID, 0)
+	if err == nil {
+		t.Error("want error got ok")
+	}
+	err = departments.UpdateByID(ctx, childID, &booclient.Department{Name: "movetest_child", ParentID: grandchildID})
+	if err == nil {
+		t.Error("want error got ok")
+	}
+	err = departments.Reorder(ctx, []booclient.DepartmentOrder{
+		{ID: rootID, ParentID: childID},
+	})
+	if err == nil {
+		t.Error("want error got ok")
+	}
+
+	err = departments.Move(ctx, 9999999, rootID, 0)
+	if code := errors.GetHttpCode(err); code != http.StatusNotFound {
+		t.Error("want 404 got", code, err)
+	}
+
+	err = departments.Move(ctx, otherID, rootID, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	subtree, err := departments.GetSubtree(ctx, rootID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(subtree.Children) != 2 || subtree.Children[0].ID != otherID || subtree.Children[1].ID != childID {
+		t.Error("want [other, child] got", subtree.Children)
+		return
+	}
+	if len(subtree.Children[1].Children) != 1 || subtree.Children[1].Children[0].ID != grandchildID {
+		t.Error("want [grandchild] got", subtree.Children[1].Children)
+	}
+
+	err = departments.Move(ctx, grandchildID, otherID, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ancestors, err := departments.GetAncestors(ctx, grandchildID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(ancestors) != 2 || ancestors[0].ID != rootID || ancestors[1].ID != otherID {
+		t.Error("want [root, other] got", ancestors)
+	}
+
+	err = departments.Reorder(ctx, []booclient.DepartmentOrder{
+		{ID: childID, ParentID: rootID, OrderNum: 0},
+		{ID: otherID, ParentID: rootID, OrderNum: 1},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	subtree, err = departments.GetSubtree(ctx, rootID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(subtree.Children) != 2 || subtree.Children[0].ID != childID || subtree.Children[1].ID != otherID {
+		t.Error("want [child, other] got", subtree.Children)
+	}
+}
+
+func TestDepartmentImport(t *testing.T) {
+	app := app_tests.NewTestApp(t, nil)
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	departments := booclient.NewRemoteDepartments(pxy)
+
+	urlstr, err := url.JoinPath(app.BaseURL(), "departments/import")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	uploadWithMode := func(t testing.TB, mode, content string) (int, string) {
+		request, err := importer.NewUploadRequest(urlstr+"?mode="+mode, nil, "file", "departments.csv", strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.SetBasicAuth("admin", "admin")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		bs, _ := ioutil.ReadAll(response.Body)
+		return response.StatusCode, string(bs)
+	}
+	upload := func(t testing.TB, content string) {
+		code, body := uploadWithMode(t, "override", content)
+		if code != http.StatusOK && code != http.StatusCreated {
+			t.Fatal(code, body)
+		}
+	}
+
+	upload(t, "部门路径,排序\n"+
+		"总部/研发/平台组,1\n"+
+		"总部/研发/应用组,0\n"+
+		"总部/市场,2\n")
+
+	root, err := departments.FindByName(ctx, "总部")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	subtree, err := departments.GetSubtree(ctx, root.ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(subtree.Children) != 2 || subtree.Children[0].Name != "研发" || subtree.Children[1].Name != "市场" {
+		t.Error("want [研发, 市场] got", subtree.Children)
+		return
+	}
+	if children := subtree.Children[0].Children; len(children) != 2 ||
+		children[0].Name != "应用组" || children[1].Name != "平台组" {
+		t.Error("want [应用组, 平台组] got", children)
+	}
+
+	// 平台组移到市场下
+	upload(t, "部门路径\n总部/市场/平台组\n")
+
+	ancestors, err := departments.GetAncestors(ctx, subtree.Children[0].Children[1].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(ancestors) != 2 || ancestors[0].Name != "总部" || ancestors[1].Name != "市场" {
+		t.Error("want [总部, 市场] got", ancestors)
+	}
+
+	// 不是覆盖模式时，不会更新或移动其它上级部门下的同名部门
+	if code, _ := uploadWithMode(t, "add", "部门路径\n总部/研发/平台组\n"); code == http.StatusOK || code == http.StatusCreated {
+		t.Error("want error got", code)
+	}
+	ancestors, err = departments.GetAncestors(ctx, subtree.Children[0].Children[1].ID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(ancestors) != 2 || ancestors[1].Name != "市场" {
+		t.Error("want [总部, 市场] got", ancestors)
+	}
+}
@@ -4,9 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 
@@ -19,7 +17,6 @@ import (
 	gobatis "github.com/runner-mei/GoBatis"
 	"golang.org/x/exp/slog"
 
-	"github.com/hjson/hjson-go/v4"
 )
 
 var NewEmployeeDaoHook func(ref gobatis.SqlSession) EmployeeDao
@@ -53,25 +50,6 @@ func NewEmployees(env *booclient.Environment,
 	db *gobatis.SessionFactory,
 	users *UserService,
 	operationLogger OperationLogger) (Employees, error) {
-	var fields []CustomField
-	if s := env.Config.StringWithDefault("employeecustomfields", ""); s != "" {
-		filename := booclient.GetRealDir(context.Background(), env, s)
-		bs, err := ioutil.ReadFile(filename)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				return nil, errors.Wrap(err, "加载员工字段的配置失败")
-			}
-		} else if err = hjson.Unmarshal(bs, &fields); err != nil {
-			return nil, errors.Wrap(err, "加载员工字段的配置失败")
-		}
-
-		if fields == nil {
-			fields = booclient.DefaultEmployeeFields
-		}
-	} else {
-		fields = booclient.DefaultEmployeeFields
-	}
-
 	sess := db.SessionReference()
 	return employeeService{
 		env:             env,
@@ -83,7 +61,7 @@ func NewEmployees(env *booclient.Environment,
 		employeeTagDao:  NewEmployeeTagDaoWith(sess),
 		employee2TagDao: NewEmployee2TagDaoWith(sess),
 		users:           users,
-		fields:          fields,
+		fields:          users.employeeFields,
 	}, nil
 }
 
@@ -99,13 +77,13 @@ type employeeService struct {
 	employeeTagDao      EmployeeTagDao
 	employee2TagDao     Employee2TagDao
 	users               *UserService
-	fields              []CustomField
+	fields              *customFieldSet
 }
 
 func (svc employeeService) ValidateEmployee(v *validation.Validation, employee *Employee) bool {
 	v.Required("name", employee.Name)
 	v.Required("nickname", employee.Nickname)
-	return v.HasErrors()
+	return validateCustomFields(v, svc.fields.Get(), employee.Fields)
 }
 
 func (svc employeeService) Create(ctx context.Context, employee *Employee) (int64, error) {
@@ -118,6 +96,13 @@ func (svc employeeService) Create(ctx context.Context, employee *Employee) (int6
 	} else if !ok {
 		return 0, errors.NewOperationReject(authn.OpCreateEmployee)
 	}
+	inScope, err := svc.users.readDataScopeFilter(ctx, currentUser)
+	if err != nil {
+		return 0, err
+	}
+	if !inScope(employee.DepartmentID) {
+		return 0, errDataScopeForbidden("新建员工 '" + employee.Name + "' 失败，不能在数据范围之外的部门中新建员工")
+	}
 	return svc.insert(ctx, currentUser, employee, actionNormal)
 }
 
@@ -172,6 +157,16 @@ func (svc employeeService) UpdateByID(ctx context.Context, id int64, employee *E
 	if err != nil {
 		return errors.Wrap(err, "更新员工 '"+strconv.FormatInt(id, 10)+"' 失败")
 	}
+	inScope, err := svc.users.readDataScopeFilter(ctx, currentUser)
+	if err != nil {
+		return err
+	}
+	if !inScope(old.DepartmentID) {
+		return errDataScopeNotFound("更新员工 '" + strconv.FormatInt(id, 10) + "' 失败")
+	}
+	if !inScope(employee.DepartmentID) {
+		return errDataScopeForbidden("更新员工 '" + old.Name + "' 失败，不能将员工移到数据范围之外的部门")
+	}
 	return svc.update(ctx, currentUser, id, employee, old, mode, actionNormal)
 }
 
@@ -388,6 +383,9 @@ func (svc employeeService) DeleteByID(ctx context.Context, id int64, force bool)
 	if err != nil {
 		return errors.Wrap(err, "删除员工时，查询员工 '"+strconv.FormatInt(id, 10)+"' 失败")
 	}
+	if err := svc.users.checkDataScope(ctx, currentUser, old.DepartmentID, "删除员工时，查询员工 '"+strconv.FormatInt(id, 10)+"' 失败"); err != nil {
+		return err
+	}
 	return svc.db.InTx(ctx, nil, true, func(ctx context.Context, tx *gobatis.Tx) error {
 		if !force {
 			if newName := AddDeleteSuffix(old.Name); newName != old.Name {
@@ -424,8 +422,15 @@ func (svc employeeService) DeleteBatch(ctx context.Context, idlist []int64, forc
 	if err != nil {
 		return errors.Wrap(err, "删除员工时，查询员工失败")
 	}
+	inScope, err := svc.users.readDataScopeFilter(ctx, currentUser)
+	if err != nil {
+		return err
+	}
 	var newList = make([]int64, 0, len(oldList))
 	for _, old := range oldList {
+		if !inScope(old.DepartmentID) {
+			return errDataScopeNotFound("删除员工时，查询员工 '" + strconv.FormatInt(old.ID, 10) + "' 失败")
+		}
 		newList = append(newList, old.ID)
 	}
 
@@ -471,6 +476,9 @@ func (svc employeeService) FindByID(ctx context.Context, id int64, includes ...s
 	if err != nil {
 		return nil, errors.Wrap(err, "查询员工失败")
 	}
+	if err := svc.users.checkDataScope(ctx, currentUser, employee.DepartmentID, "查询员工失败"); err != nil {
+		return nil, err
+	}
 
 	includes = splitIncludes(includes, GetEmployeeAllIncludes())
 	return svc.loadEmployee(ctx, employee, includes)
@@ -491,6 +499,9 @@ func (svc employeeService) FindByName(ctx context.Context, name string, includes
 	if err != nil {
 		return nil, errors.Wrap(err, "查询员工失败")
 	}
+	if err := svc.users.checkDataScope(ctx, currentUser, employee.DepartmentID, "查询员工失败"); err != nil {
+		return nil, err
+	}
 
 	includes = splitIncludes(includes, GetEmployeeAllIncludes())
 	return svc.loadEmployee(ctx, employee, includes)
@@ -528,7 +539,18 @@ func (svc employeeService) Count(ctx context.Context, departmentID int64, tag, k
 	// case "__class_nonsupport":
 	// 	tag = ""
 	// }
-	return svc.employeeDao.Count(ctx, departmentID, 0, tag, keyword, deleted)
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	scopeDepartmentIDs, visible, err := svc.users.readDataScope(ctx, currentUser)
+	if err != nil {
+		return 0, err
+	}
+	if !visible {
+		return 0, nil
+	}
+	return svc.employeeDao.Count(ctx, departmentID, scopeDepartmentIDs, 0, tag, keyword, deleted)
 }
 func (svc employeeService) List(ctx context.Context, departmentID int64, tag, keyword string, deleted sql.NullBool, includes []string, sort string, offset, limit int64) ([]Employee, error) {
 	currentUser, err := authn.ReadUserFromContext(ctx)
@@ -540,8 +562,15 @@ func (svc employeeService) List(ctx context.Context, departmentID int64, tag, ke
 	} else if !ok {
 		return nil, errors.NewOperationReject(authn.OpViewEmployee)
 	}
+	scopeDepartmentIDs, visible, err := svc.users.readDataScope(ctx, currentUser)
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return []Employee{}, nil
+	}
 
-	list, err := svc.employeeDao.List(ctx, departmentID, 0, tag, keyword, deleted, sort, offset, limit)
+	list, err := svc.employeeDao.List(ctx, departmentID, scopeDepartmentIDs, 0, tag, keyword, deleted, sort, offset, limit)
 	if err != nil {
 		return nil, errors.Wrap(err, "查询员工列表失败")
 	}
@@ -764,12 +793,21 @@ func (svc employeeService) Export(ctx context.Context, format string, inline boo
 	} else if !ok {
 		return errors.NewOperationReject(authn.OpViewEmployee)
 	}
+	scopeDepartmentIDs, visible, err := svc.users.readDataScope(ctx, currentUser)
+	if err != nil {
+		return err
+	}
 
 	return importer.WriteHTTP(ctx, "employeeDao", format, inline, writer,
 		importer.RecorderFunc(func(ctx context.Context) (importer.RecordIterator, []string, error) {
-			list, err := svc.employeeDao.List(ctx, 0, 0, "", "", sql.NullBool{Valid: true}, sort, offset, limit)
-			if err != nil {
-				return nil, nil, err
+			fields := svc.fields.Get()
+			var list []Employee
+			var err error
+			if visible {
+				list, err = svc.employeeDao.List(ctx, 0, scopeDepartmentIDs, 0, "", "", sql.NullBool{Valid: true}, sort, offset, limit)
+				if err != nil {
+					return nil, nil, err
+				}
 			}
 			titles := []string{
 				"员工名",
@@ -777,7 +815,7 @@ func (svc employeeService) Export(ctx context.Context, format string, inline boo
 				"部门",
 				"标签",
 			}
-			for _, f := range svc.fields {
+			for _, f := range fields {
 				titles = append(titles, f.Name)
 			}
 			titles = append(titles, []string{
@@ -830,7 +868,7 @@ func (svc employeeService) Export(ctx context.Context, format string, inline boo
 						tags = sb.String()
 					}
 
-					var values = make([]string, 0, 5+len(svc.fields))
+					var values = make([]string, 0, 5+len(fields))
 					values = append(values, list[index].Name)
 					values = append(values, list[index].Nickname)
 					if department != nil {
@@ -840,7 +878,7 @@ func (svc employeeService) Export(ctx context.Context, format string, inline boo
 					}
 					values = append(values, tags)
 
-					for _, f := range svc.fields {
+					for _, f := range fields {
 						if len(f.Values) == 0 {
 							values = append(values, list[index].GetStringWithDefault(f.ID, ""))
 						} else {
@@ -883,6 +921,11 @@ func (svc employeeService) Import(ctx context.Context, request *http.Request) er
 		canCreateDepartment = ok
 	}
 
+	inScope, err := svc.users.readDataScopeFilter(ctx, currentUser)
+	if err != nil {
+		return err
+	}
+
 	ctx = context.WithValue(ctx, importer.ContextToRealDirKey, booclient.ToRealDirFunc(svc.env))
 	reader, closer, err := importer.ReadHTTP(ctx, request)
 	if err != nil {
@@ -893,10 +936,11 @@ func (svc employeeService) Import(ctx context.Context, request *http.Request) er
 	override := request.URL.Query().Get("override") == "true"
 	departmentAutoCreate := request.URL.Query().Get("department_auto_create") == "true"
 
+	fields := svc.fields.Get()
 	return importer.Import(ctx, "", reader, func(ctx context.Context, lineNumber int) (importer.Row, error) {
 		record := &Employee{}
 
-		var columns = make([]importer.Column, 0, 5+len(svc.fields))
+		var columns = make([]importer.Column, 0, 5+len(fields))
 		columns = append(columns, importer.StrColumn([]string{"name", "用户", "用户名", "用户名称", "员工", "员工名", "员工名称"}, true,
 			func(ctx context.Context, lineNumber int, origin, value string) error {
 				record.Name = value
@@ -915,7 +959,7 @@ func (svc employeeService) Import(ctx context.Context, request *http.Request) er
 				return nil
 			}))
 
-		for _, f := range svc.fields {
+		for _, f := range fields {
 			func(f booclient.CustomField) {
 				columns = append(columns, importer.StrColumn(append([]string{f.ID, f.Name}, f.Alias...), false,
 					func(ctx context.Context, lineNumber int, origin, value string) error {
@@ -969,13 +1013,17 @@ func (svc employeeService) Import(ctx context.Context, request *http.Request) er
 				if old != nil {
 					if override {
 						err = errors.New("员工 '" + record.Name + "' 已存在")
+					} else if !inScope(old.DepartmentID) || !inScope(record.DepartmentID) {
+						err = errDataScopeForbidden("员工 '" + record.Name + "' 或它的部门不在数据范围之内，没有更新")
 					} else if canUpdate {
 						err = svc.update(ctx, currentUser, old.ID, record, old, booclient.UpdateModeAdd, actionImport)
 					} else {
 						err = errors.New("没有更新员工的权限，员工 '" + record.Name + "' 没有更新")
 					}
 				} else {
-					if canCreate {
+					if !inScope(record.DepartmentID) {
+						err = errDataScopeForbidden("员工 '" + record.Name + "' 的部门不在数据范围之内，没有创建")
+					} else if canCreate {
 						if record.Nickname == "" {
 							record.Nickname = record.Name
 						}
@@ -1022,7 +1070,7 @@ func (svc employeeService) logCreate(ctx context.Context, tx *gobatis.Tx, curren
 		DisplayName: "描述",
 		NewValue:    employee.Description,
 	})
-	for _, field := range svc.fields {
+	for _, field := range svc.fields.Get() {
 		fv := employee.Fields[field.ID]
 		if fv == nil {
 			continue
@@ -1127,7 +1175,7 @@ func (svc employeeService) logUpdate(ctx context.Context, tx *gobatis.Tx, curren
 		})
 	}
 
-	for _, field := range svc.fields {
+	for _, field := range svc.fields.Get() {
 		var oldfv, newfv interface{}
 		if len(old.Fields) > 0 {
 			oldfv = old.Fields[field.ID]
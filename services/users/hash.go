@@ -2,34 +2,189 @@ package users
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
+	"hash"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/boo-admin/boo/booclient"
 	"github.com/boo-admin/boo/errors"
+	"github.com/emmansun/gmsm/sm3"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
 )
 
+const (
+	// CfgUserPasswordHashAlg 加密新密码的算法，可以为 bcrypt, argon2id, pbkdf2-sha256, sm3
+	// 或用 RegisterPassworder 注册的算法，为空时为 bcrypt。
+	// 修改它后已有的密码仍然可以校验，并在用户下次登录时用新的算法重新加密
+	CfgUserPasswordHashAlg = "users.password_hash_alg"
+
+	AlgBcrypt       = "bcrypt"
+	AlgArgon2id     = "argon2id"
+	AlgPBKDF2SHA256 = "pbkdf2-sha256"
+	AlgSM3          = "sm3"
+)
+
+// ErrPasswordMismatch 密码不正确，所有的算法都返回它，以便和其它的错误区分开
+var ErrPasswordMismatch = bcrypt.ErrMismatchedHashAndPassword
+
+// PasswordRehasher 是 UserPassworder 的可选接口，用于判断已有的密码是否需要用当前的算法或参数重新加密
+type PasswordRehasher interface {
+	NeedsRehash(hashedPassword string) bool
+}
+
 var passwordHashers = map[string]func(env *booclient.Environment) (UserPassworder, error){}
 
+// RegisterPassworder 注册一个加密算法，为了在切换算法后还能校验已有的密码，
+// 算法生成的密文应该以 "$" + alg + "$" 开头
 func RegisterPassworder(alg string, factory func(env *booclient.Environment) (UserPassworder, error)) {
 	passwordHashers[alg] = factory
 }
 
+func init() {
+	RegisterPassworder(AlgBcrypt, func(env *booclient.Environment) (UserPassworder, error) {
+		return &userPasswordHasher{
+			cost: env.Config.IntWithDefault("users.password_hash.bcrypt.cost", bcrypt.DefaultCost),
+		}, nil
+	})
+	RegisterPassworder(AlgArgon2id, func(env *booclient.Environment) (UserPassworder, error) {
+		return &argon2idHasher{
+			time:    uint32(env.Config.IntWithDefault("users.password_hash.argon2id.time", 3)),
+			memory:  uint32(env.Config.IntWithDefault("users.password_hash.argon2id.memory", 64*1024)),
+			threads: uint8(env.Config.IntWithDefault("users.password_hash.argon2id.threads", 2)),
+		}, nil
+	})
+	RegisterPassworder(AlgPBKDF2SHA256, func(env *booclient.Environment) (UserPassworder, error) {
+		return &pbkdf2Hasher{
+			alg:        AlgPBKDF2SHA256,
+			hash:       sha256.New,
+			iterations: env.Config.IntWithDefault("users.password_hash.pbkdf2-sha256.iterations", 600000),
+		}, nil
+	})
+	RegisterPassworder(AlgSM3, func(env *booclient.Environment) (UserPassworder, error) {
+		return &pbkdf2Hasher{
+			alg:        AlgSM3,
+			hash:       sm3.New,
+			iterations: env.Config.IntWithDefault("users.password_hash.sm3.iterations", 100000),
+		}, nil
+	})
+}
+
+// NewUserPassworder 创建用配置的算法加密新密码的 UserPassworder，
+// 它校验密码时按密文中的算法标识选择算法，所以切换算法后已有的密码仍然可以校验。
+// 只有配置的算法在创建时初始化，其它算法在第一次遇到它的密文时才初始化，
+// 这样其它算法的配置有错误时不会影响启动
 func NewUserPassworder(env *booclient.Environment) (UserPassworder, error) {
-	alg := env.Config.StringWithDefault("users.password_hash_alg", "")
-	if alg != "" && alg != "default" {
-		f, ok := passwordHashers[alg]
-		if !ok {
-			return nil, errors.New("用户密码加密算法 '" + alg + "' 不支持")
-		}
-		return f(env)
+	alg := env.Config.StringWithDefault(CfgUserPasswordHashAlg, "")
+	if alg == "" || alg == "default" {
+		alg = AlgBcrypt
 	}
 
-	return &userPasswordHasher{
-		cost: bcrypt.DefaultCost,
+	factory, ok := passwordHashers[alg]
+	if !ok {
+		return nil, errors.New("用户密码加密算法 '" + alg + "' 不支持")
+	}
+	current, err := factory(env)
+	if err != nil {
+		return nil, errors.Wrap(err, "初始化用户密码加密算法 '"+alg+"' 失败")
+	}
+	return &multiPasswordHasher{
+		env:       env,
+		alg:       alg,
+		current:   current,
+		comparers: map[string]UserPassworder{alg: current},
 	}, nil
 }
 
+// identifyHash 返回生成密文的算法，无法识别时返回空。
+// 以前 bcrypt 的密文是用 hex 编码保存的，它没有算法标识
+func identifyHash(hashedPassword string) string {
+	if strings.HasPrefix(hashedPassword, "$") {
+		alg, _, _ := strings.Cut(hashedPassword[1:], "$")
+		switch alg {
+		case "2a", "2b", "2y":
+			return AlgBcrypt
+		}
+		return alg
+	}
+	if bs, err := hex.DecodeString(hashedPassword); err == nil && len(bs) > 4 && string(bs[:2]) == "$2" {
+		return AlgBcrypt
+	}
+	return ""
+}
+
+type multiPasswordHasher struct {
+	env     *booclient.Environment
+	alg     string
+	current UserPassworder
+
+	mu        sync.Mutex
+	comparers map[string]UserPassworder
+}
+
+func (h *multiPasswordHasher) Hash(ctx context.Context, password string) (string, error) {
+	return h.current.Hash(ctx, password)
+}
+
+// comparer 返回校验密文的算法，无法识别或没有注册的算法用当前的算法校验
+func (h *multiPasswordHasher) comparer(hashedPassword string) (UserPassworder, error) {
+	alg := identifyHash(hashedPassword)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c, ok := h.comparers[alg]; ok {
+		return c, nil
+	}
+	factory, ok := passwordHashers[alg]
+	if !ok {
+		return h.current, nil
+	}
+	c, err := factory(h.env)
+	if err != nil {
+		return nil, errors.Wrap(err, "初始化用户密码加密算法 '"+alg+"' 失败")
+	}
+	h.comparers[alg] = c
+	return c, nil
+}
+
+func (h *multiPasswordHasher) Compare(ctx context.Context, password, hashedPassword string) error {
+	c, err := h.comparer(hashedPassword)
+	if err != nil {
+		return err
+	}
+	return c.Compare(ctx, password, hashedPassword)
+}
+
+func (h *multiPasswordHasher) NeedsRehash(hashedPassword string) bool {
+	alg := identifyHash(hashedPassword)
+	if alg != "" && alg != h.alg {
+		return true
+	}
+	if rehasher, ok := h.current.(PasswordRehasher); ok {
+		return rehasher.NeedsRehash(hashedPassword)
+	}
+	return false
+}
+
+func generateSalt() ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "生成随机的盐值失败")
+	}
+	return salt, nil
+}
+
+var errHashInvalid = errors.New("密码的密文格式不正确")
+
+// userPasswordHasher 是 bcrypt 算法，为了兼容以前的数据，密文仍然用 hex 编码
 type userPasswordHasher struct {
 	cost int
 }
@@ -42,10 +197,171 @@ func (h *userPasswordHasher) Hash(ctx context.Context, password string) (string,
 	return hex.EncodeToString(bs), nil
 }
 
+func (h *userPasswordHasher) decode(hashedPassword string) ([]byte, error) {
+	if strings.HasPrefix(hashedPassword, "$") {
+		return []byte(hashedPassword), nil
+	}
+	return hex.DecodeString(hashedPassword)
+}
+
 func (h *userPasswordHasher) Compare(ctx context.Context, password, hashedPassword string) error {
-	hashedPwdBytes, err := hex.DecodeString(hashedPassword)
+	hashedPwdBytes, err := h.decode(hashedPassword)
 	if err != nil {
 		return err
 	}
 	return bcrypt.CompareHashAndPassword(hashedPwdBytes, []byte(password))
 }
+
+func (h *userPasswordHasher) NeedsRehash(hashedPassword string) bool {
+	hashedPwdBytes, err := h.decode(hashedPassword)
+	if err != nil {
+		return false
+	}
+	cost, err := bcrypt.Cost(hashedPwdBytes)
+	return err == nil && cost != h.cost
+}
+
+// argon2idHasher 的密文格式和 PHC 字符串格式相同，如
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type argon2idHasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+const argon2idKeyLen = 32
+
+func (h *argon2idHasher) Hash(ctx context.Context, password string) (string, error) {
+	salt, err := generateSalt()
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, argon2idKeyLen)
+	return "$" + AlgArgon2id + "$v=" + strconv.Itoa(argon2.Version) +
+		"$m=" + strconv.FormatUint(uint64(h.memory), 10) +
+		",t=" + strconv.FormatUint(uint64(h.time), 10) +
+		",p=" + strconv.FormatUint(uint64(h.threads), 10) +
+		"$" + base64.RawStdEncoding.EncodeToString(salt) +
+		"$" + base64.RawStdEncoding.EncodeToString(key), nil
+}
+
+func (h *argon2idHasher) parse(hashedPassword string) (memory, time uint32, threads uint8, salt, key []byte, err error) {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || parts[1] != AlgArgon2id || parts[2] != "v="+strconv.Itoa(argon2.Version) {
+		return 0, 0, 0, nil, nil, errHashInvalid
+	}
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		var n uint64
+		n, err = strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return 0, 0, 0, nil, nil, errHashInvalid
+		}
+		switch name {
+		case "m":
+			memory = uint32(n)
+		case "t":
+			time = uint32(n)
+		case "p":
+			if n > 255 {
+				return 0, 0, 0, nil, nil, errHashInvalid
+			}
+			threads = uint8(n)
+		default:
+			return 0, 0, 0, nil, nil, errHashInvalid
+		}
+	}
+	if memory == 0 || time == 0 || threads == 0 {
+		return 0, 0, 0, nil, nil, errHashInvalid
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return 0, 0, 0, nil, nil, errHashInvalid
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return 0, 0, 0, nil, nil, errHashInvalid
+	}
+	return memory, time, threads, salt, key, nil
+}
+
+func (h *argon2idHasher) Compare(ctx context.Context, password, hashedPassword string) error {
+	memory, time, threads, salt, key, err := h.parse(hashedPassword)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h *argon2idHasher) NeedsRehash(hashedPassword string) bool {
+	memory, time, threads, _, _, err := h.parse(hashedPassword)
+	if err != nil {
+		return false
+	}
+	return memory != h.memory || time != h.time || threads != h.threads
+}
+
+// pbkdf2Hasher 是 PBKDF2 算法，哈希函数为 sha256 或 sm3，密文格式为
+// $<alg>$i=<iterations>$<salt>$<hash>
+type pbkdf2Hasher struct {
+	alg        string
+	hash       func() hash.Hash
+	iterations int
+}
+
+const pbkdf2KeyLen = 32
+
+func (h *pbkdf2Hasher) Hash(ctx context.Context, password string) (string, error) {
+	salt, err := generateSalt()
+	if err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(password), salt, h.iterations, pbkdf2KeyLen, h.hash)
+	return "$" + h.alg + "$i=" + strconv.Itoa(h.iterations) +
+		"$" + base64.RawStdEncoding.EncodeToString(salt) +
+		"$" + base64.RawStdEncoding.EncodeToString(key), nil
+}
+
+func (h *pbkdf2Hasher) parse(hashedPassword string) (iterations int, salt, key []byte, err error) {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 5 || parts[1] != h.alg || !strings.HasPrefix(parts[2], "i=") {
+		return 0, nil, nil, errHashInvalid
+	}
+	iterations, err = strconv.Atoi(strings.TrimPrefix(parts[2], "i="))
+	if err != nil || iterations <= 0 {
+		return 0, nil, nil, errHashInvalid
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return 0, nil, nil, errHashInvalid
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(key) == 0 {
+		return 0, nil, nil, errHashInvalid
+	}
+	return iterations, salt, key, nil
+}
+
+func (h *pbkdf2Hasher) Compare(ctx context.Context, password, hashedPassword string) error {
+	iterations, salt, key, err := h.parse(hashedPassword)
+	if err != nil {
+		return err
+	}
+	other := pbkdf2.Key([]byte(password), salt, iterations, len(key), h.hash)
+	if !hmac.Equal(key, other) {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func (h *pbkdf2Hasher) NeedsRehash(hashedPassword string) bool {
+	iterations, _, _, err := h.parse(hashedPassword)
+	if err != nil {
+		return false
+	}
+	return iterations != h.iterations
+}
@@ -0,0 +1,119 @@
+package users_test
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/users"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestPassworder(t *testing.T, alg string) users.UserPassworder {
+	t.Helper()
+
+	hasher, err := users.NewUserPassworder(&booclient.Environment{
+		Config: booclient.NewConfigWith(map[string]string{
+			users.CfgUserPasswordHashAlg:                   alg,
+			"users.password_hash.bcrypt.cost":              "4",
+			"users.password_hash.argon2id.time":            "1",
+			"users.password_hash.argon2id.memory":          "1024",
+			"users.password_hash.argon2id.threads":         "1",
+			"users.password_hash.pbkdf2-sha256.iterations": "1000",
+			"users.password_hash.sm3.iterations":           "1000",
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func TestPasswordHashers(t *testing.T) {
+	ctx := context.Background()
+	algs := []string{users.AlgBcrypt, users.AlgArgon2id, users.AlgPBKDF2SHA256, users.AlgSM3}
+
+	hashes := map[string]string{}
+	for _, alg := range algs {
+		hasher := newTestPassworder(t, alg)
+		hashed, err := hasher.Hash(ctx, "Abcd!12345")
+		if err != nil {
+			t.Fatal(alg, err)
+		}
+		if alg != users.AlgBcrypt && !strings.HasPrefix(hashed, "$"+alg+"$") {
+			t.Error(alg, "algorithm isnot found in", hashed)
+		}
+		if err := hasher.Compare(ctx, "Abcd!12345", hashed); err != nil {
+			t.Error(alg, err)
+		}
+		if err := hasher.Compare(ctx, "Abcd!123456", hashed); !errors.Is(err, users.ErrPasswordMismatch) {
+			t.Error(alg, "want ErrPasswordMismatch got", err)
+		}
+		if hasher.(users.PasswordRehasher).NeedsRehash(hashed) {
+			t.Error(alg, "want false got true")
+		}
+		hashes[alg] = hashed
+	}
+
+	// 以前用 bcrypt 保存的密码是 hex 编码的，没有算法标识，它的 cost 也和当前的配置不同
+	legacy, err := bcrypt.GenerateFromPassword([]byte("Abcd!12345"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes["legacy"] = hex.EncodeToString(legacy)
+
+	// 切换算法后，已有的密码仍然可以校验，但需要重新加密
+	for _, alg := range algs {
+		hasher := newTestPassworder(t, alg)
+		for old, hashed := range hashes {
+			if err := hasher.Compare(ctx, "Abcd!12345", hashed); err != nil {
+				t.Error(alg, old, err)
+			}
+			if err := hasher.Compare(ctx, "Abcd!123456", hashed); !errors.Is(err, users.ErrPasswordMismatch) {
+				t.Error(alg, old, "want ErrPasswordMismatch got", err)
+			}
+
+			needsRehash := hasher.(users.PasswordRehasher).NeedsRehash(hashed)
+			if want := old != alg; needsRehash != want {
+				t.Error(alg, old, "want", want, "got", needsRehash)
+			}
+		}
+	}
+
+	// 参数改变后也需要重新加密
+	hasher, err := users.NewUserPassworder(&booclient.Environment{
+		Config: booclient.NewConfigWith(map[string]string{
+			users.CfgUserPasswordHashAlg:         users.AlgSM3,
+			"users.password_hash.sm3.iterations": "2000",
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasher.(users.PasswordRehasher).NeedsRehash(hashes[users.AlgSM3]) {
+		t.Error("want true got false")
+	}
+}
+
+func TestPasswordHashersLazy(t *testing.T) {
+	ctx := context.Background()
+	users.RegisterPassworder("hashtest-broken", func(env *booclient.Environment) (users.UserPassworder, error) {
+		return nil, errors.New("broken")
+	})
+
+	// 没有选择的算法不在创建时初始化，它出错时不影响启动
+	hasher := newTestPassworder(t, users.AlgBcrypt)
+	hashed, err := hasher.Hash(ctx, "Abcd!12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hasher.Compare(ctx, "Abcd!12345", hashed); err != nil {
+		t.Error(err)
+	}
+	if err := hasher.Compare(ctx, "Abcd!12345", "$hashtest-broken$abc"); err == nil || errors.Is(err, users.ErrPasswordMismatch) {
+		t.Error("want init error got", err)
+	}
+}
@@ -0,0 +1,926 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+	gobatis "github.com/runner-mei/GoBatis"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// CfgUserLdapSyncEnabled 是否启用 LDAP 目录同步，服务器的地址等和 LDAP 登录使用相同的配置
+	CfgUserLdapSyncEnabled = "users.ldap_sync.enabled"
+	// CfgUserLdapSyncInterval 定时同步的间隔，缺省为 1 小时，为 0 时只能手工同步
+	CfgUserLdapSyncInterval = "users.ldap_sync.interval"
+	// CfgUserLdapSyncRunAs 定时同步时的操作用户，操作日志中记录为这个用户，缺省为管理员 users.admin_name
+	CfgUserLdapSyncRunAs = "users.ldap_sync.run_as"
+	// CfgUserLdapSyncBindDN 和 CfgUserLdapSyncBindPassword 是查询目录时使用的帐号，为空时匿名查询
+	CfgUserLdapSyncBindDN       = "users.ldap_sync.bind_dn"
+	CfgUserLdapSyncBindPassword = "users.ldap_sync.bind_password"
+	// CfgUserLdapSyncBaseDN 查询用户的根节点，缺省为 users.ldap_base_dn
+	CfgUserLdapSyncBaseDN = "users.ldap_sync.base_dn"
+	// CfgUserLdapSyncFilter 查询用户的过滤条件，缺省为 (objectClass=person)
+	CfgUserLdapSyncFilter = "users.ldap_sync.filter"
+	// 用户名，呢称，邮箱和手机号对应的属性，缺省为 cn, displayName, mail 和 mobile
+	CfgUserLdapSyncUsernameAttr = "users.ldap_sync.username_attr"
+	CfgUserLdapSyncNicknameAttr = "users.ldap_sync.nickname_attr"
+	CfgUserLdapSyncEmailAttr    = "users.ldap_sync.email_attr"
+	CfgUserLdapSyncMobileAttr   = "users.ldap_sync.mobile_attr"
+	// CfgUserLdapSyncDepartments 是否按用户所在的 OU 同步部门，缺省为 true
+	CfgUserLdapSyncDepartments = "users.ldap_sync.departments"
+	// CfgUserLdapSyncDisableRemoved 是否禁用已经从目录中删除的用户，缺省为 true
+	CfgUserLdapSyncDisableRemoved = "users.ldap_sync.disable_removed"
+	// CfgUserLdapSyncMaxDisablePercent 一次同步最多禁用的用户占来源为 LDAP 的启用用户的百分比，缺省为 20，
+	// 超过时不禁用任何用户，防止目录查询异常时禁用大量的用户
+	CfgUserLdapSyncMaxDisablePercent = "users.ldap_sync.max_disable_percent"
+)
+
+const ldapSource = "ldap"
+
+// ldapSyncDisabledProfile 是用户的 profile 名，表示用户是被同步禁用的，
+// 只有这样的用户重新出现在目录中时才会被同步启用，管理员禁用的用户不会被启用
+const ldapSyncDisabledProfile = "ldap_sync.disabled"
+
+// LdapSync 从 LDAP 目录同步用户和部门
+type LdapSync interface {
+	booclient.LdapSync
+
+	// Run 按配置的间隔定时同步，直到 ctx 被取消，没有启用同步时直接返回
+	Run(ctx context.Context)
+}
+
+func NewLdapSync(env *booclient.Environment,
+	db *gobatis.SessionFactory,
+	users *UserService,
+	operationLogger OperationLogger) (LdapSync, error) {
+	svc := &ldapSyncService{
+		logger:          env.Logger.WithGroup("ldap_sync"),
+		operationLogger: operationLogger,
+		db:              db,
+		users:           users,
+		authUsers:       NewAuthUsers(users),
+		runAs:           env.Config.StringWithDefault(CfgUserLdapSyncRunAs, env.Config.StringWithDefault(CfgUserAdminName, "admin")),
+		enabled:         env.Config.BoolWithDefault(CfgUserLdapSyncEnabled, false),
+		interval:        env.Config.DurationWithDefault(CfgUserLdapSyncInterval, time.Hour),
+		address:         env.Config.StringWithDefault(session_core.CfgUserLdapAddress, ""),
+		tls:             env.Config.BoolWithDefault(session_core.CfgUserLdapTLS, false),
+		bindDN:          env.Config.StringWithDefault(CfgUserLdapSyncBindDN, ""),
+		bindPassword:    env.Config.PasswordWithDefault(CfgUserLdapSyncBindPassword, ""),
+		baseDN: env.Config.StringWithDefault(CfgUserLdapSyncBaseDN,
+			env.Config.StringWithDefault(session_core.CfgUserLdapBaseDN, "")),
+		filter:       env.Config.StringWithDefault(CfgUserLdapSyncFilter, "(objectClass=person)"),
+		usernameAttr: env.Config.StringWithDefault(CfgUserLdapSyncUsernameAttr, "cn"),
+		nicknameAttr: env.Config.StringWithDefault(CfgUserLdapSyncNicknameAttr, "displayName"),
+		emailAttr:    env.Config.StringWithDefault(CfgUserLdapSyncEmailAttr, "mail"),
+		mobileAttr:   env.Config.StringWithDefault(CfgUserLdapSyncMobileAttr, "mobile"),
+		groupAttr: env.Config.StringWithDefault(session_core.CfgUserLdapLoginRoleField,
+			env.Config.StringWithDefault("users.ldap_roles", "memberOf")),
+		departments:       env.Config.BoolWithDefault(CfgUserLdapSyncDepartments, true),
+		disableRemoved:    env.Config.BoolWithDefault(CfgUserLdapSyncDisableRemoved, true),
+		maxDisablePercent: env.Config.IntWithDefault(CfgUserLdapSyncMaxDisablePercent, 20),
+		groupRoles:        session_core.NewLdapGroupRoles(env),
+	}
+	for _, role := range strings.Split(env.Config.StringWithDefault(session_core.CfgUserLdapDefaultRoles, ""), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			svc.defaultRoles = append(svc.defaultRoles, role)
+		}
+	}
+	if !svc.enabled {
+		return svc, nil
+	}
+	if svc.address == "" {
+		return nil, errors.New("启用了 LDAP 目录同步，但是没有配置 '" + session_core.CfgUserLdapAddress + "'")
+	}
+	if svc.baseDN == "" {
+		return nil, errors.New("启用了 LDAP 目录同步，但是没有配置 '" + CfgUserLdapSyncBaseDN + "'")
+	}
+	if _, err := ldap.ParseDN(svc.baseDN); err != nil {
+		return nil, errors.Wrap(err, "LDAP 目录同步的根节点 '"+svc.baseDN+"' 格式不正确")
+	}
+	return svc, nil
+}
+
+type ldapSyncService struct {
+	logger          *slog.Logger
+	operationLogger OperationLogger
+	db              *gobatis.SessionFactory
+	users           *UserService
+	authUsers       *AuthUsers
+	runAs           string
+
+	enabled           bool
+	interval          time.Duration
+	address           string
+	tls               bool
+	bindDN            string
+	bindPassword      string
+	baseDN            string
+	filter            string
+	usernameAttr      string
+	nicknameAttr      string
+	emailAttr         string
+	mobileAttr        string
+	groupAttr         string
+	departments       bool
+	disableRemoved    bool
+	maxDisablePercent int
+	groupRoles        session_core.LdapGroupRoles
+	defaultRoles      []string
+
+	// mu 保证同一时间只有一个同步在执行
+	mu sync.Mutex
+}
+
+// ldapDirectoryUser 是目录中的一个用户， ous 是用户所在的 OU，从上级到下级排列
+type ldapDirectoryUser struct {
+	booclient.LdapSyncUser
+
+	ous []string
+}
+
+// ldapSyncPlan 是同步时要做的修改，diff 是给用户看的，其它的字段用于执行同步
+type ldapSyncPlan struct {
+	diff booclient.LdapSyncDiff
+
+	departmentOUs [][]string
+	newUsers      []*ldapDirectoryUser
+	updatedUsers  []ldapSyncUpdate
+	disabledUsers []*User
+}
+
+type ldapSyncUpdate struct {
+	old  *User
+	user *ldapDirectoryUser
+}
+
+func (svc *ldapSyncService) checkPermission(ctx context.Context) (authn.AuthUser, error) {
+	if !svc.enabled {
+		return nil, errors.WithCode(errors.New("没有启用 LDAP 目录同步"), http.StatusNotFound)
+	}
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpSyncLdapUsers); err != nil {
+		return nil, errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else if !ok {
+		return nil, errors.NewOperationReject(authn.OpSyncLdapUsers)
+	}
+	return currentUser, nil
+}
+
+func (svc *ldapSyncService) GetDiff(ctx context.Context) (*booclient.LdapSyncDiff, error) {
+	if _, err := svc.checkPermission(ctx); err != nil {
+		return nil, err
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	plan, err := svc.plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &plan.diff, nil
+}
+
+func (svc *ldapSyncService) Sync(ctx context.Context) (*booclient.LdapSyncDiff, error) {
+	currentUser, err := svc.checkPermission(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return svc.sync(ctx, currentUser)
+}
+
+func (svc *ldapSyncService) Run(ctx context.Context) {
+	if !svc.enabled || svc.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(svc.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			currentUser, err := svc.runAsUser(ctx)
+			if err != nil {
+				svc.logger.WarnContext(ctx, "定时同步 LDAP 目录失败", slog.Any("err", err))
+				continue
+			}
+			if _, err := svc.sync(ctx, currentUser); err != nil {
+				svc.logger.WarnContext(ctx, "定时同步 LDAP 目录失败", slog.Any("err", err))
+			}
+		}
+	}
+}
+
+// runAsUser 返回定时同步时的操作用户，这样操作日志中可以知道是谁做的修改
+func (svc *ldapSyncService) runAsUser(ctx context.Context) (authn.AuthUser, error) {
+	currentUser, err := svc.authUsers.UserByName(ctx, svc.runAs)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询定时同步的操作用户 '"+svc.runAs+"' 失败")
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpSyncLdapUsers); err != nil {
+		return nil, errors.Wrap(err, "判断定时同步的操作用户 '"+svc.runAs+"' 是否有权限失败")
+	} else if !ok {
+		return nil, errors.New("定时同步的操作用户 '" + svc.runAs + "' 没有同步 LDAP 用户的权限")
+	}
+	return currentUser, nil
+}
+
+func (svc *ldapSyncService) sync(ctx context.Context, currentUser authn.AuthUser) (*booclient.LdapSyncDiff, error) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	plan, err := svc.plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if plan.diff.IsEmpty() {
+		svc.logger.InfoContext(ctx, "LDAP 目录和系统中的用户一致，不需要同步",
+			slog.Int("skipped", len(plan.diff.Skipped)))
+		return &plan.diff, nil
+	}
+
+	// 先记录差异再同步，同步中途失败时可以知道原本要做哪些修改
+	svc.logger.InfoContext(ctx, "开始同步 LDAP 目录",
+		slog.Any("new_departments", plan.diff.NewDepartments),
+		slog.Int("new_users", len(plan.diff.NewUsers)),
+		slog.Int("updated_users", len(plan.diff.UpdatedUsers)),
+		slog.Any("disabled_users", plan.diff.DisabledUsers),
+		slog.Any("skipped", plan.diff.Skipped),
+		slog.Any("warnings", plan.diff.Warnings))
+
+	if err := svc.apply(ctx, currentUser, plan); err != nil {
+		return nil, err
+	}
+
+	svc.logger.InfoContext(ctx, "同步 LDAP 目录完成", slog.Any("errors", plan.diff.Errors))
+	svc.logSync(ctx, currentUser, &plan.diff)
+	return &plan.diff, nil
+}
+
+// searchDirectory 查询目录中所有的用户
+func (svc *ldapSyncService) searchDirectory(ctx context.Context) ([]*ldapDirectoryUser, error) {
+	conn, err := session_core.DialLdap(svc.address, svc.tls)
+	if err != nil {
+		return nil, errors.Wrap(err, "连接 LDAP 服务器 '"+svc.address+"' 失败")
+	}
+	defer conn.Close()
+
+	if svc.bindDN != "" {
+		if err := conn.Bind(svc.bindDN, svc.bindPassword); err != nil {
+			return nil, errors.Wrap(err, "用 '"+svc.bindDN+"' 登录 LDAP 服务器失败")
+		}
+	}
+
+	result, err := conn.SearchWithPaging(ldap.NewSearchRequest(svc.baseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		svc.filter,
+		[]string{svc.usernameAttr, svc.nicknameAttr, svc.emailAttr, svc.mobileAttr, svc.groupAttr},
+		nil), 500)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询 LDAP 目录中的用户失败")
+	}
+
+	base, _ := ldap.ParseDN(svc.baseDN)
+	users := make([]*ldapDirectoryUser, 0, len(result.Entries))
+	names := map[string]bool{}
+	for _, entry := range result.Entries {
+		name := strings.ToLower(strings.TrimSpace(entry.GetEqualFoldAttributeValue(svc.usernameAttr)))
+		if name == "" {
+			svc.logger.WarnContext(ctx, "LDAP 条目没有用户名，跳过它", slog.String("dn", entry.DN))
+			continue
+		}
+		if names[name] {
+			svc.logger.WarnContext(ctx, "LDAP 目录中有重名的用户，跳过它", slog.String("dn", entry.DN))
+			continue
+		}
+		names[name] = true
+
+		u := &ldapDirectoryUser{
+			LdapSyncUser: booclient.LdapSyncUser{
+				Name:     name,
+				Nickname: strings.TrimSpace(entry.GetEqualFoldAttributeValue(svc.nicknameAttr)),
+				DN:       entry.DN,
+				Email:    strings.TrimSpace(entry.GetEqualFoldAttributeValue(svc.emailAttr)),
+				Mobile:   strings.TrimSpace(entry.GetEqualFoldAttributeValue(svc.mobileAttr)),
+			},
+		}
+		if u.Nickname == "" {
+			u.Nickname = name
+		}
+		if svc.departments {
+			u.ous = ldapOUs(entry.DN, base)
+			u.Department = strings.Join(u.ous, "/")
+		}
+		if len(svc.groupRoles) > 0 {
+			groups := session_core.LdapGroupNames(entry.GetEqualFoldAttributeValues(svc.groupAttr))
+			u.Roles = svc.groupRoles.Roles(groups)
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// ldapOUs 返回 dn 中在 base 之下的 OU，从上级到下级排列
+func ldapOUs(dn string, base *ldap.DN) []string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) < 2 {
+		return nil
+	}
+	rdns := parsed.RDNs[1:]
+	if base != nil && base.AncestorOfFold(parsed) {
+		rdns = rdns[:len(rdns)-len(base.RDNs)]
+	}
+
+	var ous []string
+	for idx := len(rdns) - 1; idx >= 0; idx-- {
+		for _, attr := range rdns[idx].Attributes {
+			if strings.EqualFold(attr.Type, "ou") && attr.Value != "" {
+				ous = append(ous, attr.Value)
+			}
+		}
+	}
+	return ous
+}
+
+// plan 比较目录和系统中的用户，计算需要做的修改
+func (svc *ldapSyncService) plan(ctx context.Context) (*ldapSyncPlan, error) {
+	directory, err := svc.searchDirectory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existUsers, err := svc.users.userDao.QueryBySource(ctx, ldapSource)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询来源为 LDAP 的用户失败")
+	}
+	byName := make(map[string]*User, len(existUsers))
+	for idx := range existUsers {
+		byName[strings.ToLower(existUsers[idx].Name)] = &existUsers[idx]
+	}
+
+	plan := &ldapSyncPlan{}
+	departments := newLdapDepartments(svc.users.departmentDao)
+	managedRoles := svc.groupRoles.ManagedRoles()
+	seen := map[string]bool{}
+	for _, u := range directory {
+		seen[u.Name] = true
+
+		for idx := range u.ous {
+			id, err := departments.find(ctx, u.ous[:idx+1])
+			if err != nil {
+				return nil, err
+			}
+			if id < 0 {
+				break
+			}
+			if id == 0 && departments.plan(u.ous[:idx+1]) {
+				plan.departmentOUs = append(plan.departmentOUs, u.ous[:idx+1])
+				plan.diff.NewDepartments = append(plan.diff.NewDepartments, strings.Join(u.ous[:idx+1], "/"))
+			}
+		}
+
+		old := byName[u.Name]
+		if old == nil {
+			if _, err := svc.users.userDao.FindByName(ctx, u.Name); err == nil {
+				plan.diff.Skipped = append(plan.diff.Skipped, u.Name)
+				continue
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				return nil, errors.Wrap(err, "查询用户 '"+u.Name+"' 失败")
+			}
+
+			u.Roles = append(u.Roles, svc.defaultRoles...)
+			plan.newUsers = append(plan.newUsers, u)
+			plan.diff.NewUsers = append(plan.diff.NewUsers, u.LdapSyncUser)
+			continue
+		}
+
+		records, err := svc.compare(ctx, departments, old, u, managedRoles)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) > 0 {
+			plan.updatedUsers = append(plan.updatedUsers, ldapSyncUpdate{old: old, user: u})
+			plan.diff.UpdatedUsers = append(plan.diff.UpdatedUsers, booclient.LdapSyncChange{
+				UserID:  old.ID,
+				Name:    old.Name,
+				Records: records,
+			})
+		}
+	}
+
+	plan.diff.Warnings = append(plan.diff.Warnings, departments.conflicts...)
+
+	if svc.disableRemoved {
+		var disabledUsers []*User
+		enabledCount := 0
+		for idx := range existUsers {
+			old := &existUsers[idx]
+			if old.Disabled {
+				continue
+			}
+			enabledCount++
+			if !seen[strings.ToLower(old.Name)] {
+				disabledUsers = append(disabledUsers, old)
+			}
+		}
+
+		if len(disabledUsers) > 0 {
+			// 目录返回的用户为空或要禁用的用户过多时，很可能是目录查询有问题，不禁用任何用户
+			if len(directory) == 0 {
+				plan.diff.Warnings = append(plan.diff.Warnings,
+					"LDAP 目录中没有查询到任何用户，不会禁用 "+strconv.Itoa(len(disabledUsers))+" 个用户")
+				disabledUsers = nil
+			} else if len(disabledUsers)*100 > enabledCount*svc.maxDisablePercent {
+				plan.diff.Warnings = append(plan.diff.Warnings,
+					"要禁用的用户有 "+strconv.Itoa(len(disabledUsers))+" 个，超过了来源为 LDAP 的用户的 "+
+						strconv.Itoa(svc.maxDisablePercent)+"%，不会禁用任何用户，请检查 LDAP 目录或修改 '"+
+						CfgUserLdapSyncMaxDisablePercent+"'")
+				disabledUsers = nil
+			}
+		}
+		for _, old := range disabledUsers {
+			plan.disabledUsers = append(plan.disabledUsers, old)
+			plan.diff.DisabledUsers = append(plan.diff.DisabledUsers, old.Name)
+		}
+	}
+	if len(plan.diff.Warnings) > 0 {
+		svc.logger.WarnContext(ctx, "LDAP 目录同步有警告", slog.Any("warnings", plan.diff.Warnings))
+	}
+	return plan, nil
+}
+
+// compare 比较系统中的用户和目录中的用户，返回需要修改的内容
+func (svc *ldapSyncService) compare(ctx context.Context, departments *ldapDepartments, old *User, u *ldapDirectoryUser, managedRoles []string) ([]ChangeRecord, error) {
+	var records []ChangeRecord
+	if old.Nickname != u.Nickname {
+		records = append(records, ChangeRecord{
+			Name:        "nickname",
+			DisplayName: "呢称",
+			OldValue:    old.Nickname,
+			NewValue:    u.Nickname,
+		})
+	}
+	if u.Email != "" && old.GetEmail() != u.Email {
+		records = append(records, ChangeRecord{
+			Name:        booclient.Email.ID,
+			DisplayName: booclient.Email.Name,
+			OldValue:    old.GetEmail(),
+			NewValue:    u.Email,
+		})
+	}
+	if mobile := old.GetMobile(); u.Mobile != "" && mobile != u.Mobile {
+		records = append(records, ChangeRecord{
+			Name:        booclient.Mobile.ID,
+			DisplayName: booclient.Mobile.Name,
+			OldValue:    mobile,
+			NewValue:    u.Mobile,
+		})
+	}
+	if len(u.ous) > 0 {
+		id, err := departments.find(ctx, u.ous)
+		if err != nil {
+			return nil, err
+		}
+		// id < 0 时部门和系统中的其它部门冲突，保留用户原来的部门
+		if id >= 0 && id != old.DepartmentID {
+			records = append(records, ChangeRecord{
+				Name:        "department_id",
+				DisplayName: "部门",
+				OldValue:    old.DepartmentID,
+				NewValue:    u.Department,
+			})
+		}
+	}
+	if old.Disabled {
+		disabledBySync, err := svc.disabledBySync(ctx, old.ID)
+		if err != nil {
+			return nil, err
+		}
+		if disabledBySync {
+			records = append(records, ChangeRecord{
+				Name:        "disabled",
+				DisplayName: "禁用",
+				OldValue:    true,
+				NewValue:    false,
+			})
+		}
+	}
+	if len(managedRoles) > 0 {
+		added, removed, err := svc.users.diffManagedRoles(ctx, old.ID, u.Roles, managedRoles)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, roleChangeRecords(added, removed)...)
+	}
+	return records, nil
+}
+
+func (svc *ldapSyncService) apply(ctx context.Context, currentUser authn.AuthUser, plan *ldapSyncPlan) error {
+	departments := newLdapDepartments(svc.users.departmentDao)
+	// plan.departmentOUs 中上级部门总是在下级部门之前
+	for _, ous := range plan.departmentOUs {
+		var parentID int64
+		if len(ous) > 1 {
+			id, err := departments.find(ctx, ous[:len(ous)-1])
+			if err != nil {
+				return err
+			}
+			if id <= 0 {
+				continue
+			}
+			parentID = id
+		}
+		if id, err := departments.find(ctx, ous); err != nil {
+			return err
+		} else if id != 0 {
+			continue
+		}
+		name := ous[len(ous)-1]
+		id, err := svc.users.departmentDao.Insert(ctx, &Department{
+			ParentID: parentID,
+			UUID:     uuid.NewString(),
+			Name:     name,
+		})
+		if err != nil {
+			return errors.Wrap(err, "新建部门 '"+strings.Join(ous, "/")+"' 失败")
+		}
+		departments.ids[ldapDepartmentKey(ous)] = id
+	}
+
+	// departmentID 返回用户所在的 OU 对应的部门，部门冲突时返回 defaultValue，
+	// defaultValue 为 0 时返回最近的一个可以对应的上级部门
+	departmentID := func(ctx context.Context, u *ldapDirectoryUser, defaultValue int64) (int64, error) {
+		for idx := len(u.ous); idx > 0; idx-- {
+			id, err := departments.find(ctx, u.ous[:idx])
+			if err != nil {
+				return 0, err
+			}
+			if id > 0 {
+				return id, nil
+			}
+			if defaultValue > 0 {
+				break
+			}
+		}
+		return defaultValue, nil
+	}
+
+	for _, u := range plan.newUsers {
+		err := svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+			id, err := departmentID(ctx, u, 0)
+			if err != nil {
+				return err
+			}
+			user := &User{
+				Name:         u.Name,
+				Nickname:     u.Nickname,
+				Source:       ldapSource,
+				DepartmentID: id,
+				Fields:       u.fields(nil),
+			}
+			user.ID, err = svc.users.insert(ctx, currentUser, user, actionSync)
+			if err != nil {
+				return err
+			}
+			_, err = svc.users.syncManagedRoles(ctx, currentUser, user, u.Roles, nil)
+			return err
+		})
+		if err != nil {
+			plan.diff.Errors = append(plan.diff.Errors, "新建用户 '"+u.Name+"' 失败: "+err.Error())
+		}
+	}
+
+	managedRoles := svc.groupRoles.ManagedRoles()
+	for _, update := range plan.updatedUsers {
+		old, u := update.old, update.user
+		err := svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+			id, err := departmentID(ctx, u, old.DepartmentID)
+			if err != nil {
+				return err
+			}
+			newUser := *old
+			newUser.Password = ""
+			newUser.Nickname = u.Nickname
+			newUser.DepartmentID = id
+			newUser.Fields = u.fields(old.Fields)
+			if old.Disabled {
+				// 只启用被同步禁用的用户，管理员禁用的用户保持禁用
+				disabledBySync, err := svc.disabledBySync(ctx, old.ID)
+				if err != nil {
+					return err
+				}
+				if disabledBySync {
+					newUser.Disabled = false
+					if _, err := svc.users.profileDao.DeleteProfile(ctx, old.ID, ldapSyncDisabledProfile); err != nil {
+						return errors.Wrap(err, "删除用户的同步禁用标记失败")
+					}
+				}
+			}
+			if err := svc.users.update(ctx, currentUser, old.ID, &newUser, old, booclient.UpdateModeSkip, actionSync); err != nil {
+				return err
+			}
+			if len(managedRoles) == 0 {
+				return nil
+			}
+			_, err = svc.users.syncManagedRoles(ctx, currentUser, old, u.Roles, managedRoles)
+			return err
+		})
+		if err != nil {
+			plan.diff.Errors = append(plan.diff.Errors, "更新用户 '"+old.Name+"' 失败: "+err.Error())
+		}
+	}
+
+	for _, old := range plan.disabledUsers {
+		newUser := *old
+		newUser.Password = ""
+		newUser.Disabled = true
+		err := svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+			if err := svc.users.update(ctx, currentUser, old.ID, &newUser, old, booclient.UpdateModeSkip, actionSync); err != nil {
+				return err
+			}
+			if err := svc.users.profileDao.WriteProfileByKey(ctx, old.ID, ldapSyncDisabledProfile, "true"); err != nil {
+				return errors.Wrap(err, "记录用户的同步禁用标记失败")
+			}
+			return nil
+		})
+		if err != nil {
+			plan.diff.Errors = append(plan.diff.Errors, "禁用用户 '"+old.Name+"' 失败: "+err.Error())
+			continue
+		}
+		if err := svc.users.logoutOtherSessions(ctx, old.Name); err != nil {
+			svc.logger.WarnContext(ctx, "注销被禁用的用户的会话失败", slog.String("username", old.Name), slog.Any("err", err))
+		}
+	}
+	return nil
+}
+
+// disabledBySync 判断用户是否是被同步禁用的
+func (svc *ldapSyncService) disabledBySync(ctx context.Context, userID int64) (bool, error) {
+	_, err := svc.users.profileDao.ReadProfile(ctx, userID, ldapSyncDisabledProfile)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "查询用户的同步禁用标记失败")
+	}
+	return true, nil
+}
+
+// fields 返回合并了目录中的邮箱和手机号后的自定义字段
+func (u *ldapDirectoryUser) fields(old map[string]interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for key, value := range old {
+		fields[key] = value
+	}
+	if u.Email != "" {
+		fields[booclient.Email.ID] = u.Email
+	}
+	if u.Mobile != "" {
+		fields[booclient.Mobile.ID] = u.Mobile
+	}
+	return fields
+}
+
+func (svc *ldapSyncService) logSync(ctx context.Context, currentUser authn.AuthUser, diff *booclient.LdapSyncDiff) {
+	if !enableOplog {
+		return
+	}
+
+	records := []ChangeRecord{
+		{
+			Name:        "new_departments",
+			DisplayName: "新建部门",
+			NewValue:    len(diff.NewDepartments),
+		},
+		{
+			Name:        "new_users",
+			DisplayName: "新建用户",
+			NewValue:    len(diff.NewUsers),
+		},
+		{
+			Name:        "updated_users",
+			DisplayName: "更新用户",
+			NewValue:    len(diff.UpdatedUsers),
+		},
+		{
+			Name:        "disabled_users",
+			DisplayName: "禁用用户",
+			NewValue:    len(diff.DisabledUsers),
+		},
+	}
+	for _, msg := range diff.Errors {
+		records = append(records, ChangeRecord{
+			Name:        "error",
+			DisplayName: "错误",
+			NewValue:    msg,
+		})
+	}
+
+	err := svc.operationLogger.LogRecord(ctx, &OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Nickname(),
+		Successful: len(diff.Errors) == 0,
+		Type:       authn.OpSyncLdapUsers,
+		Content:    "同步 LDAP 目录，失败 " + strconv.Itoa(len(diff.Errors)) + " 个",
+		Fields: &OperationLogRecord{
+			ObjectType: "user",
+			Records:    records,
+		},
+	})
+	if err != nil {
+		svc.logger.WarnContext(ctx, "记录同步 LDAP 目录的操作失败", slog.Any("err", err))
+	}
+}
+
+// ldapDepartments 按 OU 的完整路径查询部门，路径中的每一级 OU 都要和上下级部门一一对应，
+// 因为部门名是唯一的，同名的部门在其它位置时认为是冲突，不会使用它也不会新建部门
+type ldapDepartments struct {
+	dao DepartmentDao
+	// ids 的 key 是小写的路径，值为 0 时部门不存在，为 -1 时部门冲突
+	ids map[string]int64
+	// planned 的 key 是小写的部门名，值是计划新建它的路径
+	planned   map[string]string
+	conflicts []string
+}
+
+func newLdapDepartments(dao DepartmentDao) *ldapDepartments {
+	return &ldapDepartments{
+		dao:     dao,
+		ids:     map[string]int64{},
+		planned: map[string]string{},
+	}
+}
+
+func ldapDepartmentKey(ous []string) string {
+	return strings.ToLower(strings.Join(ous, "/"))
+}
+
+// find 返回 ous 对应的部门的 ID，部门不存在时返回 0，部门和系统中的其它部门冲突时返回 -1
+func (d *ldapDepartments) find(ctx context.Context, ous []string) (int64, error) {
+	key := ldapDepartmentKey(ous)
+	if id, ok := d.ids[key]; ok {
+		return id, nil
+	}
+
+	var parentID int64
+	if len(ous) > 1 {
+		id, err := d.find(ctx, ous[:len(ous)-1])
+		if err != nil {
+			return 0, err
+		}
+		if id < 0 {
+			d.ids[key] = -1
+			return -1, nil
+		}
+		parentID = id
+	}
+
+	name := ous[len(ous)-1]
+	department, err := d.dao.FindByName(ctx, name)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.Wrap(err, "查询部门 '"+name+"' 失败")
+		}
+		d.ids[key] = 0
+		return 0, nil
+	}
+	if parentID <= 0 && len(ous) > 1 || department.ParentID != parentID {
+		d.conflict(ous)
+		return -1, nil
+	}
+	d.ids[key] = department.ID
+	return department.ID, nil
+}
+
+// plan 记录需要新建的部门，已经记录过时返回 false，
+// 同名的部门已经计划在其它的路径下新建时，记为冲突并返回 false
+func (d *ldapDepartments) plan(ous []string) bool {
+	key := ldapDepartmentKey(ous)
+	name := strings.ToLower(ous[len(ous)-1])
+	if path, ok := d.planned[name]; ok {
+		if path != key {
+			d.conflict(ous)
+		}
+		return false
+	}
+	d.planned[name] = key
+	return true
+}
+
+func (d *ldapDepartments) conflict(ous []string) {
+	d.ids[ldapDepartmentKey(ous)] = -1
+	d.conflicts = append(d.conflicts, "部门 '"+strings.Join(ous, "/")+
+		"' 和系统中其它位置的同名部门冲突，不会同步该部门")
+}
+
+// diffManagedRoles 返回需要添加和删除的角色，只会删除 managedRoles 中的角色，系统中不存在的角色会被忽略
+func (svc UserService) diffManagedRoles(ctx context.Context, userID int64, roles, managedRoles []string) (added, removed []Role, err error) {
+	var current []Role
+	if userID > 0 {
+		current, err = svc.roleDao.QueryByUserID(ctx, userID)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "查询用户的角色失败")
+		}
+	}
+
+	hasRole := func(list []Role, title string) bool {
+		for _, r := range list {
+			if strings.EqualFold(r.Title, title) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, title := range roles {
+		if hasRole(current, title) || hasRole(added, title) {
+			continue
+		}
+		role, err := svc.roleDao.FindByTitle(ctx, title)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return nil, nil, errors.Wrap(err, "查询角色 '"+title+"' 失败")
+		}
+		added = append(added, *role)
+	}
+	for _, role := range current {
+		if containsFold(managedRoles, role.Title) && !containsFold(roles, role.Title) {
+			removed = append(removed, role)
+		}
+	}
+	return added, removed, nil
+}
+
+// syncManagedRoles 将用户的角色同步为 roles，只会删除 managedRoles 中的角色，
+// 用于 LDAP 组映射的角色，管理员手工添加的其它角色不受影响
+func (svc UserService) syncManagedRoles(ctx context.Context, currentUser authn.AuthUser, user *User, roles, managedRoles []string) ([]ChangeRecord, error) {
+	var contents []ChangeRecord
+	err := svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		added, removed, err := svc.diffManagedRoles(ctx, user.ID, roles, managedRoles)
+		if err != nil {
+			return err
+		}
+		for _, role := range added {
+			if err := svc.user2RoleDao.Upsert(ctx, user.ID, role.ID); err != nil {
+				return errors.Wrap(err, "关联角色 '"+role.Title+"' 失败")
+			}
+		}
+		for _, role := range removed {
+			if err := svc.user2RoleDao.Delete(ctx, user.ID, role.ID); err != nil {
+				return errors.Wrap(err, "删除关联角色 '"+role.Title+"' 失败")
+			}
+		}
+
+		contents = roleChangeRecords(added, removed)
+		if len(contents) > 0 {
+			svc.logUpdate(ctx, tx, currentUser, user.ID, user, user, actionSync, contents)
+		}
+		return nil
+	})
+	return contents, err
+}
+
+func roleChangeRecords(added, removed []Role) []ChangeRecord {
+	var records []ChangeRecord
+	for _, role := range added {
+		records = append(records, ChangeRecord{
+			Name:        "addRoleTitle",
+			DisplayName: "添加关联角色 - '" + role.Title + "'",
+			NewValue:    role.Title,
+		})
+	}
+	for _, role := range removed {
+		records = append(records, ChangeRecord{
+			Name:        "deleteRoleTitle",
+			DisplayName: "删除关联角色 - '" + role.Title + "'",
+			OldValue:    role.Title,
+		})
+	}
+	return records
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
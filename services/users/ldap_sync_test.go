@@ -0,0 +1,250 @@
+package users_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boo-admin/boo/app_tests"
+	"github.com/boo-admin/boo/app_tests/ldaptest"
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+	"github.com/boo-admin/boo/services/users"
+)
+
+func TestLdapSync(t *testing.T) {
+	ldapServer, err := ldaptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldapServer.Close()
+
+	for _, entry := range []struct {
+		dn    string
+		attrs map[string][]string
+	}{
+		{
+			dn: "uid=zhangsan,ou=平台组,ou=研发中心,dc=example,dc=com",
+			attrs: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"zhangsan"},
+				"displayName": {"张三"},
+				"mail":        {"zhangsan@example.com"},
+				"memberOf":    {"cn=ops,ou=groups,dc=example,dc=com"},
+			},
+		},
+		{
+			dn: "uid=lisi,ou=研发中心,dc=example,dc=com",
+			attrs: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"LiSi"},
+				"displayName": {"李四"},
+				"mobile":      {"13800000000"},
+			},
+		},
+		{
+			dn: "uid=admin,dc=example,dc=com",
+			attrs: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"admin"},
+			},
+		},
+	} {
+		if err := ldapServer.Add(entry.dn, "", entry.attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := app_tests.NewTestApp(t, map[string]string{
+		session_core.CfgUserLdapAddress:             ldapServer.Addr(),
+		session_core.CfgUserLdapBaseDN:              "dc=example,dc=com",
+		session_core.CfgUserLdapGroupRoles + ".ops": "运维",
+		users.CfgUserLdapSyncEnabled:                "true",
+		users.CfgUserLdapSyncInterval:               "0",
+		users.CfgUserLdapSyncUsernameAttr:           "uid",
+		users.CfgUserLdapSyncMaxDisablePercent:      "50",
+	})
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	_, err = booclient.NewRemoteRoles(pxy).Create(ctx, &booclient.Role{Title: "运维"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	ldapSync := booclient.NewRemoteLdapSync(pxy)
+	diff, err := ldapSync.GetDiff(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(diff.NewUsers) != 2 {
+		t.Error("want 2 got", len(diff.NewUsers))
+	}
+	if len(diff.NewDepartments) != 2 {
+		t.Error("want 2 got", diff.NewDepartments)
+	}
+	if len(diff.Skipped) != 1 || diff.Skipped[0] != "admin" {
+		t.Error("want [admin] got", diff.Skipped)
+	}
+
+	// GetDiff 不会修改任何数据
+	remoteUsers := booclient.NewRemoteUsers(pxy)
+	if _, err := remoteUsers.FindByName(ctx, "zhangsan"); err == nil {
+		t.Error("want error got ok")
+	}
+
+	diff, err = ldapSync.Sync(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(diff.Errors) != 0 {
+		t.Error(diff.Errors)
+	}
+
+	user, err := remoteUsers.FindByName(ctx, "zhangsan", "*")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if user.Source != "ldap" || user.Nickname != "张三" || user.GetEmail() != "zhangsan@example.com" {
+		t.Errorf("%#v", user)
+	}
+	if len(user.Roles) != 1 || user.Roles[0].Title != "运维" {
+		t.Error("want [运维] got", user.Roles)
+	}
+
+	departments := booclient.NewRemoteDepartments(pxy)
+	platform, err := departments.FindByName(ctx, "平台组")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rd, err := departments.FindByName(ctx, "研发中心")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if platform.ParentID != rd.ID {
+		t.Error("want", rd.ID, "got", platform.ParentID)
+	}
+	if user.DepartmentID != platform.ID {
+		t.Error("want", platform.ID, "got", user.DepartmentID)
+	}
+
+	lisi, err := remoteUsers.FindByName(ctx, "lisi")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if lisi.DepartmentID != rd.ID || lisi.GetMobile() != "13800000000" {
+		t.Errorf("%#v", lisi)
+	}
+
+	// 再次同步时没有差异
+	diff, err = ldapSync.GetDiff(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !diff.IsEmpty() {
+		t.Errorf("%#v", diff)
+	}
+
+	// 从目录中删除的用户被禁用，组变化后映射的角色也被删除
+	if err := ldapServer.Delete("uid=lisi,ou=研发中心,dc=example,dc=com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ldapServer.Add("uid=zhangsan,ou=平台组,ou=研发中心,dc=example,dc=com", "", map[string][]string{
+		"objectClass": {"person"},
+		"uid":         {"zhangsan"},
+		"displayName": {"张三"},
+		"mail":        {"zhangsan@example.com"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err = ldapSync.Sync(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(diff.DisabledUsers) != 1 || diff.DisabledUsers[0] != "lisi" {
+		t.Error("want [lisi] got", diff.DisabledUsers)
+	}
+	if len(diff.UpdatedUsers) != 1 || diff.UpdatedUsers[0].Name != "zhangsan" {
+		t.Errorf("%#v", diff.UpdatedUsers)
+	}
+
+	lisi, err = remoteUsers.FindByName(ctx, "lisi")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !lisi.Disabled {
+		t.Error("want disabled got enabled")
+	}
+
+	user, err = remoteUsers.FindByName(ctx, "zhangsan", "*")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(user.Roles) != 0 {
+		t.Error("want [] got", user.Roles)
+	}
+
+	// 被同步禁用的用户重新出现在目录中时会被启用
+	if err := ldapServer.Add("uid=lisi,ou=研发中心,dc=example,dc=com", "", map[string][]string{
+		"objectClass": {"person"},
+		"uid":         {"LiSi"},
+		"displayName": {"李四"},
+		"mobile":      {"13800000000"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	diff, err = ldapSync.Sync(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(diff.UpdatedUsers) != 1 || diff.UpdatedUsers[0].Name != "lisi" {
+		t.Errorf("%#v", diff.UpdatedUsers)
+	}
+	lisi, err = remoteUsers.FindByName(ctx, "lisi")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if lisi.Disabled {
+		t.Error("want enabled got disabled")
+	}
+
+	// 目录中没有用户时不会禁用任何用户
+	for _, dn := range []string{
+		"uid=zhangsan,ou=平台组,ou=研发中心,dc=example,dc=com",
+		"uid=lisi,ou=研发中心,dc=example,dc=com",
+		"uid=admin,dc=example,dc=com",
+	} {
+		if err := ldapServer.Delete(dn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	diff, err = ldapSync.GetDiff(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(diff.DisabledUsers) != 0 || len(diff.Warnings) != 1 {
+		t.Errorf("%#v", diff)
+	}
+}
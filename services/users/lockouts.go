@@ -0,0 +1,96 @@
+package users
+
+import (
+	"context"
+
+	"github.com/boo-admin/boo/services/authn/session_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+	"golang.org/x/exp/slog"
+)
+
+// SetLockouts 设置登录失败计数和用户锁定， basic auth 等不经过登录流程校验密码的地方
+// 和登录共用同一个失败计数，密码错误次数过多时同样会锁定用户
+func (svc *UserService) SetLockouts(lockouts session_auth.Lockouts) {
+	svc.lockouts = lockouts
+}
+
+// UsernameExists 判断用户名是否存在，用于只记录已存在的用户的登录失败次数
+func (svc UserService) UsernameExists(ctx context.Context, username string) (bool, error) {
+	return svc.userDao.UsernameExists(ctx, username)
+}
+
+func (svc UserService) maxLoginFailCount() int {
+	maxLoginFailCount := svc.env.Config.IntWithDefault(session_auth.CfgUserMaxLoginFailCount, 5)
+	if maxLoginFailCount <= 0 {
+		// 和 session_core.ErrorCountCheck 一致
+		maxLoginFailCount = 3
+	}
+	return maxLoginFailCount
+}
+
+// currentAddress 返回当前会话的登录地址，不是通过会话访问时返回空
+func (svc UserService) currentAddress(ctx context.Context) string {
+	sessionID := session_auth.SessionIDFromContext(ctx)
+	if svc.onlines == nil || sessionID == "" {
+		return ""
+	}
+	info, err := svc.onlines.GetBySessionID(ctx, sessionID)
+	if err != nil || info == nil {
+		return ""
+	}
+	return info.Address
+}
+
+func (svc UserService) newAuthContext(ctx context.Context, username, address string) *session_core.AuthContext {
+	return &session_core.AuthContext{
+		Logger: svc.logger.With(slog.String("username", username), slog.String("address", address)),
+		Ctx:    ctx,
+		Request: session_core.LoginRequest{
+			Username: username,
+			Address:  address,
+		},
+	}
+}
+
+// checkLocked 检查用户是否已被锁定，失败次数已达到上限时锁定用户
+func (svc UserService) checkLocked(ctx context.Context, username, address string) error {
+	if svc.lockouts == nil {
+		return nil
+	}
+	authCtx := svc.newAuthContext(ctx, username, address)
+	if err := svc.lockouts.IsLocked(authCtx); err != nil {
+		return err
+	}
+	if svc.lockouts.Count(username) >= svc.maxLoginFailCount() {
+		svc.lock(authCtx)
+		return session_core.ErrUserErrorCountExceedLimit
+	}
+	return nil
+}
+
+// passwordFailed 记录一次密码错误，失败次数达到上限时锁定用户
+func (svc UserService) passwordFailed(ctx context.Context, username, address string) {
+	if svc.lockouts == nil {
+		return
+	}
+	svc.lockouts.Fail(username)
+	if svc.lockouts.Count(username) >= svc.maxLoginFailCount() {
+		svc.lock(svc.newAuthContext(ctx, username, address))
+	}
+}
+
+// passwordSucceeded 密码正确时清除失败计数
+func (svc UserService) passwordSucceeded(username string) {
+	if svc.lockouts == nil {
+		return
+	}
+	svc.lockouts.Zero(username)
+}
+
+func (svc UserService) lock(authCtx *session_core.AuthContext) {
+	if err := svc.lockouts.Lock(authCtx); err != nil {
+		authCtx.Logger.ErrorContext(authCtx.Ctx, "出错次数太多，锁住用户失败", slog.Any("error", err))
+		return
+	}
+	svc.lockouts.Zero(authCtx.Request.Username)
+}
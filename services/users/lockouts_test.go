@@ -0,0 +1,125 @@
+package users_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/boo-admin/boo/app_tests"
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn/session_auth"
+)
+
+func TestLoginLockout(t *testing.T) {
+	app := app_tests.NewTestApp(t, map[string]string{
+		session_auth.CfgUserMaxLoginFailCount: "2",
+	})
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	users := booclient.NewRemoteUsers(pxy)
+	_, err = users.Create(ctx, &booclient.User{
+		Name:     "locktest",
+		Nickname: "锁定测试用户",
+		Password: "Abcd!12345",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	loginWithBody := func(username, password string) (int, string) {
+		t.Helper()
+
+		res, err := http.Post(app.BaseURL()+"/login", "application/x-www-form-urlencoded",
+			strings.NewReader(url.Values{"username": {username}, "password": {password}}.Encode()))
+		if err != nil {
+			t.Error(err)
+			return 0, ""
+		}
+		defer res.Body.Close()
+		bs, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(bs)
+	}
+	loginAs := func(username, password string) int {
+		t.Helper()
+		code, _ := loginWithBody(username, password)
+		return code
+	}
+	login := func(password string) int {
+		t.Helper()
+		return loginAs("locktest", password)
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := login("bad password"); code != http.StatusUnauthorized {
+			t.Error("want 401 got", code)
+		}
+	}
+	if code := login("Abcd!12345"); code == http.StatusOK {
+		t.Error("user isnot locked")
+		return
+	}
+
+	// 用户不存在和密码不正确时返回同样的错误
+	if _, body := loginWithBody("locktest_notexists", "bad password"); !strings.Contains(body, "username or password is invalid") {
+		t.Error("want invalid credentials got", body)
+	}
+
+	// 不存在的用户不记录失败次数，也不会被锁定
+	for i := 0; i < 3; i++ {
+		if code := loginAs("locktest_notexists", "bad password"); code != http.StatusUnauthorized {
+			t.Error("want 401 got", code)
+		}
+	}
+
+	lockedUsers := booclient.NewRemoteLockedUsers(pxy)
+	if _, err := lockedUsers.FindByID(ctx, "locktest_notexists"); err == nil {
+		t.Error("want error got ok")
+	}
+
+	list, err := lockedUsers.List(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	found := false
+	for _, u := range list {
+		if u.Username == "locktest" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Error("locktest isnot in locked users", list)
+	}
+
+	locked, err := lockedUsers.FindByID(ctx, "locktest")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if locked.Username != "locktest" {
+		t.Error("want locktest got", locked.Username)
+	}
+
+	err = lockedUsers.Unlock(ctx, "locktest")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if code := login("Abcd!12345"); code != http.StatusOK {
+		t.Error("want 200 got", code)
+	}
+}
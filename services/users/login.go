@@ -0,0 +1,201 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/goutils/as"
+	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+	"github.com/mei-rune/iprange"
+)
+
+// NewLoginUsers 创建一个给 session_core.AuthService 使用的 UserManager
+func NewLoginUsers(svc *UserService) session_core.UserManager {
+	return &loginUsers{svc: svc}
+}
+
+type loginUsers struct {
+	svc *UserService
+}
+
+func (um *loginUsers) Create(ctx context.Context, name, nickname, source, password string, fields map[string]interface{}, roles []string, skipIfRoleNotExists bool) (interface{}, error) {
+	user := &User{
+		Name:     name,
+		Nickname: nickname,
+		Source:   source,
+		Password: password,
+		Fields:   fields,
+	}
+	for _, roleName := range roles {
+		roleName = strings.TrimSpace(roleName)
+		if roleName == "" {
+			continue
+		}
+		if skipIfRoleNotExists {
+			_, err := um.svc.roleDao.FindByTitle(ctx, roleName)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					continue
+				}
+				return nil, errors.Wrap(err, "查询角色 '"+roleName+"' 失败")
+			}
+		}
+		user.Roles = append(user.Roles, Role{Title: roleName})
+	}
+
+	ctx = ContextWithCreateUserInLogin(ctx)
+	return um.svc.insert(ctx, authn.NewMockUser(name), user, actionNormal)
+}
+
+func (um *loginUsers) Read(ctx *session_core.AuthContext) (interface{}, session_core.User, error) {
+	user, err := um.svc.userDao.FindByName(ctx.Ctx, ctx.Request.Username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// ldap 或 cas 第一次登录时用户不在系统中，交给后面的插件处理
+			return nil, nil, nil
+		}
+		return nil, nil, errors.Wrap(err, "查询用户 '"+ctx.Request.Username+"' 失败")
+	}
+
+	roles, err := um.svc.roleDao.QueryByUserID(ctx.Ctx, user.ID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "加载用户 '"+ctx.Request.Username+"' 的角色失败")
+	}
+	user.Roles = roles
+
+	return user.ID, &loginUser{svc: um.svc, user: user}, nil
+}
+
+var _ session_core.RoleSyncer = &loginUsers{}
+
+// SyncRoles 将 LDAP 组映射的角色同步到已有的用户上
+func (um *loginUsers) SyncRoles(ctx *session_core.AuthContext, roles, managedRoles []string) error {
+	lu, ok := ctx.Authentication.(*loginUser)
+	if !ok || lu == nil {
+		return nil
+	}
+	contents, err := um.svc.syncManagedRoles(ctx.Ctx, authn.NewMockUser(lu.user.Name), lu.user, roles, managedRoles)
+	if err != nil {
+		return errors.Wrap(err, "同步用户 '"+lu.user.Name+"' 的角色失败")
+	}
+	if len(contents) == 0 {
+		return nil
+	}
+
+	userRoles, err := um.svc.roleDao.QueryByUserID(ctx.Ctx, lu.user.ID)
+	if err != nil {
+		return errors.Wrap(err, "加载用户 '"+lu.user.Name+"' 的角色失败")
+	}
+	lu.user.Roles = userRoles
+	return nil
+}
+
+var _ session_core.User = &loginUser{}
+var _ session_core.Authenticator = &loginUser{}
+var _ session_core.CanLoginable = &loginUser{}
+var _ session_core.PasswordExpiredChecker = &loginUser{}
+var _ session_core.PasswordChangeRequired = &loginUser{}
+var _ session_core.TwoFactorAuthenticator = &loginUser{}
+
+type loginUser struct {
+	svc  *UserService
+	user *User
+}
+
+func (u *loginUser) Auth(ctx *session_core.AuthContext) (bool, error) {
+	if u.user.Source != "" && u.user.Source != "builtin" && u.user.Source != "api" {
+		return false, nil
+	}
+	if ctx.Request.Password == "" {
+		return true, session_core.ErrPasswordEmpty
+	}
+	if u.user.Password == "" {
+		return true, session_core.ErrPasswordNotMatch
+	}
+
+	err := u.svc.passwordHasher.Compare(ctx.Ctx, ctx.Request.Password, u.user.Password)
+	if err != nil {
+		if errors.Is(err, ErrPasswordMismatch) {
+			return true, session_core.ErrPasswordNotMatch
+		}
+		return true, errors.Wrap(err, "校验用户密码失败")
+	}
+	u.svc.rehashIfNeeded(ctx.Ctx, u.user, ctx.Request.Password)
+	return true, nil
+}
+
+func (u *loginUser) IsLocked() bool {
+	return false
+}
+
+func (u *loginUser) Loginable() bool {
+	return !u.user.Disabled
+}
+
+func (u *loginUser) Source() string {
+	return u.user.Source
+}
+
+func (u *loginUser) RoleNames() []string {
+	names := make([]string, 0, len(u.user.Roles))
+	for idx := range u.user.Roles {
+		names = append(names, u.user.Roles[idx].Title)
+	}
+	return names
+}
+
+func (u *loginUser) IngressIPList() ([]iprange.Checker, error) {
+	o := u.user.Fields[booclient.WhiteAddressList.ID]
+	if o == nil {
+		return nil, nil
+	}
+	var ss = as.ToStrings(o)
+	var list = make([]iprange.Checker, 0, len(ss))
+	for _, s := range ss {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		r, err := iprange.ParseIPRange(s)
+		if err != nil {
+			return nil, errors.Wrap(err, "用户 '"+u.user.Name+"' 的登录IP '"+s+"' 格式不正确")
+		}
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+func (u *loginUser) IsPasswordExpired(interval time.Duration) bool {
+	if u.user.LastPasswordModifiedAt.IsZero() {
+		return false
+	}
+	return time.Since(u.user.LastPasswordModifiedAt) > interval
+}
+
+func (u *loginUser) IsPasswordChangeRequired() bool {
+	return u.svc.isPasswordChangeRequired(u.user, time.Now())
+}
+
+func (u *loginUser) IsTwoFactorEnabled(ctx *session_core.AuthContext) (bool, error) {
+	return u.svc.twoFactors.isEnabled(ctx.Ctx, u.user.ID)
+}
+
+func (u *loginUser) IsTwoFactorRequired(ctx *session_core.AuthContext) (bool, error) {
+	return isTwoFactorRequired(u.user.Roles), nil
+}
+
+func (u *loginUser) VerifyTwoFactor(ctx *session_core.AuthContext, code string) (bool, error) {
+	tf, err := u.svc.twoFactors.find(ctx.Ctx, u.user.ID)
+	if err != nil {
+		return false, err
+	}
+	if tf == nil || !tf.Enabled {
+		return false, nil
+	}
+	return u.svc.twoFactors.verify(ctx.Ctx, tf, code)
+}
@@ -0,0 +1,204 @@
+package users_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/boo-admin/boo/app_tests"
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_store"
+)
+
+func TestLogin(t *testing.T) {
+	app := app_tests.NewTestApp(t, nil)
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	users := booclient.NewRemoteUsers(pxy)
+	_, err = users.Create(ctx, &booclient.User{
+		Name:     "logintest",
+		Nickname: "登录测试用户",
+		Password: "Abcd!12345",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	post := func(u string, values url.Values) (*http.Response, error) {
+		return client.Post(u, "application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+	}
+
+	res, err := post(app.BaseURL()+"/login", url.Values{"username": {"logintest"}, "password": {"bad password"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Error("want 401 got", res.StatusCode)
+	}
+
+	res, err = post(app.BaseURL()+"/login", url.Values{"username": {"logintest"}, "password": {"Abcd!12345"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var result struct {
+		IsOK      bool   `json:"is_ok"`
+		SessionID string `json:"session_id"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	res.Body.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !result.IsOK || result.SessionID == "" {
+		t.Error("login fail", result)
+		return
+	}
+
+	res, err = client.Get(app.BaseURL() + "/me")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var me map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&me)
+	res.Body.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if me["name"] != "logintest" {
+		t.Error("want logintest got", me["name"])
+	}
+	if me["session_id"] != result.SessionID {
+		t.Error("want", result.SessionID, "got", me["session_id"])
+	}
+
+	res, err = post(app.BaseURL()+"/logout", url.Values{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Error("want 200 got", res.StatusCode)
+	}
+
+	online, err := app.Server.Onlines.GetBySessionID(ctx, result.SessionID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if online != nil {
+		t.Error("session is still online after logout")
+	}
+}
+
+func TestLoginWithDbSessions(t *testing.T) {
+	app := app_tests.NewTestApp(t, map[string]string{
+		session_store.CfgSessionStore: "db",
+	})
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	users := booclient.NewRemoteUsers(pxy)
+	_, err = users.Create(ctx, &booclient.User{
+		Name:     "dbsessiontest",
+		Nickname: "会话测试用户",
+		Password: "Abcd!12345",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	res, err := client.Post(app.BaseURL()+"/login", "application/x-www-form-urlencoded",
+		strings.NewReader(url.Values{"username": {"dbsessiontest"}, "password": {"Abcd!12345"}}.Encode()))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var result struct {
+		IsOK      bool   `json:"is_ok"`
+		SessionID string `json:"session_id"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	res.Body.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !result.IsOK || result.SessionID == "" {
+		t.Error("login fail", result)
+		return
+	}
+
+	// 模拟另一个实例，它和当前实例共享同一个数据库
+	otherNode := session_store.CreateDb(app.Env, app.Server.Factory)
+	online, err := otherNode.GetBySessionID(ctx, result.SessionID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if online == nil || online.Username != "dbsessiontest" {
+		t.Error("session isnot found in other node", online)
+		return
+	}
+
+	res, err = client.Get(app.BaseURL() + "/me")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Error("want 200 got", res.StatusCode)
+	}
+
+	err = otherNode.LogoutBySessionID(ctx, result.SessionID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err = client.Get(app.BaseURL() + "/me")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Error("want 401 got", res.StatusCode)
+	}
+}
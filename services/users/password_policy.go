@@ -0,0 +1,260 @@
+package users
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	gobatis "github.com/runner-mei/GoBatis"
+
+	good_password "github.com/mei-rune/go-good-password"
+)
+
+const (
+	// CfgUserPasswordMinLength 密码的最小长度，缺省为 8
+	CfgUserPasswordMinLength = "users.password.min_length"
+	// CfgUserPasswordMaxLength 密码的最大长度，缺省为 250
+	CfgUserPasswordMaxLength = "users.password.max_length"
+	// CfgUserPasswordMinClasses 密码至少要包含小写字母、大写字母、数字和特殊字符中的几种
+	CfgUserPasswordMinClasses = "users.password.min_classes"
+	// CfgUserPasswordBannedWords 密码中不能包含的单词，不区分大小写
+	CfgUserPasswordBannedWords = "users.password.banned_words"
+	// CfgUserPasswordBanUsername 密码中是否不能包含用户名和呢称
+	CfgUserPasswordBanUsername = "users.password.ban_username"
+	// CfgUserPasswordMinScore 密码强度的最低分数，为 0 时不检查，
+	// 没有配置时为了兼容以前的版本， enable_password_check 为 true 时为 3
+	CfgUserPasswordMinScore = "users.password.min_score"
+	// CfgUserPasswordHistory 新密码不能和最近几次使用过的密码相同
+	CfgUserPasswordHistory = "users.password.history"
+	// CfgUserPasswordMaxAgeDays 密码的最长有效天数，过期后用户必须修改密码才能使用其它功能
+	CfgUserPasswordMaxAgeDays = "users.password.max_age_days"
+	// CfgUserPasswordChangeOnReset 管理员重置密码后，用户下次登录时是否必须修改密码
+	CfgUserPasswordChangeOnReset = "users.password.change_on_reset"
+)
+
+var NewPasswordHistoryDaoHook func(ref gobatis.SqlSession) PasswordHistoryDao
+
+func NewPasswordHistoryDaoWith(ref gobatis.SqlSession) PasswordHistoryDao {
+	if NewPasswordHistoryDaoHook != nil {
+		return NewPasswordHistoryDaoHook(ref)
+	}
+	return NewPasswordHistoryDao(ref)
+}
+
+// PasswordPolicy 是密码策略
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	MinClasses    int
+	BannedWords   []string
+	BanUsername   bool
+	MinScore      int
+	History       int
+	MaxAge        time.Duration
+	ChangeOnReset bool
+}
+
+// NewPasswordPolicy 从配置中读取密码策略
+func NewPasswordPolicy(env *booclient.Environment) *PasswordPolicy {
+	minScore := 0
+	if env.Config.BoolWithDefault("enable_password_check", false) {
+		minScore = 3
+	}
+
+	// 兼容以前的配置
+	maxAgeDays := env.Config.IntWithDefault("users.password_expired_days", 0)
+
+	var bannedWords []string
+	for _, word := range env.Config.StringsWithDefault(CfgUserPasswordBannedWords, nil) {
+		word = strings.TrimSpace(word)
+		if word != "" {
+			bannedWords = append(bannedWords, strings.ToLower(word))
+		}
+	}
+
+	return &PasswordPolicy{
+		MinLength:     env.Config.IntWithDefault(CfgUserPasswordMinLength, 8),
+		MaxLength:     env.Config.IntWithDefault(CfgUserPasswordMaxLength, 250),
+		MinClasses:    env.Config.IntWithDefault(CfgUserPasswordMinClasses, 0),
+		BannedWords:   bannedWords,
+		BanUsername:   env.Config.BoolWithDefault(CfgUserPasswordBanUsername, false),
+		MinScore:      env.Config.IntWithDefault(CfgUserPasswordMinScore, minScore),
+		History:       env.Config.IntWithDefault(CfgUserPasswordHistory, 0),
+		MaxAge:        time.Duration(env.Config.IntWithDefault(CfgUserPasswordMaxAgeDays, maxAgeDays)) * 24 * time.Hour,
+		ChangeOnReset: env.Config.BoolWithDefault(CfgUserPasswordChangeOnReset, false),
+	}
+}
+
+func countCharClasses(password string) int {
+	var lower, upper, digit, special bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			special = true
+		}
+	}
+	count := 0
+	for _, b := range []bool{lower, upper, digit, special} {
+		if b {
+			count++
+		}
+	}
+	return count
+}
+
+// Check 检查密码是否符合策略， names 为用户名和呢称，密码历史由 checkHistory 检查
+func (p *PasswordPolicy) Check(password string, names []string) error {
+	length := len([]rune(password))
+	if p.MinLength > 0 && length < p.MinLength {
+		return errors.New("密码长度不能少于 " + strconv.Itoa(p.MinLength) + " 个字符")
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return errors.New("密码长度不能超过 " + strconv.Itoa(p.MaxLength) + " 个字符")
+	}
+	if p.MinClasses > 0 && countCharClasses(password) < p.MinClasses {
+		return errors.New("密码至少要包含小写字母、大写字母、数字和特殊字符中的 " + strconv.Itoa(p.MinClasses) + " 种")
+	}
+
+	lower := strings.ToLower(password)
+	if p.BanUsername {
+		for _, name := range names {
+			// 太短的名称很容易误判，所以忽略它
+			if len([]rune(name)) >= 3 && strings.Contains(lower, strings.ToLower(name)) {
+				return errors.New("密码不能包含用户名或呢称")
+			}
+		}
+	}
+	for _, word := range p.BannedWords {
+		if strings.Contains(lower, word) {
+			return errors.New("密码不能包含 '" + word + "'")
+		}
+	}
+
+	if p.MinScore > 0 {
+		score, _ := good_password.Check(password, names)
+		if int(score) < p.MinScore {
+			// 1 ("terrible"): "something" (one type)
+			// 2 ("weak"): "somethin1", "somethingnew" (two types)
+			// 3 ("okay"): "Somethin1", "somethinglonger" (three types)
+			// 4 ("good"): "Someth!n1", "somethingmuchlonger" (four types)
+			// >=5 ("strong"): "Someth!n10", "correct horse battery staple" (five types)
+
+			return errors.New("密码强度不足")
+		}
+	}
+	return nil
+}
+
+// IsExpired 密码是否已超过最长有效时间
+func (p *PasswordPolicy) IsExpired(lastModifiedAt time.Time, now time.Time) bool {
+	if p.MaxAge <= 0 || lastModifiedAt.IsZero() {
+		return false
+	}
+	return now.Sub(lastModifiedAt) > p.MaxAge
+}
+
+// isPasswordChangeRequired 用户是否必须先修改密码，只有本地用户才需要
+func (svc UserService) isPasswordChangeRequired(user *User, now time.Time) bool {
+	if user.Source != "" && user.Source != "builtin" && user.Source != "api" {
+		return false
+	}
+	return user.MustChangePassword || svc.passwordPolicy.IsExpired(user.LastPasswordModifiedAt, now)
+}
+
+// Describe 返回策略的说明
+func (p *PasswordPolicy) Describe() *booclient.PasswordPolicy {
+	desc := &booclient.PasswordPolicy{
+		MinLength:   p.MinLength,
+		MaxLength:   p.MaxLength,
+		MinClasses:  p.MinClasses,
+		BanUsername: p.BanUsername,
+		MinScore:    p.MinScore,
+		History:     p.History,
+		MaxAgeDays:  int(p.MaxAge / (24 * time.Hour)),
+	}
+	if p.MinLength > 0 {
+		desc.Rules = append(desc.Rules, "密码长度不能少于 "+strconv.Itoa(p.MinLength)+" 个字符")
+	}
+	if p.MinClasses > 0 {
+		desc.Rules = append(desc.Rules, "密码至少要包含小写字母、大写字母、数字和特殊字符中的 "+strconv.Itoa(p.MinClasses)+" 种")
+	}
+	if p.BanUsername {
+		desc.Rules = append(desc.Rules, "密码不能包含用户名或呢称")
+	}
+	if len(p.BannedWords) > 0 {
+		desc.Rules = append(desc.Rules, "密码不能包含常见的单词")
+	}
+	if p.MinScore > 0 {
+		desc.Rules = append(desc.Rules, "密码不能太简单，请混合使用多种字符或使用更长的密码")
+	}
+	if p.History > 0 {
+		desc.Rules = append(desc.Rules, "新密码不能和最近 "+strconv.Itoa(p.History)+" 次使用过的密码相同")
+	}
+	if desc.MaxAgeDays > 0 {
+		desc.Rules = append(desc.Rules, "密码每 "+strconv.Itoa(desc.MaxAgeDays)+" 天必须修改一次")
+	}
+	return desc
+}
+
+// checkHistory 检查新密码是否和当前的密码或最近使用过的密码相同
+func (svc UserService) checkHistory(ctx context.Context, user *User, password string) error {
+	if svc.passwordPolicy.History <= 0 {
+		return nil
+	}
+	hashes := make([]string, 0, svc.passwordPolicy.History+1)
+	if user.Password != "" {
+		hashes = append(hashes, user.Password)
+	}
+	list, err := svc.passwordHistoryDao.QueryByUserID(ctx, user.ID, svc.passwordPolicy.History)
+	if err != nil {
+		return errors.Wrap(err, "查询用户 '"+user.Name+"' 的密码历史失败")
+	}
+	for idx := range list {
+		hashes = append(hashes, list[idx].Password)
+	}
+
+	for _, hashed := range hashes {
+		if svc.passwordHasher.Compare(ctx, password, hashed) == nil {
+			return errors.New("新密码不能和最近 " + strconv.Itoa(svc.passwordPolicy.History) + " 次使用过的密码相同")
+		}
+	}
+	return nil
+}
+
+// addHistory 记录新的密码，并删除超过策略要求数目的旧记录
+func (svc UserService) addHistory(ctx context.Context, userID int64, hashed string) error {
+	if svc.passwordPolicy.History <= 0 {
+		return nil
+	}
+	if _, err := svc.passwordHistoryDao.Insert(ctx, &PasswordHistory{
+		UserID:   userID,
+		Password: hashed,
+	}); err != nil {
+		return errors.Wrap(err, "记录密码历史失败")
+	}
+
+	list, err := svc.passwordHistoryDao.QueryByUserID(ctx, userID, svc.passwordPolicy.History+1)
+	if err != nil {
+		return errors.Wrap(err, "查询密码历史失败")
+	}
+	if len(list) > svc.passwordPolicy.History {
+		if err := svc.passwordHistoryDao.DeleteBefore(ctx, userID, list[svc.passwordPolicy.History].ID); err != nil {
+			return errors.Wrap(err, "删除旧的密码历史失败")
+		}
+	}
+	return nil
+}
+
+func (svc UserService) PasswordPolicy(ctx context.Context) (*booclient.PasswordPolicy, error) {
+	return svc.passwordPolicy.Describe(), nil
+}
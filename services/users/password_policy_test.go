@@ -0,0 +1,268 @@
+package users_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boo-admin/boo/app_tests"
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn/session_auth"
+	"github.com/boo-admin/boo/services/authn/session_auth/session_core"
+	"github.com/boo-admin/boo/services/users"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := &users.PasswordPolicy{
+		MinLength:   8,
+		MaxLength:   20,
+		MinClasses:  3,
+		BannedWords: []string{"boo"},
+		BanUsername: true,
+	}
+
+	for _, test := range []struct {
+		password string
+		ok       bool
+	}{
+		{password: "Ab1!", ok: false},
+		{password: "Abcd!12345Abcd!12345A", ok: false},
+		{password: "abcdefgh12", ok: false},
+		{password: "Abcd!12345", ok: true},
+		{password: "Tom12345!x", ok: false},
+		{password: "xBOO12345!", ok: false},
+	} {
+		err := policy.Check(test.password, []string{"tom", "汤姆"})
+		if test.ok && err != nil {
+			t.Error(test.password, err)
+		} else if !test.ok && err == nil {
+			t.Error(test.password, "want error got ok")
+		}
+	}
+
+	now := time.Now()
+	if policy.IsExpired(now.Add(-100*24*time.Hour), now) {
+		t.Error("want not expired when max age is 0")
+	}
+	policy.MaxAge = 90 * 24 * time.Hour
+	if !policy.IsExpired(now.Add(-100*24*time.Hour), now) {
+		t.Error("want expired")
+	}
+	if policy.IsExpired(now.Add(-10*24*time.Hour), now) {
+		t.Error("want not expired")
+	}
+	if policy.IsExpired(time.Time{}, now) {
+		t.Error("want not expired when last modified time is zero")
+	}
+
+	desc := policy.Describe()
+	if desc.MinClasses != 3 || desc.MaxAgeDays != 90 || len(desc.Rules) == 0 {
+		t.Errorf("describe is invalid: %#v", desc)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	app := app_tests.NewTestApp(t, map[string]string{
+		users.CfgUserPasswordMinClasses:    "3",
+		users.CfgUserPasswordHistory:       "2",
+		users.CfgUserPasswordChangeOnReset: "true",
+	})
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	adminUsers := booclient.NewRemoteUsers(pxy)
+	policy, err := adminUsers.PasswordPolicy(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if policy.MinClasses != 3 || policy.History != 2 || len(policy.Rules) == 0 {
+		t.Errorf("policy is invalid: %#v", policy)
+	}
+
+	_, err = adminUsers.Create(ctx, &booclient.User{
+		Name:     "policytest",
+		Nickname: "密码策略测试用户",
+		Password: "abcdefgh12",
+	})
+	if err == nil {
+		t.Error("want error got ok")
+		return
+	}
+	id, err := adminUsers.Create(ctx, &booclient.User{
+		Name:     "policytest",
+		Nickname: "密码策略测试用户",
+		Password: "Abcd!12345",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 管理员重置密码后，用户必须先修改密码
+	if err := adminUsers.ChangePassword(ctx, id, "Abcd!12345"); err == nil {
+		t.Error("want error got ok")
+	}
+	if err := adminUsers.ChangePassword(ctx, id, "Bcde!23456"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := http.Post(app.BaseURL()+"/login", "application/x-www-form-urlencoded",
+		strings.NewReader(url.Values{"username": {"policytest"}, "password": {"Bcde!23456"}}.Encode()))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var result struct {
+		IsOK               bool `json:"is_ok"`
+		MustChangePassword bool `json:"must_change_password"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	res.Body.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !result.IsOK || !result.MustChangePassword {
+		t.Error("want must change password", result)
+	}
+
+	userPxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	userPxy.SetBasicAuth("policytest", "Bcde!23456")
+	selfUsers := booclient.NewRemoteUsers(userPxy)
+	if _, err := selfUsers.FindByID(ctx, id); err == nil {
+		t.Error("want error got ok")
+	}
+	if _, err := selfUsers.PasswordPolicy(ctx); err != nil {
+		t.Error(err)
+	}
+
+	// 用户不能用重置密码的接口修改自已的密码，必须校验原密码
+	if err := selfUsers.ChangePassword(ctx, id, "Cdef!34567"); err == nil {
+		t.Error("want error got ok")
+	}
+
+	// 不能使用最近用过的密码
+	if err := selfUsers.ChangeMyPassword(ctx, "Bcde!23456", "Abcd!12345", false); err == nil {
+		t.Error("want error got ok")
+	}
+	if err := selfUsers.ChangeMyPassword(ctx, "Bcde!23456", "Cdef!34567", false); err != nil {
+		t.Error(err)
+		return
+	}
+
+	userPxy.SetBasicAuth("policytest", "Cdef!34567")
+	if _, err := selfUsers.FindByID(ctx, id); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestChangeMyPassword(t *testing.T) {
+	app := app_tests.NewTestApp(t, nil)
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	id, err := booclient.NewRemoteUsers(pxy).Create(ctx, &booclient.User{
+		Name:     "changepwdtest",
+		Nickname: "修改密码测试用户",
+		Password: "Abcd!12345",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	userPxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	userPxy.SetBasicAuth("changepwdtest", "Abcd!12345")
+	selfUsers := booclient.NewRemoteUsers(userPxy)
+
+	if err := selfUsers.ChangeMyPassword(ctx, "bad password", "Bcde!23456", false); err == nil {
+		t.Error("want error got ok")
+	}
+	if err := selfUsers.ChangeMyPassword(ctx, "Abcd!12345", "abc", false); err == nil {
+		t.Error("want error got ok")
+	}
+	if err := selfUsers.ChangeMyPassword(ctx, "Abcd!12345", "Bcde!23456", true); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := selfUsers.FindByID(ctx, id); err == nil {
+		t.Error("want error got ok")
+	}
+	userPxy.SetBasicAuth("changepwdtest", "Bcde!23456")
+	user, err := selfUsers.FindByID(ctx, id)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if user.LastPasswordModifiedAt.IsZero() {
+		t.Error("last_password_modified_at isnot updated")
+	}
+}
+
+func TestChangeMyPasswordLockout(t *testing.T) {
+	app := app_tests.NewTestApp(t, map[string]string{
+		session_auth.CfgUserMaxLoginFailCount: "2",
+	})
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	_, err = booclient.NewRemoteUsers(pxy).Create(ctx, &booclient.User{
+		Name:     "changepwdlock",
+		Nickname: "修改密码锁定测试用户",
+		Password: "Abcd!12345",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 原密码错误和登录共用同一个失败计数，次数过多时用户被锁定
+	userCtx := app.Server.AuthUsers.ContextWithUserByName(ctx, "changepwdlock")
+	for i := 0; i < 2; i++ {
+		if err := app.Server.Users.ChangeMyPassword(userCtx, "bad password", "Bcde!23456", false); err == nil {
+			t.Error("want error got ok")
+		}
+	}
+	if err := app.Server.Users.ChangeMyPassword(userCtx, "Abcd!12345", "Bcde!23456", false); err != session_core.ErrUserLocked {
+		t.Error("want ErrUserLocked got", err)
+	}
+}
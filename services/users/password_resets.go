@@ -0,0 +1,473 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/services/notify"
+	gobatis "github.com/runner-mei/GoBatis"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// CfgUserPasswordResetEnabled 是否允许用户通过邮件或短信重置忘记的密码
+	CfgUserPasswordResetEnabled = "users.password_reset.enabled"
+	// CfgUserPasswordResetExpires 重置链接的有效时间，缺省为 30 分钟
+	CfgUserPasswordResetExpires = "users.password_reset.expires"
+	// CfgUserPasswordResetURL 重置密码的页面地址，令牌会作为 token 参数加在后面，
+	// 没有配置时消息中只包含令牌
+	CfgUserPasswordResetURL = "users.password_reset.url"
+	// CfgUserPasswordResetMaxPerUser 每个用户每小时最多可以申请几次，缺省为 3
+	CfgUserPasswordResetMaxPerUser = "users.password_reset.max_per_user"
+	// CfgUserPasswordResetMaxPerAddress 每个 IP 每小时最多可以申请几次，缺省为 10
+	CfgUserPasswordResetMaxPerAddress = "users.password_reset.max_per_address"
+	// CfgUserPasswordResetEmail 邮件的发送渠道的配置前缀，见 notify.NewEmailSenderFromEnv
+	CfgUserPasswordResetEmail = "users.password_reset.email"
+	// CfgUserPasswordResetSMS 短信的发送渠道的配置前缀，见 notify.NewSMSSenderFromEnv
+	CfgUserPasswordResetSMS = "users.password_reset.sms"
+)
+
+const (
+	passwordResetRateWindow = time.Hour
+
+	// 过期的令牌保留一段时间，用于限制申请的频率
+	passwordResetKeepDuration = 24 * time.Hour
+)
+
+var NewPasswordResetDaoHook func(ref gobatis.SqlSession) PasswordResetDao
+
+func NewPasswordResetDaoWith(ref gobatis.SqlSession) PasswordResetDao {
+	if NewPasswordResetDaoHook != nil {
+		return NewPasswordResetDaoHook(ref)
+	}
+	return NewPasswordResetDao(ref)
+}
+
+var NewPasswordResetAttemptDaoHook func(ref gobatis.SqlSession) PasswordResetAttemptDao
+
+func NewPasswordResetAttemptDaoWith(ref gobatis.SqlSession) PasswordResetAttemptDao {
+	if NewPasswordResetAttemptDaoHook != nil {
+		return NewPasswordResetAttemptDaoHook(ref)
+	}
+	return NewPasswordResetAttemptDao(ref)
+}
+
+// PasswordResets 是忘记密码时重置密码的服务，它的接口都不需要登录
+type PasswordResets interface {
+	// Request 同 booclient.PasswordResets.Request， address 为客户端的地址，用于限制申请的频率
+	Request(ctx context.Context, name, channel, address string) error
+
+	// Confirm 同 booclient.PasswordResets.Confirm
+	Confirm(ctx context.Context, token, password string) error
+}
+
+func NewPasswordResets(env *booclient.Environment,
+	db *gobatis.SessionFactory,
+	users *UserService,
+	operationLogger OperationLogger) (PasswordResets, error) {
+	svc := &passwordResetService{
+		logger:          env.Logger.WithGroup("password_resets"),
+		operationLogger: operationLogger,
+		db:              db,
+		dao:             NewPasswordResetDaoWith(db.SessionReference()),
+		attemptDao:      NewPasswordResetAttemptDaoWith(db.SessionReference()),
+		accessTokenDao:  NewUserAccessTokenDaoWith(db.SessionReference()),
+		users:           users,
+		enabled:         env.Config.BoolWithDefault(CfgUserPasswordResetEnabled, false),
+		expires:         env.Config.DurationWithDefault(CfgUserPasswordResetExpires, 30*time.Minute),
+		url:             env.Config.StringWithDefault(CfgUserPasswordResetURL, ""),
+		maxPerUser:      env.Config.IntWithDefault(CfgUserPasswordResetMaxPerUser, 3),
+		maxPerAddress:   env.Config.IntWithDefault(CfgUserPasswordResetMaxPerAddress, 10),
+		senders:         map[string]notify.Sender{},
+	}
+	if !svc.enabled {
+		return svc, nil
+	}
+
+	email, err := notify.NewEmailSenderFromEnv(env, CfgUserPasswordResetEmail)
+	if err != nil {
+		return nil, errors.Wrap(err, "初始化重置密码的邮件发送渠道失败")
+	}
+	if email != nil {
+		svc.senders[booclient.PasswordResetEmail] = email
+	}
+	sms, err := notify.NewSMSSenderFromEnv(env, CfgUserPasswordResetSMS)
+	if err != nil {
+		return nil, errors.Wrap(err, "初始化重置密码的短信发送渠道失败")
+	}
+	if sms != nil {
+		svc.senders[booclient.PasswordResetSMS] = sms
+	}
+	if len(svc.senders) == 0 {
+		return nil, errors.New("启用了重置密码功能，但是没有配置邮件或短信的发送渠道")
+	}
+	return svc, nil
+}
+
+type passwordResetService struct {
+	logger          *slog.Logger
+	operationLogger OperationLogger
+	db              *gobatis.SessionFactory
+	dao             PasswordResetDao
+	attemptDao      PasswordResetAttemptDao
+	accessTokenDao  UserAccessTokenDao
+	users           *UserService
+
+	enabled       bool
+	expires       time.Duration
+	url           string
+	maxPerUser    int
+	maxPerAddress int
+	senders       map[string]notify.Sender
+}
+
+// allowAddress 记录一次申请，地址在 passwordResetRateWindow 内的申请次数超过 maxPerAddress 时返回 false，
+// 申请次数保存在数据库中，在重启后和多个实例之间都是一致的
+func (svc *passwordResetService) allowAddress(ctx context.Context, address string, now time.Time) (bool, error) {
+	if svc.maxPerAddress <= 0 || address == "" {
+		return true, nil
+	}
+	if _, err := svc.attemptDao.Insert(ctx, &PasswordResetAttempt{Address: address}); err != nil {
+		return false, errors.Wrap(err, "记录重置密码的申请失败")
+	}
+	count, err := svc.attemptDao.CountByAddress(ctx, address, now.Add(-passwordResetRateWindow))
+	if err != nil {
+		return false, errors.Wrap(err, "查询重置密码的申请次数失败")
+	}
+	return count <= int64(svc.maxPerAddress), nil
+}
+
+var errPasswordResetInvalid = errors.WithCode(errors.New("重置链接无效或已过期"), http.StatusBadRequest)
+
+func hashPasswordResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generatePasswordResetToken() (string, error) {
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+// maskContact 隐藏邮箱或手机号的中间部分，用于操作日志
+func maskContact(s string) string {
+	if at := strings.IndexByte(s, '@'); at >= 0 {
+		if at <= 1 {
+			return "*" + s[at:]
+		}
+		return s[:1] + "***" + s[at:]
+	}
+	if len(s) <= 7 {
+		return "***"
+	}
+	return s[:3] + "****" + s[len(s)-4:]
+}
+
+// findUser 按用户名、邮箱或手机号查找用户，找不到或找到多个时返回 nil
+func (svc *passwordResetService) findUser(ctx context.Context, name string) (*User, error) {
+	user, err := svc.users.userDao.FindByName(ctx, name)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.Wrap(err, "查询用户 '"+name+"' 失败")
+	}
+
+	var list []User
+	if strings.Contains(name, "@") {
+		list, err = svc.users.userDao.QueryByEmail(ctx, name)
+	} else {
+		list, err = svc.users.userDao.QueryByMobile(ctx, name)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户 '"+name+"' 失败")
+	}
+	if len(list) != 1 {
+		if len(list) > 1 {
+			svc.logger.WarnContext(ctx, "有多个用户使用相同的邮箱或手机号，不能用它重置密码",
+				slog.String("name", name))
+		}
+		return nil, nil
+	}
+	return &list[0], nil
+}
+
+// selectChannel 选择发送的渠道，返回渠道和接收人，用户没有对应的联系方式时返回空
+func (svc *passwordResetService) selectChannel(user *User, channel string) (string, string) {
+	contacts := map[string]string{
+		booclient.PasswordResetEmail: strings.TrimSpace(user.GetEmail()),
+		booclient.PasswordResetSMS:   strings.TrimSpace(user.GetStringWithDefault(booclient.Mobile.ID, "")),
+	}
+	if channel != "" {
+		return channel, contacts[channel]
+	}
+	for _, name := range []string{booclient.PasswordResetEmail, booclient.PasswordResetSMS} {
+		if _, ok := svc.senders[name]; ok && contacts[name] != "" {
+			return name, contacts[name]
+		}
+	}
+	return "", ""
+}
+
+func (svc *passwordResetService) Request(ctx context.Context, name, channel, address string) error {
+	if !svc.enabled {
+		return errors.WithCode(errors.New("没有启用重置密码功能"), http.StatusNotFound)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.WithCode(errors.New("用户名不能为空"), http.StatusBadRequest)
+	}
+	if channel != "" {
+		if _, ok := svc.senders[channel]; !ok {
+			return errors.WithCode(errors.New("不支持发送方式 '"+channel+"'"), http.StatusBadRequest)
+		}
+	}
+
+	now := time.Now()
+	if _, err := svc.dao.DeleteBefore(ctx, now.Add(-passwordResetKeepDuration)); err != nil {
+		svc.logger.WarnContext(ctx, "删除过期的重置密码令牌失败", slog.Any("err", err))
+	}
+	if _, err := svc.attemptDao.DeleteBefore(ctx, now.Add(-passwordResetRateWindow)); err != nil {
+		svc.logger.WarnContext(ctx, "删除过期的重置密码申请记录失败", slog.Any("err", err))
+	}
+	// 每次申请都计数，包括用户不存在的申请，防止用它来探测用户是否存在
+	if ok, err := svc.allowAddress(ctx, address, now); err != nil {
+		return err
+	} else if !ok {
+		return errors.WithCode(errors.New("申请太频繁，请稍后再试"), http.StatusTooManyRequests)
+	}
+
+	// 下面的情况都不返回错误，避免泄露用户是否存在
+	user, err := svc.findUser(ctx, name)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Disabled {
+		svc.logger.InfoContext(ctx, "申请重置密码的用户不存在或已禁用",
+			slog.String("name", name),
+			slog.String("address", address))
+		return nil
+	}
+	if user.Source != "" && user.Source != "builtin" {
+		svc.logger.InfoContext(ctx, "申请重置密码的用户不是本地用户",
+			slog.String("name", user.Name),
+			slog.String("source", user.Source))
+		return nil
+	}
+	channel, to := svc.selectChannel(user, channel)
+	if to == "" {
+		svc.logger.InfoContext(ctx, "申请重置密码的用户没有邮箱或手机号",
+			slog.String("name", user.Name),
+			slog.String("channel", channel))
+		return nil
+	}
+	if svc.maxPerUser > 0 {
+		count, err := svc.dao.CountByUserID(ctx, user.ID, now.Add(-passwordResetRateWindow))
+		if err != nil {
+			return errors.Wrap(err, "查询重置密码的申请次数失败")
+		}
+		if count >= int64(svc.maxPerUser) {
+			svc.logger.WarnContext(ctx, "用户申请重置密码太频繁",
+				slog.String("name", user.Name),
+				slog.String("address", address))
+			svc.logRecord(ctx, nil, user, "requestpasswordreset", false,
+				"用户 '"+user.Name+"' 申请重置密码太频繁，已忽略", channel, to, address)
+			return nil
+		}
+	}
+
+	token, err := generatePasswordResetToken()
+	if err != nil {
+		return errors.Wrap(err, "生成重置密码的令牌失败")
+	}
+	err = svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		_, err := svc.dao.Insert(ctx, &PasswordReset{
+			UserID:    user.ID,
+			TokenHash: hashPasswordResetToken(token),
+			Channel:   channel,
+			Address:   address,
+			ExpiresAt: now.Add(svc.expires),
+		})
+		if err != nil {
+			return errors.Wrap(err, "保存重置密码的令牌失败")
+		}
+
+		svc.logRecord(ctx, tx, user, "requestpasswordreset", true,
+			"用户 '"+user.Name+"' 申请重置密码", channel, to, address)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// 在后台发送，发送的耗时和失败都会泄露用户存在并且有联系方式，所以失败时只记录日志
+	msg := svc.newMessage(user, channel, to, token)
+	go func() {
+		if err := svc.senders[channel].Send(context.Background(), msg); err != nil {
+			svc.logger.Warn("发送重置密码的消息失败",
+				slog.String("name", user.Name),
+				slog.String("channel", channel),
+				slog.Any("err", err))
+		}
+	}()
+	return nil
+}
+
+func (svc *passwordResetService) newMessage(user *User, channel, to, token string) *notify.Message {
+	link := token
+	if svc.url != "" {
+		if strings.Contains(svc.url, "?") {
+			link = svc.url + "&token=" + token
+		} else {
+			link = svc.url + "?token=" + token
+		}
+	}
+	minutes := strconv.Itoa(int(svc.expires / time.Minute))
+
+	if channel == booclient.PasswordResetSMS {
+		return &notify.Message{
+			To:      to,
+			Content: "您正在重置密码，请在 " + minutes + " 分钟内使用下面的链接设置新密码，如非本人操作请忽略：" + link,
+		}
+	}
+	return &notify.Message{
+		To:      to,
+		Subject: "重置密码",
+		Content: user.Nickname + "，您好：\n\n" +
+			"您正在重置用户 '" + user.Name + "' 的密码，请在 " + minutes + " 分钟内打开下面的链接设置新密码：\n\n" +
+			link + "\n\n" +
+			"链接只能使用一次。如果这不是您本人的操作，请忽略这封邮件，您的密码不会被修改。\n",
+	}
+}
+
+func (svc *passwordResetService) Confirm(ctx context.Context, token, password string) error {
+	if !svc.enabled {
+		return errors.WithCode(errors.New("没有启用重置密码功能"), http.StatusNotFound)
+	}
+	if token == "" {
+		return errPasswordResetInvalid
+	}
+
+	reset, err := svc.dao.FindByHash(ctx, hashPasswordResetToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errPasswordResetInvalid
+		}
+		return errors.Wrap(err, "查询重置密码的令牌失败")
+	}
+	now := time.Now()
+	if reset.UsedAt.Valid || !reset.ExpiresAt.After(now) {
+		return errPasswordResetInvalid
+	}
+
+	user, err := svc.users.userDao.FindByID(ctx, reset.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errPasswordResetInvalid
+		}
+		return errors.Wrap(err, "查询用户 '"+strconv.FormatInt(reset.UserID, 10)+"' 失败")
+	}
+	if user.Disabled || user.DeletedAt != nil {
+		return errPasswordResetInvalid
+	}
+
+	if err := svc.users.ValidatePassword([]string{user.Name, user.Nickname}, password); err != nil {
+		return err
+	}
+	if err := svc.users.checkHistory(ctx, user, password); err != nil {
+		return err
+	}
+
+	err = svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		// 并发使用同一个令牌时只有一个能成功
+		if count, err := svc.dao.MarkUsed(ctx, reset.ID, now); err != nil {
+			return errors.Wrap(err, "更新重置密码的令牌失败")
+		} else if count == 0 {
+			return errPasswordResetInvalid
+		}
+		// 用户的其它令牌也一起作废
+		if _, err := svc.dao.MarkUsedByUserID(ctx, user.ID, now); err != nil {
+			return errors.Wrap(err, "更新重置密码的令牌失败")
+		}
+		// 忘记的密码可能已经泄露，用它创建的访问令牌也一起删除
+		if _, err := svc.accessTokenDao.DeleteByUserID(ctx, user.ID); err != nil {
+			return errors.Wrap(err, "删除用户的访问令牌失败")
+		}
+
+		return svc.users.updatePassword(ctx, user, password, false, func(ctx context.Context, tx *gobatis.Tx) {
+			svc.logRecord(ctx, tx, user, "resetpasswordbytoken", true,
+				"用户 '"+user.Name+"' 通过重置链接修改密码成功", reset.Channel, "", reset.Address)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	// 注销用户所有的在线会话，刷新令牌绑定在会话上，也就不能再使用了
+	if svc.users.onlines != nil {
+		if err := svc.users.onlines.LogoutByUsername(ctx, user.Name); err != nil {
+			return errors.Wrap(err, "注销用户 '"+user.Name+"' 的在线会话失败")
+		}
+	}
+	return nil
+}
+
+func (svc *passwordResetService) logRecord(ctx context.Context, tx *gobatis.Tx, user *User, typ string, successful bool, content, channel, to, address string) {
+	if !enableOplog {
+		return
+	}
+	records := []ChangeRecord{
+		{
+			Name:        "channel",
+			DisplayName: "发送方式",
+			OldValue:    channel,
+		},
+	}
+	if to != "" {
+		records = append(records, ChangeRecord{
+			Name:        "to",
+			DisplayName: "接收人",
+			OldValue:    maskContact(to),
+		})
+	}
+	if address != "" {
+		records = append(records, ChangeRecord{
+			Name:        "address",
+			DisplayName: "申请地址",
+			OldValue:    address,
+		})
+	}
+
+	oplogger := svc.operationLogger
+	if tx != nil {
+		oplogger = oplogger.WithTx(tx.DB())
+	}
+	err := oplogger.LogRecord(ctx, &OperationLog{
+		UserID:     user.ID,
+		Username:   user.Nickname,
+		Successful: successful,
+		Type:       typ,
+		Content:    content,
+		Fields: &OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   user.ID,
+			Records:    records,
+		},
+	})
+	if err != nil {
+		svc.logger.WarnContext(ctx, "记录重置密码的操作失败", slog.Any("err", err))
+	}
+}
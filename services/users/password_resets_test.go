@@ -0,0 +1,169 @@
+package users_test
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boo-admin/boo/app_tests"
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/notify"
+	"github.com/boo-admin/boo/services/users"
+)
+
+func TestPasswordResets(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "mails.txt")
+	app := app_tests.NewTestApp(t, map[string]string{
+		users.CfgUserPasswordResetEnabled:             "true",
+		users.CfgUserPasswordResetURL:                 "http://127.0.0.1/reset_password",
+		users.CfgUserPasswordResetMaxPerUser:          "2",
+		users.CfgUserPasswordResetMaxPerAddress:       "4",
+		users.CfgUserPasswordResetEmail + ".sender":   "file",
+		users.CfgUserPasswordResetEmail + ".filename": filename,
+	})
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	userID, err := booclient.NewRemoteUsers(pxy).Create(ctx, &booclient.User{
+		Name:     "resettest",
+		Nickname: "重置密码测试用户",
+		Password: "Abcd!12345",
+		Fields: map[string]interface{}{
+			booclient.Email.ID: "resettest@example.com",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	anonymous, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resets := booclient.NewRemotePasswordResets(anonymous)
+
+	// 用户不存在时也返回成功，但不会发送消息
+	if err := resets.Request(ctx, "notexists", ""); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := resets.Request(ctx, "resettest@example.com", ""); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := resets.Request(ctx, "resettest", booclient.PasswordResetEmail); err != nil {
+		t.Error(err)
+		return
+	}
+	// 超过了每个用户的次数限制，不会再发送
+	if err := resets.Request(ctx, "resettest", ""); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := resets.Request(ctx, "resettest", booclient.PasswordResetSMS); err == nil {
+		t.Error("want error got ok")
+	}
+
+	// 消息是在后台发送的
+	var messages []notify.Message
+	for i := 0; i < 50; i++ {
+		messages, err = notify.ReadFile(filename)
+		if err == nil && len(messages) >= 2 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(messages) != 2 {
+		t.Error("want 2 got", len(messages))
+		return
+	}
+	tokens := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		if msg.To != "resettest@example.com" {
+			t.Error("want resettest@example.com got", msg.To)
+		}
+		idx := strings.Index(msg.Content, "http://127.0.0.1/reset_password?")
+		if idx < 0 {
+			t.Error("link isnot found:", msg.Content)
+			return
+		}
+		link := strings.Fields(msg.Content[idx:])[0]
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		tokens = append(tokens, u.Query().Get("token"))
+	}
+
+	token, err := booclient.NewRemoteAccessTokens(pxy).Create(ctx, userID, &booclient.AccessToken{
+		Name: "resettest",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tokenPxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tokenPxy.SetHeader("Authorization", "Token "+token.Token)
+	if _, err := booclient.NewRemoteUsers(tokenPxy).PasswordPolicy(ctx); err != nil {
+		t.Error(err)
+	}
+
+	if err := resets.Confirm(ctx, "bad token", "Bcde!23456"); err == nil {
+		t.Error("want error got ok")
+	}
+	if err := resets.Confirm(ctx, tokens[1], "abc"); err == nil {
+		t.Error("want error got ok")
+	}
+	if err := resets.Confirm(ctx, tokens[1], "Bcde!23456"); err != nil {
+		t.Error(err)
+		return
+	}
+	// 令牌只能使用一次，用户的其它令牌也会作废
+	if err := resets.Confirm(ctx, tokens[1], "Cdef!34567"); err == nil {
+		t.Error("want error got ok")
+	}
+	if err := resets.Confirm(ctx, tokens[0], "Cdef!34567"); err == nil {
+		t.Error("want error got ok")
+	}
+	// 重置密码后用户的访问令牌也会被删除
+	if _, err := booclient.NewRemoteUsers(tokenPxy).PasswordPolicy(ctx); err == nil {
+		t.Error("want error got ok")
+	}
+
+	userPxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	userPxy.SetBasicAuth("resettest", "Bcde!23456")
+	if _, err := booclient.NewRemoteUsers(userPxy).PasswordPolicy(ctx); err != nil {
+		t.Error(err)
+	}
+
+	// 同一个地址的申请次数包括用户不存在的申请
+	if err := resets.Request(ctx, "notexists", ""); err == nil {
+		t.Error("want error got ok")
+	}
+}
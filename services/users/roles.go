@@ -3,6 +3,7 @@ package users
 import (
 	"context"
 	"strconv"
+	"strings"
 
 	"github.com/boo-admin/boo/booclient"
 	"github.com/boo-admin/boo/errors"
@@ -22,23 +23,46 @@ func NewRoleDaoWith(ref gobatis.SqlSession) RoleDao {
 	return NewRoleDao(ref)
 }
 
+var NewRolePermissionDaoHook func(ref gobatis.SqlSession) RolePermissionDao
+
+func NewRolePermissionDaoWith(ref gobatis.SqlSession) RolePermissionDao {
+	if NewRolePermissionDaoHook != nil {
+		return NewRolePermissionDaoHook(ref)
+	}
+	return NewRolePermissionDao(ref)
+}
+
+var NewRoleDepartmentDaoHook func(ref gobatis.SqlSession) RoleDepartmentDao
+
+func NewRoleDepartmentDaoWith(ref gobatis.SqlSession) RoleDepartmentDao {
+	if NewRoleDepartmentDaoHook != nil {
+		return NewRoleDepartmentDaoHook(ref)
+	}
+	return NewRoleDepartmentDao(ref)
+}
+
 func NewRoles(env *booclient.Environment,
 	db *gobatis.SessionFactory,
 	operationLogger OperationLogger) (booclient.Roles, error) {
 	return roleService{
-		env:             env,
-		logger:          env.Logger.WithGroup("roles"),
-		operationLogger: operationLogger,
-		dao:             NewRoleDaoWith(db.SessionReference()),
+		env:               env,
+		logger:            env.Logger.WithGroup("roles"),
+		operationLogger:   operationLogger,
+		db:                db,
+		dao:               NewRoleDaoWith(db.SessionReference()),
+		permissionDao:     NewRolePermissionDaoWith(db.SessionReference()),
+		roleDepartmentDao: NewRoleDepartmentDaoWith(db.SessionReference()),
 	}, nil
 }
 
 type roleService struct {
-	env             *booclient.Environment
-	logger          *slog.Logger
-	operationLogger OperationLogger
-	// db              *gobatis.SessionFactory
-	dao RoleDao
+	env               *booclient.Environment
+	logger            *slog.Logger
+	operationLogger   OperationLogger
+	db                *gobatis.SessionFactory
+	dao               RoleDao
+	permissionDao     RolePermissionDao
+	roleDepartmentDao RoleDepartmentDao
 }
 
 func (svc roleService) Create(ctx context.Context, role *Role) (int64, error) {
@@ -67,6 +91,9 @@ func (svc roleService) Create(ctx context.Context, role *Role) (int64, error) {
 	} else if exists {
 		v.Error("name", "无法新建角色 '"+role.Title+"'，该角色已存在")
 	}
+	if !booclient.IsValidDataScope(role.DataScope) {
+		v.Error("data_scope", "无法新建角色 '"+role.Title+"'，数据范围 '"+role.DataScope+"' 不正确")
+	}
 	if v.HasErrors() {
 		return 0, v.ToError()
 	}
@@ -95,6 +122,9 @@ func (svc roleService) UpdateByID(ctx context.Context, id int64, role *Role) err
 	if role.Title == "" {
 		v.Error("name", "无法更新角色 '"+role.Title+"'，该角色名为空")
 	}
+	if !booclient.IsValidDataScope(role.DataScope) {
+		v.Error("data_scope", "无法更新角色 '"+role.Title+"'，数据范围 '"+role.DataScope+"' 不正确")
+	}
 	if v.HasErrors() {
 		return v.ToError()
 	}
@@ -144,13 +174,21 @@ func (svc roleService) DeleteByID(ctx context.Context, id int64) error {
 		return errors.Wrap(err, "删除角色时，查询角色 '"+strconv.FormatInt(id, 10)+"' 失败")
 	}
 
-	err = svc.dao.DeleteByID(ctx, id)
-	if err != nil {
-		return errors.Wrap(err, "删除角色失败")
-	}
+	// sqlite 缺省不启用外键，不能依赖 ON DELETE CASCADE 删除角色的权限和数据范围
+	return svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		if err := svc.permissionDao.DeleteByRoleID(ctx, id); err != nil {
+			return errors.Wrap(err, "删除角色 '"+old.Title+"' 的权限失败")
+		}
+		if err := svc.roleDepartmentDao.DeleteByRoleID(ctx, id); err != nil {
+			return errors.Wrap(err, "删除角色 '"+old.Title+"' 的数据范围失败")
+		}
+		if err := svc.dao.DeleteByID(ctx, id); err != nil {
+			return errors.Wrap(err, "删除角色失败")
+		}
 
-	svc.logDelete(ctx, nil, currentUser, old)
-	return nil
+		svc.logDelete(ctx, tx, currentUser, old)
+		return nil
+	})
 }
 func (svc roleService) FindByID(ctx context.Context, id int64) (*Role, error) {
 	currentUser, err := authn.ReadUserFromContext(ctx)
@@ -197,6 +235,314 @@ func (svc roleService) List(ctx context.Context, keyword string, sort string, of
 	return svc.dao.List(ctx, keyword, sort, offset, limit)
 }
 
+func (svc roleService) ListPermissions(ctx context.Context) ([]booclient.Permission, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpViewRole); err != nil {
+		return nil, errors.Wrap(err, "判断当前角色是否有权限失败")
+	} else if !ok {
+		return nil, errors.NewOperationReject(authn.OpViewRole)
+	}
+
+	return authn.GetPermissions(), nil
+}
+
+func (svc roleService) GetPermissions(ctx context.Context, id int64) ([]string, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !currentUser.HasRoleID(id) {
+		if ok, err := currentUser.HasPermission(ctx, authn.OpViewRole); err != nil {
+			return nil, errors.Wrap(err, "判断当前角色是否有权限失败")
+		} else if !ok {
+			return nil, errors.NewOperationReject(authn.OpViewRole)
+		}
+	}
+
+	permissions, err := svc.permissionDao.QueryByRoleID(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询角色 '"+strconv.FormatInt(id, 10)+"' 的权限失败")
+	}
+	return permissions, nil
+}
+
+func (svc roleService) GrantPermissions(ctx context.Context, id int64, permissions []string) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpUpdateRole); err != nil {
+		return errors.Wrap(err, "判断当前角色是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpUpdateRole)
+	}
+
+	v := validation.Default.New()
+	for _, permission := range permissions {
+		if _, ok := authn.GetPermission(permission); !ok {
+			v.Error("permissions", "权限 '"+permission+"' 不存在")
+		}
+	}
+	if v.HasErrors() {
+		return v.ToError()
+	}
+
+	role, err := svc.dao.FindByID(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "授权时，查询角色 '"+strconv.FormatInt(id, 10)+"' 失败")
+	}
+
+	// 只能授予自已拥有的权限，以免通过授权提升权限
+	for _, permission := range permissions {
+		if ok, err := currentUser.HasPermission(ctx, permission); err != nil {
+			return errors.Wrap(err, "判断当前角色是否有权限失败")
+		} else if !ok {
+			return errors.Wrap(errors.ErrPermissionDeny, "不能授予角色 '"+role.Title+"' 权限 '"+permission+"'，当前用户没有这个权限")
+		}
+	}
+
+	return svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		old, err := svc.permissionDao.QueryByRoleID(ctx, id)
+		if err != nil {
+			return errors.Wrap(err, "查询角色 '"+role.Title+"' 的权限失败")
+		}
+
+		var added []string
+		for _, permission := range permissions {
+			if containsString(old, permission) || containsString(added, permission) {
+				continue
+			}
+			err = svc.permissionDao.Upsert(ctx, id, permission)
+			if err != nil {
+				return errors.Wrap(err, "授予角色 '"+role.Title+"' 权限 '"+permission+"' 失败")
+			}
+			added = append(added, permission)
+		}
+		if len(added) > 0 {
+			svc.logPermissions(ctx, tx, currentUser, role, added, nil)
+		}
+		return nil
+	})
+}
+
+func (svc roleService) RevokePermissions(ctx context.Context, id int64, permissions []string) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpUpdateRole); err != nil {
+		return errors.Wrap(err, "判断当前角色是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpUpdateRole)
+	}
+
+	role, err := svc.dao.FindByID(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "收回权限时，查询角色 '"+strconv.FormatInt(id, 10)+"' 失败")
+	}
+
+	return svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		old, err := svc.permissionDao.QueryByRoleID(ctx, id)
+		if err != nil {
+			return errors.Wrap(err, "查询角色 '"+role.Title+"' 的权限失败")
+		}
+
+		var removed []string
+		for _, permission := range permissions {
+			if !containsString(old, permission) || containsString(removed, permission) {
+				continue
+			}
+			err = svc.permissionDao.Delete(ctx, id, permission)
+			if err != nil {
+				return errors.Wrap(err, "收回角色 '"+role.Title+"' 的权限 '"+permission+"' 失败")
+			}
+			removed = append(removed, permission)
+		}
+		if len(removed) > 0 {
+			svc.logPermissions(ctx, tx, currentUser, role, nil, removed)
+		}
+		return nil
+	})
+}
+
+func (svc roleService) GetDataDepartments(ctx context.Context, id int64) ([]int64, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !currentUser.HasRoleID(id) {
+		if ok, err := currentUser.HasPermission(ctx, authn.OpViewRole); err != nil {
+			return nil, errors.Wrap(err, "判断当前角色是否有权限失败")
+		} else if !ok {
+			return nil, errors.NewOperationReject(authn.OpViewRole)
+		}
+	}
+
+	departments, err := svc.roleDepartmentDao.QueryByRoleID(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询角色 '"+strconv.FormatInt(id, 10)+"' 的数据范围失败")
+	}
+	return departments, nil
+}
+
+func (svc roleService) SetDataDepartments(ctx context.Context, id int64, departments []int64) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpUpdateRole); err != nil {
+		return errors.Wrap(err, "判断当前角色是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpUpdateRole)
+	}
+
+	role, err := svc.dao.FindByID(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "设置数据范围时，查询角色 '"+strconv.FormatInt(id, 10)+"' 失败")
+	}
+
+	return svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		old, err := svc.roleDepartmentDao.QueryByRoleID(ctx, id)
+		if err != nil {
+			return errors.Wrap(err, "查询角色 '"+role.Title+"' 的数据范围失败")
+		}
+
+		var changed bool
+		for _, departmentID := range old {
+			if containsInt64(departments, departmentID) {
+				continue
+			}
+			err = svc.roleDepartmentDao.Delete(ctx, id, departmentID)
+			if err != nil {
+				return errors.Wrap(err, "从角色 '"+role.Title+"' 的数据范围中删除部门 '"+strconv.FormatInt(departmentID, 10)+"' 失败")
+			}
+			changed = true
+		}
+		for _, departmentID := range departments {
+			if containsInt64(old, departmentID) {
+				continue
+			}
+			err = svc.roleDepartmentDao.Upsert(ctx, id, departmentID)
+			if err != nil {
+				return errors.Wrap(err, "在角色 '"+role.Title+"' 的数据范围中增加部门 '"+strconv.FormatInt(departmentID, 10)+"' 失败")
+			}
+			changed = true
+		}
+		if changed {
+			svc.logDataDepartments(ctx, tx, currentUser, role, old, departments)
+		}
+		return nil
+	})
+}
+
+func dataScopeTitle(scope string) string {
+	switch scope {
+	case "", booclient.DataScopeAll:
+		return "全部数据"
+	case booclient.DataScopeDepartment:
+		return "本部门数据"
+	case booclient.DataScopeDepartmentAndChildren:
+		return "本部门及下级部门数据"
+	case booclient.DataScopeCustom:
+		return "自定义部门数据"
+	}
+	return scope
+}
+
+func (svc roleService) logDataDepartments(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, role *Role, old, departments []int64) {
+	oplogger := svc.operationLogger
+	if tx != nil {
+		oplogger = oplogger.WithTx(tx.DB())
+	}
+	err := oplogger.LogRecord(ctx, &OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Nickname(),
+		Successful: true,
+		Type:       authn.OpUpdateRole,
+		Content:    "修改角色 '" + role.Title + "' 的数据范围成功",
+		Fields: &OperationLogRecord{
+			ObjectType: "role",
+			ObjectID:   role.ID,
+			Records: []ChangeRecord{{
+				Name:        "data_departments",
+				DisplayName: "数据范围中的部门",
+				OldValue:    old,
+				NewValue:    departments,
+			}},
+		},
+	})
+	if err != nil {
+		svc.logger.WarnContext(ctx, "记录修改角色数据范围的操作失败", slog.Any("err", err))
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, a := range list {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
+
+func permissionTitles(ids []string) []string {
+	titles := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if p, ok := authn.GetPermission(id); ok && p.Title != "" {
+			titles = append(titles, p.Title)
+		} else {
+			titles = append(titles, id)
+		}
+	}
+	return titles
+}
+
+func (svc roleService) logPermissions(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, role *Role, added, removed []string) {
+	records := make([]ChangeRecord, 0, 2)
+	content := "修改角色 '" + role.Title + "' 的权限成功"
+	if len(added) > 0 {
+		content = "授予角色 '" + role.Title + "' 权限成功"
+		records = append(records, ChangeRecord{
+			Name:            "permissions",
+			DisplayName:     "授予权限",
+			NewValue:        added,
+			NewDisplayValue: strings.Join(permissionTitles(added), ","),
+		})
+	}
+	if len(removed) > 0 {
+		content = "收回角色 '" + role.Title + "' 的权限成功"
+		records = append(records, ChangeRecord{
+			Name:            "permissions",
+			DisplayName:     "收回权限",
+			OldValue:        removed,
+			OldDisplayValue: strings.Join(permissionTitles(removed), ","),
+		})
+	}
+
+	oplogger := svc.operationLogger
+	if tx != nil {
+		oplogger = oplogger.WithTx(tx.DB())
+	}
+	err := oplogger.LogRecord(ctx, &OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Nickname(),
+		Successful: true,
+		Type:       authn.OpUpdateRole,
+		Content:    content,
+		Fields: &OperationLogRecord{
+			ObjectType: "role",
+			ObjectID:   role.ID,
+			Records:    records,
+		},
+	})
+	if err != nil {
+		svc.logger.WarnContext(ctx, "记录修改角色权限的操作失败", slog.Any("err", err))
+	}
+}
+
 func (svc roleService) logCreate(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, id int64, role *Role) {
 	records := make([]ChangeRecord, 0, 10)
 	records = append(records, ChangeRecord{
@@ -214,6 +560,17 @@ func (svc roleService) logCreate(ctx context.Context, tx *gobatis.Tx, currentUse
 		DisplayName: "角色描述",
 		NewValue:    role.Description,
 	})
+	records = append(records, ChangeRecord{
+		Name:            "data_scope",
+		DisplayName:     "数据范围",
+		NewValue:        role.DataScope,
+		NewDisplayValue: dataScopeTitle(role.DataScope),
+	})
+	records = append(records, ChangeRecord{
+		Name:        "two_factor_required",
+		DisplayName: "要求两步验证",
+		NewValue:    role.TwoFactorRequired,
+	})
 	// for _, field := range svc.fields {
 	// 	fv, _ := role.Fields[field.ID]
 	// 	if fv == nil {
@@ -265,6 +622,24 @@ func (svc roleService) logUpdate(ctx context.Context, tx *gobatis.Tx, currentUse
 			NewValue:    role.Description,
 		})
 	}
+	if role.DataScope != old.DataScope {
+		records = append(records, ChangeRecord{
+			Name:            "data_scope",
+			DisplayName:     "数据范围",
+			OldValue:        old.DataScope,
+			NewValue:        role.DataScope,
+			OldDisplayValue: dataScopeTitle(old.DataScope),
+			NewDisplayValue: dataScopeTitle(role.DataScope),
+		})
+	}
+	if role.TwoFactorRequired != old.TwoFactorRequired {
+		records = append(records, ChangeRecord{
+			Name:        "two_factor_required",
+			DisplayName: "要求两步验证",
+			OldValue:    old.TwoFactorRequired,
+			NewValue:    role.TwoFactorRequired,
+		})
+	}
 
 	// for _, field := range svc.fields {
 	// 	var oldfv, newfv interface{}
@@ -0,0 +1,154 @@
+package users_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/boo-admin/boo/app_tests"
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/services/authn"
+)
+
+func TestRolePermissions(t *testing.T) {
+	app := app_tests.NewTestApp(t, nil)
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	roles := booclient.NewRemoteRoles(pxy)
+
+	permissions, err := roles.ListPermissions(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	found := false
+	for _, p := range permissions {
+		if p.ID == authn.OpCreateUser {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Error("permission '" + authn.OpCreateUser + "' isnot found")
+	}
+
+	roleID, err := roles.Create(ctx, &booclient.Role{
+		Title: "permission_test",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = roles.GrantPermissions(ctx, roleID, []string{authn.OpCreateUser, authn.OpViewUser})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = roles.GrantPermissions(ctx, roleID, []string{"notexists"})
+	if err == nil {
+		t.Error("want error got ok")
+	}
+
+	err = roles.RevokePermissions(ctx, roleID, []string{authn.OpCreateUser})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	granted, err := roles.GetPermissions(ctx, roleID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(granted) != 1 || granted[0] != authn.OpViewUser {
+		t.Error("want", []string{authn.OpViewUser}, "got", granted)
+	}
+
+	// 非管理员用户只能执行它的角色被授予的操作
+	users := booclient.NewRemoteUsers(pxy)
+	for _, u := range []booclient.User{
+		{Name: "permission_test", Nickname: "permission_test", Password: "Abcd!12345", Roles: []booclient.Role{{Title: "permission_test"}}},
+		{Name: "permission_test_other", Nickname: "permission_test_other"},
+	} {
+		_, err = users.Create(ctx, &u)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	userPxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	userPxy.SetBasicAuth("permission_test", "Abcd!12345")
+	userUsers := booclient.NewRemoteUsers(userPxy)
+
+	if _, err := userUsers.FindByName(ctx, "permission_test_other"); err != nil {
+		t.Error(err)
+	}
+	_, err = userUsers.Create(ctx, &booclient.User{Name: "permission_test_created", Nickname: "permission_test_created"})
+	if err == nil {
+		t.Error("want error got ok")
+	}
+	_, err = booclient.NewRemoteDepartments(userPxy).Create(ctx, &booclient.Department{Name: "permission_test"})
+	if err == nil {
+		t.Error("want error got ok")
+	}
+
+	// 不能授予自已没有的权限，也不能关联自已没有的角色
+	err = roles.GrantPermissions(ctx, roleID, []string{authn.OpUpdateRole, authn.OpUpdateUser})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	userRoles := booclient.NewRemoteRoles(userPxy)
+	if err := userRoles.GrantPermissions(ctx, roleID, []string{authn.OpDeleteUser}); err == nil {
+		t.Error("want error got ok")
+	}
+	if err := userRoles.GrantPermissions(ctx, roleID, []string{authn.OpUpdateUser}); err != nil {
+		t.Error(err)
+	}
+	other, err := userUsers.FindByName(ctx, "permission_test_other")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	other.Roles = []booclient.Role{{Title: "administrator"}}
+	if err := userUsers.UpdateByID(ctx, other.ID, other, booclient.UpdateModeAdd); err == nil {
+		t.Error("want error got ok")
+	}
+	other.Roles = []booclient.Role{{Title: "permission_test"}}
+	if err := userUsers.UpdateByID(ctx, other.ID, other, booclient.UpdateModeAdd); err != nil {
+		t.Error(err)
+	}
+
+	// 删除角色时同时删除它的权限
+	err = roles.DeleteByID(ctx, roleID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	granted, err = roles.GetPermissions(ctx, roleID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(granted) != 0 {
+		t.Error("want empty got", granted)
+	}
+	if _, err := userUsers.FindByName(ctx, "permission_test_other"); err == nil {
+		t.Error("want error got ok")
+	}
+}
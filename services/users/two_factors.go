@@ -0,0 +1,555 @@
+package users
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/errors"
+	"github.com/boo-admin/boo/goutils/totp"
+	"github.com/boo-admin/boo/services/authn"
+	gobatis "github.com/runner-mei/GoBatis"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	CfgUserTOTPEnabled   = "users.totp.enabled"
+	CfgUserTOTPIssuer    = "users.totp.issuer"
+	CfgUserTOTPSecretKey = "users.totp.secret_key"
+	CfgUserTOTPSkew      = "users.totp.skew"
+)
+
+const recoveryCodeCount = 10
+
+var NewUserTwoFactorDaoHook func(ref gobatis.SqlSession) UserTwoFactorDao
+
+func NewUserTwoFactorDaoWith(ref gobatis.SqlSession) UserTwoFactorDao {
+	if NewUserTwoFactorDaoHook != nil {
+		return NewUserTwoFactorDaoHook(ref)
+	}
+	return NewUserTwoFactorDao(ref)
+}
+
+// secretCipher 用 AES-GCM 加密保存在数据库中的 TOTP 密钥
+type secretCipher struct {
+	aead cipher.AEAD
+}
+
+func newSecretCipher(key string) (*secretCipher, error) {
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretCipher{aead: aead}, nil
+}
+
+func (c *secretCipher) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	bs := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.RawStdEncoding.EncodeToString(bs), nil
+}
+
+func (c *secretCipher) Decrypt(ciphertext string) (string, error) {
+	bs, err := base64.RawStdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(bs) < c.aead.NonceSize() {
+		return "", errors.New("ciphertext is too short")
+	}
+	nonce, bs := bs[:c.aead.NonceSize()], bs[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, bs, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.Replace(code, "-", "", -1)
+	return strings.Replace(code, " ", "", -1)
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateRecoveryCodes 生成恢复码，返回给用户的明文和保存到数据库中的 hash 值
+func generateRecoveryCodes() ([]string, string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashed := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		bs := make([]byte, 7)
+		if _, err := rand.Read(bs); err != nil {
+			return nil, "", err
+		}
+		s := recoveryCodeEncoding.EncodeToString(bs)[:10]
+		code := s[:5] + "-" + s[5:]
+		codes = append(codes, code)
+		hashed = append(hashed, hashRecoveryCode(code))
+	}
+	return codes, strings.Join(hashed, ","), nil
+}
+
+func splitRecoveryCodes(hashed string) []string {
+	if hashed == "" {
+		return nil
+	}
+	return strings.Split(hashed, ",")
+}
+
+// useRecoveryCode 校验恢复码，成功时返回去掉这个恢复码后剩下的恢复码
+func useRecoveryCode(hashed, code string) (string, bool) {
+	if normalizeRecoveryCode(code) == "" {
+		return hashed, false
+	}
+	h := hashRecoveryCode(code)
+	list := splitRecoveryCodes(hashed)
+	for idx := range list {
+		if list[idx] == h {
+			remaining := append(append([]string{}, list[:idx]...), list[idx+1:]...)
+			return strings.Join(remaining, ","), true
+		}
+	}
+	return hashed, false
+}
+
+func newTwoFactorService(env *booclient.Environment, users *UserService) (*twoFactorService, error) {
+	svc := &twoFactorService{
+		logger: env.Logger.WithGroup("two_factors"),
+		users:  users,
+		dao:    NewUserTwoFactorDaoWith(users.db.SessionReference()),
+		issuer: env.Config.StringWithDefault(CfgUserTOTPIssuer, env.Name),
+		skew:   env.Config.IntWithDefault(CfgUserTOTPSkew, 1),
+	}
+
+	// 加密 TOTP 密钥的密钥必须单独配置，不能和 cookie 的签名密钥共用。
+	// 没有配置 users.totp.enabled 时，配置了密钥就启用两步验证
+	key := env.Config.StringWithDefault(CfgUserTOTPSecretKey, "")
+	if env.Config.BoolWithDefault(CfgUserTOTPEnabled, key != "") {
+		if key == "" {
+			return nil, errors.New("启用了两步验证，但没有配置 " + CfgUserTOTPSecretKey)
+		}
+		c, err := newSecretCipher(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "初始化两步验证的密钥加密失败")
+		}
+		svc.cipher = c
+	}
+	return svc, nil
+}
+
+// NewTwoFactors 返回两步验证的服务，它和登录时的校验共用 UserService 中的配置
+func NewTwoFactors(users *UserService) booclient.TwoFactors {
+	return users.twoFactors
+}
+
+type twoFactorService struct {
+	logger *slog.Logger
+	users  *UserService
+	dao    UserTwoFactorDao
+	cipher *secretCipher
+	issuer string
+	skew   int
+}
+
+func (svc *twoFactorService) find(ctx context.Context, userID int64) (*UserTwoFactor, error) {
+	tf, err := svc.dao.FindByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "查询用户的两步验证配置失败")
+	}
+	return tf, nil
+}
+
+func (svc *twoFactorService) findEnabled(ctx context.Context, userID int64) (*UserTwoFactor, error) {
+	tf, err := svc.find(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if tf == nil || !tf.Enabled {
+		return nil, errors.WithCode(errors.New("还没有开通两步验证"), http.StatusBadRequest)
+	}
+	return tf, nil
+}
+
+func (svc *twoFactorService) isEnabled(ctx context.Context, userID int64) (bool, error) {
+	tf, err := svc.find(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return tf != nil && tf.Enabled, nil
+}
+
+func (svc *twoFactorService) isRequired(ctx context.Context, userID int64) (bool, error) {
+	roles, err := svc.users.roleDao.QueryByUserID(ctx, userID)
+	if err != nil {
+		return false, errors.Wrap(err, "查询用户的角色失败")
+	}
+	return isTwoFactorRequired(roles), nil
+}
+
+// isTwoFactorRequired 判断用户的角色中是否有要求两步验证的
+func isTwoFactorRequired(roles []Role) bool {
+	for idx := range roles {
+		if roles[idx].TwoFactorRequired {
+			return true
+		}
+	}
+	return false
+}
+
+// mustEnroll 判断用户的角色要求两步验证，但用户还没有开通
+func (svc *twoFactorService) mustEnroll(ctx context.Context, user *User) (bool, error) {
+	if !isTwoFactorRequired(user.Roles) {
+		return false, nil
+	}
+	enabled, err := svc.isEnabled(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	return !enabled, nil
+}
+
+func (svc *twoFactorService) validateTOTP(tf *UserTwoFactor, code string) (int64, bool, error) {
+	if svc.cipher == nil {
+		return 0, false, errors.New("没有配置 " + CfgUserTOTPSecretKey)
+	}
+	secret, err := svc.cipher.Decrypt(tf.Secret)
+	if err != nil {
+		return 0, false, errors.Wrap(err, "解密两步验证的密钥失败")
+	}
+	step, ok := totp.Validate(secret, code, time.Now(), svc.skew)
+	return step, ok, nil
+}
+
+// verify 校验验证码或恢复码，用过的验证码和恢复码不能再次使用
+func (svc *twoFactorService) verify(ctx context.Context, tf *UserTwoFactor, code string) (bool, error) {
+	step, ok, err := svc.validateTOTP(tf, code)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		if step <= tf.LastUsedStep {
+			return false, nil
+		}
+		n, err := svc.dao.UseStep(ctx, tf.UserID, step, time.Now())
+		if err != nil {
+			return false, errors.Wrap(err, "更新两步验证的使用记录失败")
+		}
+		return n > 0, nil
+	}
+
+	remaining, ok := useRecoveryCode(tf.RecoveryCodes, code)
+	if !ok {
+		return false, nil
+	}
+	n, err := svc.dao.UpdateRecoveryCodes(ctx, tf.UserID, tf.RecoveryCodes, remaining, time.Now())
+	if err != nil {
+		return false, errors.Wrap(err, "更新两步验证的恢复码失败")
+	}
+	if n > 0 {
+		svc.logger.InfoContext(ctx, "用户使用恢复码通过了两步验证",
+			slog.Int64("user_id", tf.UserID),
+			slog.Int("remaining", len(splitRecoveryCodes(remaining))))
+	}
+	return n > 0, nil
+}
+
+func (svc *twoFactorService) status(ctx context.Context, userID int64) (*booclient.TwoFactorStatus, error) {
+	tf, err := svc.find(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	required, err := svc.isRequired(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &booclient.TwoFactorStatus{
+		Required: required,
+	}
+	if tf != nil && tf.Enabled {
+		status.Enabled = true
+		status.RecoveryCodesRemaining = len(splitRecoveryCodes(tf.RecoveryCodes))
+		if tf.EnabledAt.Valid {
+			status.EnabledAt = tf.EnabledAt.Time
+		}
+	}
+	return status, nil
+}
+
+func (svc *twoFactorService) Status(ctx context.Context) (*booclient.TwoFactorStatus, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return svc.status(ctx, currentUser.ID())
+}
+
+func (svc *twoFactorService) Enroll(ctx context.Context) (*booclient.TwoFactorEnrollment, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if svc.cipher == nil {
+		return nil, errors.New("没有配置 " + CfgUserTOTPSecretKey + "，不能开通两步验证")
+	}
+
+	old, err := svc.find(ctx, currentUser.ID())
+	if err != nil {
+		return nil, err
+	}
+	if old != nil && old.Enabled {
+		return nil, errors.WithCode(errors.New("已经开通了两步验证，请先关闭它"), http.StatusBadRequest)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, errors.Wrap(err, "生成两步验证的密钥失败")
+	}
+	encrypted, err := svc.cipher.Encrypt(secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "加密两步验证的密钥失败")
+	}
+	codes, hashed, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, errors.Wrap(err, "生成两步验证的恢复码失败")
+	}
+
+	err = svc.users.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		if _, err := svc.dao.DeleteByUserID(ctx, currentUser.ID()); err != nil {
+			return errors.Wrap(err, "删除旧的两步验证配置失败")
+		}
+		err := svc.dao.Insert(ctx, &UserTwoFactor{
+			UserID:        currentUser.ID(),
+			Secret:        encrypted,
+			RecoveryCodes: hashed,
+		})
+		if err != nil {
+			return errors.Wrap(err, "保存两步验证配置失败")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &booclient.TwoFactorEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(svc.issuer, currentUser.Name(), secret),
+		RecoveryCodes:   codes,
+	}, nil
+}
+
+func (svc *twoFactorService) Activate(ctx context.Context, code string) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	tf, err := svc.find(ctx, currentUser.ID())
+	if err != nil {
+		return err
+	}
+	if tf == nil {
+		return errors.WithCode(errors.New("请先生成两步验证的密钥"), http.StatusBadRequest)
+	}
+	if tf.Enabled {
+		return errors.WithCode(errors.New("已经开通了两步验证"), http.StatusBadRequest)
+	}
+
+	step, ok, err := svc.validateTOTP(tf, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.WithCode(errors.New("验证码不正确"), http.StatusBadRequest)
+	}
+
+	return svc.users.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		if err := svc.dao.Enable(ctx, currentUser.ID(), step, time.Now()); err != nil {
+			return errors.Wrap(err, "开通两步验证失败")
+		}
+		svc.logChange(ctx, tx, currentUser, currentUser.ID(), currentUser.Name(), "enabletwofactor", "开通两步验证成功", false, true)
+		return nil
+	})
+}
+
+func (svc *twoFactorService) Disable(ctx context.Context, code string) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	tf, err := svc.findEnabled(ctx, currentUser.ID())
+	if err != nil {
+		return err
+	}
+	// 角色要求两步验证时不能自已关闭它，只能由管理员重置
+	if required, err := svc.isRequired(ctx, currentUser.ID()); err != nil {
+		return err
+	} else if required {
+		return errors.WithCode(errors.New("你的角色要求两步验证，不能关闭它"), http.StatusForbidden)
+	}
+	ok, err := svc.verify(ctx, tf, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.WithCode(errors.New("验证码不正确"), http.StatusBadRequest)
+	}
+
+	return svc.users.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		if _, err := svc.dao.DeleteByUserID(ctx, currentUser.ID()); err != nil {
+			return errors.Wrap(err, "关闭两步验证失败")
+		}
+		svc.logChange(ctx, tx, currentUser, currentUser.ID(), currentUser.Name(), "disabletwofactor", "关闭两步验证成功", true, false)
+		return nil
+	})
+}
+
+func (svc *twoFactorService) RegenerateRecoveryCodes(ctx context.Context, code string) ([]string, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tf, err := svc.findEnabled(ctx, currentUser.ID())
+	if err != nil {
+		return nil, err
+	}
+	ok, err := svc.verify(ctx, tf, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.WithCode(errors.New("验证码不正确"), http.StatusBadRequest)
+	}
+
+	codes, hashed, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, errors.Wrap(err, "生成两步验证的恢复码失败")
+	}
+	err = svc.users.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		_, err := svc.dao.UpdateRecoveryCodes(ctx, currentUser.ID(), "", hashed, time.Now())
+		if err != nil {
+			return errors.Wrap(err, "保存两步验证的恢复码失败")
+		}
+		svc.logChange(ctx, tx, currentUser, currentUser.ID(), currentUser.Name(), "regeneraterecoverycodes", "重新生成两步验证的恢复码成功", true, true)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (svc *twoFactorService) StatusByUserID(ctx context.Context, userID int64) (*booclient.TwoFactorStatus, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if currentUser.ID() != userID {
+		if ok, err := currentUser.HasPermission(ctx, authn.OpViewUser); err != nil {
+			return nil, errors.Wrap(err, "判断当前用户是否有权限失败")
+		} else if !ok {
+			return nil, errors.NewOperationReject(authn.OpViewUser)
+		}
+	}
+	return svc.status(ctx, userID)
+}
+
+func (svc *twoFactorService) ResetByUserID(ctx context.Context, userID int64) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if ok, err := currentUser.HasPermission(ctx, authn.OpResetTwoFactor); err != nil {
+		return errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpResetTwoFactor)
+	}
+
+	user, err := svc.users.userDao.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.WithCode(errors.New("用户 '"+strconv.FormatInt(userID, 10)+"' 不存在"), http.StatusNotFound)
+		}
+		return errors.Wrap(err, "查询用户 '"+strconv.FormatInt(userID, 10)+"' 失败")
+	}
+
+	return svc.users.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		n, err := svc.dao.DeleteByUserID(ctx, userID)
+		if err != nil {
+			return errors.Wrap(err, "重置用户 '"+user.Name+"' 的两步验证失败")
+		}
+		if n > 0 {
+			svc.logChange(ctx, tx, currentUser, user.ID, user.Name, authn.OpResetTwoFactor, "重置用户 '"+user.Nickname+"' 的两步验证成功", true, false)
+		}
+		return nil
+	})
+}
+
+func (svc *twoFactorService) logChange(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, userID int64, username, typ, content string, oldValue, newValue bool) {
+	if !enableOplog {
+		return
+	}
+	records := make([]ChangeRecord, 0, 10)
+	records = append(records, ChangeRecord{
+		Name:        "name",
+		DisplayName: "用户名",
+		OldValue:    username,
+	})
+	records = append(records, ChangeRecord{
+		Name:        "two_factor",
+		DisplayName: "两步验证",
+		OldValue:    oldValue,
+		NewValue:    newValue,
+	})
+
+	oplogger := svc.users.operationLogger
+	if tx != nil {
+		oplogger = oplogger.WithTx(tx.DB())
+	}
+	err := oplogger.LogRecord(ctx, &OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Nickname(),
+		Successful: true,
+		Type:       typ,
+		Content:    content,
+		Fields: &OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   userID,
+			Records:    records,
+		},
+	})
+	if err != nil {
+		svc.logger.WarnContext(ctx, "记录两步验证的操作失败", slog.Any("err", err))
+	}
+}
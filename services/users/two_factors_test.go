@@ -0,0 +1,227 @@
+package users_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boo-admin/boo/app_tests"
+	"github.com/boo-admin/boo/booclient"
+	"github.com/boo-admin/boo/goutils/totp"
+	"github.com/boo-admin/boo/services/authn"
+)
+
+func TestTwoFactor(t *testing.T) {
+	app := app_tests.NewTestApp(t, nil)
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	users := booclient.NewRemoteUsers(pxy)
+	userID, err := users.Create(ctx, &booclient.User{
+		Name:     "totptest",
+		Nickname: "两步验证测试用户",
+		Password: "Abcd!12345",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	login := func(code string) int {
+		t.Helper()
+
+		values := url.Values{"username": {"totptest"}, "password": {"Abcd!12345"}}
+		if code != "" {
+			values.Set("totp_code", code)
+		}
+		res, err := http.Post(app.BaseURL()+"/login", "application/x-www-form-urlencoded",
+			strings.NewReader(values.Encode()))
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	userPxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	userPxy.SetBasicAuth("totptest", "Abcd!12345")
+	twoFactors := booclient.NewRemoteTwoFactors(userPxy)
+
+	enrollment, err := twoFactors.Enroll(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(enrollment.RecoveryCodes) != 10 {
+		t.Error("want 10 recovery codes got", enrollment.RecoveryCodes)
+	}
+	if !strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/") {
+		t.Error("want otpauth uri got", enrollment.ProvisioningURI)
+	}
+
+	// 没有确认前不需要验证码
+	if code := login(""); code != http.StatusOK {
+		t.Error("want 200 got", code)
+	}
+
+	if err := twoFactors.Activate(ctx, "000000"); err == nil {
+		t.Error("want error got ok")
+	}
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := twoFactors.Activate(ctx, code); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if code := login(""); code != http.StatusUnauthorized {
+		t.Error("want 401 got", code)
+	}
+	if code := login("000000"); code != http.StatusUnauthorized {
+		t.Error("want 401 got", code)
+	}
+	// 验证码已在开通时用过了，不能再次使用
+	if code := login(code); code != http.StatusUnauthorized {
+		t.Error("want 401 got", code)
+	}
+	if code := login(enrollment.RecoveryCodes[0]); code != http.StatusOK {
+		t.Error("want 200 got", code)
+	}
+	if code := login(enrollment.RecoveryCodes[0]); code != http.StatusUnauthorized {
+		t.Error("want 401 got", code)
+	}
+
+	// 开通后不能只用密码访问 api
+	if _, err := twoFactors.Status(ctx); err == nil {
+		t.Error("want error got ok")
+	}
+
+	adminTwoFactors := booclient.NewRemoteTwoFactors(pxy)
+	status, err := adminTwoFactors.StatusByUserID(ctx, userID)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !status.Enabled {
+		t.Error("want enabled got disabled")
+	}
+	if status.RecoveryCodesRemaining != 9 {
+		t.Error("want 9 got", status.RecoveryCodesRemaining)
+	}
+
+	if err := adminTwoFactors.ResetByUserID(ctx, userID); err != nil {
+		t.Error(err)
+		return
+	}
+	if code := login(""); code != http.StatusOK {
+		t.Error("want 200 got", code)
+	}
+	status, err = twoFactors.Status(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if status.Enabled {
+		t.Error("want disabled got enabled")
+	}
+}
+
+func TestTwoFactorRequiredByRole(t *testing.T) {
+	app := app_tests.NewTestApp(t, nil)
+	app.Start(t)
+	defer app.Stop(t)
+
+	ctx := context.Background()
+	pxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pxy.SetBasicAuth("admin", "admin")
+
+	roleID, err := booclient.NewRemoteRoles(pxy).Create(ctx, &booclient.Role{
+		Title:             "totp_required_role",
+		TwoFactorRequired: true,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = booclient.NewRemoteRoles(pxy).GrantPermissions(ctx, roleID, []string{authn.OpViewUser})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = booclient.NewRemoteUsers(pxy).Create(ctx, &booclient.User{
+		Name:     "totprequired",
+		Nickname: "必须开通两步验证的用户",
+		Password: "Abcd!12345",
+		Roles:    []booclient.Role{{Title: "totp_required_role"}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	userPxy, err := booclient.NewResty(app.BaseURL())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	userPxy.SetBasicAuth("totprequired", "Abcd!12345")
+	twoFactors := booclient.NewRemoteTwoFactors(userPxy)
+	userUsers := booclient.NewRemoteUsers(userPxy)
+
+	// 开通之前只能访问开通两步验证的功能
+	if _, err := userUsers.FindByName(ctx, "admin"); err == nil {
+		t.Error("want error got ok")
+	}
+	status, err := twoFactors.Status(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !status.Required || status.Enabled {
+		t.Errorf("%#v", status)
+	}
+
+	enrollment, err := twoFactors.Enroll(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := twoFactors.Activate(ctx, code); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 角色要求两步验证时不能自已关闭它
+	userCtx := app.Server.AuthUsers.ContextWithUserByName(ctx, "totprequired")
+	if err := app.Server.TwoFactors.Disable(userCtx, enrollment.RecoveryCodes[0]); err == nil {
+		t.Error("want error got ok")
+	}
+}
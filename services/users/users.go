@@ -4,9 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -17,12 +15,10 @@ import (
 	"github.com/boo-admin/boo/goutils/importer"
 	"github.com/boo-admin/boo/goutils/tid"
 	"github.com/boo-admin/boo/services/authn"
+	"github.com/boo-admin/boo/services/authn/session_auth"
 	"github.com/boo-admin/boo/validation"
 	gobatis "github.com/runner-mei/GoBatis"
 	"golang.org/x/exp/slog"
-
-	"github.com/hjson/hjson-go/v4"
-	good_password "github.com/mei-rune/go-good-password"
 )
 
 const (
@@ -79,24 +75,17 @@ func NewUser2TagDaoWith(ref gobatis.SqlSession) User2TagDao {
 func NewUsers(env *booclient.Environment,
 	db *gobatis.SessionFactory,
 	operationLogger OperationLogger) (*UserService, error) {
-	enablePasswordCheck := env.Config.BoolWithDefault("enable_password_check", false)
-	var fields []CustomField
-	if s := env.Config.StringWithDefault("usercustomfields", ""); s != "" {
-		filename := booclient.GetRealDir(context.Background(), env, s)
-		bs, err := ioutil.ReadFile(filename)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				return nil, errors.Wrap(err, "加载用户字段的配置失败")
-			}
-		} else if err = hjson.Unmarshal(bs, &fields); err != nil {
-			return nil, errors.Wrap(err, "加载用户字段的配置失败")
-		}
-
-		if fields == nil {
-			fields = booclient.DefaultUserFields
-		}
-	} else {
-		fields = booclient.DefaultUserFields
+	fields, err := newCustomFieldSet(env, db, booclient.CustomFieldUser, "用户", "usercustomfields", booclient.DefaultUserFields)
+	if err != nil {
+		return nil, err
+	}
+	employeeFields, err := newCustomFieldSet(env, db, booclient.CustomFieldEmployee, "员工", "employeecustomfields", booclient.DefaultEmployeeFields)
+	if err != nil {
+		return nil, err
+	}
+	departmentFields, err := newCustomFieldSet(env, db, booclient.CustomFieldDepartment, "部门", "departmentcustomfields", nil)
+	if err != nil {
+		return nil, err
 	}
 
 	var defaultUsernames = env.Config.StringsWithDefault("users.default_names", nil)
@@ -107,23 +96,33 @@ func NewUsers(env *booclient.Environment,
 	}
 
 	sess := db.SessionReference()
-	return &UserService{
+	svc := &UserService{
 		env:              env,
 		logger:           env.Logger.WithGroup("users"),
 		operationLogger:  operationLogger,
 		db:               db,
 		defaultUsernames: defaultUsernames,
 
-		enablePasswordCheck: enablePasswordCheck,
-		departmentDao:       NewDepartmentDaoWith(sess),
-		userDao:             NewUserDaoWith(sess),
-		roleDao:             NewRoleDaoWith(sess),
-		user2RoleDao:        NewUser2RoleDaoWith(sess),
-		userTagDao:          NewUserTagDaoWith(sess),
-		user2TagDao:         NewUser2TagDaoWith(sess),
-		fields:              fields,
-		passwordHasher:      passwordHasher,
-	}, nil
+		passwordPolicy:     NewPasswordPolicy(env),
+		passwordHistoryDao: NewPasswordHistoryDaoWith(sess),
+		departmentDao:      NewDepartmentDaoWith(sess),
+		userDao:            NewUserDaoWith(sess),
+		roleDao:            NewRoleDaoWith(sess),
+		user2RoleDao:       NewUser2RoleDaoWith(sess),
+		roleDepartmentDao:  NewRoleDepartmentDaoWith(sess),
+		userTagDao:         NewUserTagDaoWith(sess),
+		user2TagDao:        NewUser2TagDaoWith(sess),
+		profileDao:         NewUserProfileDaoWith(sess),
+		fields:             fields,
+		employeeFields:     employeeFields,
+		departmentFields:   departmentFields,
+		passwordHasher:     passwordHasher,
+	}
+	svc.twoFactors, err = newTwoFactorService(env, svc)
+	if err != nil {
+		return nil, err
+	}
+	return svc, nil
 }
 
 type UserService struct {
@@ -133,40 +132,34 @@ type UserService struct {
 	db               *gobatis.SessionFactory
 	defaultUsernames []string
 
-	enablePasswordCheck bool
-	departmentDao       DepartmentDao
-	userDao             UserDao
-	roleDao             RoleDao
-	user2RoleDao        User2RoleDao
-	userTagDao          UserTagDao
-	user2TagDao         User2TagDao
-	fields              []CustomField
-	passwordHasher      UserPasswordHasher
+	passwordPolicy     *PasswordPolicy
+	passwordHistoryDao PasswordHistoryDao
+	departmentDao      DepartmentDao
+	userDao            UserDao
+	roleDao            RoleDao
+	user2RoleDao       User2RoleDao
+	roleDepartmentDao  RoleDepartmentDao
+	userTagDao         UserTagDao
+	user2TagDao        User2TagDao
+	profileDao         UserProfileDao
+	fields             *customFieldSet
+	employeeFields     *customFieldSet
+	departmentFields   *customFieldSet
+	passwordHasher     UserPassworder
+	twoFactors         *twoFactorService
+	onlines            session_auth.Onlines
+	lockouts           session_auth.Lockouts
 }
 
+// ValidatePassword 按密码策略检查密码， usernames 为用户名和呢称
 func (svc UserService) ValidatePassword(usernames []string, password string) error {
-	if svc.enablePasswordCheck {
-		score, _ := good_password.Check(password, usernames)
-		if score < 3 {
-			// 1 ("terrible"): "something" (one type)
-			// 2 ("weak"): "somethin1", "somethingnew" (two types)
-			// 3 ("okay"): "Somethin1", "somethinglonger" (three types)
-			// 4 ("good"): "Someth!n1", "somethingmuchlonger" (four types)
-			// >=5 ("strong"): "Someth!n10", "correct horse battery staple" (five types)
-
-			return errors.New("密码强度不足")
-		}
-	}
-	return nil
+	return svc.passwordPolicy.Check(password, usernames)
 }
 
 func (svc UserService) ValidateUser(v *validation.Validation, user *User) bool {
 	v.Required("name", user.Name)
 	v.Required("nickname", user.Nickname)
 	if user.Source != "ldap" && user.Source != "cas" && user.Source != "oauth" && !isAllStar(user.Password) {
-		v.MinSize("Password", user.Password, 8)
-		v.MaxSize("Password", user.Password, 250)
-
 		if err := svc.ValidatePassword([]string{user.Name, user.Nickname}, user.Password); err != nil {
 			v.Error("Password", err.Error())
 		}
@@ -187,7 +180,7 @@ func (svc UserService) ValidateUser(v *validation.Validation, user *User) bool {
 			}
 		}
 	}
-	return v.HasErrors()
+	return validateCustomFields(v, svc.fields.Get(), user.Fields)
 }
 
 func (svc UserService) Create(ctx context.Context, user *User) (int64, error) {
@@ -200,6 +193,13 @@ func (svc UserService) Create(ctx context.Context, user *User) (int64, error) {
 	} else if !ok {
 		return 0, errors.NewOperationReject(authn.OpCreateUser)
 	}
+	inScope, err := svc.readDataScopeFilter(ctx, currentUser)
+	if err != nil {
+		return 0, err
+	}
+	if !inScope(user.DepartmentID) {
+		return 0, errDataScopeForbidden("新建用户 '" + user.Name + "' 失败，不能在数据范围之外的部门中新建用户")
+	}
 	return svc.insert(ctx, currentUser, user, actionNormal)
 }
 
@@ -233,11 +233,16 @@ func (svc UserService) insert(ctx context.Context, currentUser authn.AuthUser, u
 			return errors.Wrap(err, "创建用户失败")
 		}
 		user.ID = id
+		if user.Password != "" {
+			if err = svc.addHistory(ctx, id, user.Password); err != nil {
+				return err
+			}
+		}
 
 		var contents []ChangeRecord
 		if importUser == actionNormal || importUser == actionImport {
 			var roleContents []ChangeRecord
-			if roleContents, err = svc.updateRoles(ctx, id, user, false); err != nil {
+			if roleContents, err = svc.updateRoles(ctx, currentUser, id, user, false); err != nil {
 				return err
 			}
 			var tagContents []ChangeRecord
@@ -273,6 +278,16 @@ func (svc UserService) UpdateByID(ctx context.Context, id int64, user *User, mod
 	if err != nil {
 		return errors.Wrap(err, "更新用户 '"+strconv.FormatInt(id, 10)+"' 失败")
 	}
+	inScope, err := svc.readDataScopeFilter(ctx, currentUser)
+	if err != nil {
+		return err
+	}
+	if !inScope(old.DepartmentID) {
+		return errDataScopeNotFound("更新用户 '" + strconv.FormatInt(id, 10) + "' 失败")
+	}
+	if !inScope(user.DepartmentID) {
+		return errDataScopeForbidden("更新用户 '" + old.Name + "' 失败，不能将用户移到数据范围之外的部门")
+	}
 	return svc.update(ctx, currentUser, id, user, old, mode, actionNormal)
 }
 
@@ -347,6 +362,12 @@ func (svc UserService) update(ctx context.Context, currentUser authn.AuthUser, i
 		if err != nil {
 			return errors.Wrap(err, "更新用户失败")
 		}
+		if importUser != actionSync && newUser.Disabled != old.Disabled {
+			// 管理员修改了禁用状态后，LDAP 同步不再自动启用该用户
+			if _, err := svc.profileDao.DeleteProfile(ctx, id, ldapSyncDisabledProfile); err != nil {
+				return errors.Wrap(err, "删除用户的同步禁用标记失败")
+			}
+		}
 
 		var contents []ChangeRecord
 		var roleContents []ChangeRecord
@@ -354,14 +375,14 @@ func (svc UserService) update(ctx context.Context, currentUser authn.AuthUser, i
 
 		switch mode {
 		case booclient.UpdateModeOverride:
-			if roleContents, err = svc.updateRoles(ctx, id, user, true); err != nil {
+			if roleContents, err = svc.updateRoles(ctx, currentUser, id, user, true); err != nil {
 				return err
 			}
 			if tagContents, err = svc.updateTags(ctx, id, user, true); err != nil {
 				return err
 			}
 		case booclient.UpdateModeAdd:
-			if roleContents, err = svc.updateRoles(ctx, id, user, false); err != nil {
+			if roleContents, err = svc.updateRoles(ctx, currentUser, id, user, false); err != nil {
 				return err
 			}
 			if tagContents, err = svc.updateTags(ctx, id, user, false); err != nil {
@@ -386,7 +407,7 @@ func (svc UserService) update(ctx context.Context, currentUser authn.AuthUser, i
 	})
 }
 
-func (svc UserService) updateRoles(ctx context.Context, id int64, user *User, isUpdate bool) ([]ChangeRecord, error) {
+func (svc UserService) updateRoles(ctx context.Context, currentUser authn.AuthUser, id int64, user *User, isUpdate bool) ([]ChangeRecord, error) {
 	var oldRoles []User2Role
 	var err error
 	if isUpdate {
@@ -461,6 +482,10 @@ func (svc UserService) updateRoles(ctx context.Context, id int64, user *User, is
 			}
 		}
 
+		if !canAssignRole(currentUser, role, isNewRole) {
+			return nil, errors.Wrap(errors.ErrPermissionDeny, "不能关联角色 '"+role.Title+"'，当前用户没有这个角色")
+		}
+
 		err = svc.user2RoleDao.Upsert(ctx, id, role.ID)
 		if err != nil {
 			if isUpdate {
@@ -643,44 +668,159 @@ func (svc UserService) ChangePassword(ctx context.Context, id int64, password st
 		return err
 	}
 
-	var names []string
-	if currentUser.ID() != id {
-		if ok, err := currentUser.HasPermission(ctx, authn.OpResetPassword); err != nil {
-			return errors.Wrap(err, "判断当前用户是否有权限失败")
-		} else if !ok {
-			return errors.NewOperationReject(authn.OpResetPassword)
-		}
-		old, err := svc.userDao.FindByID(ctx, id)
-		if err != nil {
-			return errors.Wrap(err, "重置用户密码时，查询用户 '"+strconv.FormatInt(id, 10)+"' 失败")
-		}
-		names = []string{old.Name, old.Nickname}
-	} else {
-		names = []string{currentUser.Name(), currentUser.Nickname()}
+	// 修改自已的密码必须校验原密码，见 ChangeMyPassword
+	if currentUser.ID() == id {
+		return errors.WithCode(errors.New("不能用此方式修改自已的密码，请使用 /users/me/password"), http.StatusBadRequest)
 	}
-
-	return svc.resetPassword(ctx, currentUser, id, names, password, false)
+	if ok, err := currentUser.HasPermission(ctx, authn.OpResetPassword); err != nil {
+		return errors.Wrap(err, "判断当前用户是否有权限失败")
+	} else if !ok {
+		return errors.NewOperationReject(authn.OpResetPassword)
+	}
+	old, err := svc.userDao.FindByID(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "重置用户密码时，查询用户 '"+strconv.FormatInt(id, 10)+"' 失败")
+	}
+	if err := svc.checkDataScope(ctx, currentUser, old.DepartmentID, "重置用户密码时，查询用户 '"+strconv.FormatInt(id, 10)+"' 失败"); err != nil {
+		return err
+	}
+	return svc.resetPassword(ctx, currentUser, old, []string{old.Name, old.Nickname}, password, false)
 }
 
-func (svc UserService) resetPassword(ctx context.Context, currentUser authn.AuthUser, id int64, names []string, password string, importUser bool) error {
+func (svc UserService) resetPassword(ctx context.Context, currentUser authn.AuthUser, user *User, names []string, password string, importUser bool) error {
 	if err := svc.ValidatePassword(names, password); err != nil {
 		return err
 	}
+	if err := svc.checkHistory(ctx, user, password); err != nil {
+		return err
+	}
+
+	// 管理员重置的密码只有管理员和用户两个人知道，所以可以要求用户下次登录时修改它
+	mustChange := svc.passwordPolicy.ChangeOnReset && currentUser.ID() != user.ID
+	return svc.updatePassword(ctx, user, password, mustChange, func(ctx context.Context, tx *gobatis.Tx) {
+		svc.logResetPassword(ctx, tx, currentUser, user.ID, names[0], importUser)
+	})
+}
 
+// updatePassword 加密并保存新的密码，同时记录密码历史，oplog 用于在同一个事务中记录操作日志
+func (svc UserService) updatePassword(ctx context.Context, user *User, password string, mustChange bool, oplog func(context.Context, *gobatis.Tx)) error {
 	if pwd, err := svc.passwordHasher.Hash(ctx, password); err != nil {
 		return errors.Wrap(err, "加密用户密码失败")
 	} else {
 		password = pwd
 	}
 
-	if err := svc.userDao.UpdateUserPassword(ctx, id, password); err != nil {
-		return errors.Wrap(err, "修改密码失败")
+	return svc.db.InTx(ctx, nil, false, func(ctx context.Context, tx *gobatis.Tx) error {
+		if err := svc.userDao.UpdateUserPassword(ctx, user.ID, password, mustChange); err != nil {
+			return errors.Wrap(err, "修改密码失败")
+		}
+		if err := svc.addHistory(ctx, user.ID, password); err != nil {
+			return err
+		}
+
+		oplog(ctx, tx)
+		return nil
+	})
+}
+
+// rehashIfNeeded 在用户用密码登录成功后，如果密码不是用当前配置的算法或参数加密的，就重新加密它。
+// 它不是修改密码，所以不更新密码的修改时间和密码历史，失败时也不影响登录
+func (svc UserService) rehashIfNeeded(ctx context.Context, user *User, password string) {
+	rehasher, ok := svc.passwordHasher.(PasswordRehasher)
+	if !ok || !rehasher.NeedsRehash(user.Password) {
+		return
 	}
+	hashed, err := svc.passwordHasher.Hash(ctx, password)
+	if err != nil {
+		svc.logger.WarnContext(ctx, "重新加密用户密码失败", slog.String("username", user.Name), slog.Any("err", err))
+		return
+	}
+	if err := svc.userDao.UpdatePasswordHash(ctx, user.ID, hashed); err != nil {
+		svc.logger.WarnContext(ctx, "保存重新加密的用户密码失败", slog.String("username", user.Name), slog.Any("err", err))
+		return
+	}
+	user.Password = hashed
+}
 
-	svc.logResetPassword(ctx, nil, currentUser, id, names[0], importUser)
+// ChangeMyPassword 当前用户校验原密码后修改自已的密码，logoutOthers 为 true 时注销自已的其它在线会话
+func (svc UserService) ChangeMyPassword(ctx context.Context, oldPassword, newPassword string, logoutOthers bool) error {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := svc.userDao.FindByID(ctx, currentUser.ID())
+	if err != nil {
+		return errors.Wrap(err, "修改密码时，查询用户 '"+currentUser.Name()+"' 失败")
+	}
+	if user.Source != "" && user.Source != "builtin" && user.Source != "api" {
+		return errors.WithCode(errors.New("用户 '"+user.Name+"' 不是本地用户，不能修改密码"), http.StatusBadRequest)
+	}
+
+	// 原密码错误和登录共用同一个失败计数，避免拿到会话的人用它来猜密码
+	address := svc.currentAddress(ctx)
+	if err := svc.checkLocked(ctx, user.Name, address); err != nil {
+		return err
+	}
+	if oldPassword == "" || user.Password == "" {
+		svc.passwordFailed(ctx, user.Name, address)
+		return errors.WithCode(errors.New("原密码不正确"), http.StatusBadRequest)
+	}
+	if err := svc.passwordHasher.Compare(ctx, oldPassword, user.Password); err != nil {
+		if errors.Is(err, ErrPasswordMismatch) {
+			svc.passwordFailed(ctx, user.Name, address)
+			return errors.WithCode(errors.New("原密码不正确"), http.StatusBadRequest)
+		}
+		return errors.Wrap(err, "校验用户密码失败")
+	}
+	svc.passwordSucceeded(user.Name)
+
+	if err := svc.ValidatePassword([]string{user.Name, user.Nickname}, newPassword); err != nil {
+		return err
+	}
+	if err := svc.checkHistory(ctx, user, newPassword); err != nil {
+		return err
+	}
+
+	err = svc.updatePassword(ctx, user, newPassword, false, func(ctx context.Context, tx *gobatis.Tx) {
+		svc.logChangePassword(ctx, tx, currentUser, user)
+	})
+	if err != nil {
+		return err
+	}
+
+	if logoutOthers {
+		return svc.logoutOtherSessions(ctx, user.Name)
+	}
 	return nil
 }
 
+// logoutOtherSessions 注销用户除当前会话以外的在线会话
+func (svc UserService) logoutOtherSessions(ctx context.Context, username string) error {
+	if svc.onlines == nil {
+		return nil
+	}
+	list, err := svc.onlines.QueryByUsername(ctx, username)
+	if err != nil {
+		return errors.Wrap(err, "查询在线会话失败")
+	}
+	current := session_auth.SessionIDFromContext(ctx)
+	for _, s := range list {
+		if s.UUID == current {
+			continue
+		}
+		if err := svc.onlines.LogoutBySessionID(ctx, s.UUID); err != nil {
+			return errors.Wrap(err, "注销会话 '"+s.UUID+"' 失败")
+		}
+	}
+	return nil
+}
+
+// SetOnlines 设置在线会话，用户修改自已的密码后可以用它注销其它的会话
+func (svc *UserService) SetOnlines(onlines session_auth.Onlines) {
+	svc.onlines = onlines
+}
+
 func (svc UserService) DeleteByID(ctx context.Context, id int64, force bool) error {
 	currentUser, err := authn.ReadUserFromContext(ctx)
 	if err != nil {
@@ -696,6 +836,9 @@ func (svc UserService) DeleteByID(ctx context.Context, id int64, force bool) err
 	if err != nil {
 		return errors.Wrap(err, "删除用户时，查询用户 '"+strconv.FormatInt(id, 10)+"' 失败")
 	}
+	if err := svc.checkDataScope(ctx, currentUser, old.DepartmentID, "删除用户时，查询用户 '"+strconv.FormatInt(id, 10)+"' 失败"); err != nil {
+		return err
+	}
 
 	return svc.db.InTx(ctx, nil, true, func(ctx context.Context, tx *gobatis.Tx) error {
 		if !force {
@@ -734,8 +877,15 @@ func (svc UserService) DeleteBatch(ctx context.Context, idlist []int64, force bo
 	if err != nil {
 		return errors.Wrap(err, "删除用户时，查询用户失败")
 	}
+	inScope, err := svc.readDataScopeFilter(ctx, currentUser)
+	if err != nil {
+		return err
+	}
 	var newList = make([]int64, 0, len(oldList))
 	for _, old := range oldList {
+		if !inScope(old.DepartmentID) {
+			return errDataScopeNotFound("删除用户时，查询用户 '" + strconv.FormatInt(old.ID, 10) + "' 失败")
+		}
 		newList = append(newList, old.ID)
 	}
 
@@ -781,6 +931,11 @@ func (svc UserService) FindByID(ctx context.Context, id int64, includes ...strin
 	if err != nil {
 		return nil, errors.Wrap(err, "查询用户失败")
 	}
+	if currentUser.ID() != id {
+		if err := svc.checkDataScope(ctx, currentUser, user.DepartmentID, "查询用户失败"); err != nil {
+			return nil, err
+		}
+	}
 
 	includes = splitIncludes(includes, GetUserAllIncludes())
 	return svc.loadUser(ctx, user, includes)
@@ -802,6 +957,11 @@ func (svc UserService) FindByName(ctx context.Context, name string, includes ...
 	if err != nil {
 		return nil, errors.Wrap(err, "查询用户失败")
 	}
+	if currentUser.Name() != name {
+		if err := svc.checkDataScope(ctx, currentUser, user.DepartmentID, "查询用户失败"); err != nil {
+			return nil, err
+		}
+	}
 
 	includes = splitIncludes(includes, GetUserAllIncludes())
 	return svc.loadUser(ctx, user, includes)
@@ -857,10 +1017,22 @@ func toIdOrName(s string) (int64, string) {
 }
 
 func (svc UserService) Count(ctx context.Context, departmentID int64, role, tag, keyword string, deleted sql.NullBool) (int64, error) {
+	currentUser, err := authn.ReadUserFromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	scopeDepartmentIDs, visible, err := svc.readDataScope(ctx, currentUser)
+	if err != nil {
+		return 0, err
+	}
+	if !visible {
+		return 0, nil
+	}
+
 	roleID, roleName := toIdOrName(role)
 	tagID, tagName := toIdOrName(tag)
 
-	return svc.userDao.Count(ctx, departmentID, roleID, roleName, tagID, tagName, keyword, deleted)
+	return svc.userDao.Count(ctx, departmentID, scopeDepartmentIDs, roleID, roleName, tagID, tagName, keyword, deleted)
 }
 func (svc UserService) List(ctx context.Context, departmentID int64, role, tag, keyword string, deleted sql.NullBool, includes []string, sort string, offset, limit int64) ([]User, error) {
 	currentUser, err := authn.ReadUserFromContext(ctx)
@@ -872,10 +1044,17 @@ func (svc UserService) List(ctx context.Context, departmentID int64, role, tag,
 	} else if !ok {
 		return nil, errors.NewOperationReject(authn.OpViewUser)
 	}
+	scopeDepartmentIDs, visible, err := svc.readDataScope(ctx, currentUser)
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return []User{}, nil
+	}
 
 	roleID, roleName := toIdOrName(role)
 	tagID, tagName := toIdOrName(tag)
-	list, err := svc.userDao.List(ctx, departmentID, roleID, roleName, tagID, tagName, keyword, deleted, sort, offset, limit)
+	list, err := svc.userDao.List(ctx, departmentID, scopeDepartmentIDs, roleID, roleName, tagID, tagName, keyword, deleted, sort, offset, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -900,12 +1079,21 @@ func (svc UserService) Export(ctx context.Context, format string, inline bool, s
 	} else if !ok {
 		return errors.NewOperationReject(authn.OpViewUser)
 	}
+	scopeDepartmentIDs, visible, err := svc.readDataScope(ctx, currentUser)
+	if err != nil {
+		return err
+	}
 
 	return importer.WriteHTTP(ctx, "users", format, inline, writer,
 		importer.RecorderFunc(func(ctx context.Context) (importer.RecordIterator, []string, error) {
-			list, err := svc.userDao.List(ctx, 0, 0, "", 0, "", "", sql.NullBool{Valid: true}, sort, offset, limit)
-			if err != nil {
-				return nil, nil, err
+			fields := svc.fields.Get()
+			var list []User
+			var err error
+			if visible {
+				list, err = svc.userDao.List(ctx, 0, scopeDepartmentIDs, 0, "", 0, "", "", sql.NullBool{Valid: true}, sort, offset, limit)
+				if err != nil {
+					return nil, nil, err
+				}
 			}
 			titles := []string{
 				"用户名",
@@ -914,7 +1102,7 @@ func (svc UserService) Export(ctx context.Context, format string, inline bool, s
 				"角色",
 				"标签",
 			}
-			for _, f := range svc.fields {
+			for _, f := range fields {
 				titles = append(titles, f.Name)
 			}
 			titles = append(titles, []string{
@@ -985,7 +1173,7 @@ func (svc UserService) Export(ctx context.Context, format string, inline bool, s
 						roles = sb.String()
 					}
 
-					var values = make([]string, 0, 5+len(svc.fields))
+					var values = make([]string, 0, 5+len(fields))
 					values = append(values, list[index].Name)
 					values = append(values, list[index].Nickname)
 					if department != nil {
@@ -996,7 +1184,7 @@ func (svc UserService) Export(ctx context.Context, format string, inline bool, s
 					values = append(values, tags)
 					values = append(values, roles)
 
-					for _, f := range svc.fields {
+					for _, f := range fields {
 						if len(f.Values) == 0 {
 							values = append(values, list[index].GetStringWithDefault(f.ID, ""))
 						} else {
@@ -1046,6 +1234,11 @@ func (svc UserService) Import(ctx context.Context, request *http.Request) error
 		canCreateDepartment = ok
 	}
 
+	inScope, err := svc.readDataScopeFilter(ctx, currentUser)
+	if err != nil {
+		return err
+	}
+
 	ctx = context.WithValue(ctx, importer.ContextToRealDirKey, booclient.ToRealDirFunc(svc.env))
 	reader, closer, err := importer.ReadHTTP(ctx, request)
 	if err != nil {
@@ -1056,10 +1249,11 @@ func (svc UserService) Import(ctx context.Context, request *http.Request) error
 	override := request.URL.Query().Get("override") == "true"
 	departmentAutoCreate := request.URL.Query().Get("department_auto_create") == "true"
 
+	fields := svc.fields.Get()
 	return importer.Import(ctx, "", reader, func(ctx context.Context, lineNumber int) (importer.Row, error) {
 		record := &User{}
 
-		var columns = make([]importer.Column, 0, 5+len(svc.fields))
+		var columns = make([]importer.Column, 0, 5+len(fields))
 		columns = append(columns, importer.StrColumn([]string{"name", "用户", "姓名"}, true,
 			func(ctx context.Context, lineNumber int, origin, value string) error {
 				record.Name = value
@@ -1090,7 +1284,7 @@ func (svc UserService) Import(ctx context.Context, request *http.Request) error
 				return nil
 			}))
 
-		for _, f := range svc.fields {
+		for _, f := range fields {
 			func(f booclient.CustomField) {
 				columns = append(columns, importer.StrColumn(append([]string{f.ID, f.Name}, f.Alias...), false,
 					func(ctx context.Context, lineNumber int, origin, value string) error {
@@ -1145,6 +1339,8 @@ func (svc UserService) Import(ctx context.Context, request *http.Request) error
 				if old != nil {
 					if override {
 						err = errors.New("用户 '" + record.Name + "' 已存在")
+					} else if !inScope(old.DepartmentID) || !inScope(record.DepartmentID) {
+						err = errDataScopeForbidden("用户 '" + record.Name + "' 或它的部门不在数据范围之内，没有更新")
 					} else if canUpdate {
 						password := record.Password
 						record.Password = ""
@@ -1159,7 +1355,7 @@ func (svc UserService) Import(ctx context.Context, request *http.Request) error
 									if record.Nickname == "" {
 										names[1] = old.Nickname
 									}
-									err = svc.resetPassword(ctx, currentUser, old.ID, names, password, true)
+									err = svc.resetPassword(ctx, currentUser, old, names, password, true)
 								}
 							}
 						}
@@ -1167,7 +1363,9 @@ func (svc UserService) Import(ctx context.Context, request *http.Request) error
 						err = errors.New("没有更新用户的权限，用户 '" + record.Name + "' 没有更新")
 					}
 				} else {
-					if canCreate {
+					if !inScope(record.DepartmentID) {
+						err = errDataScopeForbidden("用户 '" + record.Name + "' 的部门不在数据范围之内，没有创建")
+					} else if canCreate {
 						if record.Nickname == "" {
 							record.Nickname = record.Name
 						}
@@ -1214,7 +1412,7 @@ func (svc UserService) logCreate(ctx context.Context, tx *gobatis.Tx, currentUse
 		DisplayName: "描述",
 		NewValue:    user.Description,
 	})
-	for _, field := range svc.fields {
+	for _, field := range svc.fields.Get() {
 		fv := user.Fields[field.ID]
 		if fv == nil {
 			continue
@@ -1319,7 +1517,7 @@ func (svc UserService) logUpdate(ctx context.Context, tx *gobatis.Tx, currentUse
 		})
 	}
 
-	for _, field := range svc.fields {
+	for _, field := range svc.fields.Get() {
 		var oldfv, newfv interface{}
 		if len(old.Fields) > 0 {
 			oldfv = old.Fields[field.ID]
@@ -1404,6 +1602,30 @@ func (svc UserService) logResetPassword(ctx context.Context, tx *gobatis.Tx, cur
 	}
 }
 
+func (svc UserService) logChangePassword(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, user *User) {
+	if !enableOplog {
+		return
+	}
+	oplogger := svc.operationLogger
+	if tx != nil {
+		oplogger = oplogger.WithTx(tx.DB())
+	}
+	err := oplogger.LogRecord(ctx, &OperationLog{
+		UserID:     currentUser.ID(),
+		Username:   currentUser.Nickname(),
+		Successful: true,
+		Type:       "changepassword",
+		Content:    "用户 '" + user.Name + "' 修改自已的密码成功",
+		Fields: &OperationLogRecord{
+			ObjectType: "user",
+			ObjectID:   user.ID,
+		},
+	})
+	if err != nil {
+		svc.logger.WarnContext(ctx, "记录用户修改密码的操作失败", slog.Any("err", err))
+	}
+}
+
 func (svc UserService) logDelete(ctx context.Context, tx *gobatis.Tx, currentUser authn.AuthUser, oldUser *User) {
 	if !enableOplog {
 		return